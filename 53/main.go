@@ -254,6 +254,9 @@ func runProgram(filename string) {
 		}
 		os.Exit(1)
 	}
+	for _, w := range p.Warnings() {
+		fmt.Fprintln(os.Stderr, w)
+	}
 	scope := eval.NewScope(nil, os.Stdout)
 
 	result := eval.Eval(program, scope)
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"magpie/token"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 )
@@ -14,6 +15,7 @@ type Node interface {
 
 	TokenLiteral() string
 	String() string
+	NodeType() string // the kind of the node, i.e. the name of its type, e.g. "LetStatement"
 }
 
 type Statement interface {
@@ -29,6 +31,10 @@ type Expression interface {
 type Program struct {
 	Statements []Statement
 	Imports    map[string]*ImportStatement
+
+	//the operator precedences set by the '#prec' pragmas at the top of the file, e.g. {"+": 13}
+	//for '#prec(+, 13)', nil if there are no pragmas
+	Precedences map[string]int
 }
 
 func (p *Program) Pos() token.Position {
@@ -46,6 +52,8 @@ func (p *Program) End() token.Position {
 	return token.Position{}
 }
 
+func (p *Program) NodeType() string { return "Program" }
+
 func (p *Program) TokenLiteral() string {
 	if len(p.Statements) > 0 {
 		return p.Statements[0].TokenLiteral()
@@ -66,7 +74,9 @@ func (p *Program) String() string {
 type ImportStatement struct {
 	Token      token.Token
 	ImportPath string
-	Program    *Program
+	Path       string     //the path as written, e.g. "sub_package/calc" for 'import sub_package.calc'
+	Program    *Program   //nil for a conditional import, which the evaluator loads
+	Condition  Expression //import "debug/tools" if DEBUG, nil if the import is unconditional
 }
 
 func (is *ImportStatement) Pos() token.Position {
@@ -74,11 +84,15 @@ func (is *ImportStatement) Pos() token.Position {
 }
 
 func (is *ImportStatement) End() token.Position {
+	if is.Condition != nil {
+		return is.Condition.End()
+	}
 	length := utf8.RuneCountInString(is.ImportPath)
 	return token.Position{Filename: is.Token.Pos.Filename, Line: is.Token.Pos.Line, Col: is.Token.Pos.Col + length}
 }
 
 func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) NodeType() string     { return "ImportStatement" }
 func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
 func (is *ImportStatement) String() string {
 	var out bytes.Buffer
@@ -86,15 +100,21 @@ func (is *ImportStatement) String() string {
 	out.WriteString(is.TokenLiteral())
 	out.WriteString(" ")
 	out.WriteString(is.ImportPath)
+	if is.Condition != nil {
+		out.WriteString(" if ")
+		out.WriteString(is.Condition.String())
+	}
 
 	return out.String()
 }
 
 //let <identifier1>,<identifier2>,... = <expression1>,<expression2>,...
 type LetStatement struct {
-	Token  token.Token
-	Names  []*Identifier
-	Values []Expression
+	Token   token.Token
+	Names   []*Identifier
+	Values  []Expression
+	Mutable bool       //'let mut x = 1'
+	Pattern Expression //'let {x, y = 0} = point' or 'let [a, b = 2] = xs'(Names is empty), nil if no destructuring
 }
 
 func (ls *LetStatement) Pos() token.Position {
@@ -111,11 +131,18 @@ func (ls *LetStatement) End() token.Position {
 }
 
 func (ls *LetStatement) statementNode()       {}
+func (ls *LetStatement) NodeType() string     { return "LetStatement" }
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
+	if ls.Mutable {
+		out.WriteString("mut ")
+	}
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	}
 
 	names := []string{}
 	for _, name := range ls.Names {
@@ -160,6 +187,7 @@ func (rs *ReturnStatement) End() token.Position {
 }
 
 func (rs *ReturnStatement) statementNode()       {}
+func (rs *ReturnStatement) NodeType() string     { return "ReturnStatement" }
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
@@ -196,6 +224,7 @@ func (ts *TailCallStatement) End() token.Position {
 }
 
 func (ts *TailCallStatement) statementNode()       {}
+func (ts *TailCallStatement) NodeType() string     { return "TailCallStatement" }
 func (ts *TailCallStatement) TokenLiteral() string { return ts.Token.Literal }
 func (ts *TailCallStatement) String() string {
 	var out bytes.Buffer
@@ -207,6 +236,63 @@ func (ts *TailCallStatement) String() string {
 	return out.String()
 }
 
+//use name = expression in { block }
+//'name' is an alias of the expression's value, which is only visible inside the block.
+type UseStatement struct {
+	Token token.Token // the 'use' token
+	Name  *Identifier
+	Value Expression
+	Block *BlockStatement
+}
+
+func (us *UseStatement) Pos() token.Position {
+	return us.Token.Pos
+}
+
+func (us *UseStatement) End() token.Position {
+	return us.Block.End()
+}
+
+func (us *UseStatement) statementNode()       {}
+func (us *UseStatement) NodeType() string     { return "UseStatement" }
+func (us *UseStatement) TokenLiteral() string { return us.Token.Literal }
+func (us *UseStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("use ")
+	out.WriteString(us.Name.String())
+	out.WriteString(" = ")
+	out.WriteString(us.Value.String())
+	out.WriteString(" in { ")
+	out.WriteString(us.Block.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+//export { a, b, c }
+//Exports the names besides the uppercase ones, which are always exported.
+type ExportStatement struct {
+	Token       token.Token // the 'export' token
+	Names       []string
+	RBraceToken token.Token //used in End() method
+}
+
+func (es *ExportStatement) Pos() token.Position {
+	return es.Token.Pos
+}
+
+func (es *ExportStatement) End() token.Position {
+	return token.Position{Filename: es.Token.Pos.Filename, Line: es.RBraceToken.Pos.Line, Col: es.RBraceToken.Pos.Col + 1}
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) NodeType() string     { return "ExportStatement" }
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) String() string {
+	return "export { " + strings.Join(es.Names, ", ") + " }"
+}
+
 type BlockStatement struct {
 	Token       token.Token
 	Statements  []Statement
@@ -219,10 +305,17 @@ func (bs *BlockStatement) Pos() token.Position {
 }
 
 func (bs *BlockStatement) End() token.Position {
+	if bs.RBraceToken.Type != token.TOKEN_RBRACE { //brace-less body, e.g. 'case 1: doA()', '(x) => x + 1'
+		if n := len(bs.Statements); n > 0 && bs.Statements[n-1] != nil {
+			return bs.Statements[n-1].End()
+		}
+		return bs.Token.Pos
+	}
 	return token.Position{Filename: bs.Token.Pos.Filename, Line: bs.RBraceToken.Pos.Line, Col: bs.RBraceToken.Pos.Col + 1}
 }
 
 func (bs *BlockStatement) statementNode()       {}
+func (bs *BlockStatement) NodeType() string     { return "BlockStatement" }
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
 
 func (bs *BlockStatement) String() string {
@@ -232,7 +325,7 @@ func (bs *BlockStatement) String() string {
 		str := s.String()
 
 		out.WriteString(str)
-		if str[len(str)-1:] != ";" {
+		if len(str) == 0 || str[len(str)-1:] != ";" { //an empty statement, e.g. a stray ';'
 			out.WriteString(";")
 		}
 	}
@@ -250,9 +343,13 @@ func (es *ExpressionStatement) Pos() token.Position {
 }
 
 func (es *ExpressionStatement) End() token.Position {
+	if es.Expression == nil { //an empty statement, e.g. a stray ';'
+		return token.Position{Filename: es.Token.Pos.Filename, Line: es.Token.Pos.Line, Col: es.Token.Pos.Col + len(es.Token.Literal)}
+	}
 	return es.Expression.End()
 }
 func (es *ExpressionStatement) statementNode()       {}
+func (es *ExpressionStatement) NodeType() string     { return "ExpressionStatement" }
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 
 func (es *ExpressionStatement) String() string {
@@ -273,10 +370,16 @@ type InfixExpression struct {
 	Next         Expression
 }
 
-func (ie *InfixExpression) Pos() token.Position { return ie.Token.Pos }
-func (ie *InfixExpression) End() token.Position { return ie.Right.End() }
+func (ie *InfixExpression) Pos() token.Position { return ie.Left.Pos() }
+func (ie *InfixExpression) End() token.Position {
+	if ie.HasNext { //a < b < c
+		return ie.Next.End()
+	}
+	return ie.Right.End()
+}
 
 func (ie *InfixExpression) expressionNode()      {}
+func (ie *InfixExpression) NodeType() string     { return "InfixExpression" }
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
@@ -296,6 +399,61 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+//start..end, start..=end, start..<end
+//We could not use `Start` & `End` as field names, because `End()` is the Node interface's method
+type RangeExpression struct {
+	Token     token.Token // the '..', '..=' or '..<' token
+	StartIdx  Expression
+	EndIdx    Expression
+	Inclusive bool //false if it's '..<'
+}
+
+func (re *RangeExpression) Pos() token.Position { return re.StartIdx.Pos() }
+func (re *RangeExpression) End() token.Position { return re.EndIdx.End() }
+
+func (re *RangeExpression) expressionNode()      {}
+func (re *RangeExpression) NodeType() string     { return "RangeExpression" }
+func (re *RangeExpression) TokenLiteral() string { return re.Token.Literal }
+func (re *RangeExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(re.StartIdx.String())
+	out.WriteString(re.Token.Literal)
+	out.WriteString(re.EndIdx.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+//cond ? a : b
+type TernaryExpression struct {
+	Token     token.Token // the '?' token
+	Condition Expression
+	IfTrue    Expression
+	IfFalse   Expression
+}
+
+func (te *TernaryExpression) Pos() token.Position { return te.Condition.Pos() }
+func (te *TernaryExpression) End() token.Position { return te.IfFalse.End() }
+
+func (te *TernaryExpression) expressionNode()      {}
+func (te *TernaryExpression) NodeType() string     { return "TernaryExpression" }
+func (te *TernaryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TernaryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(te.Condition.String())
+	out.WriteString(" ? ")
+	out.WriteString(te.IfTrue.String())
+	out.WriteString(" : ")
+	out.WriteString(te.IfFalse.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 // -2, -3
 type PrefixExpression struct {
 	Token    token.Token
@@ -307,6 +465,7 @@ func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
 func (pe *PrefixExpression) End() token.Position { return pe.Right.End() }
 
 func (pe *PrefixExpression) expressionNode()      {}
+func (pe *PrefixExpression) NodeType() string     { return "PrefixExpression" }
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
 
 func (pe *PrefixExpression) String() string {
@@ -314,6 +473,9 @@ func (pe *PrefixExpression) String() string {
 
 	out.WriteString("(")
 	out.WriteString(pe.Operator)
+	if pe.Token.Type == token.TOKEN_NOT {
+		out.WriteString(" ")
+	}
 	out.WriteString(pe.Right.String())
 	out.WriteString(")")
 
@@ -327,7 +489,7 @@ type PostfixExpression struct {
 }
 
 func (pe *PostfixExpression) Pos() token.Position {
-	return pe.Token.Pos
+	return pe.Left.Pos()
 }
 
 func (pe *PostfixExpression) End() token.Position {
@@ -338,6 +500,8 @@ func (pe *PostfixExpression) End() token.Position {
 
 func (pe *PostfixExpression) expressionNode() {}
 
+func (pe *PostfixExpression) NodeType() string { return "PostfixExpression" }
+
 func (pe *PostfixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
@@ -356,6 +520,8 @@ func (pe *PostfixExpression) String() string {
 type NumberLiteral struct {
 	Token token.Token
 	Value float64
+	IsInt bool  //the literal has no '.' or exponent, e.g. 5, 0xFF, and it fits in an int64
+	Int   int64 //the exact value if IsInt, 'Value' may lose precision for the integers beyond 2^53
 }
 
 func (nl *NumberLiteral) Pos() token.Position { return nl.Token.Pos }
@@ -366,9 +532,28 @@ func (nl *NumberLiteral) End() token.Position {
 }
 
 func (nl *NumberLiteral) expressionNode()      {}
+func (nl *NumberLiteral) NodeType() string     { return "NumberLiteral" }
 func (nl *NumberLiteral) TokenLiteral() string { return nl.Token.Literal }
 func (nl *NumberLiteral) String() string       { return nl.Token.Literal }
 
+//'a', '\n', '\u00e9'
+type CharLiteral struct {
+	Token token.Token
+	Value rune //the codepoint
+}
+
+func (cl *CharLiteral) Pos() token.Position { return cl.Token.Pos }
+func (cl *CharLiteral) End() token.Position {
+	length := utf8.RuneCountInString(cl.String())
+	pos := cl.Token.Pos
+	return token.Position{Filename: pos.Filename, Line: pos.Line, Col: pos.Col + length}
+}
+
+func (cl *CharLiteral) expressionNode()      {}
+func (cl *CharLiteral) NodeType() string     { return "CharLiteral" }
+func (cl *CharLiteral) TokenLiteral() string { return cl.Token.Literal }
+func (cl *CharLiteral) String() string       { return strconv.QuoteRune(cl.Value) }
+
 type Identifier struct {
 	Token token.Token
 	Value string
@@ -380,6 +565,7 @@ func (i *Identifier) End() token.Position {
 	return token.Position{Filename: i.Token.Pos.Filename, Line: i.Token.Pos.Line, Col: i.Token.Pos.Col + length}
 }
 func (i *Identifier) expressionNode()      {}
+func (i *Identifier) NodeType() string     { return "Identifier" }
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
 
@@ -398,6 +584,7 @@ func (n *NilLiteral) End() token.Position {
 }
 
 func (n *NilLiteral) expressionNode()      {}
+func (n *NilLiteral) NodeType() string     { return "NilLiteral" }
 func (n *NilLiteral) TokenLiteral() string { return n.Token.Literal }
 func (n *NilLiteral) String() string       { return n.Token.Literal }
 
@@ -417,12 +604,19 @@ func (b *BooleanLiteral) End() token.Position {
 }
 
 func (b *BooleanLiteral) expressionNode()      {}
+func (b *BooleanLiteral) NodeType() string     { return "BooleanLiteral" }
 func (b *BooleanLiteral) TokenLiteral() string { return b.Token.Literal }
 func (b *BooleanLiteral) String() string       { return b.Token.Literal }
 
 type StringLiteral struct {
-	Token token.Token
-	Value string
+	Token   token.Token
+	Value   string //the escape sequences are decoded
+	Raw     bool   //```raw string```, no escape processing and interpolation
+	Segment bool   //a text segment of an InterpolatedStringLiteral, it has no quotes
+
+	//the byte offsets in Value of the '$' written as an escape, e.g. "cost \$5", "\x24name",
+	//which do not start a '$name' interpolation
+	EscapedDollars []int
 }
 
 func (s *StringLiteral) Pos() token.Position {
@@ -430,20 +624,73 @@ func (s *StringLiteral) Pos() token.Position {
 }
 
 func (s *StringLiteral) End() token.Position {
-	length := utf8.RuneCountInString(s.Value)
-	return token.Position{Filename: s.Token.Pos.Filename, Line: s.Token.Pos.Line, Col: s.Token.Pos.Col + length}
+	quote := 1 //the length of the quotes
+	if s.Raw {
+		quote = 3
+	} else if s.Segment {
+		quote = 0
+	}
+
+	pos := s.Token.Pos
+	lines := strings.Split(s.Token.Literal, "\n") //the escape sequences are not decoded, a raw string could have newlines
+	if len(lines) == 1 {
+		return token.Position{Filename: pos.Filename, Line: pos.Line, Col: pos.Col + utf8.RuneCountInString(s.Token.Literal) + 2*quote}
+	}
+	last := lines[len(lines)-1]
+	return token.Position{Filename: pos.Filename, Line: pos.Line + len(lines) - 1, Col: utf8.RuneCountInString(last) + quote + 1}
 }
 
 func (s *StringLiteral) expressionNode()      {}
+func (s *StringLiteral) NodeType() string     { return "StringLiteral" }
 func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
-func (s *StringLiteral) String() string       { return s.Token.Literal }
+func (s *StringLiteral) String() string       { return s.Value }
+
+//"hello ${name}, you have ${count+1} msgs"
+//The quotes of a string inside '${...}' must be escaped, e.g. "${h[\"a\"]}".
+type InterpolatedStringLiteral struct {
+	Token token.Token
+	Parts []Expression //literal segments are *StringLiteral, others are the embedded expressions
+}
+
+func (is *InterpolatedStringLiteral) Pos() token.Position {
+	return is.Token.Pos
+}
+
+func (is *InterpolatedStringLiteral) End() token.Position {
+	length := utf8.RuneCountInString(is.Token.Literal) + 2 //the quotes
+	return token.Position{Filename: is.Token.Pos.Filename, Line: is.Token.Pos.Line, Col: is.Token.Pos.Col + length}
+}
+
+func (is *InterpolatedStringLiteral) expressionNode()      {}
+func (is *InterpolatedStringLiteral) NodeType() string     { return "InterpolatedStringLiteral" }
+func (is *InterpolatedStringLiteral) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedStringLiteral) String() string {
+	var out bytes.Buffer
+
+	for _, part := range is.Parts {
+		if s, ok := part.(*StringLiteral); ok {
+			out.WriteString(s.Value)
+			continue
+		}
+		out.WriteString("${")
+		out.WriteString(part.String())
+		out.WriteString("}")
+	}
+
+	return out.String()
+}
 
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
 	Name       string      // function's name
 	Parameters []*Identifier
+	Defaults   []Expression // 'fn f(a, b = 1)', the default values of the parameters, nil for the required ones
 	Variadic   bool
+	Async      bool        // 'async fn'
+	Static     bool        // 'static fn' inside a struct, called on the struct type: Type.method()
+	ReturnType *Identifier // 'fn f(): Number {}', nil if not annotated. It is not checked.
 	Body       *BlockStatement
+	Where      []*LetStatement // 'fn f() { ... } where a = 1, b = 2', evaluated before the body
 }
 
 func (fl *FunctionLiteral) Pos() token.Position {
@@ -451,19 +698,33 @@ func (fl *FunctionLiteral) Pos() token.Position {
 }
 
 func (fl *FunctionLiteral) End() token.Position {
+	if len(fl.Where) > 0 {
+		return fl.Where[len(fl.Where)-1].End()
+	}
 	return fl.Body.End()
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
+func (fl *FunctionLiteral) NodeType() string     { return "FunctionLiteral" }
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
+	for i, p := range fl.Parameters {
+		if fl.Defaults != nil && fl.Defaults[i] != nil {
+			params = append(params, p.String()+" = "+fl.Defaults[i].String())
+			continue
+		}
 		params = append(params, p.String())
 	}
 
+	if fl.Static {
+		out.WriteString("static ")
+	}
+	if fl.Async {
+		out.WriteString("async ")
+	}
 	out.WriteString(fl.TokenLiteral())
 	if fl.Name != "" {
 		out.WriteString(" ")
@@ -474,16 +735,54 @@ func (fl *FunctionLiteral) String() string {
 	if fl.Variadic {
 		out.WriteString("...")
 	}
-	out.WriteString(") {")
+	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(": ")
+		out.WriteString(fl.ReturnType.String())
+	}
+	out.WriteString(" {")
 	out.WriteString(fl.Body.String())
 	out.WriteString("}")
 
+	if len(fl.Where) > 0 {
+		bindings := []string{}
+		for _, w := range fl.Where {
+			bindings = append(bindings, w.Names[0].String()+" = "+w.Values[0].String())
+		}
+		out.WriteString(" where ")
+		out.WriteString(strings.Join(bindings, ", "))
+	}
+
+	return out.String()
+}
+
+//await <expression>
+type AwaitExpression struct {
+	Token token.Token // the 'await' token
+	Value Expression
+}
+
+func (ae *AwaitExpression) Pos() token.Position { return ae.Token.Pos }
+func (ae *AwaitExpression) End() token.Position { return ae.Value.End() }
+
+func (ae *AwaitExpression) expressionNode()      {}
+func (ae *AwaitExpression) NodeType() string     { return "AwaitExpression" }
+func (ae *AwaitExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AwaitExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.TokenLiteral() + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
 	return out.String()
 }
 
 type ArrayLiteral struct {
-	Token   token.Token
-	Members []Expression
+	Token         token.Token
+	Members       []Expression
+	RBracketToken token.Token
 }
 
 func (a *ArrayLiteral) Pos() token.Position {
@@ -491,6 +790,9 @@ func (a *ArrayLiteral) Pos() token.Position {
 }
 
 func (a *ArrayLiteral) End() token.Position {
+	if a.RBracketToken.Type == token.TOKEN_RBRACKET {
+		return token.Position{Filename: a.Token.Pos.Filename, Line: a.RBracketToken.Pos.Line, Col: a.RBracketToken.Pos.Col + 1}
+	}
 	aLen := len(a.Members)
 	if aLen > 0 {
 		return a.Members[aLen-1].End()
@@ -499,6 +801,7 @@ func (a *ArrayLiteral) End() token.Position {
 }
 
 func (a *ArrayLiteral) expressionNode()      {}
+func (a *ArrayLiteral) NodeType() string     { return "ArrayLiteral" }
 func (a *ArrayLiteral) TokenLiteral() string { return a.Token.Literal }
 func (a *ArrayLiteral) String() string {
 	var out bytes.Buffer
@@ -514,9 +817,64 @@ func (a *ArrayLiteral) String() string {
 	return out.String()
 }
 
+//[expr for x in xs for y in ys]
+type ArrayComprehension struct {
+	Token         token.Token // The '[' token
+	Expr          Expression
+	Clauses       []*ComprehensionClause //nested from left to right
+	RBracketToken token.Token
+}
+
+func (ac *ArrayComprehension) Pos() token.Position {
+	return ac.Token.Pos
+}
+
+func (ac *ArrayComprehension) End() token.Position {
+	return token.Position{Filename: ac.Token.Pos.Filename, Line: ac.RBracketToken.Pos.Line, Col: ac.RBracketToken.Pos.Col + 1}
+}
+
+func (ac *ArrayComprehension) expressionNode()      {}
+func (ac *ArrayComprehension) NodeType() string     { return "ArrayComprehension" }
+func (ac *ArrayComprehension) TokenLiteral() string { return ac.Token.Literal }
+func (ac *ArrayComprehension) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("[")
+	out.WriteString(ac.Expr.String())
+	for _, c := range ac.Clauses {
+		out.WriteString(" ")
+		out.WriteString(c.String())
+	}
+	out.WriteString("]")
+	return out.String()
+}
+
+//the 'for x in xs' part of an array comprehension
+type ComprehensionClause struct {
+	Token token.Token // The 'for' token
+	Var   string
+	Value Expression //value to range over
+}
+
+func (cc *ComprehensionClause) Pos() token.Position {
+	return cc.Token.Pos
+}
+
+func (cc *ComprehensionClause) End() token.Position {
+	return cc.Value.End()
+}
+
+func (cc *ComprehensionClause) expressionNode()      {}
+func (cc *ComprehensionClause) NodeType() string     { return "ComprehensionClause" }
+func (cc *ComprehensionClause) TokenLiteral() string { return cc.Token.Literal }
+func (cc *ComprehensionClause) String() string {
+	return "for " + cc.Var + " in " + cc.Value.String()
+}
+
 type TupleLiteral struct {
-	Token   token.Token
-	Members []Expression
+	Token       token.Token
+	Members     []Expression
+	RParenToken token.Token
 }
 
 func (t *TupleLiteral) Pos() token.Position {
@@ -524,6 +882,9 @@ func (t *TupleLiteral) Pos() token.Position {
 }
 
 func (t *TupleLiteral) End() token.Position {
+	if t.RParenToken.Type == token.TOKEN_RPAREN {
+		return token.Position{Filename: t.Token.Pos.Filename, Line: t.RParenToken.Pos.Line, Col: t.RParenToken.Pos.Col + 1}
+	}
 	tLen := len(t.Members)
 	if tLen > 0 {
 		return t.Members[tLen-1].End()
@@ -532,6 +893,7 @@ func (t *TupleLiteral) End() token.Position {
 }
 
 func (t *TupleLiteral) expressionNode()      {}
+func (t *TupleLiteral) NodeType() string     { return "TupleLiteral" }
 func (t *TupleLiteral) TokenLiteral() string { return t.Token.Literal }
 func (t *TupleLiteral) String() string {
 	var out bytes.Buffer
@@ -551,25 +913,81 @@ func (t *TupleLiteral) String() string {
 
 //<Left-Expression>[<Index-Expression>]
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token         token.Token
+	Left          Expression
+	Index         Expression
+	Optional      bool //a?[i], returns nil if 'a' is nil
+	RBracketToken token.Token
+}
+
+//a[low:high], a[low:high:step], every part could be omitted, e.g. a[:2], a[1:], a[:]
+type SliceExpression struct {
+	Token         token.Token //the '[' or '?[' token
+	Left          Expression
+	Low           Expression //nil if omitted
+	High          Expression //nil if omitted
+	Step          Expression //nil if omitted
+	Optional      bool       //a?[1:2], returns nil if 'a' is nil
+	RBracketToken token.Token
+}
+
+func (se *SliceExpression) Pos() token.Position {
+	return se.Left.Pos()
+}
+
+func (se *SliceExpression) End() token.Position {
+	pos := se.RBracketToken.Pos
+	return token.Position{Filename: pos.Filename, Line: pos.Line, Col: pos.Col + 1}
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) NodeType() string     { return "SliceExpression" }
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	if se.Optional {
+		out.WriteString("?")
+	}
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	if se.Step != nil {
+		out.WriteString(":")
+		out.WriteString(se.Step.String())
+	}
+	out.WriteString("]")
+	out.WriteString(")")
+	return out.String()
 }
 
 func (ie *IndexExpression) Pos() token.Position {
-	return ie.Token.Pos
+	return ie.Left.Pos()
 }
 
 func (ie *IndexExpression) End() token.Position {
+	if ie.RBracketToken.Type == token.TOKEN_RBRACKET {
+		return token.Position{Filename: ie.Token.Pos.Filename, Line: ie.RBracketToken.Pos.Line, Col: ie.RBracketToken.Pos.Col + 1}
+	}
 	return ie.Index.End()
 }
 
 func (ie *IndexExpression) expressionNode()      {}
+func (ie *IndexExpression) NodeType() string     { return "IndexExpression" }
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
 	out.WriteString(ie.Left.String())
+	if ie.Optional {
+		out.WriteString("?")
+	}
 	out.WriteString("[")
 	out.WriteString(ie.Index.String())
 	out.WriteString("]")
@@ -594,6 +1012,7 @@ func (h *HashLiteral) End() token.Position {
 }
 
 func (h *HashLiteral) expressionNode()      {}
+func (h *HashLiteral) NodeType() string     { return "HashLiteral" }
 func (h *HashLiteral) TokenLiteral() string { return h.Token.Literal }
 func (h *HashLiteral) String() string {
 	var out bytes.Buffer
@@ -622,22 +1041,28 @@ type CallExpression struct {
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
 	Variadic  bool
+	Optional  bool // f?.(), returns nil if 'f' is nil
+
+	RParenToken token.Token //the ')', not set for a call without parentheses, e.g. arr.each { ... }
 }
 
 func (ce *CallExpression) Pos() token.Position {
-	length := utf8.RuneCountInString(ce.Function.String())
-	return token.Position{Filename: ce.Token.Pos.Filename, Line: ce.Token.Pos.Line, Col: ce.Token.Pos.Col - length}
+	return ce.Function.Pos()
 }
 
 func (ce *CallExpression) End() token.Position {
 	aLen := len(ce.Arguments)
-	if aLen > 0 {
+	if aLen > 0 && ce.Arguments[aLen-1].Pos().Offset > ce.RParenToken.Pos.Offset { //a trailing closure, e.g. f(x) { y -> y }
 		return ce.Arguments[aLen-1].End()
 	}
+	if ce.RParenToken.Type == token.TOKEN_RPAREN {
+		return token.Position{Filename: ce.RParenToken.Pos.Filename, Line: ce.RParenToken.Pos.Line, Col: ce.RParenToken.Pos.Col + 1}
+	}
 	return ce.Function.End()
 }
 
 func (ce *CallExpression) expressionNode()      {}
+func (ce *CallExpression) NodeType() string     { return "CallExpression" }
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
@@ -648,6 +1073,9 @@ func (ce *CallExpression) String() string {
 	}
 
 	out.WriteString(ce.Function.String())
+	if ce.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(args, ", "))
 	if ce.Variadic {
@@ -657,14 +1085,32 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+//name: value, a named argument of a call, e.g. f(1, y: 2)
+type NamedArgument struct {
+	Token token.Token // The name's token
+	Name  *Identifier
+	Value Expression
+}
+
+func (na *NamedArgument) Pos() token.Position { return na.Name.Pos() }
+func (na *NamedArgument) End() token.Position { return na.Value.End() }
+
+func (na *NamedArgument) expressionNode()      {}
+func (na *NamedArgument) NodeType() string     { return "NamedArgument" }
+func (na *NamedArgument) TokenLiteral() string { return na.Token.Literal }
+func (na *NamedArgument) String() string {
+	return na.Name.String() + ": " + na.Value.String()
+}
+
 type MethodCallExpression struct {
-	Token  token.Token
-	Object Expression
-	Call   Expression
+	Token    token.Token
+	Object   Expression
+	Call     Expression
+	Optional bool //a?.b, a?.b(), returns nil if 'a' is nil
 }
 
 func (mc *MethodCallExpression) Pos() token.Position {
-	return mc.Token.Pos
+	return mc.Object.Pos()
 }
 
 func (mc *MethodCallExpression) End() token.Position {
@@ -672,16 +1118,48 @@ func (mc *MethodCallExpression) End() token.Position {
 }
 
 func (mc *MethodCallExpression) expressionNode()      {}
+func (mc *MethodCallExpression) NodeType() string     { return "MethodCallExpression" }
 func (mc *MethodCallExpression) TokenLiteral() string { return mc.Token.Literal }
 func (mc *MethodCallExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString(mc.Object.String())
-	out.WriteString(".")
+	if mc.Optional {
+		out.WriteString("?.")
+	} else {
+		out.WriteString(".")
+	}
 	out.WriteString(mc.Call.String())
 
 	return out.String()
 }
 
+//module::name, Type::member, e.g. math::sqrt(2), Color::Red
+type ScopeResolution struct {
+	Token  token.Token // The '::' token
+	Left   Expression
+	Member Expression // Identifier, or CallExpression for 'a::b(args)'
+}
+
+func (sr *ScopeResolution) Pos() token.Position {
+	return sr.Left.Pos()
+}
+
+func (sr *ScopeResolution) End() token.Position {
+	return sr.Member.End()
+}
+
+func (sr *ScopeResolution) expressionNode()      {}
+func (sr *ScopeResolution) NodeType() string     { return "ScopeResolution" }
+func (sr *ScopeResolution) TokenLiteral() string { return sr.Token.Literal }
+func (sr *ScopeResolution) String() string {
+	var out bytes.Buffer
+	out.WriteString(sr.Left.String())
+	out.WriteString("::")
+	out.WriteString(sr.Member.String())
+
+	return out.String()
+}
+
 type IfExpression struct {
 	Token       token.Token
 	Conditions  []*IfConditionExpr //if or else-if part
@@ -702,6 +1180,7 @@ func (ifex *IfExpression) End() token.Position {
 }
 
 func (ifex *IfExpression) expressionNode()      {}
+func (ifex *IfExpression) NodeType() string     { return "IfExpression" }
 func (ifex *IfExpression) TokenLiteral() string { return ifex.Token.Literal }
 
 func (ifex *IfExpression) String() string {
@@ -711,13 +1190,13 @@ func (ifex *IfExpression) String() string {
 		if i == 0 {
 			out.WriteString("if ")
 		} else {
-			out.WriteString("elif ")
+			out.WriteString(" elif ")
 		}
 		out.WriteString(c.String())
 	}
 
 	if ifex.Alternative != nil {
-		out.WriteString(" else ")
+		out.WriteString(" else")
 		out.WriteString(" { ")
 		out.WriteString(ifex.Alternative.String())
 		out.WriteString(" }")
@@ -742,6 +1221,7 @@ func (ic *IfConditionExpr) End() token.Position {
 }
 
 func (ic *IfConditionExpr) expressionNode()      {}
+func (ic *IfConditionExpr) NodeType() string     { return "IfConditionExpr" }
 func (ic *IfConditionExpr) TokenLiteral() string { return ic.Token.Literal }
 
 func (ic *IfConditionExpr) String() string {
@@ -775,6 +1255,7 @@ func (as *MultiAssignStatement) End() token.Position {
 }
 
 func (as *MultiAssignStatement) statementNode()       {}
+func (as *MultiAssignStatement) NodeType() string     { return "MultiAssignStatement" }
 func (as *MultiAssignStatement) TokenLiteral() string { return as.Token.Literal }
 
 func (as *MultiAssignStatement) String() string {
@@ -813,6 +1294,7 @@ func (ae *AssignExpression) End() token.Position {
 }
 
 func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) NodeType() string     { return "AssignExpression" }
 func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
 
 func (ae *AssignExpression) String() string {
@@ -826,6 +1308,35 @@ func (ae *AssignExpression) String() string {
 	return out.String()
 }
 
+//x := 5
+type DeclareAssignExpression struct {
+	Token token.Token
+	Name  *Identifier
+	Value Expression
+}
+
+func (de *DeclareAssignExpression) Pos() token.Position {
+	return de.Name.Pos()
+}
+
+func (de *DeclareAssignExpression) End() token.Position {
+	return de.Value.End()
+}
+
+func (de *DeclareAssignExpression) expressionNode()      {}
+func (de *DeclareAssignExpression) NodeType() string     { return "DeclareAssignExpression" }
+func (de *DeclareAssignExpression) TokenLiteral() string { return de.Token.Literal }
+
+func (de *DeclareAssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(de.Name.String())
+	out.WriteString(de.Token.Literal)
+	out.WriteString(de.Value.String())
+
+	return out.String()
+}
+
 type BreakExpression struct {
 	Token token.Token
 }
@@ -841,6 +1352,7 @@ func (be *BreakExpression) End() token.Position {
 }
 
 func (be *BreakExpression) expressionNode()      {}
+func (be *BreakExpression) NodeType() string     { return "BreakExpression" }
 func (be *BreakExpression) TokenLiteral() string { return be.Token.Literal }
 
 func (be *BreakExpression) String() string { return be.Token.Literal }
@@ -863,6 +1375,7 @@ func (ce *ContinueExpression) End() token.Position {
 }
 
 func (ce *ContinueExpression) expressionNode()      {}
+func (ce *ContinueExpression) NodeType() string     { return "ContinueExpression" }
 func (ce *ContinueExpression) TokenLiteral() string { return ce.Token.Literal }
 
 func (ce *ContinueExpression) String() string { return ce.Token.Literal }
@@ -885,6 +1398,7 @@ func (fl *CForLoop) End() token.Position {
 }
 
 func (fl *CForLoop) expressionNode()      {}
+func (fl *CForLoop) NodeType() string     { return "CForLoop" }
 func (fl *CForLoop) TokenLiteral() string { return fl.Token.Literal }
 
 func (fl *CForLoop) String() string {
@@ -931,6 +1445,7 @@ func (fal *ForEachArrayLoop) End() token.Position {
 }
 
 func (fal *ForEachArrayLoop) expressionNode()      {}
+func (fal *ForEachArrayLoop) NodeType() string     { return "ForEachArrayLoop" }
 func (fal *ForEachArrayLoop) TokenLiteral() string { return fal.Token.Literal }
 
 func (fal *ForEachArrayLoop) String() string {
@@ -965,6 +1480,7 @@ func (fml *ForEachMapLoop) End() token.Position {
 }
 
 func (fml *ForEachMapLoop) expressionNode()      {}
+func (fml *ForEachMapLoop) NodeType() string     { return "ForEachMapLoop" }
 func (fml *ForEachMapLoop) TokenLiteral() string { return fml.Token.Literal }
 
 func (fml *ForEachMapLoop) String() string {
@@ -996,6 +1512,7 @@ func (fel *ForEverLoop) End() token.Position {
 }
 
 func (fel *ForEverLoop) expressionNode()      {}
+func (fel *ForEverLoop) NodeType() string     { return "ForEverLoop" }
 func (fel *ForEverLoop) TokenLiteral() string { return fel.Token.Literal }
 
 func (fel *ForEverLoop) String() string {
@@ -1025,6 +1542,7 @@ func (wl *WhileLoop) End() token.Position {
 }
 
 func (wl *WhileLoop) expressionNode()      {}
+func (wl *WhileLoop) NodeType() string     { return "WhileLoop" }
 func (wl *WhileLoop) TokenLiteral() string { return wl.Token.Literal }
 
 func (wl *WhileLoop) String() string {
@@ -1054,6 +1572,7 @@ func (dl *DoLoop) End() token.Position {
 }
 
 func (dl *DoLoop) expressionNode()      {}
+func (dl *DoLoop) NodeType() string     { return "DoLoop" }
 func (dl *DoLoop) TokenLiteral() string { return dl.Token.Literal }
 
 func (dl *DoLoop) String() string {
@@ -1066,6 +1585,34 @@ func (dl *DoLoop) String() string {
 	return out.String()
 }
 
+//let x = do { block }
+type DoExpression struct {
+	Token token.Token
+	Block *BlockStatement
+}
+
+func (de *DoExpression) Pos() token.Position {
+	return de.Token.Pos
+}
+
+func (de *DoExpression) End() token.Position {
+	return de.Block.End()
+}
+
+func (de *DoExpression) expressionNode()      {}
+func (de *DoExpression) NodeType() string     { return "DoExpression" }
+func (de *DoExpression) TokenLiteral() string { return de.Token.Literal }
+
+func (de *DoExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(do")
+	out.WriteString(" { ")
+	out.WriteString(de.Block.String())
+	out.WriteString(" })")
+	return out.String()
+}
+
 type RegExLiteral struct {
 	Token token.Token
 	Value string // value of the regular expression
@@ -1076,12 +1623,16 @@ func (rel *RegExLiteral) Pos() token.Position {
 }
 
 func (rel *RegExLiteral) End() token.Position {
-	length := utf8.RuneCountInString(rel.Value)
+	length := utf8.RuneCountInString(rel.Value) + 2 //the slashes
+	if strings.HasPrefix(rel.Value, "(?") {         //the lexer turns '/x/i' into '(?i)x'
+		length -= 3
+	}
 	pos := rel.Token.Pos
 	return token.Position{Filename: pos.Filename, Line: pos.Line, Col: pos.Col + length}
 }
 
 func (rel *RegExLiteral) expressionNode()      {}
+func (rel *RegExLiteral) NodeType() string     { return "RegExLiteral" }
 func (rel *RegExLiteral) TokenLiteral() string { return rel.Token.Literal }
 func (rel *RegExLiteral) String() string {
 	reg := rel.Value
@@ -1096,10 +1647,11 @@ func (rel *RegExLiteral) String() string {
 }
 
 type StructStatement struct {
-	Token token.Token
-	Name  string //struct's name
+	Token  token.Token
+	Name   string         //struct's name
+	Fields []*StructField //field declarations
 
-	Block       *BlockStatement //used in the String() method
+	Block       *BlockStatement //methods and other statements
 	RBraceToken token.Token     //used in End() method
 }
 
@@ -1112,6 +1664,7 @@ func (s *StructStatement) End() token.Position {
 }
 
 func (s *StructStatement) statementNode()       {}
+func (s *StructStatement) NodeType() string     { return "StructStatement" }
 func (s *StructStatement) TokenLiteral() string { return s.Token.Literal }
 func (s *StructStatement) String() string {
 	var out bytes.Buffer
@@ -1120,12 +1673,123 @@ func (s *StructStatement) String() string {
 	out.WriteString(s.Name)
 
 	out.WriteString("{ ")
+	for _, field := range s.Fields {
+		out.WriteString(field.String())
+		out.WriteString(";")
+	}
 	out.WriteString(s.Block.String())
 	out.WriteString(" }")
 
 	return out.String()
 }
 
+//StaticMethod returns the static method with the given name, or nil if there is no such static method.
+func (s *StructStatement) StaticMethod(name string) *FunctionLiteral {
+	for _, stmt := range s.Block.Statements {
+		if es, ok := stmt.(*ExpressionStatement); ok {
+			if fn, ok := es.Expression.(*FunctionLiteral); ok && fn.Static && fn.Name == name {
+				return fn
+			}
+		}
+	}
+	return nil
+}
+
+//a destructured name of a let statement: name [= default], e.g. 'x = 0' in 'let {x = 0, y} = point'
+type PatternElement struct {
+	Name    *Identifier
+	Default Expression //nil if no default value supplied, used when the member is absent or nil
+}
+
+func (pe *PatternElement) Pos() token.Position { return pe.Name.Pos() }
+func (pe *PatternElement) End() token.Position {
+	if pe.Default != nil {
+		return pe.Default.End()
+	}
+	return pe.Name.End()
+}
+
+func (pe *PatternElement) NodeType() string     { return "PatternElement" }
+func (pe *PatternElement) TokenLiteral() string { return pe.Name.TokenLiteral() }
+func (pe *PatternElement) String() string {
+	if pe.Default == nil {
+		return pe.Name.String()
+	}
+	return pe.Name.String() + " = " + pe.Default.String()
+}
+
+//let {x, y = 0} = point: the names are the keys of the hash
+type HashPattern struct {
+	Token       token.Token // the '{' token
+	Elements    []*PatternElement
+	RBraceToken token.Token //used in End() method
+}
+
+func (hp *HashPattern) Pos() token.Position { return hp.Token.Pos }
+func (hp *HashPattern) End() token.Position {
+	return token.Position{Filename: hp.Token.Pos.Filename, Line: hp.RBraceToken.Pos.Line, Col: hp.RBraceToken.Pos.Col + 1}
+}
+
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) NodeType() string     { return "HashPattern" }
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) String() string {
+	return "{" + patternElementsString(hp.Elements) + "}"
+}
+
+//let [a, b = 2] = xs: the names are bound to the members of the array(or tuple) by position
+type ArrayPattern struct {
+	Token         token.Token // the '[' token
+	Elements      []*PatternElement
+	RBracketToken token.Token //used in End() method
+}
+
+func (ap *ArrayPattern) Pos() token.Position { return ap.Token.Pos }
+func (ap *ArrayPattern) End() token.Position {
+	return token.Position{Filename: ap.Token.Pos.Filename, Line: ap.RBracketToken.Pos.Line, Col: ap.RBracketToken.Pos.Col + 1}
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) NodeType() string     { return "ArrayPattern" }
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	return "[" + patternElementsString(ap.Elements) + "]"
+}
+
+func patternElementsString(elements []*PatternElement) string {
+	elems := []string{}
+	for _, e := range elements {
+		elems = append(elems, e.String())
+	}
+	return strings.Join(elems, ", ")
+}
+
+//field declaration inside struct: name [= default]
+type StructField struct {
+	Name    *Identifier
+	Default Expression //nil if no default value supplied
+}
+
+func (sf *StructField) Pos() token.Position {
+	return sf.Name.Pos()
+}
+
+func (sf *StructField) End() token.Position {
+	if sf.Default != nil {
+		return sf.Default.End()
+	}
+	return sf.Name.End()
+}
+
+func (sf *StructField) NodeType() string     { return "StructField" }
+func (sf *StructField) TokenLiteral() string { return sf.Name.TokenLiteral() }
+func (sf *StructField) String() string {
+	if sf.Default == nil {
+		return sf.Name.String()
+	}
+	return sf.Name.String() + " = " + sf.Default.String()
+}
+
 /*
     switch Expr {
     case expr1, expr2, ... { block1 }
@@ -1150,6 +1814,7 @@ func (se *SwitchExpression) End() token.Position {
 }
 
 func (se *SwitchExpression) expressionNode()      {}
+func (se *SwitchExpression) NodeType() string     { return "SwitchExpression" }
 func (se *SwitchExpression) TokenLiteral() string { return se.Token.Literal }
 func (se *SwitchExpression) String() string {
 	var out bytes.Buffer
@@ -1188,7 +1853,19 @@ func (ce *CaseExpression) End() token.Position {
 	return ce.RBraceToken.Pos
 }
 
+//HasLabel reports whether the case has the label, e.g. 'case 1, 3' has the label '3'.
+//It is used for finding the target of 'fallthrough case 3'.
+func (ce *CaseExpression) HasLabel(label string) bool {
+	for _, expr := range ce.Exprs {
+		if expr.String() == label {
+			return true
+		}
+	}
+	return false
+}
+
 func (ce *CaseExpression) expressionNode()      {}
+func (ce *CaseExpression) NodeType() string     { return "CaseExpression" }
 func (ce *CaseExpression) TokenLiteral() string { return ce.Token.Literal }
 func (ce *CaseExpression) String() string {
 	var out bytes.Buffer
@@ -1208,8 +1885,101 @@ func (ce *CaseExpression) String() string {
 	return out.String()
 }
 
+//the type pattern of a match arm, e.g. 'is number => x + 1'
+type TypePattern struct {
+	Token token.Token //the 'is'
+	Type  *Identifier
+}
+
+func (tp *TypePattern) Pos() token.Position {
+	return tp.Token.Pos
+}
+
+func (tp *TypePattern) End() token.Position {
+	return tp.Type.End()
+}
+
+func (tp *TypePattern) expressionNode()      {}
+func (tp *TypePattern) NodeType() string     { return "TypePattern" }
+func (tp *TypePattern) TokenLiteral() string { return tp.Token.Literal }
+func (tp *TypePattern) String() string       { return "is " + tp.Type.String() }
+
+/*
+    match Expr {
+        is number => expr1,
+        is string => expr2,
+        _         => expr3
+    }
+*/
+type MatchExpression struct {
+	Token       token.Token
+	Expr        Expression
+	Arms        []*MatchArm
+	RBraceToken token.Token //used in End() method
+}
+
+func (me *MatchExpression) Pos() token.Position {
+	return me.Token.Pos
+}
+
+func (me *MatchExpression) End() token.Position {
+	return token.Position{Filename: me.Token.Pos.Filename, Line: me.RBraceToken.Pos.Line, Col: me.RBraceToken.Pos.Col + 1}
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) NodeType() string     { return "MatchExpression" }
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("match ")
+	out.WriteString(me.Expr.String())
+	out.WriteString(" { ")
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+/*
+   is number => expr
+   1         => expr
+   _         => expr
+*/
+type MatchArm struct {
+	Token   token.Token //the '=>'
+	Pattern Expression  //a *TypePattern, the wildcard '_', or a value
+	Body    Expression
+}
+
+func (ma *MatchArm) Pos() token.Position {
+	return ma.Pattern.Pos()
+}
+
+func (ma *MatchArm) End() token.Position {
+	return ma.Body.End()
+}
+
+//IsWildcard reports whether the arm is '_ => expr', which matches any value.
+func (ma *MatchArm) IsWildcard() bool {
+	ident, ok := ma.Pattern.(*Identifier)
+	return ok && ident.Value == "_"
+}
+
+func (ma *MatchArm) expressionNode()      {}
+func (ma *MatchArm) NodeType() string     { return "MatchArm" }
+func (ma *MatchArm) TokenLiteral() string { return ma.Token.Literal }
+func (ma *MatchArm) String() string {
+	return ma.Pattern.String() + " => " + ma.Body.String()
+}
+
 type FallthroughExpression struct {
-	Token token.Token
+	Token  token.Token
+	Target Expression //the label of 'fallthrough case 3', nil if falls through to the next case
 }
 
 //t: through
@@ -1218,15 +1988,24 @@ func (t *FallthroughExpression) Pos() token.Position {
 }
 
 func (t *FallthroughExpression) End() token.Position {
+	if t.Target != nil {
+		return t.Target.End()
+	}
 	length := utf8.RuneCountInString(t.Token.Literal)
 	pos := t.Token.Pos
 	return token.Position{Filename: pos.Filename, Line: pos.Line, Col: pos.Col + length}
 }
 
 func (t *FallthroughExpression) expressionNode()      {}
+func (t *FallthroughExpression) NodeType() string     { return "FallthroughExpression" }
 func (t *FallthroughExpression) TokenLiteral() string { return t.Token.Literal }
 
-func (t *FallthroughExpression) String() string { return t.Token.Literal }
+func (t *FallthroughExpression) String() string {
+	if t.Target != nil {
+		return t.Token.Literal + " case " + t.Target.String()
+	}
+	return t.Token.Literal
+}
 
 //TryStmt provide "try/catch/finally" statement.
 /*
@@ -1261,6 +2040,7 @@ func (t *TryStmt) End() token.Position {
 }
 
 func (t *TryStmt) statementNode()       {}
+func (t *TryStmt) NodeType() string     { return "TryStmt" }
 func (t *TryStmt) TokenLiteral() string { return t.Token.Literal }
 
 func (t *TryStmt) String() string {
@@ -1304,6 +2084,7 @@ func (ts *ThrowStmt) End() token.Position {
 }
 
 func (ts *ThrowStmt) statementNode()       {}
+func (ts *ThrowStmt) NodeType() string     { return "ThrowStmt" }
 func (ts *ThrowStmt) TokenLiteral() string { return ts.Token.Literal }
 
 func (ts *ThrowStmt) String() string {
@@ -1333,6 +2114,7 @@ func (dc *DecoratorExpr) End() token.Position {
 }
 
 func (dc *DecoratorExpr) expressionNode()      {}
+func (dc *DecoratorExpr) NodeType() string     { return "DecoratorExpr" }
 func (dc *DecoratorExpr) TokenLiteral() string { return dc.Token.Literal }
 func (dc *DecoratorExpr) String() string {
 	var out bytes.Buffer
@@ -1345,6 +2127,8 @@ func (dc *DecoratorExpr) String() string {
 	return out.String()
 }
 
+//`ls ${dir}`
+//'Value' is the raw command text, the interpolation('$dir' or '${dir}') is done by the evaluator.
 type CmdExpression struct {
 	Token token.Token
 	Value string
@@ -1355,10 +2139,11 @@ func (c *CmdExpression) Pos() token.Position {
 }
 
 func (c *CmdExpression) End() token.Position {
-	length := utf8.RuneCountInString(c.Value)
+	length := utf8.RuneCountInString(c.Value) + 2 //two backticks
 	return token.Position{Filename: c.Token.Pos.Filename, Line: c.Token.Pos.Line, Col: c.Token.Pos.Col + length}
 }
 
 func (c *CmdExpression) expressionNode()      {}
+func (c *CmdExpression) NodeType() string     { return "CmdExpression" }
 func (c *CmdExpression) TokenLiteral() string { return c.Token.Literal }
 func (c *CmdExpression) String() string       { return c.Value }
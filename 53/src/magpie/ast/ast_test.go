@@ -0,0 +1,104 @@
+package ast_test
+
+import (
+	"io/ioutil"
+	"magpie/ast"
+	"magpie/lexer"
+	"magpie/parser"
+	"magpie/token"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//NodeType is the name of the node's Go type, for every node of the test programs
+func TestNodeType(t *testing.T) {
+	seen := map[string]bool{}
+	for _, name := range []string{"program.mp", "format.mp"} {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Walk(parseProgram(t, string(src)), func(node ast.Node) bool {
+			expected := reflect.TypeOf(node).Elem().Name()
+			if node.NodeType() != expected {
+				t.Errorf("%s: expected NodeType() %q, got %q", node.String(), expected, node.NodeType())
+			}
+			seen[expected] = true
+			return true
+		})
+	}
+
+	for _, kind := range []string{"Program", "LetStatement", "InfixExpression", "FunctionLiteral", "MatchExpression", "MatchArm", "TypePattern"} {
+		if !seen[kind] {
+			t.Errorf("expected the test programs to have a %s", kind)
+		}
+	}
+}
+
+//a statement which prints as "", e.g. an expression statement of a stray ';', doesn't panic
+func TestBlockStringEmptyStatement(t *testing.T) {
+	block := &ast.BlockStatement{Statements: []ast.Statement{
+		&ast.ExpressionStatement{},
+		&ast.ExpressionStatement{Expression: &ast.Identifier{Value: "a"}},
+	}}
+	if got, expected := block.String(), ";a;"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	for _, input := range []string{"if x { ; }", "fn() { a;; b }", "while x { ; ; }"} {
+		p := parser.NewParser(lexer.NewLexer(input))
+		program := p.ParseProgram() //the stray ';' is reported, but the tree is still printable
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected an error for the stray ';'", input)
+		}
+		_ = program.String()
+	}
+}
+
+func TestEmptyProgram(t *testing.T) {
+	for _, input := range []string{"", " \n", "// comment", "/* comment */"} {
+		program := parseProgram(t, input)
+		if len(program.Statements) != 0 {
+			t.Fatalf("%q: expected no statements, got %d", input, len(program.Statements))
+		}
+		if program.Pos() != (token.Position{}) || program.End() != (token.Position{}) {
+			t.Errorf("%q: expected zero positions, got %+v and %+v", input, program.Pos(), program.End())
+		}
+		if program.TokenLiteral() != "" || program.String() != "" {
+			t.Errorf("%q: expected an empty literal and string, got %q and %q", input, program.TokenLiteral(), program.String())
+		}
+		if got := ast.Format(program); got != "" {
+			t.Errorf("%q: expected an empty program to be formatted as empty, got %q", input, got)
+		}
+		if _, err := ast.ToJSON(program); err != nil {
+			t.Errorf("%q: %s", input, err)
+		}
+		folded, _ := ast.Fold(ast.Clone(program))
+		ast.Walk(folded, func(node ast.Node) bool { return true })
+	}
+
+	//a 'return' at the end of the input, and a stray ';'
+	tests := []struct {
+		input    string
+		str      string
+		endCol   int //the end is on the first line
+		hasError bool
+	}{
+		{"return", "return ;", 7, false},
+		{";", "", 2, true},
+	}
+	for _, tt := range tests {
+		p := parser.NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		if hasError := len(p.Errors()) != 0; hasError != tt.hasError {
+			t.Errorf("%q: expected errors %t, got %q", tt.input, tt.hasError, p.Errors())
+		}
+		if program.String() != tt.str {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.str, program.String())
+		}
+		if end := program.End(); end.Line != 1 || end.Col != tt.endCol {
+			t.Errorf("%q: expected the end 1:%d, got %d:%d", tt.input, tt.endCol, end.Line, end.Col)
+		}
+	}
+}
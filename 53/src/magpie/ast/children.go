@@ -0,0 +1,216 @@
+package ast
+
+import (
+	"sort"
+)
+
+//children returns the direct child nodes of the node, in source order.
+//Nil children are skipped.
+func children(node Node) []Node {
+	var nodes []Node
+	addExpr := func(exprs ...Expression) {
+		for _, e := range exprs {
+			if e != nil {
+				nodes = append(nodes, e)
+			}
+		}
+	}
+	addBlock := func(blocks ...*BlockStatement) {
+		for _, b := range blocks {
+			if b != nil {
+				nodes = append(nodes, b)
+			}
+		}
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		paths := []string{}
+		for path := range n.Imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths) //map iteration order is random
+		for _, path := range paths {
+			nodes = append(nodes, n.Imports[path])
+		}
+		for _, s := range n.Statements {
+			nodes = append(nodes, s)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			nodes = append(nodes, s)
+		}
+	case *ExpressionStatement:
+		addExpr(n.Expression)
+	case *ImportStatement:
+		addExpr(n.Condition)
+	case *LetStatement:
+		addExpr(n.Pattern)
+		for _, name := range n.Names {
+			nodes = append(nodes, name)
+		}
+		addExpr(n.Values...)
+	case *HashPattern:
+		for _, e := range n.Elements {
+			nodes = append(nodes, e)
+		}
+	case *ArrayPattern:
+		for _, e := range n.Elements {
+			nodes = append(nodes, e)
+		}
+	case *PatternElement:
+		nodes = append(nodes, n.Name)
+		addExpr(n.Default)
+	case *ReturnStatement:
+		addExpr(n.ReturnValues...)
+	case *TailCallStatement:
+		addExpr(n.Call)
+	case *UseStatement:
+		nodes = append(nodes, n.Name)
+		addExpr(n.Value)
+		addBlock(n.Block)
+	case *MultiAssignStatement:
+		addExpr(n.Names...)
+		addExpr(n.Values...)
+	case *StructStatement:
+		for _, f := range n.Fields {
+			nodes = append(nodes, f)
+		}
+		addBlock(n.Block)
+	case *StructField:
+		nodes = append(nodes, n.Name)
+		addExpr(n.Default)
+	case *TryStmt:
+		addBlock(n.Try, n.Catch, n.Finally)
+	case *ThrowStmt:
+		addExpr(n.Expr)
+
+	case *InfixExpression:
+		addExpr(n.Left, n.Right, n.Next)
+	case *PrefixExpression:
+		addExpr(n.Right)
+	case *PostfixExpression:
+		addExpr(n.Left)
+	case *RangeExpression:
+		addExpr(n.StartIdx, n.EndIdx)
+	case *TypePattern:
+		nodes = append(nodes, n.Type)
+	case *SliceExpression:
+		addExpr(n.Left, n.Low, n.High, n.Step)
+	case *FallthroughExpression:
+		addExpr(n.Target)
+	case *TernaryExpression:
+		addExpr(n.Condition, n.IfTrue, n.IfFalse)
+	case *AssignExpression:
+		addExpr(n.Name, n.Value)
+	case *DeclareAssignExpression:
+		addExpr(n.Name, n.Value)
+	case *InterpolatedStringLiteral:
+		addExpr(n.Parts...)
+	case *FunctionLiteral:
+		for i, p := range n.Parameters {
+			nodes = append(nodes, p)
+			if n.Defaults != nil {
+				addExpr(n.Defaults[i])
+			}
+		}
+		if n.ReturnType != nil {
+			nodes = append(nodes, n.ReturnType)
+		}
+		addBlock(n.Body)
+		for _, w := range n.Where {
+			nodes = append(nodes, w)
+		}
+	case *AwaitExpression:
+		addExpr(n.Value)
+	case *ArrayLiteral:
+		addExpr(n.Members...)
+	case *ArrayComprehension:
+		addExpr(n.Expr)
+		for _, c := range n.Clauses {
+			nodes = append(nodes, c)
+		}
+	case *ComprehensionClause:
+		addExpr(n.Value)
+	case *TupleLiteral:
+		addExpr(n.Members...)
+	case *HashLiteral:
+		for _, key := range n.Order {
+			addExpr(key, n.Pairs[key])
+		}
+	case *IndexExpression:
+		addExpr(n.Left, n.Index)
+	case *CallExpression:
+		addExpr(n.Function)
+		addExpr(n.Arguments...)
+	case *NamedArgument:
+		addExpr(n.Value) //the name is not a reference to a variable
+	case *MethodCallExpression:
+		addExpr(n.Object, n.Call)
+	case *ScopeResolution:
+		addExpr(n.Left, n.Member)
+	case *IfExpression:
+		for _, c := range n.Conditions {
+			if c != nil {
+				nodes = append(nodes, c)
+			}
+		}
+		addBlock(n.Alternative)
+	case *IfConditionExpr:
+		addExpr(n.Cond)
+		addBlock(n.Body)
+	case *CForLoop:
+		addExpr(n.Init, n.Cond, n.Update)
+		addBlock(n.Block)
+	case *ForEachArrayLoop:
+		addExpr(n.Value)
+		addBlock(n.Block)
+	case *ForEachMapLoop:
+		addExpr(n.X)
+		addBlock(n.Block)
+	case *ForEverLoop:
+		addBlock(n.Block)
+	case *WhileLoop:
+		addExpr(n.Condition)
+		addBlock(n.Block)
+	case *DoLoop:
+		addBlock(n.Block)
+	case *DoExpression:
+		addBlock(n.Block)
+	case *SwitchExpression:
+		addExpr(n.Expr)
+		for _, c := range n.Cases {
+			if c != nil {
+				nodes = append(nodes, c)
+			}
+		}
+	case *CaseExpression:
+		addExpr(n.Exprs...)
+		addBlock(n.Block)
+	case *MatchExpression:
+		addExpr(n.Expr)
+		for _, arm := range n.Arms {
+			if arm != nil {
+				nodes = append(nodes, arm)
+			}
+		}
+	case *MatchArm:
+		addExpr(n.Pattern, n.Body)
+	case *DecoratorExpr:
+		addExpr(n.Decorator, n.Decorated)
+	}
+
+	return nodes
+}
+
+//NodeCounts returns the number of nodes of each kind in the tree(including the node itself),
+//the kind is the node's NodeType(), e.g. "InfixExpression".
+func NodeCounts(node Node) map[string]int {
+	counts := make(map[string]int)
+	Walk(node, func(n Node) bool {
+		counts[n.NodeType()]++
+		return true
+	})
+
+	return counts
+}
@@ -0,0 +1,438 @@
+package ast
+
+import "fmt"
+
+//Clone returns a deep copy of the node: the copy shares no nodes, slices or maps with the original,
+//so it could be modified(e.g. by a macro or decorator expansion) without affecting the original.
+//Tokens and positions are copied by value. Clone(nil) returns nil.
+func Clone(node Node) Node {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *Program:
+		c := *n
+		c.Statements = cloneStatements(n.Statements)
+		if n.Imports != nil {
+			c.Imports = make(map[string]*ImportStatement, len(n.Imports))
+			for path, imp := range n.Imports {
+				c.Imports[path] = Clone(imp).(*ImportStatement)
+			}
+		}
+		if n.Precedences != nil {
+			c.Precedences = make(map[string]int, len(n.Precedences))
+			for op, prec := range n.Precedences {
+				c.Precedences[op] = prec
+			}
+		}
+		return &c
+	case *ImportStatement:
+		c := *n
+		if n.Program != nil {
+			c.Program = Clone(n.Program).(*Program)
+		}
+		c.Condition = cloneExpression(n.Condition)
+		return &c
+	case *LetStatement:
+		c := *n
+		c.Names = cloneIdentifiers(n.Names)
+		c.Values = cloneExpressions(n.Values)
+		c.Pattern = cloneExpression(n.Pattern)
+		return &c
+	case *HashPattern:
+		c := *n
+		c.Elements = clonePatternElements(n.Elements)
+		return &c
+	case *ArrayPattern:
+		c := *n
+		c.Elements = clonePatternElements(n.Elements)
+		return &c
+	case *PatternElement:
+		c := *n
+		c.Name = cloneIdentifier(n.Name)
+		c.Default = cloneExpression(n.Default)
+		return &c
+	case *ReturnStatement:
+		c := *n
+		c.ReturnValues = cloneExpressions(n.ReturnValues)
+		if len(n.ReturnValues) > 0 && n.ReturnValue == n.ReturnValues[0] {
+			c.ReturnValue = c.ReturnValues[0] //keep them the same node as the parser does
+		} else {
+			c.ReturnValue = cloneExpression(n.ReturnValue)
+		}
+		return &c
+	case *TailCallStatement:
+		c := *n
+		c.Call = cloneExpression(n.Call)
+		return &c
+	case *UseStatement:
+		c := *n
+		c.Name = cloneIdentifier(n.Name)
+		c.Value = cloneExpression(n.Value)
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *ExportStatement:
+		c := *n
+		c.Names = append([]string(nil), n.Names...)
+		return &c
+	case *BlockStatement:
+		return cloneBlock(n)
+	case *ExpressionStatement:
+		c := *n
+		c.Expression = cloneExpression(n.Expression)
+		return &c
+	case *MultiAssignStatement:
+		c := *n
+		c.Names = cloneExpressions(n.Names)
+		c.Values = cloneExpressions(n.Values)
+		return &c
+	case *StructStatement:
+		c := *n
+		if n.Fields != nil {
+			c.Fields = make([]*StructField, len(n.Fields))
+			for i, f := range n.Fields {
+				c.Fields[i] = Clone(f).(*StructField)
+			}
+		}
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *StructField:
+		c := *n
+		c.Name = cloneIdentifier(n.Name)
+		c.Default = cloneExpression(n.Default)
+		return &c
+	case *TryStmt:
+		c := *n
+		c.Try = cloneBlock(n.Try)
+		c.Catch = cloneBlock(n.Catch)
+		c.Finally = cloneBlock(n.Finally)
+		return &c
+	case *ThrowStmt:
+		c := *n
+		c.Expr = cloneExpression(n.Expr)
+		return &c
+
+	//leaves
+	case *Identifier:
+		return cloneIdentifier(n)
+	case *NumberLiteral:
+		c := *n
+		return &c
+	case *CharLiteral:
+		c := *n
+		return &c
+	case *NilLiteral:
+		c := *n
+		return &c
+	case *BooleanLiteral:
+		c := *n
+		return &c
+	case *StringLiteral:
+		c := *n
+		c.EscapedDollars = append([]int(nil), n.EscapedDollars...)
+		return &c
+	case *RegExLiteral:
+		c := *n
+		return &c
+	case *CmdExpression:
+		c := *n
+		return &c
+	case *BreakExpression:
+		c := *n
+		return &c
+	case *ContinueExpression:
+		c := *n
+		return &c
+	case *FallthroughExpression:
+		c := *n
+		c.Target = cloneExpression(n.Target)
+		return &c
+
+	case *InfixExpression:
+		c := *n
+		c.Left = cloneExpression(n.Left)
+		c.Right = cloneExpression(n.Right)
+		c.Next = cloneExpression(n.Next)
+		return &c
+	case *PrefixExpression:
+		c := *n
+		c.Right = cloneExpression(n.Right)
+		return &c
+	case *PostfixExpression:
+		c := *n
+		c.Left = cloneExpression(n.Left)
+		return &c
+	case *RangeExpression:
+		c := *n
+		c.StartIdx = cloneExpression(n.StartIdx)
+		c.EndIdx = cloneExpression(n.EndIdx)
+		return &c
+	case *TypePattern:
+		c := *n
+		c.Type = cloneIdentifier(n.Type)
+		return &c
+	case *TernaryExpression:
+		c := *n
+		c.Condition = cloneExpression(n.Condition)
+		c.IfTrue = cloneExpression(n.IfTrue)
+		c.IfFalse = cloneExpression(n.IfFalse)
+		return &c
+	case *AssignExpression:
+		c := *n
+		c.Name = cloneExpression(n.Name)
+		c.Value = cloneExpression(n.Value)
+		return &c
+	case *DeclareAssignExpression:
+		c := *n
+		c.Name = cloneIdentifier(n.Name)
+		c.Value = cloneExpression(n.Value)
+		return &c
+	case *InterpolatedStringLiteral:
+		c := *n
+		c.Parts = cloneExpressions(n.Parts)
+		return &c
+	case *FunctionLiteral:
+		c := *n
+		c.Parameters = cloneIdentifiers(n.Parameters)
+		c.Defaults = cloneExpressions(n.Defaults)
+		c.ReturnType = cloneIdentifier(n.ReturnType)
+		c.Body = cloneBlock(n.Body)
+		if n.Where != nil {
+			c.Where = make([]*LetStatement, len(n.Where))
+			for i, w := range n.Where {
+				c.Where[i] = Clone(w).(*LetStatement)
+			}
+		}
+		return &c
+	case *AwaitExpression:
+		c := *n
+		c.Value = cloneExpression(n.Value)
+		return &c
+	case *ArrayLiteral:
+		c := *n
+		c.Members = cloneExpressions(n.Members)
+		return &c
+	case *ArrayComprehension:
+		c := *n
+		c.Expr = cloneExpression(n.Expr)
+		if n.Clauses != nil {
+			c.Clauses = make([]*ComprehensionClause, len(n.Clauses))
+			for i, cl := range n.Clauses {
+				c.Clauses[i] = Clone(cl).(*ComprehensionClause)
+			}
+		}
+		return &c
+	case *ComprehensionClause:
+		c := *n
+		c.Value = cloneExpression(n.Value)
+		return &c
+	case *TupleLiteral:
+		c := *n
+		c.Members = cloneExpressions(n.Members)
+		return &c
+	case *HashLiteral:
+		c := *n
+		//the keys of 'Pairs' and 'Order' are the same nodes, so clone each key once
+		c.Order = cloneExpressions(n.Order)
+		if n.Pairs != nil {
+			c.Pairs = make(map[Expression]Expression, len(n.Pairs))
+			for i, key := range n.Order {
+				c.Pairs[c.Order[i]] = cloneExpression(n.Pairs[key])
+			}
+		}
+		return &c
+	case *IndexExpression:
+		c := *n
+		c.Left = cloneExpression(n.Left)
+		c.Index = cloneExpression(n.Index)
+		return &c
+	case *SliceExpression:
+		c := *n
+		c.Left = cloneExpression(n.Left)
+		c.Low = cloneExpression(n.Low)
+		c.High = cloneExpression(n.High)
+		c.Step = cloneExpression(n.Step)
+		return &c
+	case *CallExpression:
+		c := *n
+		c.Function = cloneExpression(n.Function)
+		c.Arguments = cloneExpressions(n.Arguments)
+		return &c
+	case *NamedArgument:
+		c := *n
+		c.Name = cloneIdentifier(n.Name)
+		c.Value = cloneExpression(n.Value)
+		return &c
+	case *MethodCallExpression:
+		c := *n
+		c.Object = cloneExpression(n.Object)
+		c.Call = cloneExpression(n.Call)
+		return &c
+	case *ScopeResolution:
+		c := *n
+		c.Left = cloneExpression(n.Left)
+		c.Member = cloneExpression(n.Member)
+		return &c
+	case *IfExpression:
+		c := *n
+		if n.Conditions != nil {
+			c.Conditions = make([]*IfConditionExpr, len(n.Conditions))
+			for i, cond := range n.Conditions {
+				if cond != nil {
+					c.Conditions[i] = Clone(cond).(*IfConditionExpr)
+				}
+			}
+		}
+		c.Alternative = cloneBlock(n.Alternative)
+		return &c
+	case *IfConditionExpr:
+		c := *n
+		c.Cond = cloneExpression(n.Cond)
+		c.Body = cloneBlock(n.Body)
+		return &c
+	case *CForLoop:
+		c := *n
+		c.Init = cloneExpression(n.Init)
+		c.Cond = cloneExpression(n.Cond)
+		c.Update = cloneExpression(n.Update)
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *ForEachArrayLoop:
+		c := *n
+		c.Value = cloneExpression(n.Value)
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *ForEachMapLoop:
+		c := *n
+		c.X = cloneExpression(n.X)
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *ForEverLoop:
+		c := *n
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *WhileLoop:
+		c := *n
+		c.Condition = cloneExpression(n.Condition)
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *DoLoop:
+		c := *n
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *DoExpression:
+		c := *n
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *SwitchExpression:
+		c := *n
+		c.Expr = cloneExpression(n.Expr)
+		if n.Cases != nil {
+			c.Cases = make([]*CaseExpression, len(n.Cases))
+			for i, cs := range n.Cases {
+				if cs != nil {
+					c.Cases[i] = Clone(cs).(*CaseExpression)
+				}
+			}
+		}
+		return &c
+	case *CaseExpression:
+		c := *n
+		c.Exprs = cloneExpressions(n.Exprs)
+		c.Block = cloneBlock(n.Block)
+		return &c
+	case *MatchExpression:
+		c := *n
+		c.Expr = cloneExpression(n.Expr)
+		if n.Arms != nil {
+			c.Arms = make([]*MatchArm, len(n.Arms))
+			for i, arm := range n.Arms {
+				if arm != nil {
+					c.Arms[i] = Clone(arm).(*MatchArm)
+				}
+			}
+		}
+		return &c
+	case *MatchArm:
+		c := *n
+		c.Pattern = cloneExpression(n.Pattern)
+		c.Body = cloneExpression(n.Body)
+		return &c
+	case *DecoratorExpr:
+		c := *n
+		c.Decorator = cloneExpression(n.Decorator)
+		c.Decorated = cloneExpression(n.Decorated)
+		return &c
+	}
+
+	panic(fmt.Sprintf("ast.Clone: unknown node type %T", node))
+}
+
+func cloneExpression(e Expression) Expression {
+	if e == nil {
+		return nil
+	}
+	return Clone(e).(Expression)
+}
+
+func cloneExpressions(exprs []Expression) []Expression {
+	if exprs == nil {
+		return nil
+	}
+	c := make([]Expression, len(exprs))
+	for i, e := range exprs {
+		c[i] = cloneExpression(e)
+	}
+	return c
+}
+
+func cloneStatements(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	c := make([]Statement, len(stmts))
+	for i, s := range stmts {
+		if s != nil {
+			c[i] = Clone(s).(Statement)
+		}
+	}
+	return c
+}
+
+func cloneIdentifier(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	c := *ident
+	return &c
+}
+
+func cloneIdentifiers(idents []*Identifier) []*Identifier {
+	if idents == nil {
+		return nil
+	}
+	c := make([]*Identifier, len(idents))
+	for i, ident := range idents {
+		c[i] = cloneIdentifier(ident)
+	}
+	return c
+}
+
+func clonePatternElements(elements []*PatternElement) []*PatternElement {
+	if elements == nil {
+		return nil
+	}
+	c := make([]*PatternElement, len(elements))
+	for i, e := range elements {
+		c[i] = Clone(e).(*PatternElement)
+	}
+	return c
+}
+
+func cloneBlock(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	c := *block
+	c.Statements = cloneStatements(block.Statements)
+	return &c
+}
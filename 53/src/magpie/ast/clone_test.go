@@ -0,0 +1,75 @@
+package ast_test
+
+import (
+	"io/ioutil"
+	"magpie/ast"
+	"path/filepath"
+	"testing"
+)
+
+//the clone of every node of the test programs prints the same, and shares no node with the original
+func TestClone(t *testing.T) {
+	for _, name := range []string{"program.mp", "format.mp"} {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		program := parseProgram(t, string(src))
+		clone := ast.Clone(program).(*ast.Program)
+		if clone.String() != program.String() {
+			t.Errorf("%s: expected the clone to print the same as the original", name)
+		}
+
+		nodes := map[ast.Node]bool{}
+		ast.Walk(program, func(node ast.Node) bool {
+			nodes[node] = true
+			return true
+		})
+		ast.Walk(clone, func(node ast.Node) bool {
+			if nodes[node] {
+				t.Errorf("%s: %s(%s) is shared by the clone", name, node.NodeType(), node.String())
+			}
+			return true
+		})
+	}
+
+	if ast.Clone(nil) != nil {
+		t.Errorf("expected Clone(nil) to be nil")
+	}
+}
+
+func TestCloneMutation(t *testing.T) {
+	const input = `let h = {"a": 1}; fn f(x) { let y = x + 1 }; [y, 2]`
+	program := parseProgram(t, input)
+	original := program.String()
+	pos := program.Statements[0].Pos()
+
+	clone := ast.Clone(program).(*ast.Program)
+	ast.Walk(clone, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.Identifier:
+			n.Value = "z"
+			n.Token.Pos.Line = 100
+		case *ast.HashLiteral:
+			for key := range n.Pairs {
+				n.Pairs[key] = &ast.NilLiteral{}
+			}
+		case *ast.BlockStatement:
+			n.Statements = append(n.Statements[:0], &ast.ExpressionStatement{Expression: &ast.NilLiteral{}})
+		case *ast.ArrayLiteral:
+			n.Members[0] = &ast.NilLiteral{}
+		}
+		return true
+	})
+	clone.Statements = clone.Statements[:1]
+
+	if program.String() != original {
+		t.Errorf("expected the original to be unchanged by the clone, got %s", program.String())
+	}
+	if len(program.Statements) != 3 {
+		t.Errorf("expected the original to keep its 3 statements, got %d", len(program.Statements))
+	}
+	if program.Statements[0].Pos() != pos {
+		t.Errorf("expected the original position %v, got %v", pos, program.Statements[0].Pos())
+	}
+}
@@ -0,0 +1,256 @@
+package ast
+
+import (
+	"fmt"
+	"magpie/token"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//Fold evaluates the constant subtrees of the tree rooted at node, and returns the folded node,
+//e.g. '2 + 3 * 4' becomes the number literal 14, '1 < 2 && !false' becomes the boolean literal true.
+//Only the arithmetic, comparison and logical operators on number and boolean literals are folded.
+//A subtree is left as is if folding it would change the runtime behaviour, e.g. '1 / 0'(a runtime
+//error), '1 % 0'(NaN), or a chained comparison like '1 < x < 3'.
+//The tree is changed in place, so the caller should use the returned node, which is a new node
+//only if 'node' itself is folded.
+//It also returns the warnings about the constants found, e.g. "Warning: <1:5> - division by zero"
+//for 'x / 0'.
+func Fold(node Node) (Node, []string) {
+	f := &folder{}
+	if e, ok := node.(Expression); ok {
+		node = f.expression(e)
+	}
+	Walk(node, func(n Node) bool {
+		f.children(n)
+		return true
+	})
+	return node, f.warnings
+}
+
+type folder struct {
+	warnings []string
+}
+
+func (f *folder) warnf(pos token.Position, format string, args ...interface{}) {
+	f.warnings = append(f.warnings, fmt.Sprintf("Warning:%v- %s", pos, fmt.Sprintf(format, args...)))
+}
+
+//folds the direct child expressions of the node
+func (f *folder) children(node Node) {
+	switch n := node.(type) {
+	case *ExpressionStatement:
+		n.Expression = f.expression(n.Expression)
+	case *LetStatement:
+		f.expressions(n.Values)
+	case *ReturnStatement:
+		f.expressions(n.ReturnValues)
+		if len(n.ReturnValues) > 0 {
+			n.ReturnValue = n.ReturnValues[0] //keep them the same node as the parser does
+		}
+	case *MultiAssignStatement:
+		f.expressions(n.Values)
+	case *UseStatement:
+		n.Value = f.expression(n.Value)
+	case *StructField:
+		n.Default = f.expression(n.Default)
+	case *ThrowStmt:
+		n.Expr = f.expression(n.Expr)
+	case *PatternElement:
+		n.Default = f.expression(n.Default)
+	case *FunctionLiteral:
+		f.expressions(n.Defaults)
+
+	case *InfixExpression:
+		n.Left = f.expression(n.Left)
+		n.Right = f.expression(n.Right)
+		n.Next = f.expression(n.Next)
+	case *PrefixExpression:
+		n.Right = f.expression(n.Right)
+	case *RangeExpression:
+		n.StartIdx = f.expression(n.StartIdx)
+		n.EndIdx = f.expression(n.EndIdx)
+	case *SliceExpression:
+		n.Low = f.expression(n.Low)
+		n.High = f.expression(n.High)
+		n.Step = f.expression(n.Step)
+	case *TernaryExpression:
+		n.Condition = f.expression(n.Condition)
+		n.IfTrue = f.expression(n.IfTrue)
+		n.IfFalse = f.expression(n.IfFalse)
+	case *AssignExpression:
+		n.Value = f.expression(n.Value)
+	case *DeclareAssignExpression:
+		n.Value = f.expression(n.Value)
+	case *AwaitExpression:
+		n.Value = f.expression(n.Value)
+	case *ArrayLiteral:
+		f.expressions(n.Members)
+	case *ArrayComprehension:
+		n.Expr = f.expression(n.Expr)
+	case *ComprehensionClause:
+		n.Value = f.expression(n.Value)
+	case *TupleLiteral:
+		f.expressions(n.Members)
+	case *HashLiteral:
+		//only the values, the keys of 'Pairs' and 'Order' must stay the same nodes
+		for _, key := range n.Order {
+			n.Pairs[key] = f.expression(n.Pairs[key])
+		}
+	case *IndexExpression:
+		n.Index = f.expression(n.Index)
+	case *CallExpression:
+		f.expressions(n.Arguments)
+	case *NamedArgument:
+		n.Value = f.expression(n.Value)
+	case *IfConditionExpr:
+		n.Cond = f.expression(n.Cond)
+	case *CForLoop:
+		n.Cond = f.expression(n.Cond)
+	case *ForEachArrayLoop:
+		n.Value = f.expression(n.Value)
+	case *ForEachMapLoop:
+		n.X = f.expression(n.X)
+	case *WhileLoop:
+		n.Condition = f.expression(n.Condition)
+	case *SwitchExpression:
+		n.Expr = f.expression(n.Expr)
+	case *MatchExpression:
+		n.Expr = f.expression(n.Expr)
+	case *MatchArm:
+		n.Body = f.expression(n.Body)
+	}
+}
+
+func (f *folder) expressions(exprs []Expression) {
+	for i, e := range exprs {
+		exprs[i] = f.expression(e)
+	}
+}
+
+//returns the folded expression, or the expression itself(with its operands folded) if it is not constant
+func (f *folder) expression(e Expression) Expression {
+	switch n := e.(type) {
+	case *InfixExpression:
+		n.Left = f.expression(n.Left)
+		n.Right = f.expression(n.Right)
+		if n.HasNext { //a < b < c
+			n.Next = f.expression(n.Next)
+			return n
+		}
+		if folded := foldInfix(n); folded != nil {
+			return folded
+		}
+		if right, ok := n.Right.(*NumberLiteral); ok && right.Value == 0 && n.Operator == "/" { //left unfolded, e.g. 'x / 0', '1 / (2 - 2)'
+			f.warnf(right.Pos(), "division by zero")
+		}
+	case *PrefixExpression:
+		n.Right = f.expression(n.Right)
+		if folded := foldPrefix(n); folded != nil {
+			return folded
+		}
+	}
+	return e
+}
+
+//returns nil if the infix expression could not be folded
+func foldInfix(n *InfixExpression) Expression {
+	pos := n.Left.Pos()
+	switch left := n.Left.(type) {
+	case *NumberLiteral:
+		right, ok := n.Right.(*NumberLiteral)
+		if !ok {
+			return nil
+		}
+		l, r := left.Value, right.Value
+		switch n.Operator {
+		case "+":
+			return numberLiteral(pos, l+r)
+		case "-":
+			return numberLiteral(pos, l-r)
+		case "*":
+			return numberLiteral(pos, l*r)
+		case "/":
+			if r == 0 { //a runtime error
+				return nil
+			}
+			return numberLiteral(pos, l/r)
+		case "%":
+			if r == 0 {
+				return nil
+			}
+			return numberLiteral(pos, math.Mod(l, r))
+		case "**":
+			return numberLiteral(pos, math.Pow(l, r))
+		case "<":
+			return booleanLiteral(pos, l < r)
+		case "<=":
+			return booleanLiteral(pos, l <= r)
+		case ">":
+			return booleanLiteral(pos, l > r)
+		case ">=":
+			return booleanLiteral(pos, l >= r)
+		case "==":
+			return booleanLiteral(pos, l == r)
+		case "!=":
+			return booleanLiteral(pos, l != r)
+		}
+	case *BooleanLiteral:
+		right, ok := n.Right.(*BooleanLiteral)
+		if !ok {
+			return nil
+		}
+		l, r := left.Value, right.Value
+		switch n.Operator {
+		case "&&":
+			return booleanLiteral(pos, l && r)
+		case "||":
+			return booleanLiteral(pos, l || r)
+		case "==":
+			return booleanLiteral(pos, l == r)
+		case "!=":
+			return booleanLiteral(pos, l != r)
+		}
+	}
+	return nil
+}
+
+//returns nil if the prefix expression could not be folded
+func foldPrefix(n *PrefixExpression) Expression {
+	switch right := n.Right.(type) {
+	case *NumberLiteral:
+		switch n.Operator {
+		case "-":
+			return numberLiteral(n.Token.Pos, -right.Value)
+		case "+":
+			return right
+		}
+	case *BooleanLiteral:
+		if n.Operator == "!" || n.Operator == "not" {
+			return booleanLiteral(n.Token.Pos, !right.Value)
+		}
+	}
+	return nil
+}
+
+//returns nil for Inf and NaN, which have no literals
+func numberLiteral(pos token.Position, value float64) Expression {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return nil
+	}
+	literal := strconv.FormatFloat(value, 'g', -1, 64)
+	lit := &NumberLiteral{Token: token.Token{Type: token.TOKEN_NUMBER, Literal: literal, Pos: pos}, Value: value}
+	if !strings.ContainsAny(literal, ".e") && math.Abs(value) < 1<<63 { //as the parser does, e.g. '2.5 * 2' is the integer 5
+		lit.IsInt, lit.Int = true, int64(value)
+	}
+	return lit
+}
+
+func booleanLiteral(pos token.Position, value bool) Expression {
+	tok := token.Token{Type: token.TOKEN_FALSE, Literal: "false", Pos: pos}
+	if value {
+		tok = token.Token{Type: token.TOKEN_TRUE, Literal: "true", Pos: pos}
+	}
+	return &BooleanLiteral{Token: tok, Value: value}
+}
@@ -0,0 +1,110 @@
+package ast_test
+
+import (
+	"magpie/ast"
+	"strings"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		//arithmetic
+		{"2 + 3 * 4", "14"},
+		{"-(2 + 3)", "-5"},
+		{"1.5 * 2", "3"},
+		{"let a = 10 / 4", "let a = 2.5"},
+		{"2 ** 0.5", "1.4142135623730951"},
+		{"[1 + 1, 2 * 2]", "[2, 4]"},
+		{"fn() { return 1 + 1 }", "fn() {return 2;}"},
+
+		//boolean and comparison
+		{"1 < 2 && !false", "true"},
+		{"true == false", "false"},
+		{"if 1 > 2 { 1 }", "if false { 1; }"},
+
+		//non-constant operands
+		{"x + 2 * 3", "(x + 6)"},
+		{"2 + x + 3", "((2 + x) + 3)"}, //left associative, no reordering
+		{`"a" + "b"`, "(a + b)"},
+		{"1 < x < 3", "(1 < x < 3)"},
+
+		//the runtime behaviour is kept
+		{"1 / 0", "(1 / 0)"},
+		{"1 % 0", "(1 % 0)"},
+		{"10 ** 400", "(10 ** 400)"}, //Inf has no literal
+	}
+
+	for _, tt := range tests {
+		folded, _ := ast.Fold(parseProgram(t, tt.input))
+		if got := folded.String(); got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFoldWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"x / 2", nil},
+		{"x / y", nil},
+		{"x % 0", nil},
+		{"1 + 2", nil},
+		{"x / 0", []string{"Warning: <1:5> - division by zero"}},
+		{"let a = 1 / 0.0", []string{"Warning: <1:13> - division by zero"}},
+		{"x / (2 - 2)", []string{"Warning: <1:6> - division by zero"}}, //the folded operand
+		{"fn() { a / 0 }\nb / 0", []string{"Warning: <1:12> - division by zero", "Warning: <2:5> - division by zero"}},
+	}
+
+	for _, tt := range tests {
+		_, warnings := ast.Fold(parseProgram(t, tt.input))
+		if strings.Join(warnings, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("%q: expected the warnings %q, got %q", tt.input, tt.expected, warnings)
+		}
+	}
+}
+
+func TestFoldExpression(t *testing.T) {
+	stmt := parseProgram(t, "2 + 3 * 4").Statements[0].(*ast.ExpressionStatement)
+	node, _ := ast.Fold(stmt.Expression)
+	folded, ok := node.(*ast.NumberLiteral)
+	if !ok {
+		t.Fatalf("expected a number literal, got %T", node)
+	}
+	if folded.Value != 14 || folded.Token.Literal != "14" {
+		t.Errorf("expected 14, got %v(%q)", folded.Value, folded.Token.Literal)
+	}
+	if folded.Pos().Line != 1 || folded.Pos().Col != 1 {
+		t.Errorf("expected the position of the left operand(1:1), got %d:%d", folded.Pos().Line, folded.Pos().Col)
+	}
+}
+
+//a folded number is an integer literal if its value is an integer, as the parser does
+func TestFoldInteger(t *testing.T) {
+	tests := []struct {
+		input string
+		isInt bool
+		value int64
+	}{
+		{"2 + 3", true, 5},
+		{"2.5 * 2", true, 5},
+		{"1 / 2", false, 0},
+		{"2 ** 70", false, 0}, //formatted with an exponent
+	}
+
+	for _, tt := range tests {
+		stmt := parseProgram(t, tt.input).Statements[0].(*ast.ExpressionStatement)
+		node, _ := ast.Fold(stmt.Expression)
+		n, ok := node.(*ast.NumberLiteral)
+		if !ok {
+			t.Fatalf("%q: expected a number literal, got %T", tt.input, node)
+		}
+		if n.IsInt != tt.isInt || n.Int != tt.value {
+			t.Errorf("%q: expected IsInt %t and Int %d, got %t and %d", tt.input, tt.isInt, tt.value, n.IsInt, n.Int)
+		}
+	}
+}
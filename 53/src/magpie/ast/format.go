@@ -0,0 +1,768 @@
+package ast
+
+import (
+	"bytes"
+	"magpie/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Format returns the source code of the node, formatted for humans: one statement per line,
+//blocks indented by four spaces, a space around binary operators, and parentheses only where
+//they are needed. Formatting the output again yields the same text.
+//Comments are not kept in the AST, so they are lost.
+func Format(node Node) string {
+	f := &formatter{}
+	if program, ok := node.(*Program); ok {
+		f.precedences = program.Precedences
+	}
+	f.node(node)
+	if _, ok := node.(*Program); ok && f.out.Len() > 0 { //an empty program stays empty
+		f.out.WriteString("\n")
+	}
+	return f.out.String()
+}
+
+const indentString = "    "
+
+//operator precedences, same as the parser's, so a '#prec' pragma could be applied as is
+const (
+	_ int = iota
+	precLowest
+	precAssign
+	precTernary
+	precNilCoalesce
+	precPipe
+	precOr
+	precAnd
+	precEquals
+	precLessGreater
+	precRange
+	precSum
+	precProduct
+	precRegexpMatch
+	precPrefix
+	precPower
+	precIncrement
+	precCall
+)
+
+var infixPrecedences = map[string]int{
+	"?:": precTernary,
+	"??": precNilCoalesce,
+	"||": precOr,
+	"&&": precAnd,
+	"==": precEquals,
+	"!=": precEquals,
+	"<":  precLessGreater,
+	"<=": precLessGreater,
+	">":  precLessGreater,
+	">=": precLessGreater,
+	"in": precLessGreater,
+	"+":  precSum,
+	"-":  precSum,
+	"*":  precProduct,
+	"/":  precProduct,
+	"%":  precProduct,
+	"=~": precRegexpMatch,
+	"!~": precRegexpMatch,
+	"**": precPower,
+}
+
+type formatter struct {
+	out    bytes.Buffer
+	indent int
+
+	precedences map[string]int //set by the '#prec' pragmas of the program, see Program.Precedences
+}
+
+func (f *formatter) write(s ...string) {
+	for _, str := range s {
+		f.out.WriteString(str)
+	}
+}
+
+func (f *formatter) newline() {
+	f.out.WriteString("\n")
+	f.out.WriteString(strings.Repeat(indentString, f.indent))
+}
+
+//precedence returns the precedence of the expression, an expression must be enclosed
+//in parentheses if its precedence is lower than its position requires.
+func (f *formatter) precedence(e Expression) int {
+	switch n := e.(type) {
+	case *InfixExpression:
+		if prec, ok := f.precedences[n.Operator]; ok { //#prec(+, 13)
+			return prec
+		}
+		if prec, ok := infixPrecedences[n.Operator]; ok {
+			return prec
+		}
+		return precLowest
+	case *TernaryExpression:
+		return precTernary
+	case *AssignExpression, *DeclareAssignExpression:
+		return precAssign
+	case *RangeExpression:
+		if prec, ok := f.precedences[n.Token.Literal]; ok {
+			return prec
+		}
+		return precRange
+	case *PrefixExpression, *AwaitExpression:
+		return precPrefix
+	case *PostfixExpression:
+		return precIncrement
+	case *FunctionLiteral, *IfExpression, *SwitchExpression, *MatchExpression, *DoExpression, *DecoratorExpr,
+		*CForLoop, *ForEachArrayLoop, *ForEachMapLoop, *ForEverLoop, *WhileLoop, *DoLoop:
+		return precLowest
+	}
+	return precCall
+}
+
+//isDeclaration reports whether the statement is a struct or named function, which are separated
+//from their neighbours by a blank line.
+func isDeclaration(s Statement) bool {
+	switch n := s.(type) {
+	case *StructStatement:
+		return true
+	case *ExpressionStatement:
+		switch e := n.Expression.(type) {
+		case *FunctionLiteral:
+			return e.Name != ""
+		case *DecoratorExpr:
+			return true
+		}
+	}
+	return false
+}
+
+func (f *formatter) statements(stmts []Statement) {
+	for i, s := range stmts {
+		if i > 0 {
+			if isDeclaration(s) || isDeclaration(stmts[i-1]) {
+				f.out.WriteString("\n")
+			}
+			f.newline()
+		}
+		f.node(s)
+	}
+}
+
+func (f *formatter) block(b *BlockStatement) {
+	if b == nil || len(b.Statements) == 0 {
+		f.write("{}")
+		return
+	}
+	f.write("{")
+	f.indent++
+	f.newline()
+	f.statements(b.Statements)
+	f.indent--
+	f.newline()
+	f.write("}")
+}
+
+//expr writes the expression, enclosed in parentheses if its precedence is lower than 'prec'.
+func (f *formatter) expr(e Expression, prec int) {
+	if f.precedence(e) < prec {
+		f.write("(")
+		f.node(e)
+		f.write(")")
+		return
+	}
+	f.node(e)
+}
+
+func (f *formatter) exprList(exprs []Expression) {
+	for i, e := range exprs {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.expr(e, precLowest)
+	}
+}
+
+func (f *formatter) node(node Node) {
+	switch n := node.(type) {
+	case nil:
+	case *Program:
+		ops := []string{}
+		for op := range n.Precedences {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for i, op := range ops { //the pragmas must stay at the top of the file
+			if i > 0 {
+				f.newline()
+			}
+			f.write("#prec(", op, ", ", strconv.Itoa(n.Precedences[op]), ")")
+		}
+		if len(ops) > 0 && (len(n.Imports) > 0 || len(n.Statements) > 0) {
+			f.out.WriteString("\n")
+			f.newline()
+		}
+
+		paths := []string{}
+		for path := range n.Imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for i, path := range paths {
+			if i > 0 {
+				f.newline()
+			}
+			f.node(n.Imports[path])
+		}
+		if len(paths) > 0 && len(n.Statements) > 0 {
+			f.out.WriteString("\n")
+			f.newline()
+		}
+		f.statements(n.Statements)
+	case *ImportStatement:
+		path := n.Path
+		if path == "" {
+			path = n.ImportPath
+		}
+		f.write("import ", quoteString(path))
+		if n.Condition != nil {
+			f.write(" if ")
+			f.expr(n.Condition, precLowest)
+		}
+	case *LetStatement:
+		f.write("let ")
+		if n.Mutable {
+			f.write("mut ")
+		}
+		f.node(n.Pattern)
+		for i, name := range n.Names {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.write(name.Value)
+		}
+		if len(n.Values) > 0 {
+			f.write(" = ")
+			f.exprList(n.Values)
+		}
+	case *ReturnStatement:
+		f.write("return")
+		values := n.ReturnValues
+		if len(values) == 0 && n.ReturnValue != nil {
+			values = []Expression{n.ReturnValue}
+		}
+		if len(values) > 0 {
+			f.write(" ")
+			f.exprList(values)
+		}
+	case *TailCallStatement:
+		f.write(n.Token.Literal, " ")
+		f.expr(n.Call, precLowest)
+	case *UseStatement:
+		f.write("use ", n.Name.Value, " = ")
+		f.expr(n.Value, precLessGreater+1) //the value is parsed before 'in'
+		f.write(" in ")
+		f.block(n.Block)
+	case *ExportStatement:
+		f.write("export { ", strings.Join(n.Names, ", "), " }")
+	case *BlockStatement:
+		f.block(n)
+	case *ExpressionStatement:
+		if h, ok := n.Expression.(*HashLiteral); ok && h.Token.Type != token.TOKEN_AT { //'{' at the start of a statement is a block
+			f.write("(")
+			f.node(h)
+			f.write(")")
+		} else {
+			f.expr(n.Expression, precLowest)
+		}
+	case *MultiAssignStatement:
+		f.exprList(n.Names)
+		f.write(" = ")
+		f.exprList(n.Values)
+	case *StructStatement:
+		f.structStatement(n)
+	case *TryStmt:
+		f.write("try ")
+		f.block(n.Try)
+		if n.Catch != nil {
+			f.write(" catch ")
+			if n.Var != "" {
+				f.write(n.Var, " ")
+			}
+			f.block(n.Catch)
+		}
+		if n.Finally != nil {
+			f.write(" finally ")
+			f.block(n.Finally)
+		}
+	case *ThrowStmt:
+		f.write("throw")
+		if n.Expr != nil {
+			f.write(" ")
+			f.expr(n.Expr, precLowest)
+		}
+
+	case *Identifier:
+		f.write(n.Value)
+	case *NumberLiteral:
+		f.write(n.Token.Literal)
+	case *CharLiteral:
+		f.write(strconv.QuoteRune(n.Value))
+	case *NilLiteral:
+		f.write("nil")
+	case *BooleanLiteral:
+		f.write(strconv.FormatBool(n.Value))
+	case *StringLiteral:
+		if n.Raw {
+			f.write("```", n.Value, "```")
+		} else {
+			f.write(`"`, escapeString(n), `"`)
+		}
+	case *InterpolatedStringLiteral:
+		f.write(`"`)
+		for _, part := range n.Parts {
+			if s, ok := part.(*StringLiteral); ok {
+				f.write(escapeString(s))
+				continue
+			}
+			f.write("${")
+			f.expr(part, precLowest)
+			f.write("}")
+		}
+		f.write(`"`)
+	case *RegExLiteral:
+		f.write(n.String())
+	case *CmdExpression:
+		f.write("`", strings.Replace(n.Value, "`", "\\`", -1), "`")
+	case *BreakExpression, *ContinueExpression:
+		f.write(node.TokenLiteral())
+	case *FallthroughExpression:
+		f.write(n.Token.Literal)
+		if n.Target != nil {
+			f.write(" case ")
+			f.expr(n.Target, precLowest)
+		}
+
+	case *InfixExpression:
+		prec := f.precedence(n)
+		leftPrec, rightPrec := prec, prec+1
+		switch n.Operator {
+		case "**", "??", "?:": //right-associative
+			leftPrec, rightPrec = prec+1, prec
+		}
+		if prec == precEquals || prec == precLessGreater { //comparisons are chained, e.g. 'a < b < c'
+			leftPrec = prec + 1
+		}
+		//the parser chains a comparison after any infix expression('a + b < c' is '+' with Next '< c'),
+		//so an infix operand which is followed by a comparison operator must be parenthesized.
+		if _, ok := n.Left.(*InfixExpression); ok && isCompareOperator(n.Operator) {
+			leftPrec = precCall
+		}
+		f.expr(n.Left, leftPrec)
+		f.write(" ", n.Operator, " ")
+		if _, ok := n.Right.(*InfixExpression); ok && n.HasNext {
+			f.expr(n.Right, precCall)
+		} else {
+			f.expr(n.Right, rightPrec)
+		}
+		if n.HasNext {
+			f.write(" ", n.NextOperator, " ")
+			f.expr(n.Next, rightPrec)
+		}
+	case *TernaryExpression:
+		f.expr(n.Condition, precTernary+1)
+		f.write(" ? ")
+		f.expr(n.IfTrue, precLowest)
+		f.write(" : ")
+		f.expr(n.IfFalse, precTernary)
+	case *PrefixExpression:
+		f.write(n.Operator)
+		if n.Operator == "not" {
+			f.write(" ")
+		}
+		//'- -x' must not be written as '--x'
+		if right, ok := n.Right.(*PrefixExpression); ok && right.Operator[0] == n.Operator[0] {
+			f.write("(")
+			f.node(right)
+			f.write(")")
+		} else {
+			f.expr(n.Right, precPrefix)
+		}
+	case *PostfixExpression:
+		f.expr(n.Left, precCall)
+		f.write(n.Operator)
+	case *RangeExpression:
+		prec := f.precedence(n)
+		f.expr(n.StartIdx, prec+1)
+		f.write(n.Token.Literal)
+		f.expr(n.EndIdx, prec+1)
+	case *AssignExpression:
+		f.expr(n.Name, precCall)
+		f.write(" ", n.Token.Literal, " ")
+		f.expr(n.Value, precLowest)
+	case *DeclareAssignExpression:
+		f.write(n.Name.Value, " := ")
+		f.expr(n.Value, precLowest)
+	case *AwaitExpression:
+		f.write("await ")
+		f.expr(n.Value, precPrefix)
+	case *HashPattern:
+		f.write("{")
+		f.patternElements(n.Elements)
+		f.write("}")
+	case *ArrayPattern:
+		f.write("[")
+		f.patternElements(n.Elements)
+		f.write("]")
+	case *ArrayLiteral:
+		f.write("[")
+		f.exprList(n.Members)
+		f.write("]")
+	case *ArrayComprehension:
+		f.write("[")
+		f.expr(n.Expr, precLowest)
+		for _, c := range n.Clauses {
+			f.write(" ")
+			f.node(c)
+		}
+		f.write("]")
+	case *ComprehensionClause:
+		f.write("for " + n.Var + " in ")
+		f.expr(n.Value, precLowest)
+	case *TupleLiteral:
+		f.write("(")
+		f.exprList(n.Members)
+		if len(n.Members) == 1 {
+			f.write(",")
+		}
+		f.write(")")
+	case *HashLiteral:
+		if n.Token.Type == token.TOKEN_AT { //@{...}
+			f.write("@")
+		}
+		f.write("{")
+		for i, key := range n.Order {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.expr(key, precLowest)
+			f.write(": ")
+			f.expr(n.Pairs[key], precLowest)
+		}
+		f.write("}")
+	case *IndexExpression:
+		f.expr(n.Left, precCall)
+		if n.Optional {
+			f.write("?[")
+		} else {
+			f.write("[")
+		}
+		f.expr(n.Index, precLowest)
+		f.write("]")
+	case *SliceExpression:
+		f.expr(n.Left, precCall)
+		if n.Optional {
+			f.write("?[")
+		} else {
+			f.write("[")
+		}
+		if n.Low != nil {
+			f.expr(n.Low, precLowest)
+		}
+		f.write(":")
+		if n.High != nil {
+			f.expr(n.High, precLowest)
+		}
+		if n.Step != nil {
+			f.write(":")
+			f.expr(n.Step, precLowest)
+		}
+		f.write("]")
+	case *CallExpression:
+		f.expr(n.Function, precCall)
+		if n.Optional {
+			f.write("?.")
+		}
+		f.write("(")
+		f.exprList(n.Arguments)
+		if n.Variadic {
+			f.write("...")
+		}
+		f.write(")")
+	case *NamedArgument:
+		f.write(n.Name.Value, ": ")
+		f.expr(n.Value, precLowest)
+	case *MethodCallExpression:
+		f.expr(n.Object, precCall)
+		if n.Optional {
+			f.write("?.")
+		} else {
+			f.write(".")
+		}
+		f.node(n.Call)
+	case *ScopeResolution:
+		f.expr(n.Left, precCall)
+		f.write("::")
+		f.node(n.Member)
+	case *FunctionLiteral:
+		f.functionLiteral(n)
+	case *IfExpression:
+		for i, c := range n.Conditions {
+			if i > 0 {
+				f.write(" else ")
+			}
+			f.write("if ")
+			f.expr(c.Cond, precLowest)
+			f.write(" ")
+			f.block(c.Body)
+		}
+		if n.Alternative != nil {
+			f.write(" else ")
+			f.block(n.Alternative)
+		}
+	case *CForLoop:
+		f.write("for (")
+		if n.Init != nil {
+			f.expr(n.Init, precLowest)
+		}
+		f.write(";")
+		if n.Cond != nil {
+			f.write(" ")
+			f.expr(n.Cond, precLowest)
+		}
+		f.write(";")
+		if n.Update != nil {
+			f.write(" ")
+			f.expr(n.Update, precLowest)
+		} else {
+			f.write(";") //for (init; cond;;)
+		}
+		f.write(") ")
+		f.block(n.Block)
+	case *ForEachArrayLoop:
+		f.write("for ", n.Var, " in ")
+		f.expr(n.Value, precLowest)
+		f.write(" ")
+		f.block(n.Block)
+	case *ForEachMapLoop:
+		f.write("for ", n.Key, ", ", n.Value, " in ")
+		f.expr(n.X, precLowest)
+		f.write(" ")
+		f.block(n.Block)
+	case *ForEverLoop:
+		f.write("for ")
+		f.block(n.Block)
+	case *WhileLoop:
+		f.write("while ")
+		f.expr(n.Condition, precLowest)
+		f.write(" ")
+		f.block(n.Block)
+	case *DoLoop:
+		f.write("do ")
+		f.block(n.Block)
+	case *DoExpression:
+		f.write("do ")
+		f.block(n.Block)
+	case *SwitchExpression:
+		f.write("switch ")
+		f.expr(n.Expr, precLowest)
+		f.write(" {")
+		f.indent++
+		for _, c := range n.Cases {
+			f.newline()
+			if c.Default {
+				f.write("default ")
+			} else {
+				f.write("case ")
+				f.exprList(c.Exprs)
+				f.write(" ")
+			}
+			f.block(c.Block)
+		}
+		f.indent--
+		f.newline()
+		f.write("}")
+	case *MatchExpression:
+		f.write("match ")
+		f.expr(n.Expr, precLowest)
+		f.write(" {")
+		f.indent++
+		for i, arm := range n.Arms {
+			f.newline()
+			f.expr(arm.Pattern, precLowest)
+			f.write(" => ")
+			f.expr(arm.Body, precLowest)
+			if i < len(n.Arms)-1 {
+				f.write(",")
+			}
+		}
+		f.indent--
+		f.newline()
+		f.write("}")
+	case *DecoratorExpr:
+		f.write("@")
+		f.expr(n.Decorator, precLowest)
+		f.newline()
+		f.node(n.Decorated)
+	default:
+		f.write(node.String())
+	}
+}
+
+func (f *formatter) patternElements(elements []*PatternElement) {
+	for i, e := range elements {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.write(e.Name.Value)
+		if e.Default != nil {
+			f.write(" = ")
+			f.expr(e.Default, precLowest)
+		}
+	}
+}
+
+func (f *formatter) functionLiteral(fn *FunctionLiteral) {
+	if fn.Static {
+		f.write("static ")
+	}
+	if fn.Async {
+		f.write("async ")
+	}
+	f.write("fn")
+	if fn.Name != "" {
+		f.write(" ", fn.Name)
+	}
+	f.write("(")
+	for i, p := range fn.Parameters {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.write(p.Value)
+		if fn.Defaults != nil && fn.Defaults[i] != nil {
+			f.write(" = ")
+			f.expr(fn.Defaults[i], precLowest)
+		}
+	}
+	if fn.Variadic {
+		f.write("...")
+	}
+	f.write(")")
+	if fn.ReturnType != nil {
+		f.write(": ", fn.ReturnType.Value)
+	}
+	f.write(" ")
+	f.block(fn.Body)
+
+	for i, w := range fn.Where {
+		if i == 0 {
+			f.write(" where ")
+		} else {
+			f.write(", ")
+		}
+		f.write(w.Names[0].Value, " = ")
+		f.expr(w.Values[0], precLowest)
+	}
+}
+
+//struct Name {
+//    field1 = default
+//    field2;
+//
+//    fn method() {}
+//}
+func (f *formatter) structStatement(s *StructStatement) {
+	f.write("struct ", s.Name, " {")
+	var stmts []Statement
+	if s.Block != nil {
+		stmts = s.Block.Statements
+	}
+	if len(s.Fields) == 0 && len(stmts) == 0 {
+		f.write("}")
+		return
+	}
+
+	f.indent++
+	for i, field := range s.Fields {
+		f.newline()
+		f.write(field.Name.Value)
+		if field.Default != nil {
+			f.write(" = ")
+			f.expr(field.Default, precLowest)
+		} else if i == len(s.Fields)-1 && len(stmts) > 0 && !fieldMayPrecede(stmts[0]) {
+			f.write(";")
+		}
+	}
+	if len(s.Fields) > 0 && len(stmts) > 0 {
+		f.out.WriteString("\n")
+	}
+	if len(stmts) > 0 {
+		f.newline()
+		f.statements(stmts)
+	}
+	f.indent--
+	f.newline()
+	f.write("}")
+}
+
+//fieldMayPrecede reports whether a field without a default value could be followed by the statement
+//without a ';', the parser recognizes such a field by the token after it(see parser.isStructField).
+func fieldMayPrecede(s Statement) bool {
+	switch n := s.(type) {
+	case *LetStatement:
+		return true
+	case *ExpressionStatement:
+		if fn, ok := n.Expression.(*FunctionLiteral); ok {
+			return !fn.Static && !fn.Async
+		}
+	}
+	return false
+}
+
+func isCompareOperator(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+//quoteString returns the string literal of s, which could be read back by the lexer.
+func quoteString(s string) string {
+	return `"` + escapeString(&StringLiteral{Value: s}) + `"`
+}
+
+//escapeString escapes the characters of s.Value which the lexer reads as escape sequences(see
+//lexer.readString), and writes the escaped '$' as '\$', see StringLiteral.EscapedDollars.
+func escapeString(s *StringLiteral) string {
+	var out bytes.Buffer
+	dollars := s.EscapedDollars
+	for i, r := range s.Value {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '$':
+			if len(dollars) > 0 && dollars[0] == i {
+				out.WriteString(`\$`)
+				dollars = dollars[1:]
+			} else {
+				out.WriteRune(r)
+			}
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\b':
+			out.WriteString(`\b`)
+		case '\f':
+			out.WriteString(`\f`)
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,62 @@
+package ast_test
+
+import (
+	"io/ioutil"
+	"magpie/ast"
+	"magpie/lexer"
+	"magpie/parser"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []string{
+		"format", //testdata/format.mp => testdata/format.golden
+		"prec",   //the '#prec' pragmas are kept, and the parentheses follow them
+	}
+
+	for _, name := range tests {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", name+".mp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := formatSource(t, name, string(src))
+
+		golden := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(expected) {
+			t.Errorf("%s: Format does not match %s, run 'go test -update' if the change is expected.\ngot:\n%s", name, golden, got)
+		}
+
+		//formatting the output again yields the same text, and the same tree
+		if again := formatSource(t, name, got); again != got {
+			t.Errorf("%s: Format is not stable, got:\n%s", name, again)
+		}
+		if parse(t, name, got).String() != parse(t, name, string(src)).String() {
+			t.Errorf("%s: the formatted program differs from the original", name)
+		}
+	}
+}
+
+func parse(t *testing.T, name string, src string) *ast.Program {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%s: parser errors: %v", name, p.Errors())
+	}
+	return program
+}
+
+func formatSource(t *testing.T, name string, src string) string {
+	t.Helper()
+	return ast.Format(parse(t, name, src))
+}
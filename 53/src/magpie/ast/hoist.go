@@ -0,0 +1,29 @@
+package ast
+
+//HoistFunctions returns the named function declarations(e.g. 'fn f() { }') which are the direct
+//statements of the block, in source order. The evaluator binds them before running the block,
+//so they could be called before their declarations, e.g. two mutually recursive functions:
+//   {
+//       println(isEven(10))
+//       fn isEven(n) { n == 0 ? true : isOdd(n - 1) }
+//       fn isOdd(n) { n == 0 ? false : isEven(n - 1) }
+//   }
+//The statements of the block are not reordered. Decorated functions are not hoisted,
+//because the decorators should be applied in the order they appear.
+func HoistFunctions(block *BlockStatement) []*FunctionLiteral {
+	if block == nil {
+		return nil
+	}
+
+	var fns []*FunctionLiteral
+	for _, stmt := range block.Statements {
+		es, ok := stmt.(*ExpressionStatement)
+		if !ok {
+			continue
+		}
+		if fn, ok := es.Expression.(*FunctionLiteral); ok && fn.Name != "" {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
@@ -0,0 +1,43 @@
+package ast_test
+
+import (
+	"magpie/ast"
+	"strings"
+	"testing"
+)
+
+func TestHoistFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string //the names of the hoisted functions
+	}{
+		{`fn() {
+	println(isEven(10))
+	fn isEven(n) { n == 0 ? true : isOdd(n - 1) }
+	fn isOdd(n) { n == 0 ? false : isEven(n - 1) }
+}`, []string{"isEven", "isOdd"}},
+		{"fn() { let f = fn g() { 1 }; fn h() { fn inner() { } } }", []string{"h"}}, //only the direct statements
+		{"fn() { fn() { 1 }; 2 }", nil},                                             //anonymous
+		{"fn() { @log\nfn f() { } }", nil},                                          //decorated
+		{"fn() { }", nil},
+	}
+
+	for _, tt := range tests {
+		fn := firstFunction(t, parseProgram(t, tt.input))
+		before := fn.Body.String()
+		var names []string
+		for _, f := range ast.HoistFunctions(fn.Body) {
+			names = append(names, f.Name)
+		}
+		if strings.Join(names, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("%q: expected %v to be hoisted, got %v", tt.input, tt.expected, names)
+		}
+		if fn.Body.String() != before {
+			t.Errorf("%q: expected the statements not to be reordered", tt.input)
+		}
+	}
+
+	if ast.HoistFunctions(nil) != nil {
+		t.Errorf("expected no functions for a nil block")
+	}
+}
@@ -0,0 +1,395 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"magpie/token"
+	"reflect"
+)
+
+//ToJSON returns the JSON representation of the tree rooted at node, so the tree could be consumed
+//by the tools written in other languages. Every node is an object with:
+//  "type":    the node's kind, e.g. "InfixExpression"
+//  "pos":     the start position, e.g. {"line": 1, "col": 5}
+//  "end":     the end position
+//  "literal": the literal of the node's token, e.g. "+", "42", if the node has a token
+//and the fields of the node, e.g. "left", "operator", "right" of an infix expression.
+//A missing child is null. The field names are part of the format, they don't change with the
+//Go field names. Like Walk, imported programs(ImportStatement.Program) are not included.
+func ToJSON(node Node) ([]byte, error) {
+	e := &jsonEncoder{}
+	value := e.node(node)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return json.MarshalIndent(value, "", "  ")
+}
+
+type jsonObject map[string]interface{}
+
+type jsonEncoder struct {
+	err error //the first unsupported node
+}
+
+func (e *jsonEncoder) node(node Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	if v := reflect.ValueOf(node); v.Kind() == reflect.Ptr && v.IsNil() { //e.g. a nil *BlockStatement
+		return nil
+	}
+
+	obj := jsonObject{"pos": jsonPosition(node.Pos()), "end": jsonPosition(node.End())}
+	withToken := func(typ string, tok token.Token) {
+		obj["type"] = typ
+		obj["literal"] = tok.Literal
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		obj["type"] = "Program"
+		obj["statements"] = e.statements(n.Statements)
+		if n.Precedences != nil { //#prec(+, 13)
+			obj["precedences"] = n.Precedences
+		}
+	case *ImportStatement:
+		withToken("ImportStatement", n.Token)
+		obj["importPath"] = n.ImportPath
+		obj["path"] = n.Path
+		obj["condition"] = e.node(n.Condition)
+	case *LetStatement:
+		withToken("LetStatement", n.Token)
+		obj["names"] = e.identifiers(n.Names)
+		obj["values"] = e.expressions(n.Values)
+		obj["mutable"] = n.Mutable
+		obj["pattern"] = e.node(n.Pattern)
+	case *ReturnStatement:
+		withToken("ReturnStatement", n.Token)
+		obj["returnValues"] = e.expressions(n.ReturnValues)
+	case *TailCallStatement:
+		withToken("TailCallStatement", n.Token)
+		obj["call"] = e.node(n.Call)
+	case *UseStatement:
+		withToken("UseStatement", n.Token)
+		obj["name"] = e.node(n.Name)
+		obj["value"] = e.node(n.Value)
+		obj["block"] = e.node(n.Block)
+	case *ExportStatement:
+		withToken("ExportStatement", n.Token)
+		obj["names"] = n.Names
+	case *BlockStatement:
+		withToken("BlockStatement", n.Token)
+		obj["statements"] = e.statements(n.Statements)
+	case *ExpressionStatement:
+		withToken("ExpressionStatement", n.Token)
+		obj["expression"] = e.node(n.Expression)
+	case *MultiAssignStatement:
+		withToken("MultiAssignStatement", n.Token)
+		obj["names"] = e.expressions(n.Names)
+		obj["values"] = e.expressions(n.Values)
+	case *StructStatement:
+		withToken("StructStatement", n.Token)
+		obj["name"] = n.Name
+		fields := []interface{}{}
+		for _, f := range n.Fields {
+			fields = append(fields, e.node(f))
+		}
+		obj["fields"] = fields
+		obj["block"] = e.node(n.Block)
+	case *StructField:
+		obj["type"] = "StructField"
+		obj["name"] = e.node(n.Name)
+		obj["default"] = e.node(n.Default)
+	case *TryStmt:
+		withToken("TryStmt", n.Token)
+		obj["try"] = e.node(n.Try)
+		obj["var"] = n.Var
+		obj["catch"] = e.node(n.Catch)
+		obj["finally"] = e.node(n.Finally)
+	case *ThrowStmt:
+		withToken("ThrowStmt", n.Token)
+		obj["expr"] = e.node(n.Expr)
+
+	case *HashPattern:
+		withToken("HashPattern", n.Token)
+		obj["elements"] = e.patternElements(n.Elements)
+	case *ArrayPattern:
+		withToken("ArrayPattern", n.Token)
+		obj["elements"] = e.patternElements(n.Elements)
+	case *PatternElement:
+		obj["type"] = "PatternElement"
+		obj["name"] = e.node(n.Name)
+		obj["default"] = e.node(n.Default)
+
+	case *Identifier:
+		withToken("Identifier", n.Token)
+		obj["value"] = n.Value
+	case *NumberLiteral:
+		withToken("NumberLiteral", n.Token)
+		obj["value"] = n.Value
+		obj["isInt"] = n.IsInt
+		obj["int"] = n.Int
+	case *CharLiteral:
+		withToken("CharLiteral", n.Token)
+		obj["value"] = string(n.Value)
+	case *NilLiteral:
+		withToken("NilLiteral", n.Token)
+	case *BooleanLiteral:
+		withToken("BooleanLiteral", n.Token)
+		obj["value"] = n.Value
+	case *StringLiteral:
+		withToken("StringLiteral", n.Token)
+		obj["value"] = n.Value
+		obj["raw"] = n.Raw
+		if n.EscapedDollars != nil { //"cost \$5"
+			obj["escapedDollars"] = n.EscapedDollars
+		}
+	case *InterpolatedStringLiteral:
+		withToken("InterpolatedStringLiteral", n.Token)
+		obj["parts"] = e.expressions(n.Parts)
+	case *RegExLiteral:
+		withToken("RegExLiteral", n.Token)
+		obj["value"] = n.Value
+	case *CmdExpression:
+		withToken("CmdExpression", n.Token)
+		obj["value"] = n.Value
+	case *ArrayLiteral:
+		withToken("ArrayLiteral", n.Token)
+		obj["members"] = e.expressions(n.Members)
+	case *ArrayComprehension:
+		withToken("ArrayComprehension", n.Token)
+		obj["expr"] = e.node(n.Expr)
+		clauses := []interface{}{}
+		for _, c := range n.Clauses {
+			clauses = append(clauses, e.node(c))
+		}
+		obj["clauses"] = clauses
+	case *ComprehensionClause:
+		withToken("ComprehensionClause", n.Token)
+		obj["var"] = n.Var
+		obj["value"] = e.node(n.Value)
+	case *TupleLiteral:
+		withToken("TupleLiteral", n.Token)
+		obj["members"] = e.expressions(n.Members)
+	case *HashLiteral:
+		withToken("HashLiteral", n.Token)
+		obj["isOrdered"] = n.IsOrdered
+		pairs := []interface{}{} //in the declaration order
+		for _, key := range n.Order {
+			pairs = append(pairs, jsonObject{"key": e.node(key), "value": e.node(n.Pairs[key])})
+		}
+		obj["pairs"] = pairs
+	case *FunctionLiteral:
+		withToken("FunctionLiteral", n.Token)
+		obj["name"] = n.Name
+		obj["parameters"] = e.identifiers(n.Parameters)
+		obj["defaults"] = e.expressions(n.Defaults)
+		obj["variadic"] = n.Variadic
+		obj["async"] = n.Async
+		obj["static"] = n.Static
+		obj["returnType"] = e.node(n.ReturnType)
+		obj["body"] = e.node(n.Body)
+		where := []interface{}{}
+		for _, w := range n.Where {
+			where = append(where, e.node(w))
+		}
+		obj["where"] = where
+
+	case *InfixExpression:
+		withToken("InfixExpression", n.Token)
+		obj["left"] = e.node(n.Left)
+		obj["operator"] = n.Operator
+		obj["right"] = e.node(n.Right)
+		if n.HasNext { //a < b < c
+			obj["nextOperator"] = n.NextOperator
+			obj["next"] = e.node(n.Next)
+		}
+	case *PrefixExpression:
+		withToken("PrefixExpression", n.Token)
+		obj["operator"] = n.Operator
+		obj["right"] = e.node(n.Right)
+	case *PostfixExpression:
+		withToken("PostfixExpression", n.Token)
+		obj["left"] = e.node(n.Left)
+		obj["operator"] = n.Operator
+	case *RangeExpression:
+		withToken("RangeExpression", n.Token)
+		obj["startIdx"] = e.node(n.StartIdx) //not "end", which is the end position
+		obj["endIdx"] = e.node(n.EndIdx)
+		obj["inclusive"] = n.Inclusive
+	case *TernaryExpression:
+		withToken("TernaryExpression", n.Token)
+		obj["condition"] = e.node(n.Condition)
+		obj["ifTrue"] = e.node(n.IfTrue)
+		obj["ifFalse"] = e.node(n.IfFalse)
+	case *AwaitExpression:
+		withToken("AwaitExpression", n.Token)
+		obj["value"] = e.node(n.Value)
+	case *IndexExpression:
+		withToken("IndexExpression", n.Token)
+		obj["left"] = e.node(n.Left)
+		obj["index"] = e.node(n.Index)
+		obj["optional"] = n.Optional
+	case *SliceExpression:
+		withToken("SliceExpression", n.Token)
+		obj["left"] = e.node(n.Left)
+		obj["low"] = e.node(n.Low)
+		obj["high"] = e.node(n.High)
+		obj["step"] = e.node(n.Step)
+		obj["optional"] = n.Optional
+	case *CallExpression:
+		withToken("CallExpression", n.Token)
+		obj["function"] = e.node(n.Function)
+		obj["arguments"] = e.expressions(n.Arguments)
+		obj["variadic"] = n.Variadic
+		obj["optional"] = n.Optional
+	case *NamedArgument:
+		withToken("NamedArgument", n.Token)
+		obj["name"] = e.node(n.Name)
+		obj["value"] = e.node(n.Value)
+	case *MethodCallExpression:
+		withToken("MethodCallExpression", n.Token)
+		obj["object"] = e.node(n.Object)
+		obj["call"] = e.node(n.Call)
+		obj["optional"] = n.Optional
+	case *ScopeResolution:
+		withToken("ScopeResolution", n.Token)
+		obj["left"] = e.node(n.Left)
+		obj["member"] = e.node(n.Member)
+	case *AssignExpression:
+		withToken("AssignExpression", n.Token)
+		obj["name"] = e.node(n.Name)
+		obj["value"] = e.node(n.Value)
+	case *DeclareAssignExpression:
+		withToken("DeclareAssignExpression", n.Token)
+		obj["name"] = e.node(n.Name)
+		obj["value"] = e.node(n.Value)
+	case *DecoratorExpr:
+		withToken("DecoratorExpr", n.Token)
+		obj["decorator"] = e.node(n.Decorator)
+		obj["decorated"] = e.node(n.Decorated)
+
+	case *IfExpression:
+		withToken("IfExpression", n.Token)
+		conditions := []interface{}{}
+		for _, c := range n.Conditions {
+			conditions = append(conditions, e.node(c))
+		}
+		obj["conditions"] = conditions
+		obj["alternative"] = e.node(n.Alternative)
+	case *IfConditionExpr:
+		withToken("IfConditionExpr", n.Token)
+		obj["cond"] = e.node(n.Cond)
+		obj["body"] = e.node(n.Body)
+	case *SwitchExpression:
+		withToken("SwitchExpression", n.Token)
+		obj["expr"] = e.node(n.Expr)
+		cases := []interface{}{}
+		for _, c := range n.Cases {
+			cases = append(cases, e.node(c))
+		}
+		obj["cases"] = cases
+	case *CaseExpression:
+		withToken("CaseExpression", n.Token)
+		obj["default"] = n.Default
+		obj["exprs"] = e.expressions(n.Exprs)
+		obj["block"] = e.node(n.Block)
+	case *TypePattern:
+		withToken("TypePattern", n.Token)
+		obj["typeName"] = e.node(n.Type) //not "type", which is the node's kind
+	case *MatchExpression:
+		withToken("MatchExpression", n.Token)
+		obj["expr"] = e.node(n.Expr)
+		arms := []interface{}{}
+		for _, arm := range n.Arms {
+			arms = append(arms, e.node(arm))
+		}
+		obj["arms"] = arms
+	case *MatchArm:
+		withToken("MatchArm", n.Token)
+		obj["pattern"] = e.node(n.Pattern)
+		obj["body"] = e.node(n.Body)
+	case *FallthroughExpression:
+		withToken("FallthroughExpression", n.Token)
+		obj["target"] = e.node(n.Target)
+	case *BreakExpression:
+		withToken("BreakExpression", n.Token)
+	case *ContinueExpression:
+		withToken("ContinueExpression", n.Token)
+	case *DoExpression:
+		withToken("DoExpression", n.Token)
+		obj["block"] = e.node(n.Block)
+
+	case *CForLoop:
+		withToken("CForLoop", n.Token)
+		obj["init"] = e.node(n.Init)
+		obj["cond"] = e.node(n.Cond)
+		obj["update"] = e.node(n.Update)
+		obj["block"] = e.node(n.Block)
+	case *ForEachArrayLoop:
+		withToken("ForEachArrayLoop", n.Token)
+		obj["var"] = n.Var
+		obj["value"] = e.node(n.Value)
+		obj["block"] = e.node(n.Block)
+	case *ForEachMapLoop:
+		withToken("ForEachMapLoop", n.Token)
+		obj["key"] = n.Key
+		obj["value"] = n.Value
+		obj["x"] = e.node(n.X)
+		obj["block"] = e.node(n.Block)
+	case *ForEverLoop:
+		withToken("ForEverLoop", n.Token)
+		obj["block"] = e.node(n.Block)
+	case *WhileLoop:
+		withToken("WhileLoop", n.Token)
+		obj["condition"] = e.node(n.Condition)
+		obj["block"] = e.node(n.Block)
+	case *DoLoop:
+		withToken("DoLoop", n.Token)
+		obj["block"] = e.node(n.Block)
+
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("ast.ToJSON: unsupported node type %T", node)
+		}
+		return nil
+	}
+
+	return obj
+}
+
+func (e *jsonEncoder) identifiers(idents []*Identifier) []interface{} {
+	list := []interface{}{}
+	for _, ident := range idents {
+		list = append(list, e.node(ident))
+	}
+	return list
+}
+
+func (e *jsonEncoder) patternElements(elements []*PatternElement) []interface{} {
+	list := []interface{}{}
+	for _, element := range elements {
+		list = append(list, e.node(element))
+	}
+	return list
+}
+
+func (e *jsonEncoder) expressions(exprs []Expression) []interface{} {
+	list := []interface{}{}
+	for _, expr := range exprs {
+		list = append(list, e.node(expr))
+	}
+	return list
+}
+
+func (e *jsonEncoder) statements(stmts []Statement) []interface{} {
+	list := []interface{}{}
+	for _, stmt := range stmts {
+		list = append(list, e.node(stmt))
+	}
+	return list
+}
+
+func jsonPosition(pos token.Position) jsonObject {
+	return jsonObject{"line": pos.Line, "col": pos.Col}
+}
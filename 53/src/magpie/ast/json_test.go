@@ -0,0 +1,58 @@
+package ast_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"magpie/ast"
+	"magpie/lexer"
+	"magpie/parser"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestToJSON(t *testing.T) {
+	tests := []string{
+		"program", //testdata/program.mp => testdata/program.json
+	}
+
+	for _, name := range tests {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", name+".mp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := parser.NewParser(lexer.NewLexer(string(src)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parser errors: %v", name, p.Errors())
+		}
+
+		got, err := ast.ToJSON(program)
+		if err != nil {
+			t.Fatalf("%s: ToJSON failed: %s", name, err)
+		}
+
+		golden := filepath.Join("testdata", name+".json")
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("%s: ToJSON does not match %s, run 'go test -update' if the change is expected.\ngot:\n%s", name, golden, got)
+		}
+	}
+}
+
+func TestToJSONNil(t *testing.T) {
+	got, err := ast.ToJSON(nil)
+	if err != nil || string(got) != "null" {
+		t.Errorf("ToJSON(nil) = %q, %v, expected \"null\", nil", got, err)
+	}
+}
@@ -0,0 +1,59 @@
+package ast
+
+//MaxNestingDepth returns the deepest nesting of blocks/loops/conditionals in a function body.
+//Each block, loop, if, switch, try and use increments the depth, nested function literals are not counted.
+//e.g.
+//   fn f() { x = 1 }                      => 0
+//   fn f() { if x { for i in arr { } } }  => 2
+func MaxNestingDepth(fn *FunctionLiteral) int {
+	if fn == nil || fn.Body == nil {
+		return 0
+	}
+	return nestingDepth(fn.Body)
+}
+
+//returns the nesting depth of the node, including the node itself. The children are found by
+//children(), so every node type is descended into, e.g. the operands of a ternary expression.
+func nestingDepth(node Node) int {
+	switch n := node.(type) {
+	case nil:
+		return 0
+	case *FunctionLiteral: //do not descend into nested functions
+		return 0
+	case *BlockStatement:
+		if n == nil {
+			return 0
+		}
+	}
+
+	depth := 0
+	_, inBlock := node.(*BlockStatement)
+	for _, child := range children(node) {
+		d := nestingDepth(child)
+		if _, ok := child.(*BlockStatement); ok && inBlock { //a nested '{ ... }' block statement
+			d++
+		}
+		depth = maxDepth(depth, d)
+	}
+	if incrementsDepth(node) {
+		depth++
+	}
+	return depth
+}
+
+//The body of a loop, an if branch or a case is not counted again as a block.
+func incrementsDepth(node Node) bool {
+	switch node.(type) {
+	case *IfExpression, *SwitchExpression, *MatchExpression, *TryStmt, *UseStatement, *DoExpression,
+		*CForLoop, *ForEachArrayLoop, *ForEachMapLoop, *ForEverLoop, *WhileLoop, *DoLoop:
+		return true
+	}
+	return false
+}
+
+func maxDepth(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
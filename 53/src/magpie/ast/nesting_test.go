@@ -0,0 +1,62 @@
+package ast_test
+
+import (
+	"magpie/ast"
+	"magpie/lexer"
+	"magpie/parser"
+	"strings"
+	"testing"
+)
+
+//parses the input, and fails the test if there are any errors
+func parseProgram(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: unexpected parser errors: %s", input, strings.Join(p.Errors(), "; "))
+	}
+	return program
+}
+
+//returns the first function literal of the program
+func firstFunction(t *testing.T, program *ast.Program) *ast.FunctionLiteral {
+	t.Helper()
+	var fn *ast.FunctionLiteral
+	ast.Walk(program, func(node ast.Node) bool {
+		if f, ok := node.(*ast.FunctionLiteral); ok && fn == nil {
+			fn = f
+		}
+		return fn == nil
+	})
+	if fn == nil {
+		t.Fatal("no function literal found")
+	}
+	return fn
+}
+
+func TestMaxNestingDepth(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"fn f() { x = 1 }", 0},
+		{"fn f() { return x + 1 }", 0},
+		{"fn f() { if x { for i in arr { } } }", 2},
+		{"fn f() { while x { if y { switch z { case 1 { try { } catch e { } } } } } }", 4},
+		{"fn f() { { { x } } }", 2},
+		{"fn f() { fn g() { if x { if y { } } } }", 0}, //nested functions are not counted
+		{"fn f() { x = y ? do { if a { } } : 1 }", 2},
+		{"fn f() { x = arr[do { 1 }:] }", 1},
+		{"fn f() { switch x { case do { if a { 1 } } { } } }", 3},
+		{"fn f() { x = [if a { 1 } for a in do { b }] }", 1},
+		{"fn f() { return { \"k\": if a { while b { } } } }", 2},
+	}
+
+	for _, tt := range tests {
+		fn := firstFunction(t, parseProgram(t, tt.input))
+		if got := ast.MaxNestingDepth(fn); got != tt.expected {
+			t.Errorf("%q: expected depth %d, got %d", tt.input, tt.expected, got)
+		}
+	}
+}
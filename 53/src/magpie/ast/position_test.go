@@ -0,0 +1,85 @@
+package ast_test
+
+import (
+	"fmt"
+	"magpie/ast"
+	"testing"
+)
+
+//returns the first node of the given type in the program
+func firstNode(t *testing.T, program *ast.Program, nodeType string) ast.Node {
+	t.Helper()
+	var found ast.Node
+	ast.Walk(program, func(node ast.Node) bool {
+		if found == nil && node.NodeType() == nodeType {
+			found = node
+		}
+		return found == nil
+	})
+	if found == nil {
+		t.Fatalf("no %s found", nodeType)
+	}
+	return found
+}
+
+func TestBlockEnd(t *testing.T) {
+	tests := []struct {
+		input    string
+		nodeType string
+		pos      string
+		end      string //the column after the last character
+	}{
+		{"if x {\n  a = 1\n  b = 2\n  c = 3\n  }", "BlockStatement", "1:6", "5:4"},
+		{"fn() {\n}", "BlockStatement", "1:6", "2:2"},
+		{"let f = (x) => x + 1", "BlockStatement", "1:16", "1:21"}, //brace-less body
+		{"switch x { case 1: doA()\n}", "BlockStatement", "1:18", "1:25"},
+		{"a < b < c", "InfixExpression", "1:1", "1:10"},
+	}
+
+	for _, tt := range tests {
+		node := firstNode(t, parseProgram(t, tt.input), tt.nodeType)
+		pos, end := node.Pos(), node.End()
+		if got := fmt.Sprintf("%d:%d", pos.Line, pos.Col); got != tt.pos {
+			t.Errorf("%q: expected %s to start at %s, got %s", tt.input, tt.nodeType, tt.pos, got)
+		}
+		if got := fmt.Sprintf("%d:%d", end.Line, end.Col); got != tt.end {
+			t.Errorf("%q: expected %s to end at %s, got %s", tt.input, tt.nodeType, tt.end, got)
+		}
+	}
+}
+
+func TestCallPos(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   string
+	}{
+		{"foo(1)", "1:1"},
+		{"  obj.method(1)", "1:3"},
+		{"a.b.c(1)", "1:1"},
+		{"f(1)(2)", "1:1"},
+		{"let x = 1\n  obj.a\n.b(\n1)", "2:3"}, //the callee starts on a previous line
+		{"let x = 1\n  foo(\n1,\n2)", "2:3"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		var call ast.Node
+		ast.Walk(program, func(node ast.Node) bool {
+			switch node.(type) {
+			case *ast.CallExpression, *ast.MethodCallExpression:
+				if call == nil {
+					call = node
+				}
+			}
+			return call == nil
+		})
+		if call == nil {
+			t.Errorf("%q: no call found", tt.input)
+			continue
+		}
+		pos := call.Pos()
+		if got := fmt.Sprintf("%d:%d", pos.Line, pos.Col); got != tt.pos {
+			t.Errorf("%q: expected the call to start at %s, got %s", tt.input, tt.pos, got)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package ast
+
+import (
+	"magpie/token"
+)
+
+//NodeSpan returns the source range of the node, from Pos() to End().
+func NodeSpan(node Node) token.Span {
+	return token.Span{Start: node.Pos(), End: node.End()}
+}
+
+//Source returns the exact text of the node in 'src', which is the source the node is parsed from,
+//e.g. for underlining the node in error messages. The text could span multiple lines.
+//It returns "" if the node's positions are not inside 'src', e.g. for an empty program.
+//The parentheses are not kept in the tree, so they are not part of the text, e.g. the text of
+//'a * (b + c)' misses the closing ')'.
+func Source(node Node, src string) string {
+	return NodeSpan(node).Text(src)
+}
@@ -0,0 +1,80 @@
+package ast_test
+
+import (
+	"magpie/ast"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []string{ //each is one expression, its source must be the whole input
+		"a + b",
+		"1 + 2 * 3",
+		"-x",
+		"f(1, 2)",
+		"f()",
+		"obj.method(1)",
+		"[1, 2, 3]",
+		"[]",
+		`"hello"`,
+		`"a\tb"`,
+		"\"h\u00e9llo\"",
+		`"hi ${name}!"`,
+		"@{\"a\": 1}",
+		"```raw```",
+		"/ab+c/",
+		"a[1]",
+		"a[1:2]",
+		"a.b",
+		"x = 1",
+		"a ? b : c",
+		"1..10",
+		"fn(x) { x }",
+		"x => x + 1",
+		"(x, y) => x + y",
+		"if a { 1 } else { 2 }",
+		"m::x",
+		"'c'",
+		"true",
+		"nil",
+		"3.14",
+		"a < b < c",
+		"(1, 2)",
+		"x++",
+		"f(x) { y -> y }",
+		"do { 1 }",
+		"[x for x in arr]",
+		"f(a: 1)",
+		"a?.b(1)",
+		"`ls`",
+	}
+
+	for _, input := range tests {
+		program := parseProgram(t, input)
+		node := program.Statements[0].(*ast.ExpressionStatement).Expression
+		if got := ast.Source(node, input); got != input {
+			t.Errorf("%q: expected the source of %T to be the whole input, got %q", input, node, got)
+		}
+	}
+}
+
+func TestSourceMultiLine(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string //the source of the last statement
+	}{
+		{"let a = 1\nf(a,\n  2)", "f(a,\n  2)"},
+		{"let a = [\n  1,\n  2,\n]", "let a = [\n  1,\n  2,\n]"},
+		{"x\nfn(a) {\n  a + 1\n}", "fn(a) {\n  a + 1\n}"},
+		{"x\n```line1\nline2```", "```line1\nline2```"},
+		{"x\nobj.call(\"h\u00e9\",\n  \"${y}\")", "obj.call(\"h\u00e9\",\n  \"${y}\")"},
+		{"x\na +\n  b", "a +\n  b"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		node := program.Statements[len(program.Statements)-1]
+		if got := ast.Source(node, tt.input); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package ast
+
+//StringLiterals returns all the string literals in the node(including the node itself),
+//in source order. For interpolated strings, their literal segments are returned.
+//Useful for tools which extract the messages for localization.
+func StringLiterals(node Node) []*StringLiteral {
+	var result []*StringLiteral
+
+	var collect func(n Node)
+	collect = func(n Node) {
+		if s, ok := n.(*StringLiteral); ok {
+			result = append(result, s)
+			return
+		}
+		for _, child := range children(n) {
+			collect(child)
+		}
+	}
+	collect(node)
+
+	return result
+}
@@ -0,0 +1,33 @@
+package ast_test
+
+import (
+	"fmt"
+	"magpie/ast"
+	"strings"
+	"testing"
+)
+
+func TestStringLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string //value@line:col
+	}{
+		{`println("hello")`, []string{"hello@1:9"}},
+		{"let a = \"x\"\nfn f() {\n  fn g() { return \"nested\" }\n}", []string{"x@1:9", "nested@3:19"}},
+		{`let h = {"k": "v"}; h["k"]`, []string{"k@1:10", "v@1:15", "k@1:23"}},
+		{`"hello ${name}, bye"`, []string{"hello @1:2", ", bye@1:15"}}, //the segments of an interpolated string
+		{`"a\tb"`, []string{"a\tb@1:1"}},                               //the decoded value
+		{`"cost \$5" + "cost \x245"`, []string{"cost $5@1:1", "cost $5@1:14"}},
+		{"let x = 1", nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, s := range ast.StringLiterals(parseProgram(t, tt.input)) {
+			got = append(got, fmt.Sprintf("%s@%d:%d", s.Value, s.Pos().Line, s.Pos().Col))
+		}
+		if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package ast
+
+//Walk traverses the tree rooted at node in depth-first pre-order: it calls visitor(node),
+//and if the visitor returns true, walks each child of the node in source order.
+//Imported programs(ImportStatement.Program) are not walked into.
+//e.g. count the function literals of a program:
+//   n := 0
+//   ast.Walk(program, func(node ast.Node) bool {
+//       if _, ok := node.(*ast.FunctionLiteral); ok {
+//           n++
+//       }
+//       return true
+//   })
+func Walk(node Node, visitor func(Node) bool) {
+	if node == nil || !visitor(node) {
+		return
+	}
+	for _, child := range children(node) {
+		Walk(child, visitor)
+	}
+}
+
+//SetParents returns the parent of every node in the tree rooted at program, so refactoring tools
+//could walk up the tree, e.g. parents[node] is the statement or expression which contains node.
+//The program itself has no entry. Like Walk, imported programs are not walked into.
+func SetParents(program *Program) map[Node]Node {
+	parents := make(map[Node]Node)
+	var setParents func(node Node)
+	setParents = func(node Node) {
+		for _, child := range children(node) {
+			parents[child] = node
+			setParents(child)
+		}
+	}
+	if program != nil {
+		setParents(program)
+	}
+
+	return parents
+}
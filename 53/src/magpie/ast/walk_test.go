@@ -0,0 +1,144 @@
+package ast_test
+
+import (
+	"magpie/ast"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	const input = `@log
+fn add(a, b) { a + b }
+for (i = 0; i < 3; i++) { continue }
+for i in [1] { break }
+while x > 0 { x = x - 1 }
+switch x { case 1 { "a" } default { "b" } }
+try { throw "e" } catch e { e } finally { 0 }
+`
+	counts := map[string]int{}
+	ast.Walk(parseProgram(t, input), func(node ast.Node) bool {
+		counts[node.NodeType()]++
+		return true
+	})
+
+	expected := map[string]int{
+		"Program":            1,
+		"DecoratorExpr":      1,
+		"FunctionLiteral":    1,
+		"CForLoop":           1,
+		"PostfixExpression":  1,
+		"ContinueExpression": 1,
+		"ForEachArrayLoop":   1,
+		"ArrayLiteral":       1,
+		"BreakExpression":    1,
+		"WhileLoop":          1,
+		"SwitchExpression":   1,
+		"CaseExpression":     2,
+		"TryStmt":            1,
+		"ThrowStmt":          1,
+		"StringLiteral":      3,
+		"BlockStatement":     9, //fn, 3 loops, 2 cases, try, catch and finally
+	}
+	for kind, n := range expected {
+		if counts[kind] != n {
+			t.Errorf("expected %d %s, got %d", n, kind, counts[kind])
+		}
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"-a + f(b)", []string{"Program", "ExpressionStatement", "InfixExpression", "PrefixExpression", "Identifier a",
+			"CallExpression", "Identifier f", "Identifier b"}},
+		{"let x = [1, y]", []string{"Program", "LetStatement", "Identifier x", "ArrayLiteral", "NumberLiteral 1", "Identifier y"}},
+	}
+
+	for _, tt := range tests {
+		var order []string
+		ast.Walk(parseProgram(t, tt.input), func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.Identifier:
+				order = append(order, "Identifier "+n.Value)
+			case *ast.NumberLiteral:
+				order = append(order, "NumberLiteral "+n.String())
+			default:
+				order = append(order, node.NodeType())
+			}
+			return true
+		})
+		if strings.Join(order, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("%q: expected the order %v, got %v", tt.input, tt.expected, order)
+		}
+	}
+}
+
+//returning false stops the descent into the node's children, not the walk of its siblings
+func TestWalkSkip(t *testing.T) {
+	program := parseProgram(t, "fn(a) { a + 1 }; b + 2")
+	var idents []string
+	ast.Walk(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			idents = append(idents, ident.Value)
+		}
+		_, isFunc := node.(*ast.FunctionLiteral)
+		return !isFunc
+	})
+	if strings.Join(idents, " ") != "b" {
+		t.Errorf("expected only b to be visited, got %v", idents)
+	}
+
+	ast.Walk(nil, func(node ast.Node) bool {
+		t.Errorf("expected a nil node not to be visited")
+		return true
+	})
+}
+
+func TestSetParents(t *testing.T) {
+	program := parseProgram(t, "let x = a + b * c\nif x { let y = 1 }")
+	parents := ast.SetParents(program)
+
+	if _, ok := parents[program]; ok {
+		t.Errorf("expected the program to have no parent")
+	}
+
+	var c *ast.Identifier
+	var y *ast.LetStatement
+	ast.Walk(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok && ident.Value == "c" {
+			c = ident
+		}
+		if let, ok := node.(*ast.LetStatement); ok && let.Names[0].Value == "y" {
+			y = let
+		}
+		return true
+	})
+
+	//c -> (b * c) -> (a + (b * c)) -> let x -> program
+	mul, ok := parents[c].(*ast.InfixExpression)
+	if !ok || mul.Operator != "*" {
+		t.Fatalf("expected the parent of c to be b * c, got %v", parents[c])
+	}
+	add, ok := parents[mul].(*ast.InfixExpression)
+	if !ok || add.Operator != "+" {
+		t.Fatalf("expected the parent of b * c to be a + b * c, got %v", parents[mul])
+	}
+	if let, ok := parents[add].(*ast.LetStatement); !ok || parents[let] != program {
+		t.Errorf("expected a + b * c to be in a top level let statement, got %v", parents[add])
+	}
+
+	//let y -> block -> if condition -> if expression
+	block, ok := parents[y].(*ast.BlockStatement)
+	if !ok {
+		t.Fatalf("expected the parent of 'let y' to be a block, got %T", parents[y])
+	}
+	if _, ok := parents[block].(*ast.IfConditionExpr); !ok {
+		t.Errorf("expected the parent of the block to be the if condition, got %T", parents[block])
+	}
+
+	if len(ast.SetParents(nil)) != 0 {
+		t.Errorf("expected no parents for a nil program")
+	}
+}
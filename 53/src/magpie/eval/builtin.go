@@ -194,54 +194,63 @@ func typeBuiltin() *Builtin {
 				return newError(line, ERR_ARGUMENT, 1, len(args))
 			}
 
-			switch args[0].(type) {
-			case *Number:
-				return NewString("number")
-			case *Nil:
-				return NewString("nil")
-			case *Boolean:
-				return NewString("bool")
-			case *Error:
-				return NewString("error")
-			case *Break:
-				return NewString("break")
-			case *Continue:
-				return NewString("continue")
-			case *ReturnValue:
-				return NewString("return")
-			case *Function:
-				return NewString("function")
-			case *Builtin:
-				return NewString("builtin")
-			case *RegEx:
-				return NewString("regex")
-			case *GoObject:
-				return NewString("go")
-			case *GoFuncObject:
-				return NewString("gofunction")
-			case *FileObject:
-				return NewString("file")
-			case *Os:
-				return NewString("os")
-			case *Struct:
-				return NewString("struct")
-			case *Throw:
-				return NewString("throw")
-			case *String:
-				return NewString("string")
-			case *Array:
-				return NewString("array")
-			case *Tuple:
-				return NewString("tuple")
-			case *Hash:
-				return NewString("hash")
-			default:
+			name := typeName(args[0])
+			if name == "" {
 				return newError(line, "argument to `type` not supported, got=%s", args[0].Type())
 			}
+			return NewString(name)
 		},
 	}
 }
 
+//typeName returns the type name of the object used by 'type()' and the type pattern
+//of match arms(e.g. 'is number => x'), "" if not supported.
+func typeName(obj Object) string {
+	switch obj.(type) {
+	case *Number:
+		return "number"
+	case *Nil:
+		return "nil"
+	case *Boolean:
+		return "bool"
+	case *Error:
+		return "error"
+	case *Break:
+		return "break"
+	case *Continue:
+		return "continue"
+	case *ReturnValue:
+		return "return"
+	case *Function:
+		return "function"
+	case *Builtin:
+		return "builtin"
+	case *RegEx:
+		return "regex"
+	case *GoObject:
+		return "go"
+	case *GoFuncObject:
+		return "gofunction"
+	case *FileObject:
+		return "file"
+	case *Os:
+		return "os"
+	case *Struct:
+		return "struct"
+	case *Throw:
+		return "throw"
+	case *String:
+		return "string"
+	case *Array:
+		return "array"
+	case *Tuple:
+		return "tuple"
+	case *Hash:
+		return "hash"
+	}
+	return ""
+}
+
 func flushStdoutBuiltin() *Builtin {
 	return &Builtin{
 		Fn: func(line string, scope *Scope, args ...Object) Object {
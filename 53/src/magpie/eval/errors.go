@@ -31,7 +31,9 @@ var (
 	ERR_DECORATOR       = "decorator '%s' is not a function"
 	ERR_DECORATED_NAME  = "can not find the name of the decorated function"
 	ERR_DECORATOR_FN    = "a decorator must decorate a named function or another decorator"
-	ERR_PIPE            = "pipe operator's right hand side is not a function"
+	ERR_DESTRUCTURE     = "can not destructure %s with a %s pattern"
+	ERR_SLICE           = "slice error: %s"
+	ERR_NAMEDARG        = "named argument error: %s"
 )
 
 func newError(line string, format string, args ...interface{}) *Error {
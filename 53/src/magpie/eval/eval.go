@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"magpie/ast"
+	"magpie/parser"
+	"magpie/token"
 	"math"
 	"os"
 	"os/exec"
@@ -48,14 +50,20 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		return Eval(node.Expression, scope)
 	case *ast.NumberLiteral:
 		return evalNumber(node, scope)
+	case *ast.CharLiteral:
+		return evalCharLiteral(node, scope)
 	case *ast.StringLiteral:
 		return evalStringLiteral(node, scope)
+	case *ast.InterpolatedStringLiteral:
+		return evalInterpolatedStringLiteral(node, scope)
 	case *ast.FunctionLiteral:
 		return evalFunctionLiteral(node, scope)
 	case *ast.StructStatement:
 		return evalStructStatement(node, scope)
 	case *ast.SwitchExpression:
 		return evalSwitchExpression(node, scope)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, scope)
 	case *ast.TryStmt:
 		return evalTryStatement(node, scope)
 	case *ast.ThrowStmt:
@@ -64,6 +72,10 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		return evalCallExpression(node, nil, scope)
 	case *ast.MethodCallExpression:
 		return evalMethodCallExpression(node, scope)
+	case *ast.NamedArgument: //only evaluated by evalCallExpression()
+		return newError(node.Pos().Sline(), ERR_NAMEDARG, fmt.Sprintf("'%s' is only allowed in function calls", node.Name.Value))
+	case *ast.ScopeResolution:
+		return evalScopeResolution(node, scope)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, scope)
 		if isError(right) {
@@ -71,8 +83,11 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		}
 		return evalPrefixExpression(node, right, scope)
 	case *ast.InfixExpression:
-		if node.Operator == "|>" {
-			return evalPipeInfix(node, scope)
+		if node.Operator == "??" {
+			return evalNilCoalescingInfix(node, scope)
+		}
+		if node.Operator == "?:" {
+			return evalElvisInfix(node, scope)
 		}
 
 		left := Eval(node.Left, scope)
@@ -84,6 +99,10 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 			return right
 		}
 		return evalInfixExpression(node, left, right, scope)
+	case *ast.RangeExpression:
+		return evalRangeExpression(node, scope)
+	case *ast.TernaryExpression:
+		return evalTernaryExpression(node, scope)
 	case *ast.PostfixExpression:
 		left := Eval(node.Left, scope)
 		if left.Type() == ERROR_OBJ {
@@ -94,6 +113,8 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.NilLiteral:
 		return NIL
+	case *ast.ArrayComprehension:
+		return evalArrayComprehension(node, scope)
 	case *ast.ArrayLiteral:
 		members := evalExpressions(node.Members, scope)
 		if len(members) == 1 && isError(members[0]) {
@@ -106,6 +127,9 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		if isError(left) {
 			return left
 		}
+		if node.Optional && left == NIL { //a?[i]
+			return NIL
+		}
 
 		index := Eval(node.Index, scope)
 		if isError(index) {
@@ -113,6 +137,8 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		}
 
 		return evalIndexExpression(node, left, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, scope)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, scope)
 	case *ast.TupleLiteral:
@@ -134,11 +160,16 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		return evalMultiAssignStatement(node, scope)
 	case *ast.AssignExpression:
 		return evalAssignExpression(node, scope)
+	case *ast.DeclareAssignExpression:
+		return evalDeclareAssignExpression(node, scope)
 	case *ast.BreakExpression:
 		return BREAK
 	case *ast.ContinueExpression:
 		return CONTINUE
 	case *ast.FallthroughExpression:
+		if node.Target != nil {
+			return &Fallthrough{Target: node.Target.String()}
+		}
 		return FALLTHROUGH
 	case *ast.CForLoop:
 		return evalCForLoopExpression(node, scope)
@@ -150,6 +181,8 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		return evalForEachMapExpression(node, scope)
 	case *ast.DoLoop:
 		return evalDoLoopExpression(node, scope)
+	case *ast.DoExpression:
+		return evalDoExpression(node, scope)
 	case *ast.WhileLoop:
 		return evalWhileLoopExpression(node, scope)
 
@@ -157,10 +190,17 @@ func Eval(node ast.Node, scope *Scope) (val Object) {
 		return evalRegExLiteral(node, scope)
 	case *ast.TailCallStatement:
 		return &TailCall{tail: node}
+	case *ast.UseStatement:
+		return evalUseStatement(node, scope)
+	case *ast.ExportStatement:
+		return evalExportStatement(node, scope)
 	case *ast.DecoratorExpr:
 		return evalDecorator(node, scope)
 	case *ast.CmdExpression:
 		return evalCmdExpression(node, scope)
+	case *ast.AwaitExpression:
+		//no real asynchrony yet, we just evaluate the awaited expression
+		return Eval(node.Value, scope)
 	}
 
 	return nil
@@ -174,6 +214,7 @@ func evalProgram(program *ast.Program, scope *Scope) (results Object) {
 		}
 	}
 
+	hoistFunctions(ast.HoistFunctions(&ast.BlockStatement{Statements: program.Statements}), scope)
 	for _, stmt := range program.Statements {
 		results = Eval(stmt, scope)
 		if returnValue, ok := results.(*ReturnValue); ok {
@@ -205,13 +246,32 @@ func loadImports(imports map[string]*ast.ImportStatement, scope *Scope) Object {
 }
 
 func evalImportStatement(i *ast.ImportStatement, scope *Scope) Object {
+	if i.Condition != nil { //import "debug/tools" if DEBUG
+		cond := Eval(i.Condition, scope)
+		if isError(cond) {
+			return cond
+		}
+		if !IsTrue(cond) {
+			return NIL
+		}
+	}
+
 	if importedScope, ok := importMap[i.ImportPath]; ok {
 		importedScope.GetAllExported(scope)
 		return NIL
 	}
 
+	program := i.Program
+	if program == nil { //a conditional import, which is loaded only when its condition is true
+		var err error
+		program, err = parser.LoadImport(i)
+		if err != nil {
+			return newError(i.Pos().Sline(), "%s", err)
+		}
+	}
+
 	newScope := NewScope(nil, scope.Writer)
-	v := evalProgram(i.Program, newScope)
+	v := evalProgram(program, newScope)
 	if v.Type() == ERROR_OBJ {
 		return newError(i.Pos().Sline(), ERR_IMPORT, i.ImportPath)
 	}
@@ -224,6 +284,7 @@ func evalImportStatement(i *ast.ImportStatement, scope *Scope) Object {
 
 func evalBlockStatement(block *ast.BlockStatement, scope *Scope) Object {
 	var result Object
+	hoistFunctions(ast.HoistFunctions(block), scope)
 	for _, statement := range block.Statements {
 		result = Eval(statement, scope)
 		if result != nil {
@@ -245,12 +306,64 @@ func evalBlockStatement(block *ast.BlockStatement, scope *Scope) Object {
 	return result
 }
 
+//binds the named functions of a block before running it, so they could be called before their declarations.
+func hoistFunctions(fns []*ast.FunctionLiteral, scope *Scope) {
+	for _, fl := range fns {
+		scope.Set(fl.Name, &Function{Literal: fl, Scope: scope})
+	}
+}
+
 func evalNumber(n *ast.NumberLiteral, scope *Scope) Object {
 	return NewNumber(n.Value)
 }
 
+//character literals are evaluated to their codepoints
+func evalCharLiteral(c *ast.CharLiteral, scope *Scope) Object {
+	return NewNumber(float64(c.Value))
+}
+
 func evalStringLiteral(s *ast.StringLiteral, scope *Scope) Object {
-	return NewString(InterpolateString(s.Value, scope))
+	if s.Raw {
+		return NewString(s.Value)
+	}
+	return NewString(interpolateStringLiteral(s, scope))
+}
+
+//interpolates the '$var' and '${var}' of a string literal, like InterpolateString(). The escape
+//sequences are already decoded, so a '$' written as an escape is found in s.EscapedDollars, instead
+//of being preceded by a backslash.
+func interpolateStringLiteral(s *ast.StringLiteral, scope *Scope) string {
+	re := regexp.MustCompile("\\$(\\{)?( )*([a-zA-Z_0-9]{1,})( )*(\\})?")
+	dollars := s.EscapedDollars
+	var out bytes.Buffer
+	last := 0
+	for _, loc := range re.FindAllStringIndex(s.Value, -1) {
+		for len(dollars) > 0 && dollars[0] < loc[0] {
+			dollars = dollars[1:]
+		}
+		if len(dollars) > 0 && dollars[0] == loc[0] { //e.g. "\$var"
+			continue
+		}
+		out.WriteString(s.Value[last:loc[0]])
+		out.WriteString(interpolateVar(s.Value[loc[0]:loc[1]], scope))
+		last = loc[1]
+	}
+	out.WriteString(s.Value[last:])
+	return out.String()
+}
+
+func evalInterpolatedStringLiteral(is *ast.InterpolatedStringLiteral, scope *Scope) Object {
+	var out bytes.Buffer
+
+	for _, part := range is.Parts {
+		v := Eval(part, scope)
+		if v.Type() == ERROR_OBJ {
+			return v
+		}
+		out.WriteString(v.Inspect())
+	}
+
+	return NewString(out.String())
 }
 
 func InterpolateString(str string, scope *Scope) string {
@@ -262,27 +375,32 @@ func InterpolateString(str string, scope *Scope) string {
 			return m[1:]
 		}
 
-		// If the string starts with $, then it's an interpolation.
-		// We support both ${var} and $var.
-		name := ""
-		if m[1] == '{' {
-			if m[len(m)-1] != '}' { // e.g. "my ${var"
-				return m
-			}
-			name = m[2 : len(m)-1] //remove first '{' and last '}'
-		} else {
-			name = m[1:]
-		}
+		return interpolateVar(m, scope)
+	})
+
+	return str
+}
 
-		v, ok := scope.Get(name)
-		if !ok { //not found, just return an empty string
-			return ""
+//returns the value of the '$var' or '${var}' in m
+func interpolateVar(m string, scope *Scope) string {
+	// If the string starts with $, then it's an interpolation.
+	// We support both ${var} and $var.
+	name := ""
+	if m[1] == '{' {
+		if m[len(m)-1] != '}' { // e.g. "my ${var"
+			return m
 		}
+		name = m[2 : len(m)-1] //remove first '{' and last '}'
+	} else {
+		name = m[1:]
+	}
 
-		return v.Inspect()
-	})
+	v, ok := scope.Get(name)
+	if !ok { //not found, just return an empty string
+		return ""
+	}
 
-	return str
+	return v.Inspect()
 }
 
 func evalFunctionLiteral(fl *ast.FunctionLiteral, scope *Scope) Object {
@@ -304,6 +422,7 @@ func evalSwitchExpression(switchExpr *ast.SwitchExpression, scope *Scope) Object
 	var defaultBlock *ast.BlockStatement
 	match := false
 	through := false
+	target := "" //the label of 'fallthrough case 3'
 
 loopCases:
 	for _, choice := range switchExpr.Cases { //iterate through all cases
@@ -312,9 +431,30 @@ loopCases:
 			continue
 		}
 
+		// targeted fallthrough, skip the cases until the labeled one.
+		if target != "" {
+			if !choice.HasLabel(target) {
+				continue
+			}
+			target = ""
+		}
+
 		// only go through the evaluation of the cases when not in fallthrough mode.
 		if !through {
 			for _, expr := range choice.Exprs {
+				// range match? e.g. 'case 1..5'
+				if r, ok := expr.(*ast.RangeExpression); ok {
+					in := evalRangeContains(r, obj, scope)
+					if in.Type() == ERROR_OBJ {
+						return in
+					}
+					if in == TRUE {
+						match = true
+						break
+					}
+					continue
+				}
+
 				out := Eval(expr, scope)
 
 				// literal match?
@@ -337,8 +477,9 @@ loopCases:
 		if match || through {
 			through = false
 			result := evalBlockStatement(choice.Block, scope)
-			if _, ok := result.(*Fallthrough); ok {
+			if f, ok := result.(*Fallthrough); ok {
 				through = true
+				target = f.Target
 				continue loopCases
 			}
 			return NIL
@@ -403,11 +544,37 @@ func evalTryStatement(tryStmt *ast.TryStmt, scope *Scope) Object {
 	return rv
 }
 
+//returns the static method if 'call' is 'StructName.method(args)', or else nil.
+func getStaticMethod(call *ast.MethodCallExpression, scope *Scope) *ast.FunctionLiteral {
+	name, ok := call.Object.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+	method, ok := call.Call.(*ast.CallExpression)
+	if !ok {
+		return nil
+	}
+	structStmt, ok := scope.GetStruct(name.Value)
+	if !ok {
+		return nil
+	}
+	return structStmt.StaticMethod(method.Function.String())
+}
+
 func createStructObj(structStmt *ast.StructStatement, scope *Scope) *Struct {
 	structObj := &Struct{
 		Scope: NewScope(scope, nil),
 	}
 
+	//fields without default value are initialized to nil
+	for _, field := range structStmt.Fields {
+		var val Object = NIL
+		if field.Default != nil {
+			val = Eval(field.Default, structObj.Scope)
+		}
+		structObj.Scope.Set(field.Name.Value, val)
+	}
+
 	Eval(structStmt.Block, structObj.Scope)
 	scope.Set(structStmt.Name, structObj)
 
@@ -420,8 +587,12 @@ func evalPrefixExpression(node *ast.PrefixExpression, right Object, scope *Scope
 		return evalPlusPrefixOperatorExpression(node, right, scope)
 	case "-":
 		return evalMinusPrefixOperatorExpression(node, right, scope)
-	case "!":
+	case "!", "not":
 		return evalBangOperatorExpression(node, right, scope)
+	case "~":
+		return evalTildePrefixOperatorExpression(node, right, scope)
+	case "++", "--":
+		return evalIncDecPrefixExpression(node, right, scope)
 	default:
 		return newError(node.Pos().Sline(), ERR_PREFIXOP, node.Operator, right.Type())
 	}
@@ -434,6 +605,18 @@ func evalPlusPrefixOperatorExpression(node *ast.PrefixExpression, right Object,
 	return right
 }
 
+//~x: bitwise not, 'x' must be an integer
+func evalTildePrefixOperatorExpression(node *ast.PrefixExpression, right Object, scope *Scope) Object {
+	if right.Type() != NUMBER_OBJ {
+		return newError(node.Pos().Sline(), ERR_PREFIXOP, node.Operator, right.Type())
+	}
+	value := right.(*Number).Value
+	if value != math.Trunc(value) {
+		return newError(node.Pos().Sline(), ERR_PREFIXOP, node.Operator, right.Type())
+	}
+	return NewNumber(float64(^int64(value)))
+}
+
 func evalMinusPrefixOperatorExpression(node *ast.PrefixExpression, right Object, scope *Scope) Object {
 	if right.Type() != NUMBER_OBJ {
 		return newError(node.Pos().Sline(), ERR_PREFIXOP, node.Operator, right.Type())
@@ -458,8 +641,6 @@ func evalInfixExpression(node *ast.InfixExpression, left, right Object, scope *S
 	switch {
 	case operator == "in":
 		return evalInExpression(node, left, right, scope)
-	case operator == "..":
-		return evalRangeExpression(node, left, right, scope)
 	case operator == "=~", operator == "!~":
 		if right.Type() != REGEX_OBJ {
 			return newError(node.Pos().Sline(), ERR_NOTREGEXP, right.Type())
@@ -507,7 +688,89 @@ func evalInfixExpression(node *ast.InfixExpression, left, right Object, scope *S
 	}
 }
 
-func evalRangeExpression(node *ast.InfixExpression, left, right Object, scope *Scope) Object {
+//evaluates the body of the first matched arm, NIL if no arm matched.
+func evalMatchExpression(me *ast.MatchExpression, scope *Scope) Object {
+	obj := Eval(me.Expr, scope)
+	if isError(obj) {
+		return obj
+	}
+
+	for _, arm := range me.Arms {
+		matched := false
+		switch pattern := arm.Pattern.(type) {
+		case *ast.TypePattern: //is number => expr
+			matched = strings.EqualFold(typeName(obj), pattern.Type.Value)
+		case *ast.RangeExpression: //1..5 => expr
+			in := evalRangeContains(pattern, obj, scope)
+			if isError(in) {
+				return in
+			}
+			matched = in == TRUE
+		default:
+			if arm.IsWildcard() {
+				matched = true
+				break
+			}
+			out := Eval(pattern, scope)
+			if isError(out) {
+				return out
+			}
+			matched = obj.Type() == out.Type() && obj.Inspect() == out.Inspect()
+		}
+
+		if matched {
+			return Eval(arm.Body, scope)
+		}
+	}
+
+	return NIL
+}
+
+//reports whether 'obj' is in the range, without creating the array.
+//The range may be descending, e.g. '5..1', and an exclusive range does not include the end value.
+func evalRangeContains(node *ast.RangeExpression, obj Object, scope *Scope) Object {
+	left := Eval(node.StartIdx, scope)
+	if isError(left) {
+		return left
+	}
+	right := Eval(node.EndIdx, scope)
+	if isError(right) {
+		return right
+	}
+
+	start, ok := left.(*Number)
+	if !ok {
+		return newError(node.Pos().Sline(), ERR_RANGETYPE, NUMBER_OBJ, left.Type())
+	}
+	end, ok := right.(*Number)
+	if !ok {
+		return newError(node.Pos().Sline(), ERR_RANGETYPE, NUMBER_OBJ, right.Type())
+	}
+	n, ok := obj.(*Number)
+	if !ok {
+		return FALSE
+	}
+
+	v, lo, hi := n.Value, start.Value, end.Value
+	if !node.Inclusive && v == hi {
+		return FALSE
+	}
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return nativeBoolToBooleanObject(v >= lo && v <= hi)
+}
+
+func evalRangeExpression(node *ast.RangeExpression, scope *Scope) Object {
+	left := Eval(node.StartIdx, scope)
+	if isError(left) {
+		return left
+	}
+	right := Eval(node.EndIdx, scope)
+	if isError(right) {
+		return right
+	}
+
 	arr := &Array{}
 	switch l := left.(type) {
 	case *Number:
@@ -521,6 +784,17 @@ func evalRangeExpression(node *ast.InfixExpression, left, right Object, scope *S
 			return newError(node.Pos().Sline(), ERR_RANGETYPE, NUMBER_OBJ, right.Type())
 		}
 
+		//exclusive range: do not include the end value
+		if !node.Inclusive {
+			if startVal == endVal {
+				return arr
+			} else if startVal > endVal {
+				endVal++
+			} else {
+				endVal--
+			}
+		}
+
 		var j int64
 		if startVal >= endVal {
 			for j = startVal; j >= endVal; j = j - 1 {
@@ -659,7 +933,7 @@ func evalNumberInfixExpression(node *ast.InfixExpression, left, right Object, sc
 		return n
 	case "/":
 		if rightVal == 0 {
-			return newError(node.Pos().Sline(), ERR_DIVIDEBYZERO)
+			return newError(node.Pos().Sline(), "%s", ERR_DIVIDEBYZERO)
 		}
 		n := &Number{Value: leftVal / rightVal}
 		if node.HasNext {
@@ -720,37 +994,43 @@ func evalNextNumberInfix(node *ast.InfixExpression, result *Boolean, right Objec
 	return FALSE
 }
 
-func evalPipeInfix(node *ast.InfixExpression, scope *Scope) Object {
-	switch rightFunc := node.Right.(type) {
-	case *ast.MethodCallExpression:
-		switch rightFunc.Call.(type) {
-		case *ast.Identifier:
-			//e.g.
-			//x = "hello, world" |> xxx.upper    : rightFunc.Call.(type) == *ast.Identifier
-			//x = "hello, world" |> xxx.upper()  : rightFunc.Call.(type) == *ast.CallExpression
-			//so here we convert *ast.Identifier to * ast.CallExpression
-			rightFunc.Call = &ast.CallExpression{Token: node.Token, Function: rightFunc.Call}
-		}
-		rightFunc.Call.(*ast.CallExpression).Arguments = append([]ast.Expression{node.Left}, rightFunc.Call.(*ast.CallExpression).Arguments...)
-		return Eval(rightFunc, scope)
-	case *ast.CallExpression:
-		rightFunc.Arguments = append([]ast.Expression{node.Left}, rightFunc.Arguments...)
-		return Eval(rightFunc, scope)
-	case *ast.Identifier:
-		right := Eval(node.Right, scope)
-		if isError(right) {
-			return right
-		}
-		if right.Type() == FUNCTION_OBJ {
-			call := &ast.CallExpression{Token: node.Token, Function: rightFunc, Variadic: right.(*Function).Literal.Variadic}
-			call.Arguments = append([]ast.Expression{node.Left}, call.Arguments...)
-			return evalCallExpression(call, right, scope)
-		} else {
-			return newError(node.Pos().Sline(), ERR_PIPE)
-		}
+//left ?? right: returns left if it's not nil, otherwise returns right.
+//Note: right is evaluated only when left is nil.
+func evalNilCoalescingInfix(node *ast.InfixExpression, scope *Scope) Object {
+	left := Eval(node.Left, scope)
+	if isError(left) {
+		return left
+	}
+	if left != NIL {
+		return left
 	}
 
-	return NIL
+	return Eval(node.Right, scope)
+}
+
+//a ?: b: 'a' if it's true, or else 'b'(only evaluated when needed)
+func evalElvisInfix(node *ast.InfixExpression, scope *Scope) Object {
+	left := Eval(node.Left, scope)
+	if isError(left) {
+		return left
+	}
+	if IsTrue(left) {
+		return left
+	}
+
+	return Eval(node.Right, scope)
+}
+
+func evalTernaryExpression(te *ast.TernaryExpression, scope *Scope) Object {
+	condition := Eval(te.Condition, scope)
+	if isError(condition) {
+		return condition
+	}
+
+	if IsTrue(condition) {
+		return Eval(te.IfTrue, scope)
+	}
+	return Eval(te.IfFalse, scope)
 }
 
 func evalPostfixExpression(node *ast.PostfixExpression, left Object, scope *Scope) Object {
@@ -769,7 +1049,9 @@ func evalIncrementPostfixExpression(node *ast.PostfixExpression, left Object, sc
 	case NUMBER_OBJ:
 		leftObj := left.(*Number)
 		returnVal := NewNumber(leftObj.Value)
-		scope.Set(node.Left.String(), NewNumber(leftObj.Value+1))
+		if r := assignIncDecResult(node.Token, node.Left, NewNumber(leftObj.Value+1), scope); isError(r) {
+			return r
+		}
 		return returnVal
 	default:
 		return newError(node.Pos().Sline(), ERR_POSTFIXOP, node.Operator, left.Type())
@@ -781,14 +1063,53 @@ func evalDecrementPostfixExpression(node *ast.PostfixExpression, left Object, sc
 	case NUMBER_OBJ:
 		leftObj := left.(*Number)
 		returnVal := NewNumber(leftObj.Value)
-		scope.Set(node.Left.String(), NewNumber(leftObj.Value-1))
+		if r := assignIncDecResult(node.Token, node.Left, NewNumber(leftObj.Value-1), scope); isError(r) {
+			return r
+		}
 		return returnVal
 	default:
 		return newError(node.Pos().Sline(), ERR_POSTFIXOP, node.Operator, left.Type())
 	}
 }
 
+//++x, --x: returns the updated value
+func evalIncDecPrefixExpression(node *ast.PrefixExpression, right Object, scope *Scope) Object {
+	if right.Type() != NUMBER_OBJ {
+		return newError(node.Pos().Sline(), ERR_PREFIXOP, node.Operator, right.Type())
+	}
+
+	value := right.(*Number).Value
+	if node.Operator == "++" {
+		value++
+	} else {
+		value--
+	}
+	return assignIncDecResult(node.Token, node.Right, NewNumber(value), scope)
+}
+
+//stores the result of '++'/'--' back to the operand if it is an identifier, an index expression or a struct field,
+//other operands(e.g. '2++') only yield the value.
+func assignIncDecResult(tok token.Token, target ast.Expression, val Object, scope *Scope) Object {
+	switch t := target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case *ast.MethodCallExpression:
+		if _, ok := t.Call.(*ast.Identifier); !ok {
+			return val
+		}
+	default:
+		return val
+	}
+
+	a := &ast.AssignExpression{Token: tok, Name: target}
+	a.Token.Literal = "="
+	return _evalAssignExpression(a, val, scope)
+}
+
 func evalLetStatement(l *ast.LetStatement, scope *Scope) (val Object) {
+	if l.Pattern != nil {
+		return evalDestructuringLet(l, scope)
+	}
+
 	values := []Object{}
 	valuesLen := 0
 	for _, value := range l.Values {
@@ -832,6 +1153,65 @@ func evalLetStatement(l *ast.LetStatement, scope *Scope) (val Object) {
 	return
 }
 
+//let {x, y = 0} = point
+//let [a, b = 2] = xs
+//A member which is absent or nil takes the default value(or nil if no default supplied).
+func evalDestructuringLet(l *ast.LetStatement, scope *Scope) Object {
+	val := Eval(l.Values[0], scope)
+	if isError(val) {
+		return val
+	}
+
+	var members []Object //for array pattern
+	var hash *Hash       //for hash pattern
+	var elements []*ast.PatternElement
+	switch pattern := l.Pattern.(type) {
+	case *ast.HashPattern:
+		elements = pattern.Elements
+		switch v := val.(type) {
+		case *Hash:
+			hash = v
+		case *Nil:
+		default:
+			return newError(l.Pos().Sline(), ERR_DESTRUCTURE, val.Type(), "hash")
+		}
+	case *ast.ArrayPattern:
+		elements = pattern.Elements
+		switch v := val.(type) {
+		case *Array:
+			members = v.Members
+		case *Tuple:
+			members = v.Members
+		case *Nil:
+		default:
+			return newError(l.Pos().Sline(), ERR_DESTRUCTURE, val.Type(), "array")
+		}
+	}
+
+	for idx, e := range elements {
+		var member Object = NIL
+		if hash != nil {
+			if pair, ok := hash.Pairs[NewString(e.Name.Value).HashKey()]; ok {
+				member = pair.Value
+			}
+		} else if idx < len(members) {
+			member = members[idx]
+		}
+
+		if member == NIL && e.Default != nil {
+			member = Eval(e.Default, scope)
+			if isError(member) {
+				return member
+			}
+		}
+		if e.Name.Value != "_" {
+			scope.Set(e.Name.Value, member)
+		}
+	}
+
+	return val
+}
+
 func evalReturnStatement(r *ast.ReturnStatement, scope *Scope) Object {
 	if r.ReturnValue == nil { //no return value, we default return `NIL` object
 		return &ReturnValue{Value: NIL, Values: []Object{NIL}}
@@ -915,6 +1295,76 @@ func evalIndexExpression(node *ast.IndexExpression, left, index Object) Object {
 	}
 }
 
+//a[low:high:step], the omitted 'low' is 0, 'high' is the length of 'a', and 'step' is 1.
+func evalSliceExpression(node *ast.SliceExpression, scope *Scope) Object {
+	line := node.Pos().Sline()
+	left := Eval(node.Left, scope)
+	if isError(left) {
+		return left
+	}
+	if node.Optional && left == NIL { //a?[1:2]
+		return NIL
+	}
+
+	var length int
+	switch o := left.(type) {
+	case *String:
+		length = utf8.RuneCountInString(o.String)
+	case *Array:
+		length = len(o.Members)
+	case *Tuple:
+		length = len(o.Members)
+	default:
+		return newError(line, ERR_NOINDEXABLE, left.Type())
+	}
+
+	bounds := []int{0, length, 1}
+	for i, expr := range []ast.Expression{node.Low, node.High, node.Step} {
+		if expr == nil {
+			continue
+		}
+		v := Eval(expr, scope)
+		if isError(v) {
+			return v
+		}
+		n, ok := v.(*Number)
+		if !ok {
+			return newError(line, ERR_SLICE, fmt.Sprintf("'%s' should be a NUMBER, got %s", expr.String(), v.Type()))
+		}
+		bounds[i] = int(n.Value)
+	}
+
+	low, high, step := bounds[0], bounds[1], bounds[2]
+	if low < 0 || high > length || low > high {
+		return newError(line, ERR_SLICE, fmt.Sprintf("bounds [%d:%d] out of range with length %d", low, high, length))
+	}
+	if step <= 0 {
+		return newError(line, ERR_SLICE, fmt.Sprintf("step should be positive, got %d", step))
+	}
+
+	switch o := left.(type) {
+	case *String:
+		runes := []rune(o.String)
+		var ret []rune
+		for i := low; i < high; i += step {
+			ret = append(ret, runes[i])
+		}
+		return NewString(string(ret))
+	case *Array:
+		members := []Object{}
+		for i := low; i < high; i += step {
+			members = append(members, o.Members[i])
+		}
+		return &Array{Members: members}
+	default:
+		members := []Object{}
+		for i := low; i < high; i += step {
+			members = append(members, left.(*Tuple).Members[i])
+		}
+		return &Tuple{Members: members}
+	}
+}
+
 func evalStringIndex(line string, left, index Object) Object {
 	str := left.(*String)
 
@@ -1016,7 +1466,29 @@ func evalHashLiteral(node *ast.HashLiteral, scope *Scope) Object {
 }
 */
 
+//'a::b' resolves the member 'b' of 'a' in the same way as 'a.b'
+func evalScopeResolution(node *ast.ScopeResolution, scope *Scope) Object {
+	call := &ast.MethodCallExpression{Token: node.Token, Object: node.Left, Call: node.Member}
+	return evalMethodCallExpression(call, scope)
+}
+
 func evalMethodCallExpression(call *ast.MethodCallExpression, scope *Scope) Object {
+	//static method of a struct, e.g. 'Point.create(1, 2)'
+	if fn := getStaticMethod(call, scope); fn != nil {
+		method := call.Call.(*ast.CallExpression)
+		args := evalExpressions(method.Arguments, scope)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		if method.Variadic {
+			args = getVariadicArgs(method, args)
+			if len(args) == 1 && isError(args[0]) {
+				return args[0]
+			}
+		}
+		return applyFunction(call.Call.Pos().Sline(), scope, &Function{Literal: fn, Scope: scope}, args)
+	}
+
 	//First check if is a stanard library object
 	str := call.Object.String()
 	if obj, ok := GetGlobalObj(str); ok {
@@ -1069,6 +1541,9 @@ func evalMethodCallExpression(call *ast.MethodCallExpression, scope *Scope) Obje
 	if obj.Type() == ERROR_OBJ {
 		return obj
 	}
+	if call.Optional && obj == NIL { //a?.b, a?.b(), the arguments are not evaluated if 'a' is nil
+		return NIL
+	}
 
 	switch m := obj.(type) {
 	case *Struct:
@@ -1108,7 +1583,11 @@ func evalMethodCallExpression(call *ast.MethodCallExpression, scope *Scope) Obje
 			index := NewString(call.Call.String())
 			return evalHashIndexExpression(call.Call.Pos().Sline(), m, index)
 		case *ast.CallExpression:
-			funcObj := m.get(call.Call.Pos().Sline(), NewString(o.Function.String()))
+			key := NewString(o.Function.String())
+			if _, ok := m.Pairs[key.HashKey()]; !ok { //not a key, e.g. '{"a": 1}.keys()'
+				return evalBuiltinMethodCall(obj, o, scope)
+			}
+			funcObj := m.get(call.Call.Pos().Sline(), key)
 			if isError(funcObj) {
 				return funcObj
 			}
@@ -1136,23 +1615,28 @@ func evalMethodCallExpression(call *ast.MethodCallExpression, scope *Scope) Obje
 		}
 
 		if method, ok := call.Call.(*ast.CallExpression); ok {
-			args := evalExpressions(method.Arguments, scope)
-			if len(args) == 1 && isError(args[0]) {
-				return args[0]
-			}
+			return evalBuiltinMethodCall(obj, method, scope)
+		}
+	}
 
-			if method.Variadic {
-				args = getVariadicArgs(method, args)
-				if len(args) == 1 && isError(args[0]) {
-					return args[0]
-				}
-			}
+	return newError(call.Call.Pos().Sline(), ERR_NOMETHOD, call.String(), obj.Type())
+}
 
-			return obj.CallMethod(call.Call.Pos().Sline(), scope, method.Function.String(), args...)
+//calls the method implemented by the object itself, e.g. 'str.upper()', 'arr.push(1)'
+func evalBuiltinMethodCall(obj Object, method *ast.CallExpression, scope *Scope) Object {
+	args := evalExpressions(method.Arguments, scope)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+
+	if method.Variadic {
+		args = getVariadicArgs(method, args)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
 		}
 	}
 
-	return newError(call.Call.Pos().Sline(), ERR_NOMETHOD, call.String(), obj.Type())
+	return obj.CallMethod(method.Pos().Sline(), scope, method.Function.String(), args...)
 }
 
 func evalMultiAssignStatement(ma *ast.MultiAssignStatement, scope *Scope) Object {
@@ -1170,6 +1654,9 @@ func evalMultiAssignStatement(ma *ast.MultiAssignStatement, scope *Scope) Object
 			if tupleObj.IsMulti { //it's a function which returns multiple results
 				valuesLen += len(tupleObj.Members)
 				values = append(values, tupleObj.Members...)
+			} else if len(ma.Values) == 1 && len(ma.Names) > 1 { //destructure a real tuple, e.g. 'a, _ = pair'
+				valuesLen += len(tupleObj.Members)
+				values = append(values, tupleObj.Members...)
 			} else { //it's a real tuple
 				valuesLen += 1
 				values = append(values, tupleObj)
@@ -1181,7 +1668,7 @@ func evalMultiAssignStatement(ma *ast.MultiAssignStatement, scope *Scope) Object
 	}
 
 	if len(values) != len(ma.Names) {
-		return newError(ma.Pos().Sline(), ERR_MULTIASSIGN)
+		return newError(ma.Pos().Sline(), "%s", ERR_MULTIASSIGN)
 	}
 
 	for idx, name := range ma.Names {
@@ -1196,7 +1683,22 @@ func evalMultiAssignStatement(ma *ast.MultiAssignStatement, scope *Scope) Object
 	return NIL
 }
 
+//x := 5, binds 'x' in the current scope like 'let x = 5', and the value is the result.
+func evalDeclareAssignExpression(d *ast.DeclareAssignExpression, scope *Scope) Object {
+	val := Eval(d.Value, scope)
+	if isError(val) {
+		return val
+	}
+
+	scope.Set(d.Name.Value, val)
+	return val
+}
+
 func evalAssignExpression(a *ast.AssignExpression, scope *Scope) Object {
+	if a.Token.Literal == "||=" || a.Token.Literal == "??=" {
+		return evalLogicalAssignExpression(a, scope)
+	}
+
 	val := Eval(a.Value, scope)
 	if val.Type() == ERROR_OBJ {
 		return val
@@ -1205,6 +1707,30 @@ func evalAssignExpression(a *ast.AssignExpression, scope *Scope) Object {
 	return _evalAssignExpression(a, val, scope)
 }
 
+// x ||= val: assign only if 'x' is falsy
+// x ??= val: assign only if 'x' is nil
+//The target may not exist yet, e.g. 'm[k] ??= 1', in which case it is created.
+func evalLogicalAssignExpression(a *ast.AssignExpression, scope *Scope) Object {
+	current := Eval(a.Name, scope)
+	if current.Type() != ERROR_OBJ {
+		if a.Token.Literal == "??=" && current != NIL {
+			return current
+		}
+		if a.Token.Literal == "||=" && IsTrue(current) {
+			return current
+		}
+	}
+
+	val := Eval(a.Value, scope)
+	if val.Type() == ERROR_OBJ {
+		return val
+	}
+
+	b := &ast.AssignExpression{Token: a.Token, Name: a.Name, Value: a.Value}
+	b.Token.Literal = "="
+	return _evalAssignExpression(b, val, scope)
+}
+
 func _evalAssignExpression(a *ast.AssignExpression, val Object, scope *Scope) Object {
 	if strings.Contains(a.Name.String(), ".") {
 		switch o := a.Name.(type) {
@@ -1282,6 +1808,9 @@ func _evalAssignExpression(a *ast.AssignExpression, val Object, scope *Scope) Ob
 	if a.Token.Literal == "=" {
 		switch nodeType := a.Name.(type) {
 		case *ast.Identifier: //e.g. a = "hello"
+			if nodeType.Value == "_" { //'_ = f()': discard the value
+				return val
+			}
 			scope.Set(nodeType.Value, val)
 			return val
 		}
@@ -1395,6 +1924,10 @@ func evalArrayAssignExpression(a *ast.AssignExpression, name string, left Object
 	case "=":
 		switch nodeType := a.Name.(type) {
 		case *ast.IndexExpression: //arr[idx] = xxx
+			if r, ok := nodeType.Index.(*ast.RangeExpression); ok { //arr[1..3] = [x, y]
+				return evalArrayRangeAssignExpression(a, r, name, leftVals, scope, val)
+			}
+
 			index := Eval(nodeType.Index, scope)
 			if index == NIL {
 				ret = NIL
@@ -1439,6 +1972,52 @@ func evalArrayAssignExpression(a *ast.AssignExpression, name string, left Object
 	return newError(a.Pos().Sline(), ERR_INFIXOP, left.Type(), a.Token.Literal, val.Type())
 }
 
+//arr[start..end] = [x, y, ...]: replace the elements in the range with the members of the array value,
+//the array may shrink or grow if the number of members differs from the length of the range.
+func evalArrayRangeAssignExpression(a *ast.AssignExpression, r *ast.RangeExpression, name string, leftVals []Object, scope *Scope, val Object) Object {
+	newVals, ok := val.(*Array)
+	if !ok {
+		return newError(a.Pos().Sline(), ERR_INFIXOP, ARRAY_OBJ, a.Token.Literal, val.Type())
+	}
+
+	start := Eval(r.StartIdx, scope)
+	if isError(start) {
+		return start
+	}
+	end := Eval(r.EndIdx, scope)
+	if isError(end) {
+		return end
+	}
+	startNum, ok := start.(*Number)
+	if !ok {
+		return newError(a.Pos().Sline(), ERR_RANGETYPE, NUMBER_OBJ, start.Type())
+	}
+	endNum, ok := end.(*Number)
+	if !ok {
+		return newError(a.Pos().Sline(), ERR_RANGETYPE, NUMBER_OBJ, end.Type())
+	}
+
+	startIdx, endIdx := int64(startNum.Value), int64(endNum.Value)
+	if r.Inclusive {
+		endIdx++
+	}
+	if startIdx < 0 || startIdx > int64(len(leftVals)) {
+		return newError(a.Pos().Sline(), ERR_INDEX, startIdx)
+	}
+	if endIdx < startIdx || endIdx > int64(len(leftVals)) {
+		return newError(a.Pos().Sline(), ERR_INDEX, endIdx)
+	}
+
+	members := make([]Object, 0, int64(len(leftVals))-(endIdx-startIdx)+int64(len(newVals.Members)))
+	members = append(members, leftVals[:startIdx]...)
+	members = append(members, newVals.Members...)
+	members = append(members, leftVals[endIdx:]...)
+
+	ret := &Array{Members: members}
+	scope.Set(name, ret)
+	return ret
+}
+
 //tuple element can not be assigned
 func evalTupleAssignExpression(a *ast.AssignExpression, name string, left Object, scope *Scope, val Object) (ret Object) {
 	//Tuple is an immutable sequence of values
@@ -1577,35 +2156,12 @@ func evalForEachArrayExpression(fal *ast.ForEachArrayLoop, scope *Scope) Object
 		return &Array{Members: []Object{}} //return empty array
 	}
 
-	iterObj, ok := aValue.(Iterable)
+	members, ok := iterMembers(aValue)
 	if !ok {
-		errObj := newError(fal.Pos().Sline(), ERR_NOTITERABLE)
-		return &Array{Members: []Object{errObj}}
-	}
-	if !iterObj.iter() {
-		errObj := newError(fal.Pos().Sline(), ERR_NOTITERABLE)
+		errObj := newError(fal.Pos().Sline(), "%s", ERR_NOTITERABLE)
 		return &Array{Members: []Object{errObj}}
 	}
 
-	var members []Object
-	if aValue.Type() == STRING_OBJ {
-		aStr, _ := aValue.(*String)
-		runes := []rune(aStr.String)
-		for _, rune := range runes {
-			members = append(members, NewString(string(rune)))
-		}
-	} else if aValue.Type() == ARRAY_OBJ {
-		arr, _ := aValue.(*Array)
-		members = arr.Members
-	} else if aValue.Type() == TUPLE_OBJ {
-		tuple, _ := aValue.(*Tuple)
-		members = tuple.Members
-	} else if aValue.Type() == GO_OBJ { //go object
-		goObj := aValue.(*GoObject)
-		arr := goValueToObject(goObj.obj).(*Array)
-		members = arr.Members
-	}
-
 	if len(members) == 0 {
 		return &Array{Members: []Object{}} //return empty array
 	}
@@ -1639,6 +2195,77 @@ func evalForEachArrayExpression(fal *ast.ForEachArrayLoop, scope *Scope) Object
 	return arr
 }
 
+//returns the values which 'for x in val' ranges over, ok is false if 'val' is not iterable.
+func iterMembers(val Object) (members []Object, ok bool) {
+	iterObj, ok := val.(Iterable)
+	if !ok || !iterObj.iter() {
+		return nil, false
+	}
+
+	if val.Type() == STRING_OBJ {
+		aStr, _ := val.(*String)
+		runes := []rune(aStr.String)
+		for _, rune := range runes {
+			members = append(members, NewString(string(rune)))
+		}
+	} else if val.Type() == ARRAY_OBJ {
+		arr, _ := val.(*Array)
+		members = arr.Members
+	} else if val.Type() == TUPLE_OBJ {
+		tuple, _ := val.(*Tuple)
+		members = tuple.Members
+	} else if val.Type() == GO_OBJ { //go object
+		goObj := val.(*GoObject)
+		arr := goValueToObject(goObj.obj).(*Array)
+		members = arr.Members
+	}
+	return members, true
+}
+
+//[expr for x in xs for y in ys], the variables are only visible inside the comprehension.
+//Like the 'for' loop, ranging over nil yields nothing.
+func evalArrayComprehension(node *ast.ArrayComprehension, scope *Scope) Object {
+	arr := &Array{Members: []Object{}}
+	if err := evalComprehensionClauses(node, 0, NewScope(scope, nil), arr); err != nil {
+		return err
+	}
+	return arr
+}
+
+//ranges over the i-th clause, and appends the values of the expression to 'arr' after the last one.
+//It returns nil, or the error.
+func evalComprehensionClauses(node *ast.ArrayComprehension, i int, scope *Scope, arr *Array) Object {
+	if i == len(node.Clauses) {
+		val := Eval(node.Expr, scope)
+		if isError(val) {
+			return val
+		}
+		arr.Members = append(arr.Members, val)
+		return nil
+	}
+
+	clause := node.Clauses[i]
+	val := Eval(clause.Value, scope)
+	if isError(val) {
+		return val
+	}
+	if val == NIL {
+		return nil
+	}
+
+	members, ok := iterMembers(val)
+	if !ok {
+		return newError(clause.Pos().Sline(), "%s", ERR_NOTITERABLE)
+	}
+	for _, m := range members {
+		scope.Set(clause.Var, m)
+		if err := evalComprehensionClauses(node, i+1, scope, arr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 //for index, value in string
 //for index, value in array
 //for index, value in tuple
@@ -1718,11 +2345,11 @@ func evalForEachMapExpression(fml *ast.ForEachMapLoop, scope *Scope) Object { //
 
 	iterObj, ok := aValue.(Iterable)
 	if !ok {
-		errObj := newError(fml.Pos().Sline(), ERR_NOTITERABLE)
+		errObj := newError(fml.Pos().Sline(), "%s", ERR_NOTITERABLE)
 		return &Array{Members: []Object{errObj}}
 	}
 	if !iterObj.iter() {
-		errObj := newError(fml.Pos().Sline(), ERR_NOTITERABLE)
+		errObj := newError(fml.Pos().Sline(), "%s", ERR_NOTITERABLE)
 		return &Array{Members: []Object{errObj}}
 	}
 
@@ -1808,6 +2435,57 @@ func evalDoLoopExpression(dl *ast.DoLoop, scope *Scope) Object {
 	return e
 }
 
+//use name = expression in { block }
+//The alias is set in the current scope while evaluating the block, and then removed(or restored
+//if it shadows a variable of the current scope), so assignments in the block behave as usual.
+func evalUseStatement(us *ast.UseStatement, scope *Scope) Object {
+	val := Eval(us.Value, scope)
+	if val.Type() == ERROR_OBJ {
+		return val
+	}
+
+	name := us.Name.Value
+	old, shadowed := scope.store[name]
+	scope.Set(name, val)
+	defer func() {
+		if shadowed {
+			scope.Set(name, old)
+		} else {
+			scope.Del(name)
+		}
+	}()
+
+	return evalBlockStatement(us.Block, scope)
+}
+
+//export { a, b, c }
+//The names must be already defined(variables, functions or structs) in the current scope.
+func evalExportStatement(es *ast.ExportStatement, scope *Scope) Object {
+	for _, name := range es.Names {
+		_, isVar := scope.store[name]
+		_, isStruct := scope.structStore[name]
+		if !isVar && !isStruct {
+			return newError(es.Pos().Sline(), ERR_UNKNOWNIDENT, name)
+		}
+		if scope.exported == nil {
+			scope.exported = make(map[string]bool)
+		}
+		scope.exported[name] = true
+	}
+	return NIL
+}
+
+//let x = do { block }
+// returns the last expression value or NIL
+func evalDoExpression(de *ast.DoExpression, scope *Scope) Object {
+	result := evalBlockStatement(de.Block, scope)
+	if result == nil {
+		return NIL
+	}
+
+	return result
+}
+
 //while condition { block }
 // returns the last expression value or NIL
 func evalWhileLoopExpression(wl *ast.WhileLoop, scope *Scope) Object {
@@ -1848,7 +2526,7 @@ func evalWhileLoopExpression(wl *ast.WhileLoop, scope *Scope) Object {
 func evalRegExLiteral(node *ast.RegExLiteral, scope *Scope) Object {
 	regExp, err := regexp.Compile(node.Value)
 	if err != nil {
-		return newError(node.Pos().Sline(), ERR_INVALIDARG)
+		return newError(node.Pos().Sline(), "%s", ERR_INVALIDARG)
 	}
 
 	return &RegEx{RegExp: regExp, Value: node.Value}
@@ -1957,7 +2635,7 @@ func _evalDecorator(node *ast.DecoratorExpr, scope *Scope) (string, Object, Obje
 
 	name, ok := getDecoratedFuncName(node.Decorated) //get decorated function's name
 	if !ok {
-		return "", nil, newError(node.Pos().Sline(), ERR_DECORATED_NAME)
+		return "", nil, newError(node.Pos().Sline(), "%s", ERR_DECORATED_NAME)
 	}
 
 	decoratorFn := decorator.(*Function)
@@ -1976,7 +2654,7 @@ func _evalDecorator(node *ast.DecoratorExpr, scope *Scope) (string, Object, Obje
 	}
 
 	//should never reach here
-	return "", nil, newError(node.Pos().Sline(), ERR_DECORATOR_FN)
+	return "", nil, newError(node.Pos().Sline(), "%s", ERR_DECORATOR_FN)
 }
 
 // get the actual name of the decorated function.
@@ -1996,11 +2674,11 @@ func getVariadicArgs(call *ast.CallExpression, args []Object) []Object {
 	lastArg := args[len(args)-1]
 	iterObj, ok := lastArg.(Iterable)
 	if !ok {
-		errObj := newError(call.Pos().Sline(), ERR_NOTITERABLE)
+		errObj := newError(call.Pos().Sline(), "%s", ERR_NOTITERABLE)
 		return []Object{errObj}
 	}
 	if !iterObj.iter() {
-		errObj := newError(call.Pos().Sline(), ERR_NOTITERABLE)
+		errObj := newError(call.Pos().Sline(), "%s", ERR_NOTITERABLE)
 		return []Object{errObj}
 	}
 
@@ -2032,6 +2710,17 @@ func getVariadicArgs(call *ast.CallExpression, args []Object) []Object {
 }
 
 func evalCallExpression(node *ast.CallExpression, funcObj Object, scope *Scope) Object {
+	if node.Optional && funcObj == nil { //f?.(), the arguments are not evaluated if 'f' is nil
+		funcObj = Eval(node.Function, scope)
+		if isError(funcObj) {
+			return funcObj
+		}
+		if funcObj == NIL {
+			return NIL
+		}
+	}
+
+	positional, named := splitNamedArguments(node.Arguments)
 	var args []Object
 	if len(node.Arguments) == 1 && node.Arguments[0].TokenLiteral() == ALL_ARGS {
 		if arr, ok := scope.Get(ALL_ARGS); ok {
@@ -2040,7 +2729,7 @@ func evalCallExpression(node *ast.CallExpression, funcObj Object, scope *Scope)
 			}
 		}
 	} else {
-		args = evalExpressions(node.Arguments, scope)
+		args = evalExpressions(positional, scope)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
@@ -2055,6 +2744,9 @@ func evalCallExpression(node *ast.CallExpression, funcObj Object, scope *Scope)
 
 	//check if it is a struct call
 	if structStmt, ok := scope.GetStruct(node.Function.String()); ok {
+		if len(named) > 0 {
+			return newError(node.Pos().Sline(), ERR_NAMEDARG, fmt.Sprintf("struct '%s' could not be created with named arguments", structStmt.Name))
+		}
 		structObj := createStructObj(structStmt, scope)
 		//check if the struct has 'init' function
 		if _, ok := structObj.Scope.Get("init"); !ok {
@@ -2081,13 +2773,73 @@ func evalCallExpression(node *ast.CallExpression, funcObj Object, scope *Scope)
 		}
 	}
 
+	if len(named) > 0 {
+		var err Object
+		if args, err = bindNamedArguments(node.Pos().Sline(), function, args, named, scope); err != nil {
+			return err
+		}
+	}
 	return applyFunction(node.Pos().Sline(), scope, function, args)
 }
 
+//f(1, y: 2): the parser makes sure the named arguments are after the positional ones
+func splitNamedArguments(args []ast.Expression) ([]ast.Expression, []*ast.NamedArgument) {
+	for i, arg := range args {
+		if _, ok := arg.(*ast.NamedArgument); ok {
+			named := make([]*ast.NamedArgument, 0, len(args)-i)
+			for _, n := range args[i:] {
+				named = append(named, n.(*ast.NamedArgument))
+			}
+			return args[:i], named
+		}
+	}
+	return args, nil
+}
+
+//evaluates the named arguments, and puts them to the positions of the parameters with the same names.
+//The parameters which are skipped are left nil, to be bound to their default values by evalDefaultParameters().
+func bindNamedArguments(line string, fn Object, args []Object, named []*ast.NamedArgument, scope *Scope) ([]Object, Object) {
+	f, ok := fn.(*Function)
+	if !ok || f.Literal.Variadic {
+		return nil, newError(line, ERR_NAMEDARG, "only the user defined functions which are not variadic accept named arguments")
+	}
+	params := f.Literal.Parameters
+	for _, n := range named {
+		idx := -1
+		for i, param := range params {
+			if param.Value == n.Name.Value {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return nil, newError(n.Pos().Sline(), ERR_NAMEDARG, fmt.Sprintf("unknown parameter '%s'", n.Name.Value))
+		}
+		if idx < len(args) && args[idx] != nil {
+			return nil, newError(n.Pos().Sline(), ERR_NAMEDARG, fmt.Sprintf("parameter '%s' is already passed", n.Name.Value))
+		}
+		value := Eval(n.Value, scope)
+		if isError(value) {
+			return nil, value
+		}
+		for len(args) <= idx {
+			args = append(args, nil)
+		}
+		args[idx] = value
+	}
+	return args, nil
+}
+
 func applyFunction(line string, scope *Scope, fn Object, args []Object) Object {
 	switch fn := fn.(type) {
 	case *Function:
 		extendedScope := extendFunctionScope(fn, args)
+		if err := evalDefaultParameters(line, fn, args, extendedScope); err != nil {
+			return err
+		}
+		if err := evalWhereBindings(fn, extendedScope); err != nil {
+			return err
+		}
 		evaluated := Eval(fn.Literal.Body, extendedScope)
 		if evaluated.Type() == TAIL_OBJ {
 			call := evaluated.(*TailCall).tail.Call.(*ast.CallExpression)
@@ -2116,7 +2868,9 @@ func applyFunction(line string, scope *Scope, fn Object, args []Object) Object {
 				fn2 := function.(*Function)
 				argObjTable := make(map[string]Object)
 				for i, identNode := range fn2.Literal.Parameters {
-					argObjTable[identNode.Value] = args2[i]
+					if i < len(args2) {
+						argObjTable[identNode.Value] = args2[i]
+					}
 				}
 
 				//This is the most important part. we reuse the scope
@@ -2136,6 +2890,12 @@ func applyFunction(line string, scope *Scope, fn Object, args []Object) Object {
 				}
 
 				extendedScope.Set(ALL_ARGS, &Array{Members: args2})
+				if err := evalDefaultParameters(line, fn2, args2, extendedScope); err != nil {
+					return err
+				}
+				if err := evalWhereBindings(fn2, extendedScope); err != nil {
+					return err
+				}
 
 				o = Eval(fn2.Literal.Body, extendedScope)
 				if o.Type() == ERROR_OBJ {
@@ -2171,13 +2931,59 @@ func extendFunctionScope(fn *Function, args []Object) *Scope {
 		}
 	} else {
 		for paramIdx, param := range fn.Literal.Parameters {
-			scope.Set(param.Value, args[paramIdx])
+			if paramIdx == len(args) { //the rest are bound by evalDefaultParameters()
+				break
+			}
+			if args[paramIdx] != nil { //skipped by the named arguments, e.g. 'f(1, c: 3)'
+				scope.Set(param.Value, args[paramIdx])
+			}
 		}
 	}
 	scope.Set(ALL_ARGS, &Array{Members: args})
 	return scope
 }
 
+//binds the parameters which are not passed to their default values in the function's scope.
+//They are evaluated in order, so a default value could refer to the parameters before it,
+//e.g. 'fn(a, b = a + 1)'. Returns the error object if a required parameter is missing.
+func evalDefaultParameters(line string, fn *Function, args []Object, scope *Scope) Object {
+	params := fn.Literal.Parameters
+	if fn.Literal.Variadic {
+		return nil
+	}
+	for i, param := range params {
+		if i < len(args) && args[i] != nil {
+			continue
+		}
+		if fn.Literal.Defaults == nil || fn.Literal.Defaults[i] == nil {
+			if i < len(args) { //skipped by the named arguments
+				return newError(line, ERR_NAMEDARG, fmt.Sprintf("missing argument for parameter '%s'", param.Value))
+			}
+			return newError(line, ERR_ARGUMENT, len(params), len(args))
+		}
+		value := Eval(fn.Literal.Defaults[i], scope)
+		if isError(value) {
+			return value
+		}
+		scope.Set(param.Value, value)
+		if i < len(args) {
+			args[i] = value //so the arguments array has no holes
+		}
+	}
+	return nil
+}
+
+//evaluates the function's 'where' bindings in the function's scope,
+//returns the error object if one of the bindings failed, or else nil.
+func evalWhereBindings(fn *Function, scope *Scope) Object {
+	for _, w := range fn.Literal.Where {
+		if v := Eval(w, scope); isError(v) {
+			return v
+		}
+	}
+	return nil
+}
+
 func unwrapReturnValue(obj Object) Object {
 	if returnValue, ok := obj.(*ReturnValue); ok {
 		// if function returns multiple-values
@@ -0,0 +1,809 @@
+package eval
+
+import (
+	"fmt"
+	"io/ioutil"
+	"magpie/lexer"
+	"magpie/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//evaluates the input, a parser error is returned as "parse error: ..."
+func testEval(input string) string {
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "parse error: " + strings.Join(p.Errors(), "; ")
+	}
+	scope := NewScope(nil, ioutil.Discard)
+	result := Eval(program, scope)
+	if result == nil {
+		return ""
+	}
+	return result.Inspect()
+}
+
+//runs the tests, an expected value "error" matches any error
+func runEvalTests(t *testing.T, tests []struct {
+	input    string
+	expected string
+}) {
+	t.Helper()
+	for _, tt := range tests {
+		got := testEval(tt.input)
+		if tt.expected == "error" {
+			if !strings.Contains(strings.ToLower(got), "error") {
+				t.Errorf("%q: expected an error, got %s", tt.input, got)
+			}
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRangeExpression(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"1..3", "[1, 2, 3]"},
+		{"1..=3", "[1, 2, 3]"},
+		{"1..<3", "[1, 2]"},
+		{"3..1", "[3, 2, 1]"},
+		{"3..<1", "[3, 2]"},
+		{"1..<1", "[]"},
+		{"let sum = 0; for i in 1..4 { sum += i }; sum", "10"},
+		{"let arr = [0, 1, 2, 3]; arr[1..2] = [9]; arr", "[0, 9, 3]"},
+		{"let arr = [0, 1, 2, 3]; arr[1..<2] = [9]; arr", "[0, 9, 2, 3]"},
+	})
+}
+
+func TestHexNumbers(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"0xFF", "255"},
+		{"0x1.8p3", "12"},
+		{"0xFF.floor()", "255"},
+		{"0x10.ceil()", "16"},
+		{"0xff.fp1", "511.875"},
+		{"0XFF + 1", "256"},
+		{"0o17", "15"},
+		{"0b1010", "10"},
+	})
+}
+
+func TestMethodCallOnString(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`let x = "hello"; "${x}, world".upper()`, "HELLO, WORLD"},
+		{`let x = 3; "${x + 1} apples".len()`, "8"},
+	})
+}
+
+func TestStringEscapes(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`"a\tb".len()`, "3"},
+		{`"\0".len()`, "1"},
+		{`"\x41\u00e9"`, "A\u00e9"},
+		{`let x = 1; "\${x}"`, `${x}`},
+		{`let x = 1; "\x24{x}"`, `${x}`},
+		{`let x = 1; "\x24x"`, `$x`},
+		{`let x = 1; "${x} \${x}"`, `1 ${x}`},
+		{`let x = 1; "\$x"`, `$x`},
+		{`"cost \$5"`, `cost $5`},
+		{`"cost \x245"`, `cost $5`},
+		{`let name = "a"; "\\$name"`, `\a`}, //a backslash, then an interpolation
+		{`let name = "a"; "$name-\$name-\x24name"`, `a-$name-$name`},
+	})
+}
+
+func TestHashLiteralOrder(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`let h = {"c": 1, "a": 2, "b": 3}; h`, `{"c":1, "a":2, "b":3}`},
+		{`let h = {"a": 1, "b": 2, "a": 3}; h`, `{"a":3, "b":2}`},
+		{`let h = {"b": 1, "a": 2}; h.keys()`, `["b", "a"]`},
+	})
+}
+
+func TestVariadicFunctions(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(args...) { args }; f(1, 2, 3)", "[1, 2, 3]"},
+		{"let f = fn(args...) { len(args) }; f()", "0"},
+		{"let f = fn(a, b, args...) { [a, b, args] }; f(1, 2, 3, 4)", "[1, 2, [3, 4]]"},
+	})
+}
+
+func TestConditionalImport(t *testing.T) {
+	root, err := ioutil.TempDir("", "magpie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "dbg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dbg", "tools.mp"), []byte("fn Trace(x) { \"trace \" + x }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MAGPIE_ROOT", root)
+
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`let DEBUG = false; import "nosuchmod" if DEBUG; 1`, "1"},
+		{`let DEBUG = true; import "nosuchmod" if DEBUG; 1`, "error"},
+		{`let DEBUG = true; import "dbg/tools" if DEBUG; Trace("x")`, "trace x"},
+		{`let DEBUG = false; import "dbg/tools" if DEBUG; let Trace = 1; Trace`, "1"},
+	})
+}
+
+func TestSliceStep(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [0, 1, 2, 3, 4, 5]; let i = 1; let n = 2; a[i::n]", "[1, 3, 5]"},
+		{"let a = [0, 1, 2, 3, 4, 5]; let n = 3; a[::n]", "[0, 3]"},
+		{"let a = [0, 1, 2, 3, 4, 5]; a[1::2]", "[1, 3, 5]"},
+	})
+}
+
+func TestMatchExpression(t *testing.T) {
+	const kind = `fn kind(v) {
+		return match v {
+			is number => "number",
+			is string => "string",
+			is array  => "array",
+			_         => "other"
+		}
+	}
+	`
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{kind + `kind(1)`, "number"},
+		{kind + `kind("a")`, "string"},
+		{kind + `kind([1, 2])`, "array"},
+		{kind + `kind(true)`, "other"},
+		{kind + `kind(nil)`, "other"},
+		{`match 3 { 1..5 => "low", _ => "high" }`, "low"},
+		{`match "b" { "a" => 1, "b" => 2 }`, "2"},
+		{`match 3 { is string => 1 }`, "nil"},                //no arm matched
+		{`match 3 { _ => 1, is number => 2 }`, "1"},          //the first matched arm
+		{`let x = 2; match x { is NUMBER => x * 10 }`, "20"}, //type names are case insensitive
+		{`let match = 5; match + 1`, "6"},
+		{`let match = 5; match - 1`, "4"},
+		{`let x = 2; let r = match (x) { is number => 1, _ => 2 }; r`, "1"},
+		{`let x = 2; match -x { -2 => "neg", _ => "other" }`, "neg"},
+	})
+}
+
+func TestDefaultParameters(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"fn f(a, b = 10) { a + b }; f(1)", "11"},
+		{"fn f(a, b = 10) { a + b }; f(1, 2)", "3"},
+		{"fn f(a, b = 10, c = a + 1) { a + b + c }; f(1)", "13"},
+		{"fn f(a, b = 10, c = a + 1) { a + b + c }; f(1, 2)", "5"},
+		{"let f = fn(a = 5) { a }; f()", "5"},
+	})
+}
+
+func TestNamedArguments(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"fn f(x, y) { x - y }; f(x: 5, y: 2)", "3"},
+		{"fn f(x, y) { x - y }; f(y: 2, x: 5)", "3"},
+		{"fn f(x, y) { x - y }; f(5, y: 2)", "3"},
+		{"fn f(x, y = 1, z = 10) { x + y + z }; f(1, z: 100)", "102"},
+		{"fn f(x, y) { x - y }; f(5, x: 2)", "error"},
+		{"fn f(x, y) { x - y }; f(5, w: 2)", "error"},
+		{"fn f(args...) { len(args) }; f(x: 1)", "error"},
+	})
+}
+
+func TestStructFields(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"struct P { x = 1; y }\nlet p = P(); p.x", "1"},
+		{"struct P { x = 1; y }\nlet p = P(); p.y", "nil"},
+		{"struct P { x = 1; fn Sum() { return self.x + 1 } }\nlet p = P(); p.Sum()", "2"},
+		{"struct P { x = [] }\nlet a = P(); let b = P(); a.x.push(1); len(b.x)", "0"}, //the default is evaluated per instance
+	})
+}
+
+func TestNilCoalescing(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"nil ?? 2", "2"},
+		{"1 ?? 2", "1"},
+		{"false ?? 1", "false"}, //only nil is replaced
+		{"nil ?? nil ?? 3", "3"},
+		{"let x = nil; x ?? 1 + 2", "3"},
+	})
+}
+
+func TestDoExpression(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let x = do { let a = 2; a + 1 }; x", "3"},
+		{"let f = fn() { return do { 5 } }; f()", "5"},
+		{"let i = 0; do { i++; if i >= 3 { break } }; i", "3"}, //a loop
+	})
+}
+
+func TestPipeOperator(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"fn double(x) { x * 2 }; 3 |> double", "6"},
+		{"fn double(x) { x * 2 }; 3 |> double |> double", "12"},
+		{"fn add(x, y) { x + y }; 3 |> add(4) |> add(1)", "8"},
+	})
+}
+
+func TestInterpolatedString(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Bob"; let count = 2; "hello ${name}, you have ${count+1} msgs"`, "hello Bob, you have 3 msgs"},
+		{`let x = 1; "a ${x} \${x}"`, "a 1 ${x}"},
+		{`"${ {\"k\": 1}[\"k\"] }"`, "1"},
+		{`"${x"`, "${x"},
+	})
+}
+
+func TestBracelessCase(t *testing.T) {
+	const input = "let r = []; switch %s { case 1: r.push(1)\n case 2, 3: r.push(2); r.push(3)\n case 4 { r.push(4) }\n default: r.push(5) }; r"
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{fmt.Sprintf(input, "1"), "[1]"},
+		{fmt.Sprintf(input, "3"), "[2, 3]"},
+		{fmt.Sprintf(input, "4"), "[4]"},
+		{fmt.Sprintf(input, "9"), "[5]"},
+	})
+}
+
+func TestCharLiterals(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`'a'`, "97"},
+		{`'\n'`, "10"},
+		{`'\u00e9'`, "233"},
+		{`'a' + 1`, "98"},
+	})
+}
+
+func TestLogicalAssignment(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`let m = {}; m["k"] ??= 1; m["k"] ??= 2; m["k"]`, "1"},
+		{"let a = [0, 5]; a[0] ||= 7; a[1] ||= 9; a", "[7, 5]"},
+		{"let x = nil; x ??= 3; x", "3"},
+		{"let x = false; x ??= 3; x", "false"},
+	})
+}
+
+func TestRangeCaseLabels(t *testing.T) {
+	const input = `let r = ""; switch %s { case 1..<5 { r = "low" } case 5, 6..=10 { r = "mid" } default { r = "high" } }; r`
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{fmt.Sprintf(input, "1"), "low"},
+		{fmt.Sprintf(input, "4.5"), "low"},
+		{fmt.Sprintf(input, "5"), "mid"},
+		{fmt.Sprintf(input, "10"), "mid"},
+		{fmt.Sprintf(input, "11"), "high"},
+		{fmt.Sprintf(input, `"a"`), "high"}, //not a number
+	})
+}
+
+func TestUseStatement(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`let o = {"x": 2}; use s = o["x"] in { s + 1 }`, "3"},
+		{"use a = 1 in { use b = a + 1 in { a + b } }", "3"},
+		{"let a = 5\nuse a = 1 in { a }\na", "5"}, //the alias is only visible in the block
+	})
+}
+
+func TestPowerOperator(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"(1 + 2) ** 2", "9"},
+		{"(2) ** (3) ** (2)", "512"},
+		{"(2 ** 3) ** 2", "64"},
+		{"-2 ** 2", "-4"},
+		{"2 ** -1", "0.5"},
+	})
+}
+
+func TestPrefixOnCalls(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { 3 }; -f()", "-3"},
+		{"let f = fn() { 3 }; +f()", "3"},
+		{`-"ab".len()`, "-2"},
+		{"let f = fn() { 3 }; -f() ** 2", "-9"},
+	})
+}
+
+func TestSpreadArguments(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"fn f(a, b, c) { a + b + c }; let r = [2, 3]; f(1, r...)", "6"},
+		{"fn f(args...) { len(args) }; let r = [1, 2, 3]; f(r...)", "3"},
+	})
+}
+
+func TestMultiAssignRotation(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1; let b = 2; a, b = b, a; [a, b]", "[2, 1]"},
+		{"let a = 1; let b = 2; let c = 3; a, b, c = c, a, b; [a, b, c]", "[3, 1, 2]"},
+		{"let arr = [1, 2]; arr[0], arr[1] = arr[1], arr[0]; arr", "[2, 1]"},
+	})
+}
+
+func TestNamedFunctionLiterals(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"fn add(a, b) { a + b }; add(1, 2)", "3"},
+		{"fn fact(n) { if n <= 1 { return 1 }; n * fact(n - 1) }; fact(5)", "120"},
+	})
+}
+
+func TestOptionalIndex(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let a = nil; a?[1]", "nil"},
+		{"let a = [[1, 2]]; a?[0]?[1]", "2"},
+		{"let a = [nil]; a?[0]?[1]", "nil"},
+		{`let h = {"k": 1}; h?["k"]`, "1"},
+	})
+}
+
+func TestWhereClause(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"fn area(r) { return pi * r * r } where pi = 3; area(2)", "12"},
+		{"let f = fn(x) { x + a + b } where a = 1, b = a + 1; f(10)", "13"},
+		{"fn h() { pi } where pi = 3; h(); pi", "error"}, //scoped to the function
+	})
+}
+
+func TestIncrementDecrement(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let i = 1; i++; i", "2"},
+		{"let i = 1; let j = i++; [i, j]", "[2, 1]"},
+		{"let j = 5; let k = --j; [j, k]", "[4, 4]"},
+		{"let x = 1; ++x", "2"},
+		{"let arr = [1, 2]; arr[0]++; arr", "[2, 2]"},
+		{`let h = {"a": 1}; h["a"]--; h`, `{"a":0}`},
+	})
+}
+
+func TestBlankIdentifier(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"_ = 5; 1", "1"},
+		{"_ = 5; _", "error"}, //'_' is never bound
+		{"let arr = [1]; _ = arr.push(2); arr", "[1, 2]"},
+		{"let pair = (1, 2); let a = 0; a, _ = pair; a", "1"},
+		{"let pair = (1, 2); let b = 0; _, b = pair; b", "2"},
+		{"let a = 0; let b = 0; a, _, b = 1, 2, 3; [a, b]", "[1, 3]"},
+	})
+}
+
+func TestBitwiseNot(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"~5", "-6"},
+		{"~~5", "5"},
+		{"~0 + 1", "0"},
+		{"-~5", "6"},
+		{`~"a"`, "error"},
+	})
+}
+
+func TestIndexMethodChains(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let m = [[1.4, 2.6]]; m[0][1].round(0).str()", "3"},
+		{`let s = ["ab"]; s[0].upper().len()`, "2"},
+		{`let h = {"k": ["ab"]}; h["k"][0].upper().len()`, "2"},
+	})
+}
+
+func TestHashConditionalValues(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`let ok = true; let h = {"status": if ok { "up" } else { "down" }}; h["status"]`, "up"},
+		{`let ok = false; let h = {"status": if ok { "up" } else { "down" }, "level": switch 2 { case 1 { "low" } default { "high" } }}; h`, `{"status":"down", "level":"high"}`},
+	})
+}
+
+func TestExportList(t *testing.T) {
+	root, err := ioutil.TempDir("", "magpie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	src := "let helper = 1\nlet other = 2\nstruct point { let x = 0 }\nexport { helper, point }"
+	if err := ioutil.WriteFile(filepath.Join(root, "mod.mp"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MAGPIE_ROOT", root)
+
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"import \"mod\"\nhelper", "1"},
+		{"import \"mod\"\npoint().x", "0"},
+		{"import \"mod\"\nother", "error"}, //not exported
+		{"let a = 1\nexport { a }\na", "1"},
+		{"export { nosuch }", "error"},
+	})
+}
+
+func TestStaticMethods(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"struct P {\nstatic fn Two() { 2 }\n}\nP.Two()", "2"},
+		{"struct P {\nx = 5\nstatic fn Origin() { P() }\nfn Get() { self.x }\n}\nP.Origin().Get()", "5"},
+		{"struct P {\nstatic fn Two() { 2 }\n}\nP::Two()", "2"},
+	})
+}
+
+func TestRangeAssignment(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let arr = [0, 1, 2, 3, 4]; arr[1..3] = [7, 8, 9]; arr", "[0, 7, 8, 9, 4]"},
+		{"let arr = [0, 1, 2, 3, 4]; arr[1..<3] = []; arr", "[0, 3, 4]"},
+		{"let arr = [0, 1, 2]; arr[0..1] = [9, 9, 9, 9]; arr", "[9, 9, 9, 9, 2]"},
+		{"let arr = [0, 1]; arr[0] = 5; arr", "[5, 1]"},
+		{"let arr = [0, 1]; arr[0..1] = 5; arr", "error"},
+		{"let arr = [0, 1]; arr[1..5] = [2]; arr", "error"},
+	})
+}
+
+func TestTrailingClosures(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(g) { g(5) }; f() { x -> x * 2 }", "10"},
+		{"let f = fn(g) { g() }; f() { 7 }", "7"},
+		{"let f = fn(a, g) { g(a) }; f(3) { x -> x + 1 }", "4"},
+	})
+}
+
+func TestTernaryAndElvis(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"true ? 1 : 2", "1"},
+		{"false ? 1 : 2", "2"},
+		{"nil ?: 3", "3"},
+		{"false ?: 3", "3"},
+		{"5 ?: 3", "5"},
+		{"false ? 1 : nil ?: 4", "4"},
+		{"let x = 0; true ? 1 : x++; x", "0"}, //only the chosen branch is evaluated
+		{"let c = true; let a = c ?[1] : [2]; a", "[1]"},
+		{"let c = false; let b = c ?.5 : 1; b", "1"},
+		{"let c = true; c ?.5 : 1", "0.5"},
+		{"let a = [1, 2]; true ? a?[0] : 3", "1"},
+	})
+}
+
+func TestDestructuringDefaults(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`let {x = 0, y = 0} = {"x": 5}; [x, y]`, "[5, 0]"},
+		{`let {x = 0, y = 0} = {"x": 5, "y": nil}; [x, y]`, "[5, 0]"},
+		{`let {x} = {"y": 1}; x`, "nil"},
+		{"let [a = 1, b = 2] = [9]; [a, b]", "[9, 2]"},
+		{"let [a = 1, b = 2] = [nil, 3]; [a, b]", "[1, 3]"},
+		{"let [a, b] = 5", "error"},
+	})
+}
+
+func TestPrecPragma(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"#prec(+, 13)\n2*3+4", "14"},
+		{"2*3+4", "10"},
+	})
+}
+
+func TestDeclareAssign(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"x := 5; x", "5"},
+		{"let f = fn() { 3 }; if (y := f()) > 0 { y } else { 0 }", "3"},
+		{"let x = 1; x := 2; x", "2"},
+		{"let f = fn() { x := 1; x }; f(); x", "error"}, //a local binding of the function
+	})
+}
+
+func TestElif(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"if true { 1 } elif true { 2 } else { 3 }", "1"},
+		{"if false { 1 } elif true { 2 } else { 3 }", "2"},
+		{"if false { 1 } elif false { 2 } else { 3 }", "3"},
+		{"if false { 1 } else if false { 2 } elif true { 3 }", "3"},
+		{"if false { 1 } elif false { 2 }", "nil"},
+	})
+}
+
+func TestHoistFunctions(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() {\nlet r = isEven(10)\nfn isEven(n) { n == 0 ? true : isOdd(n - 1) }\nfn isOdd(n) { n == 0 ? false : isEven(n - 1) }\nr\n}; f()", "true"},
+		{"let r = isOdd(3)\nfn isOdd(n) { n == 0 ? false : !isOdd(n - 1) }\nr", "true"},
+		{"let r = g()\nlet g = fn() { 1 }\nr", "error"}, //only the function declarations are hoisted
+	})
+}
+
+func TestMethodChains(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`"abc".upper().lower().upper()`, "ABC"},
+		{"let arr = [1]; arr.push(2).push(3)", "[1, 2, 3]"},
+		{"let arr = [1]; arr.push(2).push(3).len()", "3"},
+	})
+}
+
+func TestFallthroughTarget(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; let r = []; switch x { case 1 { r.push(1); fallthrough case 3 } case 2 { r.push(2) } case 3 { r.push(3) } }; r", "[1, 3]"},
+		{"let x = 1; let r = []; switch x { case 1 { r.push(1); fallthrough } case 2 { r.push(2) } case 3 { r.push(3) } }; r", "[1, 2]"},
+	})
+}
+
+func TestSliceExpression(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"[0, 1, 2, 3, 4][1:3]", "[1, 2]"},
+		{"[0, 1, 2, 3, 4][:2]", "[0, 1]"},
+		{"[0, 1, 2, 3, 4][3:]", "[3, 4]"},
+		{"[0, 1, 2, 3, 4][:]", "[0, 1, 2, 3, 4]"},
+		{"[0, 1, 2, 3, 4][::2]", "[0, 2, 4]"},
+		{`"hello"[1:3]`, "el"},
+		{"[0, 1, 2][::0]", "error"},
+	})
+}
+
+func TestOptionalCall(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let f = nil; f?.()", "nil"},
+		{"let f = fn(x) { x + 1 }; f?.(1)", "2"},
+		{`let h = {"m": nil}; h.m?.()`, "nil"},
+		{`let o = {"m": fn() { 7 }}; o?.m?.()`, "7"},
+		{"let o = nil; o?.m?.()", "nil"},
+		{"let f = 5; f?.()", "error"}, //only nil is skipped
+	})
+}
+
+func TestTrailingCommas(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2,]", "[1, 2]"},
+		{`let h = {"a": 1,}; h["a"]`, "1"},
+		{"let f = fn(a, b) { a + b }; f(1, 2,)", "3"},
+		{"(1, 2,)", "(1, 2)"},
+	})
+}
+
+func TestIfAsValue(t *testing.T) {
+	const grade = `let grade = if s >= 90 { "A" } elif s >= 80 { "B" } else { "C" }; grade`
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let s = 95; " + grade, "A"},
+		{"let s = 85; " + grade, "B"},
+		{"let s = 5; " + grade, "C"},
+	})
+}
+
+func TestScopeResolution(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"struct P {\nstatic fn Two() { 2 }\n}\nP::Two()", "2"},
+		{`let h = {"a": 1}; h::a`, "1"},
+		{`let h = {"a": {"b": 2}}; h::a::b`, "2"},
+		{"nosuch::x", "error"},
+	})
+}
+
+func TestArrayComprehension(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"[x for x in 1..5]", "[1, 2, 3, 4, 5]"},
+		{"[x * y for x in 1..3 for y in 1..3]", "[1, 2, 3, 2, 4, 6, 3, 6, 9]"},
+		{"[[x, y] for x in 1..2 for y in x..2]", "[[1, 1], [1, 2], [2, 2]]"},
+		{"let a = [1, 2, 3]; [x + 1 for x in a]", "[2, 3, 4]"},
+		{"[x for x in []]", "[]"},
+	})
+}
+
+func TestReturnCoalesce(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(a) { return a ?? 5 }; f(nil)", "5"},
+		{"let f = fn(a) { return a ?? 5 }; f(3)", "3"},
+		{"let f = fn(a) { return a ?? 5, 2 }; f(nil)", "(5, 2)"},
+		{"let f = fn(a) { return a ?? 5, 2 }; let x, y = f(3); [x, y]", "[3, 2]"},
+	})
+}
+
+func TestTypedArrowFunction(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let f = (x, y): Number => { return x + y }; f(1, 2)", "3"},
+		{"let f = (x, y): Number => x * y; f(3, 4)", "12"},
+		{"fn add(x, y): Number { x + y }; add(2, 5)", "7"},
+	})
+}
+
+func TestNotKeyword(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"not true", "false"},
+		{"not nil", "true"},
+		{"not not 1", "true"},
+		{"not 1 == 2", "false"}, //(not 1) == 2
+		{"not (1 == 2)", "true"},
+		{"not false && false", "false"},
+	})
+}
+
+func TestMethodCallOnLiterals(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{`"hello".len()`, "5"},
+		{"[1, 2, 3].len()", "3"},
+		{"[1, 2].push(3)", "[1, 2, 3]"},
+		{`let k = {"a": 1}.keys(); k`, `["a"]`},
+		{`let f = {"f": fn() { 7 }}.f(); f`, "7"}, //a key is called before a builtin method
+		{"16.sqrt()", "4"},
+		{"2.5.floor()", "2"},
+		{"true.toYesNo()", "yes"},
+		{"[1].nosuch()", "error"},
+	})
+}
+
+func TestOptionalChaining(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let a = nil; a?.b", "nil"},
+		{"let a = nil; a?.b(x)", "nil"}, //the arguments are not evaluated
+		{`let a = {"b": nil}; a?.b?.c`, "nil"},
+		{`let a = {"b": {"c": 3}}; a?.b?.c`, "3"},
+		{`"hi"?.upper()`, "HI"},
+		{"let a = nil; a?.b.c", "error"}, //only the member right after '?.' is skipped
+	})
+}
+
+func TestArrowFunction(t *testing.T) {
+	runEvalTests(t, []struct {
+		input    string
+		expected string
+	}{
+		{"let f = x => x + 1; f(1)", "2"},
+		{"let f = (a, b) => a + b; f(2, 3)", "5"},
+		{"let f = (a) => { a * 2 }; f(4)", "8"},
+		{"let f = () => 42; f()", "42"},
+		{"let add = x => y => x + y; add(1)(2)", "3"},
+	})
+}
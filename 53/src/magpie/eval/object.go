@@ -257,6 +257,8 @@ func (s *String) HashKey() HashKey {
 
 func (s *String) CallMethod(line string, scope *Scope, method string, args ...Object) Object {
 	switch method {
+	case "len":
+		return s.len(line, args...)
 	case "lower":
 		return s.lower(line, args...)
 	case "upper":
@@ -267,6 +269,14 @@ func (s *String) CallMethod(line string, scope *Scope, method string, args ...Ob
 	return newError(line, ERR_NOMETHOD, method, s.Type())
 }
 
+//the number of characters, same as the builtin 'len()'
+func (s *String) len(line string, args ...Object) Object {
+	if len(args) != 0 {
+		return newError(line, ERR_ARGUMENT, "0", len(args))
+	}
+	return NewNumber(float64(utf8.RuneCountInString(s.String)))
+}
+
 func (s *String) lower(line string, args ...Object) Object {
 	if len(args) != 0 {
 		return newError(line, ERR_ARGUMENT, "0", len(args))
@@ -759,7 +769,9 @@ func (c *Continue) CallMethod(line string, scope *Scope, method string, args ...
 	return newError(line, ERR_NOMETHOD, method, c.Type())
 }
 
-type Fallthrough struct{}
+type Fallthrough struct {
+	Target string //the label of 'fallthrough case 3', "" if falls through to the next case
+}
 
 func (f *Fallthrough) Inspect() string  { return "fallthrough" }
 func (f *Fallthrough) Type() ObjectType { return FALLTHROUGH_OBJ }
@@ -944,6 +956,12 @@ func (s *Struct) CallMethod(line string, scope *Scope, method string, args ...Ob
 	fn = fn2.(*Function)
 	extendedScope := extendFunctionScope(fn, args)
 	extendedScope.Set("self", s)
+	if err := evalDefaultParameters(line, fn, args, extendedScope); err != nil {
+		return err
+	}
+	if err := evalWhereBindings(fn, extendedScope); err != nil {
+		return err
+	}
 	obj := Eval(fn.Literal.Body, extendedScope)
 	return unwrapReturnValue(obj)
 }
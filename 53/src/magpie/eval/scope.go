@@ -26,18 +26,19 @@ type Scope struct {
 	Writer      io.Writer
 
 	structStore map[string]*ast.StructStatement
+	exported    map[string]bool //names exported by 'export { a, b }'
 }
 
 //Get all exported to 'anotherScope'
 func (s *Scope) GetAllExported(anotherScope *Scope) {
 	for key, value := range s.store {
-		if unicode.IsUpper(rune(key[0])) { //only upppercase functions/variables are exported
+		if unicode.IsUpper(rune(key[0])) || s.exported[key] { //only upppercase or explicitly exported functions/variables are exported
 			anotherScope.Set(key, value)
 		}
 	}
 
 	for key, value := range s.structStore {
-		if unicode.IsUpper(rune(key[0])) { //only upppercase struct name are exported
+		if unicode.IsUpper(rune(key[0])) || s.exported[key] { //only upppercase or explicitly exported struct name are exported
 			anotherScope.SetStruct(value)
 		}
 	}
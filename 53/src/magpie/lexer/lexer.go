@@ -5,12 +5,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"magpie/token"
+	"strconv"
 	"strings"
 	"unicode"
 )
 
-var prevToken token.Token
-
 // Lexer
 type Lexer struct {
 	Filename     string
@@ -21,6 +20,12 @@ type Lexer struct {
 
 	line int
 	col  int
+
+	prevToken token.Token //used for telling a regular expression from a division
+
+	keepComments bool //return the comments as TOKEN_COMMENT tokens instead of skipping them, see Tokens()
+
+	offset int //the offset of input in the file, see NewLexerAt()
 }
 
 func NewFileLexer(filename string) (*Lexer, error) {
@@ -52,6 +57,35 @@ func NewLexer(input string) *Lexer {
 	return l
 }
 
+//NewLexerAt returns a lexer whose first character is at 'pos' of a file, so the positions of the
+//tokens point into that file, e.g. for the source of an interpolation '${...}' inside a string.
+func NewLexerAt(input string, pos token.Position) *Lexer {
+	l := &Lexer{input: []rune(input), Filename: pos.Filename}
+	l.line = pos.Line
+	l.col = pos.Col - 1
+	l.offset = pos.Offset
+
+	l.readNext()
+	return l
+}
+
+//Tokens returns all the tokens of input in order, the last one is a TOKEN_EOF token, e.g. for
+//syntax highlighters. If 'comments' is true, the comments are returned as TOKEN_COMMENT tokens,
+//whose literals are the comment text, e.g. "// note", "# note", "/* note */".
+func Tokens(input string, comments bool) []token.Token {
+	l := NewLexer(input)
+	l.keepComments = comments
+
+	var tokens []token.Token
+	for {
+		tok := l.NextToken()
+		tokens = append(tokens, tok)
+		if tok.Type == token.TOKEN_EOF {
+			return tokens
+		}
+	}
+}
+
 func (l *Lexer) readNext() {
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
@@ -76,6 +110,26 @@ func (l *Lexer) peek() rune {
 	return l.input[l.readPosition]
 }
 
+//returns the n-th rune after the current one, peekAt(1) is the same as peek()
+func (l *Lexer) peekAt(n int) rune {
+	if l.position+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.position+n]
+}
+
+//reports whether the input starts with 's' at current position
+func (l *Lexer) hasPrefix(s string) bool {
+	i := l.position
+	for _, r := range s {
+		if i >= len(l.input) || l.input[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 	l.skipWhitespace()
@@ -100,6 +154,9 @@ func (l *Lexer) NextToken() token.Token {
 		} else if l.peek() == '=' {
 			tok = token.Token{Type: token.TOKEN_MINUS_A, Literal: string(l.ch) + string(l.peek())}
 			l.readNext()
+		} else if l.peek() == '>' {
+			tok = token.Token{Type: token.TOKEN_THINARROW, Literal: string(l.ch) + string(l.peek())}
+			l.readNext()
 		} else {
 			tok = newToken(token.TOKEN_MINUS, l.ch)
 		}
@@ -115,13 +172,21 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	case '/':
 		if l.peek() == '/' {
+			start := l.position
 			l.readNext()
 			l.skipComment()
+			if l.keepComments {
+				return l.commentToken(pos, start)
+			}
 			return l.NextToken()
 		} else if l.peek() == '*' {
+			start := l.position
 			l.readNext()
 			err := l.skipMultilineComment()
 			if err == nil {
+				if l.keepComments {
+					return l.commentToken(pos, start)
+				}
 				return l.NextToken()
 			} else {
 				tok.Type = token.TOKEN_ILLEGAL
@@ -132,10 +197,10 @@ func (l *Lexer) NextToken() token.Token {
 		}
 
 		// '/'通常表示除法，但是也可能是一个正则表达式
-		if prevToken.Type == token.TOKEN_RPAREN || // (a+c) / b
-			prevToken.Type == token.TOKEN_RBRACKET || // a[3] / b
-			prevToken.Type == token.TOKEN_IDENTIFIER || // a / b
-			prevToken.Type == token.TOKEN_NUMBER { // 3 / b,  3.5 / b
+		if l.prevToken.Type == token.TOKEN_RPAREN || // (a+c) / b
+			l.prevToken.Type == token.TOKEN_RBRACKET || // a[3] / b
+			l.prevToken.Type == token.TOKEN_IDENTIFIER || // a / b
+			l.prevToken.Type == token.TOKEN_NUMBER { // 3 / b,  3.5 / b
 			if l.peek() == '=' {
 				tok = token.Token{Type: token.TOKEN_SLASH_A, Literal: string(l.ch) + string(l.peek())}
 				l.readNext()
@@ -209,10 +274,21 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.TOKEN_BANG, l.ch)
 		}
+	case '~':
+		tok = newToken(token.TOKEN_TILDE, l.ch)
 	case ';':
 		tok = newToken(token.TOKEN_SEMICOLON, l.ch)
 	case ':':
-		tok = newToken(token.TOKEN_COLON, l.ch)
+		if l.peek() == '=' {
+			tok = token.Token{Type: token.TOKEN_COLONASSIGN, Literal: string(l.ch) + string(l.peek())}
+			l.readNext()
+		} else if l.peek() == ':' {
+			//scope resolution, e.g. math::sqrt, the parser reads it as two colons in a slice, e.g. a[i::2]
+			tok = token.Token{Type: token.TOKEN_SCOPE, Literal: string(l.ch) + string(l.peek())}
+			l.readNext()
+		} else {
+			tok = newToken(token.TOKEN_COLON, l.ch)
+		}
 	case ',':
 		tok = newToken(token.TOKEN_COMMA, l.ch)
 	case '.':
@@ -221,9 +297,21 @@ func (l *Lexer) NextToken() token.Token {
 			if l.peek() == '.' {
 				tok = token.Token{Type: token.TOKEN_ELLIPSIS, Literal: "..."}
 				l.readNext()
+			} else if l.peek() == '=' {
+				tok = token.Token{Type: token.TOKEN_DOTDOTEQ, Literal: "..="}
+				l.readNext()
+			} else if l.peek() == '<' {
+				tok = token.Token{Type: token.TOKEN_DOTDOTLT, Literal: "..<"}
+				l.readNext()
 			} else {
 				tok = token.Token{Type: token.TOKEN_DOTDOT, Literal: ".."}
 			}
+		} else if isDigit(l.peek()) && !isOperand(l.prevToken) { //leading-dot float, e.g. .5
+			tok.Literal = l.readNumber()
+			tok.Type = token.TOKEN_NUMBER
+			tok.Pos = pos
+			l.prevToken = tok
+			return tok
 		} else {
 			tok = newToken(token.TOKEN_DOT, l.ch)
 		}
@@ -240,12 +328,44 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peek() == '|' {
 			tok = token.Token{Type: token.TOKEN_OR, Literal: string(l.ch) + string(l.peek())}
 			l.readNext()
+			if l.peek() == '=' {
+				tok = token.Token{Type: token.TOKEN_OR_A, Literal: "||="}
+				l.readNext()
+			}
 		} else if l.peek() == '>' {
 			tok = token.Token{Type: token.TOKEN_PIPE, Literal: string(l.ch) + string(l.peek())}
 			l.readNext()
 		}
+	case '?':
+		if l.peek() == '?' {
+			tok = token.Token{Type: token.TOKEN_NILCOALESCE, Literal: string(l.ch) + string(l.peek())}
+			l.readNext()
+			if l.peek() == '=' {
+				tok = token.Token{Type: token.TOKEN_NILCOALESCE_A, Literal: "??="}
+				l.readNext()
+			}
+		} else if l.peek() == '[' { //null-safe index, e.g. a?[i]
+			tok = token.Token{Type: token.TOKEN_OPTIONAL_LBRACKET, Literal: string(l.ch) + string(l.peek())}
+			l.readNext()
+		} else if l.peek() == '.' && !isDigit(l.peekAt(2)) { //optional call, e.g. f?.(), but not 'c ?.5 : 1'
+			tok = token.Token{Type: token.TOKEN_OPTIONAL_DOT, Literal: string(l.ch) + string(l.peek())}
+			l.readNext()
+		} else {
+			tok = newToken(token.TOKEN_QUESTIONM, l.ch)
+		}
 	case '#': //comment
+		if l.hasPrefix("#prec(") { //pragma, e.g. '#prec(+, 5)', the parser decides whether it takes effect
+			tok.Pos = pos
+			l.readNext() //skip the '#'
+			tok.Literal = l.readPragma()
+			tok.Type = token.TOKEN_PRAGMA
+			return tok
+		}
+		start := l.position
 		l.skipComment()
+		if l.keepComments {
+			return l.commentToken(pos, start)
+		}
 		return l.NextToken()
 	case 0:
 		tok.Literal = "<EOF>"
@@ -255,20 +375,46 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Literal = l.readNumber()
 			tok.Type = token.TOKEN_NUMBER
 			tok.Pos = pos
-			prevToken = tok
+			l.prevToken = tok
 			return tok
 		} else if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Pos = pos
 			tok.Type = token.LookupIdent(tok.Literal)
-			prevToken = tok
+			l.prevToken = tok
 			return tok
 		} else if l.ch == 34 { //double quotes
 			if s, err := l.readString(l.ch); err == nil {
 				tok.Type = token.TOKEN_STRING
 				tok.Pos = pos
 				tok.Literal = s
-				prevToken = tok
+				l.prevToken = tok
+				return tok
+			} else {
+				tok.Type = token.TOKEN_ILLEGAL
+				tok.Pos = pos
+				tok.Literal = err.Error()
+				return tok
+			}
+		} else if l.ch == '\'' { //single quote
+			if s, err := l.readChar(); err == nil {
+				tok.Type = token.TOKEN_CHAR
+				tok.Pos = pos
+				tok.Literal = s
+				l.prevToken = tok
+				return tok
+			} else {
+				tok.Type = token.TOKEN_ILLEGAL
+				tok.Pos = pos
+				tok.Literal = err.Error()
+				return tok
+			}
+		} else if l.ch == '`' && l.hasPrefix("```") { //raw string, should be checked before command
+			if s, err := l.readRawString(); err == nil {
+				tok.Type = token.TOKEN_RAWSTRING
+				tok.Pos = pos
+				tok.Literal = s
+				l.prevToken = tok
 				return tok
 			} else {
 				tok.Type = token.TOKEN_ILLEGAL
@@ -279,7 +425,9 @@ func (l *Lexer) NextToken() token.Token {
 		} else if l.ch == '`' {
 			if s, err := l.readCommand(l.ch); err == nil {
 				tok.Type = token.TOKEN_CMD
+				tok.Pos = pos
 				tok.Literal = s
+				l.prevToken = tok
 				return tok
 			} else {
 				tok.Type = token.TOKEN_ILLEGAL
@@ -294,7 +442,7 @@ func (l *Lexer) NextToken() token.Token {
 
 	tok.Pos = pos
 	l.readNext()
-	prevToken = tok
+	l.prevToken = tok
 	return tok
 }
 
@@ -349,7 +497,20 @@ func (l *Lexer) readNumber() string {
 	ret = append(ret, ch)
 	l.readNext()
 
-	for isDigit(l.ch) || l.ch == '.' {
+	//hex, octal and binary literals, e.g. 0xFF, 0o17, 0b1010, and hex floats, e.g. 0x1.8p3.
+	//The digits are validated by the parser, so '0b102' is reported as an error.
+	if ch == '0' && strings.ContainsRune("xXoObB", l.ch) {
+		hex := l.ch == 'x' || l.ch == 'X'
+		for isLetter(l.ch) || isDigit(l.ch) ||
+			(hex && l.ch == '.' && l.isHexFraction()) || //0x1.8p3
+			(hex && (l.ch == '+' || l.ch == '-') && (ret[len(ret)-1] == 'p' || ret[len(ret)-1] == 'P')) { //0x1p-2
+			ret = append(ret, l.ch)
+			l.readNext()
+		}
+		return string(ret)
+	}
+
+	for isDigit(l.ch) || l.ch == '.' || l.ch == '_' { //'_' is the digit separator, e.g. 1_000_000
 		if l.ch == '.' {
 			if !isDigit(l.peek()) { //should be a method calling, e.g. 10.2.floor()
 				return string(ret)
@@ -359,9 +520,38 @@ func (l *Lexer) readNumber() string {
 		l.readNext()
 	}
 
+	//exponent, e.g. 1e10, 1.5E-3
+	if l.ch == 'e' || l.ch == 'E' {
+		ret = append(ret, l.ch)
+		l.readNext()
+		if l.ch == '+' || l.ch == '-' {
+			ret = append(ret, l.ch)
+			l.readNext()
+		}
+		for isDigit(l.ch) || l.ch == '_' {
+			ret = append(ret, l.ch)
+			l.readNext()
+		}
+	}
+
 	return string(ret)
 }
 
+//reports whether the '.' at current position starts the fraction of a hex float, e.g. '.8p3' of
+//'0x1.8p3'. A fraction of letters needs the 'p' exponent, so the '.' of '0xFF.floor()' is a method
+//call. A decimal digit can't start a method name, so '0x1.8' is read as a hex float, for the parser
+//to report the missing exponent.
+func (l *Lexer) isHexFraction() bool {
+	if isDigit(l.peek()) {
+		return true
+	}
+	i := l.readPosition
+	for i < len(l.input) && (isHexDigit(l.input[i]) || l.input[i] == '_') {
+		i++
+	}
+	return i > l.readPosition && i < len(l.input) && (l.input[i] == 'p' || l.input[i] == 'P')
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
 	for isLetter(l.ch) || isDigit(l.ch) {
@@ -370,40 +560,26 @@ func (l *Lexer) readIdentifier() string {
 	return string(l.input[position:l.position])
 }
 
+//returns the text between the quotes as is, the escape sequences are decoded by the parser.
 func (l *Lexer) readString(r rune) (string, error) {
+	start := l.getPos() //the opening quote
 	var ret []rune
 eos:
 	for {
 		l.readNext()
 		switch l.ch {
-		case '\n':
-			return "", errors.New("unexpected EOL")
-		case 0:
-			return "", errors.New("unexpected EOF")
+		case '\n', 0: //EOL or EOF
+			return "", fmt.Errorf("unterminated string literal starting at line %d col %d", start.Line, start.Col)
 		case r:
 			l.readNext()
 			break eos //eos:end of string
-		case '\\':
+		case '\\': //the escaped character never ends the string, e.g. "\""
+			ret = append(ret, l.ch)
 			l.readNext()
-			switch l.ch {
-			case 'b':
-				ret = append(ret, '\b')
-				continue
-			case 'f':
-				ret = append(ret, '\f')
-				continue
-			case 'r':
-				ret = append(ret, '\r')
-				continue
-			case 'n':
-				ret = append(ret, '\n')
-				continue
-			case 't':
-				ret = append(ret, '\t')
-				continue
+			if l.ch == 0 {
+				return "", fmt.Errorf("unterminated string literal starting at line %d col %d", start.Line, start.Col)
 			}
 			ret = append(ret, l.ch)
-			continue
 		default:
 			ret = append(ret, l.ch)
 		}
@@ -412,6 +588,63 @@ eos:
 	return string(ret), nil
 }
 
+//read a character literal, e.g. 'a', '\n', '\u00e9', returns the decoded character.
+func (l *Lexer) readChar() (string, error) {
+	var raw []rune
+	for {
+		l.readNext()
+		if l.ch == '\n' || l.ch == 0 {
+			return "", errors.New("unterminated character literal")
+		}
+		if l.ch == '\'' {
+			l.readNext()
+			break
+		}
+		raw = append(raw, l.ch)
+		if l.ch == '\\' { //escaped character, e.g. '\''
+			l.readNext()
+			raw = append(raw, l.ch)
+		}
+	}
+
+	lit := "'" + string(raw) + "'"
+	if len(raw) == 0 {
+		return "", fmt.Errorf("empty character literal %s", lit)
+	}
+	value, _, tail, err := strconv.UnquoteChar(string(raw), '\'')
+	if err != nil {
+		return "", fmt.Errorf("invalid character literal %s", lit)
+	}
+	if tail != "" {
+		return "", fmt.Errorf("more than one character in character literal %s", lit)
+	}
+	return string(value), nil
+}
+
+//Raw strings are delimited by three backticks, because a single backtick is used by command.
+//No escape processing is done, and newlines are preserved, e.g.
+//  ```line1\n
+//  line2```
+func (l *Lexer) readRawString() (string, error) {
+	var ret []rune
+
+	l.readNext()
+	l.readNext()
+	l.readNext() //skip the opening '```'
+	for !l.hasPrefix("```") {
+		if l.ch == 0 {
+			return "", errors.New("unterminated raw string, GOT EOF")
+		}
+		ret = append(ret, l.ch)
+		l.readNext()
+	}
+	l.readNext()
+	l.readNext()
+	l.readNext() //skip the closing '```'
+
+	return string(ret), nil
+}
+
 func (l *Lexer) readCommand(r rune) (string, error) {
 	var ret []rune
 eoc:
@@ -454,22 +687,50 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
+//the comment from 'start' to the current position. It does not change 'prevToken',
+//so a comment does not affect telling a regular expression from a division.
+func (l *Lexer) commentToken(pos token.Position, start int) token.Token {
+	literal := strings.TrimSuffix(string(l.input[start:l.position]), "\r")
+	return token.Token{Type: token.TOKEN_COMMENT, Literal: literal, Pos: pos}
+}
+
 func (l *Lexer) skipComment() {
 	for l.ch != '\n' && l.ch != 0 {
 		l.readNext()
 	}
 }
 
+//reads the rest of the line, without the trailing white spaces.
+func (l *Lexer) readPragma() string {
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readNext()
+	}
+	return strings.TrimSpace(string(l.input[position:l.position]))
+}
+
+//block comments nest, e.g. '/* a /* b */ c */' is one comment, so a block of code
+//which already has comments in it could be commented out.
 func (l *Lexer) skipMultilineComment() error {
 	var err error = nil
+	depth := 0 //the number of unclosed nested '/*'
 loop:
 	for {
 		l.readNext()
 		switch l.ch {
+		case '/':
+			if l.peek() == '*' { // nested '/*'
+				l.readNext() //skip the '/'
+				depth++
+			}
 		case '*':
 			switch l.peek() {
 			case '/': // '*/'
 				l.readNext() //skip the '*'
+				if depth > 0 {
+					depth--
+					continue
+				}
 				l.readNext() //skip the '/'
 				break loop
 			}
@@ -481,10 +742,20 @@ loop:
 	return err
 }
 
+//reports whether the token could be the operand of a '.', e.g. 'a.1', 'f().1'
+func isOperand(tok token.Token) bool {
+	switch tok.Type {
+	case token.TOKEN_IDENTIFIER, token.TOKEN_NUMBER, token.TOKEN_STRING,
+		token.TOKEN_RPAREN, token.TOKEN_RBRACKET, token.TOKEN_RBRACE:
+		return true
+	}
+	return false
+}
+
 func (l *Lexer) getPos() token.Position {
 	return token.Position{
 		Filename: l.Filename,
-		Offset:   l.position,
+		Offset:   l.offset + l.position,
 		Line:     l.line,
 		Col:      l.col,
 	}
@@ -498,6 +769,10 @@ func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
 func isLetter(ch rune) bool {
 	return unicode.IsLetter(ch) || ch == '_' || ch == '$'
 }
@@ -0,0 +1,241 @@
+package lexer
+
+import (
+	"fmt"
+	"magpie/token"
+	"strings"
+	"testing"
+)
+
+//returns the literals of the tokens, separated by spaces, the EOF token is not included
+func lexLiterals(input string) string {
+	l := NewLexer(input)
+	var literals []string
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.TOKEN_EOF {
+			break
+		}
+		literals = append(literals, tok.Literal)
+	}
+	return strings.Join(literals, " ")
+}
+
+func TestHexNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0xFF", "0xFF"},
+		{"0x1.8p3", "0x1.8p3"},
+		{"0x1p-2", "0x1p-2"},
+		{"0xA.Bp0", "0xA.Bp0"},
+		{"0xFF.floor()", "0xFF . floor ( )"}, //'.f' is not a fraction without a 'p' exponent
+		{"0x10.abs()", "0x10 . abs ( )"},
+		{"0x1.ep1.floor()", "0x1.ep1 . floor ( )"},
+		{"0x1.8", "0x1.8"}, //reported by the parser
+	}
+
+	for _, tt := range tests {
+		if got := lexLiterals(tt.input); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestNewLexerAt(t *testing.T) {
+	l := NewLexerAt("a +\n b", token.Position{Filename: "f.mp", Offset: 20, Line: 3, Col: 7})
+	tests := []struct {
+		literal string
+		pos     token.Position
+	}{
+		{"a", token.Position{Filename: "f.mp", Offset: 20, Line: 3, Col: 7}},
+		{"+", token.Position{Filename: "f.mp", Offset: 22, Line: 3, Col: 9}},
+		{"b", token.Position{Filename: "f.mp", Offset: 25, Line: 4, Col: 2}},
+	}
+
+	for _, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.literal || tok.Pos != tt.pos {
+			t.Errorf("expected %q at %+v, got %q at %+v", tt.literal, tt.pos, tok.Literal, tok.Pos)
+		}
+	}
+}
+
+func TestScopeToken(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"math::sqrt", "math :: sqrt"},
+		{"a[i::n]", "a [ i :: n ]"}, //the parser reads it as two colons
+		{"a[1::2]", "a [ 1 :: 2 ]"},
+		{"a[1: :2]", "a [ 1 : : 2 ]"},
+		{"x := 1", "x := 1"},
+	}
+
+	for _, tt := range tests {
+		if got := lexLiterals(tt.input); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestQuestionTokens(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a?[i]", "a ?[ i ]"},
+		{"o?.m", "o ?. m"},
+		{"f?.()", "f ?. ( )"},
+		{"c ?.5 : 1", "c ? .5 : 1"}, //a ternary with a leading-dot float
+		{"c?.5:1", "c ? .5 : 1"},
+		{"a ?? b", "a ?? b"},
+		{"a ?: b", "a ? : b"},
+	}
+
+	for _, tt := range tests {
+		if got := lexLiterals(tt.input); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFloatNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"6.022e23", "6.022e23"},
+		{"1.5E-3", "1.5E-3"},
+		{".25", ".25"},
+		{"a.b", "a . b"}, //not a number
+		{"1..5", "1 .. 5"},
+		{"1e", "1e"}, //reported by the parser
+	}
+
+	for _, tt := range tests {
+		if got := lexLiterals(tt.input); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBlockComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 /* a */ + 2", "1 + 2"},
+		{"1 /* a /* b */ c */ + 2", "1 + 2"},
+		{"1 /* a /* b /* c */ */ */ + 2", "1 + 2"},
+		{"1 /* a\n/* b\n*/ c */ + 2", "1 + 2"},
+		{"/* */ 3", "3"},
+	}
+
+	for _, tt := range tests {
+		if got := lexLiterals(tt.input); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+
+	//an unterminated comment is an illegal token at the opening '/*'
+	for _, input := range []string{"1 /* a", "1 /* a /* b */"} {
+		l := NewLexer(input)
+		l.NextToken()
+		tok := l.NextToken()
+		if tok.Type != token.TOKEN_ILLEGAL || !strings.Contains(tok.Literal, "Unterminated multiline comment") {
+			t.Errorf("%q: expected an unterminated comment, got %s %q", input, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != 1 || tok.Pos.Col != 3 {
+			t.Errorf("%q: expected the error at 1:3, got %d:%d", input, tok.Pos.Line, tok.Pos.Col)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	tests := []struct {
+		input string
+		line  int
+		col   int
+	}{
+		{`let s = "oops`, 1, 9},
+		{"let s = \"a\nb", 1, 9},
+		{"let a = 1\nlet s = \"oops", 2, 9},
+	}
+
+	for _, tt := range tests {
+		l := NewLexer(tt.input)
+		tok := l.NextToken()
+		for tok.Type != token.TOKEN_EOF && tok.Type != token.TOKEN_ILLEGAL {
+			tok = l.NextToken()
+		}
+		expected := fmt.Sprintf("unterminated string literal starting at line %d col %d", tt.line, tt.col)
+		if tok.Type != token.TOKEN_ILLEGAL || tok.Literal != expected {
+			t.Errorf("%q: expected an illegal token %q, got %s %q", tt.input, expected, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != tt.line || tok.Pos.Col != tt.col {
+			t.Errorf("%q: expected the token at the opening quote %d:%d, got %d:%d", tt.input, tt.line, tt.col, tok.Pos.Line, tok.Pos.Col)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	type tokenAt struct {
+		typ       token.TokenType
+		literal   string
+		line, col int
+	}
+	input := "let a = 1 // one\n# two\nb /* three */ / 2"
+	tests := []struct {
+		comments bool
+		expected []tokenAt
+	}{
+		{false, []tokenAt{
+			{token.TOKEN_LET, "let", 1, 1},
+			{token.TOKEN_IDENTIFIER, "a", 1, 5},
+			{token.TOKEN_ASSIGN, "=", 1, 7},
+			{token.TOKEN_NUMBER, "1", 1, 9},
+			{token.TOKEN_IDENTIFIER, "b", 3, 1},
+			{token.TOKEN_DIVIDE, "/", 3, 15},
+			{token.TOKEN_NUMBER, "2", 3, 17},
+			{token.TOKEN_EOF, "<EOF>", 0, 0},
+		}},
+		{true, []tokenAt{
+			{token.TOKEN_LET, "let", 1, 1},
+			{token.TOKEN_IDENTIFIER, "a", 1, 5},
+			{token.TOKEN_ASSIGN, "=", 1, 7},
+			{token.TOKEN_NUMBER, "1", 1, 9},
+			{token.TOKEN_COMMENT, "// one", 1, 11},
+			{token.TOKEN_COMMENT, "# two", 2, 1},
+			{token.TOKEN_IDENTIFIER, "b", 3, 1},
+			{token.TOKEN_COMMENT, "/* three */", 3, 3},
+			{token.TOKEN_DIVIDE, "/", 3, 15}, //still a division after the comment
+			{token.TOKEN_NUMBER, "2", 3, 17},
+			{token.TOKEN_EOF, "<EOF>", 0, 0},
+		}},
+	}
+
+	for _, tt := range tests {
+		tokens := Tokens(input, tt.comments)
+		if len(tokens) != len(tt.expected) {
+			t.Fatalf("comments=%t: expected %d tokens, got %d", tt.comments, len(tt.expected), len(tokens))
+		}
+		for i, exp := range tt.expected {
+			tok := tokens[i]
+			if exp.typ == token.TOKEN_EOF { //only the type of EOF is checked
+				exp.line, exp.col = tok.Pos.Line, tok.Pos.Col
+			}
+			if tok.Type != exp.typ || tok.Literal != exp.literal || tok.Pos.Line != exp.line || tok.Pos.Col != exp.col {
+				t.Errorf("comments=%t: token %d: expected %s %q at %d:%d, got %s %q at %d:%d", tt.comments, i,
+					exp.typ, exp.literal, exp.line, exp.col, tok.Type, tok.Literal, tok.Pos.Line, tok.Pos.Col)
+			}
+		}
+	}
+
+	//the '\r' of a CRLF line is not part of the comment
+	if tokens := Tokens("// one\r\n1", true); tokens[0].Literal != "// one" {
+		t.Errorf("expected the comment %q, got %q", "// one", tokens[0].Literal)
+	}
+}
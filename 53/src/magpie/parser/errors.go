@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"fmt"
+	"magpie/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+//ParseError is a parser error with its position, for tools which need the position programmatically.
+type ParseError struct {
+	Pos      token.Position
+	Message  string //message without position, e.g. "expected next token to be ), got EOF instead"
+	Severity Severity
+}
+
+//Error returns the message in the same format as Errors(), e.g. "Syntax Error: <1:7> - xxx".
+//Warnings are formatted as "Syntax Warning: <1:7> - xxx".
+func (e ParseError) Error() string {
+	if e.Severity == SeverityWarning {
+		return fmt.Sprintf("Syntax Warning:%v- %s", e.Pos, e.Message)
+	}
+	return fmt.Sprintf("Syntax Error:%v- %s", e.Pos, e.Message)
+}
+
+//ParseErrors returns the errors and warnings of the parser in the order they are found,
+//the errors are in the same order as Errors().
+func (p *Parser) ParseErrors() []ParseError {
+	return p.parseErrors
+}
+
+//Warnings returns the formatted warning messages, the warnings do not fail the parsing,
+//so they are not included in Errors().
+func (p *Parser) Warnings() []string {
+	var warnings []string
+	for _, e := range p.parseErrors {
+		if e.Severity == SeverityWarning {
+			warnings = append(warnings, e.Error())
+		}
+	}
+	return warnings
+}
+
+//errorf records an error at 'pos', both as a ParseError and as a formatted string for Errors()/ErrorLines().
+func (p *Parser) errorf(pos token.Position, format string, args ...interface{}) {
+	p.addError(ParseError{Pos: pos, Message: fmt.Sprintf(format, args...), Severity: SeverityError})
+}
+
+//warnf records a warning at 'pos', it is only reported by ParseErrors() and Warnings().
+func (p *Parser) warnf(pos token.Position, format string, args ...interface{}) {
+	p.parseErrors = append(p.parseErrors, ParseError{Pos: pos, Message: fmt.Sprintf(format, args...), Severity: SeverityWarning})
+}
+
+func (p *Parser) addError(e ParseError) {
+	if p.maxErrors > 0 && len(p.errors) >= p.maxErrors || p.tooDeep {
+		return
+	}
+	p.parseErrors = append(p.parseErrors, e)
+	p.errors = append(p.errors, e.Error())
+	p.errorLines = append(p.errorLines, e.Pos.Sline())
+}
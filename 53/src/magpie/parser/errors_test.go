@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"magpie/lexer"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		line     int
+		col      int
+		message  string
+		severity Severity
+	}{
+		{"let = 5", 1, 5, "expected token to be identifier|underscore, got = instead.", SeverityError},
+		{"let x = 1\nlet y = (1 + 2", 2, 9, "unclosed parenthesis opened at line 2, column 9, got EOF instead", SeverityError},
+		{"a, b = 1, 2, 3", 1, 1, "assignment mismatch: 2 variables but 3 values", SeverityError},
+		{"let x = 1\nfn() { return x; x }", 2, 18, "unreachable code after 'return'", SeverityWarning},
+	}
+
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		p.ParseProgram()
+		errs := p.ParseErrors()
+		if len(errs) == 0 {
+			t.Errorf("%q: expected a parse error", tt.input)
+			continue
+		}
+		e := errs[0]
+		if e.Pos.Line != tt.line || e.Pos.Col != tt.col {
+			t.Errorf("%q: expected the position <%d:%d>, got <%d:%d>", tt.input, tt.line, tt.col, e.Pos.Line, e.Pos.Col)
+		}
+		if e.Message != tt.message {
+			t.Errorf("%q: expected the message %q, got %q", tt.input, tt.message, e.Message)
+		}
+		if e.Severity != tt.severity {
+			t.Errorf("%q: expected the severity %s, got %s", tt.input, tt.severity, e.Severity)
+		}
+
+		//Errors() is the formatted shim of the errors, the warnings are only in Warnings()
+		var errors, warnings []string
+		for _, e := range errs {
+			if e.Severity == SeverityWarning {
+				warnings = append(warnings, e.Error())
+			} else {
+				errors = append(errors, e.Error())
+			}
+		}
+		if strings.Join(errors, "\n") != strings.Join(p.Errors(), "\n") {
+			t.Errorf("%q: expected Errors() to be %q, got %q", tt.input, errors, p.Errors())
+		}
+		if strings.Join(warnings, "\n") != strings.Join(p.Warnings(), "\n") {
+			t.Errorf("%q: expected Warnings() to be %q, got %q", tt.input, warnings, p.Warnings())
+		}
+	}
+
+	e := ParseError{Message: "bad", Severity: SeverityWarning}
+	if !strings.HasPrefix(e.Error(), "Syntax Warning:") || !strings.HasSuffix(e.Error(), "- bad") {
+		t.Errorf("unexpected warning format %q", e.Error())
+	}
+}
+
+//parses the input, and returns the warnings, it fails the test if there are any errors
+func parseWarnings(t *testing.T, input string) []string {
+	t.Helper()
+	p := NewParser(lexer.NewLexer(input))
+	p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: unexpected parser errors: %s", input, strings.Join(p.Errors(), "; "))
+	}
+	return p.Warnings()
+}
+
+func TestLargeIntegerWarning(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string //"" if no warning
+	}{
+		{"9007199254740991", ""},
+		{"9007199254740992", ""}, //2^53 is exact
+		{"1e20", ""},             //not an integer literal
+		{"9007199254740993", "<1:1> - integer literal 9007199254740993 exceeds the exact integer range of float64(2^53), it is stored as 9007199254740992"},
+		{"x = -9007199254740993", "<1:6> - integer literal 9007199254740993 exceeds"},
+		{"0x20000000000001", "<1:1> - integer literal 0x20000000000001 exceeds"},
+	}
+
+	for _, tt := range tests {
+		checkWarning(t, tt.input, parseWarnings(t, tt.input), tt.expected)
+	}
+}
+
+func TestArrayIndexWarning(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string //"" if no warning
+	}{
+		{"[1, 2, 3][-1]", "<1:11> - index (-1) out of range of the array literal with 3 elements"},
+		{"[1, 2][5]", "<1:8> - index 5 out of range of the array literal with 2 elements"},
+		{"[1, 2][2]", "index 2 out of range"},
+		{"[1, 2][1]", ""},
+		{"[1, 2][i]", ""}, //not a constant
+		{"a[5]", ""},      //not an array literal
+	}
+
+	for _, tt := range tests {
+		checkWarning(t, tt.input, parseWarnings(t, tt.input), tt.expected)
+	}
+}
+
+//checks that there is only one warning containing 'expected', or no warnings if 'expected' is ""
+func checkWarning(t *testing.T, input string, warnings []string, expected string) {
+	t.Helper()
+	if expected == "" {
+		if len(warnings) != 0 {
+			t.Errorf("%q: expected no warnings, got %q", input, warnings)
+		}
+		return
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], expected) {
+		t.Errorf("%q: expected a warning containing %q, got %q", input, expected, warnings)
+	}
+}
+
+func TestHashKeyWarning(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string //"" if no warning
+	}{
+		{`let h = {"a": 1, 2: 3, true: 4, x: 5}`, ""},
+		{`let h = {(1, 2): 3}`, ""}, //a tuple is hashable
+		{`let h = {[1]: 2}`, "<1:10> - array literal '[1]' cannot be used as a hash key"},
+		{`let h = {{"a": 1}: 2}`, "hash literal '{a: 1}' cannot be used as a hash key"},
+		{`let h = {"a": 1, fn() {}: 2}`, "function literal 'fn() {}' cannot be used as a hash key"},
+	}
+
+	for _, tt := range tests {
+		checkWarning(t, tt.input, parseWarnings(t, tt.input), tt.expected)
+	}
+
+	//the hash is still parsed
+	program := NewParser(lexer.NewLexer(`let h = {[1]: 2, "b": 3}`)).ParseProgram()
+	if got := program.String(); got != "let h = {[1]: 2, b: 3}" {
+		t.Errorf("expected the hash to be parsed, got %s", got)
+	}
+}
+
+//the parse of an unterminated string terminates, and reports where the string starts
+func TestUnterminatedString(t *testing.T) {
+	checkParseError(t, `let s = "oops`, "<1:9> - Illegal token found. Literal: 'unterminated string literal starting at line 1 col 9'")
+	checkParseError(t, "let a = 1\nlet s = \"oops", "unterminated string literal starting at line 2 col 9")
+}
+
+func TestUnreachableWarning(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string //"" if no warning
+	}{
+		{"fn() { foo(); return 1 }", ""},
+		{"fn() { return 1; foo() }", "<1:18> - unreachable code after 'return'"},
+		{"fn() { throw \"e\"; foo(); bar() }", "<1:19> - unreachable code after 'throw'"}, //only the first one
+		{"while true { break; foo() }", "<1:21> - unreachable code after 'break'"},
+		{"while true { if x { continue }\nfoo() }", ""},
+		{"while true { continue\nfoo() }", "<2:1> - unreachable code after 'continue'"},
+		{"return 1; foo()", ""}, //only in blocks
+	}
+
+	for _, tt := range tests {
+		checkWarning(t, tt.input, parseWarnings(t, tt.input), tt.expected)
+	}
+}
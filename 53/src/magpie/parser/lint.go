@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"magpie/ast"
+)
+
+//EnableEmptyBlockWarnings makes ParseProgram() warn about the empty bodies of loops, if branches and
+//functions, e.g. 'while x > 0 {}', which are usually mistakes. It should be called before ParseProgram().
+//The warnings could be retrieved by Warnings().
+func (p *Parser) EnableEmptyBlockWarnings() {
+	p.warnEmptyBlocks = true
+}
+
+//checkEmptyBlocks runs after the program is parsed, it only reports warnings.
+func (p *Parser) checkEmptyBlocks(program *ast.Program) {
+	ast.Walk(program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.CForLoop:
+			p.warnEmptyBlock(n.Block, "loop body")
+		case *ast.ForEachArrayLoop:
+			p.warnEmptyBlock(n.Block, "loop body")
+		case *ast.ForEachMapLoop:
+			p.warnEmptyBlock(n.Block, "loop body")
+		case *ast.ForEverLoop:
+			p.warnEmptyBlock(n.Block, "loop body")
+		case *ast.WhileLoop:
+			p.warnEmptyBlock(n.Block, "loop body")
+		case *ast.DoLoop:
+			p.warnEmptyBlock(n.Block, "loop body")
+		case *ast.IfExpression:
+			for _, c := range n.Conditions {
+				if c != nil {
+					p.warnEmptyBlock(c.Body, "if branch")
+				}
+			}
+			p.warnEmptyBlock(n.Alternative, "else branch")
+		case *ast.FunctionLiteral:
+			p.warnEmptyBlock(n.Body, "function body")
+		}
+		return true
+	})
+}
+
+func (p *Parser) warnEmptyBlock(block *ast.BlockStatement, what string) {
+	if block != nil && len(block.Statements) == 0 {
+		p.warnf(block.Pos(), "empty %s", what)
+	}
+}
@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"magpie/lexer"
+	"strings"
+	"testing"
+)
+
+func TestEmptyBlockWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"while x > 0 {}", []string{"<1:13> - empty loop body"}},
+		{"while x > 0 { x-- }", nil},
+		{"for i in [1] {}", []string{"empty loop body"}},
+		{"for (i = 0; i < 3; i++) {\n}", []string{"<1:25> - empty loop body"}},
+		{"if x {} else { 1 }", []string{"empty if branch"}},
+		{"if x { 1 } elif y {} else {}", []string{"empty if branch", "empty else branch"}},
+		{"let f = fn() {}", []string{"empty function body"}},
+		{"let f = fn() { 1 }", nil},
+		{"do {}", []string{"empty loop body"}},
+		{"for {}", []string{"empty loop body"}},
+	}
+
+	for _, tt := range tests {
+		for _, p := range []*Parser{
+			NewParser(lexer.NewLexer(tt.input), WithEmptyBlockWarnings()),
+			func() *Parser { p := NewParser(lexer.NewLexer(tt.input)); p.EnableEmptyBlockWarnings(); return p }(),
+		} {
+			p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				t.Fatalf("%q: unexpected parser errors: %s", tt.input, strings.Join(p.Errors(), "; "))
+			}
+			warnings := p.Warnings()
+			if len(warnings) != len(tt.expected) {
+				t.Errorf("%q: expected %d warnings, got %q", tt.input, len(tt.expected), warnings)
+				continue
+			}
+			for i, w := range tt.expected {
+				if !strings.Contains(warnings[i], w) {
+					t.Errorf("%q: expected the warning %d to contain %q, got %q", tt.input, i, w, warnings[i])
+				}
+			}
+		}
+
+		//not enabled by default
+		p := NewParser(lexer.NewLexer(tt.input))
+		p.ParseProgram()
+		if len(p.Warnings()) != 0 {
+			t.Errorf("%q: expected no warnings when not enabled, got %q", tt.input, p.Warnings())
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package parser
+
+//Option configures the parser, it is passed to NewParser(), e.g. 'NewParser(l, WithMaxErrors(10))'.
+type Option func(*Parser)
+
+//WithMaxErrors makes the parser stop collecting errors after 'n' errors, so Errors() returns at most
+//'n' errors, which is useful when the later errors are caused by the first ones. The warnings are not
+//counted. n <= 0 means no limit, which is the default.
+func WithMaxErrors(n int) Option {
+	return func(p *Parser) {
+		p.maxErrors = n
+	}
+}
+
+//WithMaxDepth limits the nesting depth of the expressions, e.g. '((((1))))' is 5 levels deep,
+//deeper input is reported as an error instead of exhausting the stack. The default is 1000,
+//n <= 0 means no limit.
+func WithMaxDepth(n int) Option {
+	return func(p *Parser) {
+		p.maxDepth = n
+	}
+}
+
+//WithAutoSemicolon makes a newline end an expression, see ParserOptions.AutoSemicolon.
+func WithAutoSemicolon() Option {
+	return func(p *Parser) {
+		p.autoSemicolon = true
+	}
+}
+
+//WithStats is the same as calling EnableStats() before ParseProgram().
+func WithStats() Option {
+	return func(p *Parser) {
+		p.EnableStats()
+	}
+}
+
+//WithEmptyBlockWarnings is the same as calling EnableEmptyBlockWarnings() before ParseProgram().
+func WithEmptyBlockWarnings() Option {
+	return func(p *Parser) {
+		p.EnableEmptyBlockWarnings()
+	}
+}
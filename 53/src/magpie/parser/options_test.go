@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"magpie/lexer"
+	"strings"
+	"testing"
+)
+
+//ASI compares the statements of the programs parsed with and without AutoSemicolon
+func TestAutoSemicolon(t *testing.T) {
+	tests := []struct {
+		input   string
+		without string //the statements without ASI, joined by '|'
+		with    string //the statements with ASI
+	}{
+		{"a\n-b", "(a - b)", "a|(-b)"},
+		{"a +\nb", "(a + b)", "(a + b)"},
+		{"let x = 1\nlet y = 2", "let x = 1|let y = 2", "let x = 1|let y = 2"},
+		{"f\n(x)", "f(x)", "f|x"},
+		{"a; b", "a|b", "a|b"},
+	}
+
+	for _, tt := range tests {
+		for _, asi := range []bool{false, true} {
+			p := NewParserWithOptions(lexer.NewLexer(tt.input), ParserOptions{AutoSemicolon: asi})
+			program := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				t.Errorf("%q(ASI=%t): unexpected parser errors: %s", tt.input, asi, strings.Join(p.Errors(), "; "))
+				continue
+			}
+			var stmts []string
+			for _, stmt := range program.Statements {
+				stmts = append(stmts, strings.TrimSuffix(stmt.String(), ";"))
+			}
+			expected := tt.without
+			if asi {
+				expected = tt.with
+			}
+			if got := strings.Join(stmts, "|"); got != expected {
+				t.Errorf("%q(ASI=%t): expected %s, got %s", tt.input, asi, expected, got)
+			}
+		}
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	const input = "let = 1\nlet = 2\nlet = 3\nlet = 4"
+
+	p := NewParser(lexer.NewLexer(input))
+	p.ParseProgram()
+	if len(p.Errors()) < 4 {
+		t.Fatalf("expected at least 4 errors without a limit, got %q", p.Errors())
+	}
+	all := p.Errors()
+
+	tests := []struct {
+		max      int
+		expected int
+	}{
+		{2, 2},
+		{1, 1},
+		{0, len(all)},  //no limit
+		{-1, len(all)}, //no limit
+		{100, len(all)},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(input), WithMaxErrors(tt.max))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != tt.expected {
+			t.Errorf("WithMaxErrors(%d): expected %d errors, got %d: %q", tt.max, tt.expected, len(errors), errors)
+			continue
+		}
+		for i, err := range errors { //the first ones are kept
+			if err != all[i] {
+				t.Errorf("WithMaxErrors(%d): expected error %d to be %q, got %q", tt.max, i, all[i], err)
+			}
+		}
+		if len(p.ErrorLines()) != tt.expected {
+			t.Errorf("WithMaxErrors(%d): expected %d error lines, got %d", tt.max, tt.expected, len(p.ErrorLines()))
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	const n = 100000
+	tests := []struct {
+		input    string
+		opts     []Option
+		expected string //"" if no error is expected
+	}{
+		{strings.Repeat("(", n) + "1" + strings.Repeat(")", n), nil, "maximum nesting depth exceeded, the limit is 1000"},
+		{strings.Repeat("[", n) + strings.Repeat("]", n), nil, "maximum nesting depth exceeded"},
+		{strings.Repeat("-", n) + "1", nil, "maximum nesting depth exceeded"},
+		{strings.Repeat("(", n), nil, "maximum nesting depth exceeded"}, //unterminated
+		{strings.Repeat("(", 10) + "1" + strings.Repeat(")", 10), []Option{WithMaxDepth(5)}, "the limit is 5"},
+		{strings.Repeat("(", 10) + "1" + strings.Repeat(")", 10), []Option{WithMaxDepth(20)}, ""},
+		{strings.Repeat("a + ", n) + "a", nil, ""}, //a long chain is not nested
+	}
+
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input), tt.opts...)
+		p.ParseProgram()
+		errors := p.Errors()
+		if tt.expected == "" {
+			if len(errors) != 0 {
+				t.Errorf("%.20q...: unexpected parser errors: %q", tt.input, errors)
+			}
+			continue
+		}
+		//only the depth error is reported, not the missing ')' of every level
+		if len(errors) != 1 || !strings.Contains(errors[0], tt.expected) {
+			t.Errorf("%.20q...: expected one error containing %q, got %.200q", tt.input, tt.expected, errors)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -19,57 +20,81 @@ const (
 	_ int = iota
 	LOWEST
 	ASSIGN       //=, =>, +=, -=, */, /=, %=
-	RANGE        // ..
+	TERNARY      // a ? b : c, a ?: b
+	NILCOALESCE  // ??
+	PIPE         // |>
 	CONDOR       // ||
 	CONDAND      // &&
 	EQUALS       //==, !=
-	LESSGREATER  //<, <=, >, >=, |>
+	LESSGREATER  //<, <=, >, >=
+	RANGE        // .., ..=, ..<
 	SUM          //+, -
-	PRODUCT      //*, /, %, **
+	PRODUCT      //*, /, %
 	REGEXP_MATCH // !~, ~=
 	PREFIX       //!true, -10
-	INCREMENT    //++, --
+	POWER        //** (binds tighter than prefix operators on its left: -a ** 2 => -(a ** 2))
+	INCREMENT    //x++, x--(postfix, binds looser than CALL, so 'a[i]++' is '(a[i])++')
 	CALL         //add(1,2), array[index], obj.add(1,2)
 )
 
+//precedences of the infix(and postfix) operators, grouped by level from the lowest to the highest:
+//assignment, ternary, nil-coalescing, pipe, logical-or, logical-and, equality, comparison,
+//range, additive, multiplicative, regexp match, power, postfix, call.
+//Prefix operators(PREFIX) are parsed by their own parse functions, so they are not in the table.
 var precedences = map[token.TokenType]int{
-	token.TOKEN_ASSIGN:     ASSIGN,
-	token.TOKEN_PLUS_A:     ASSIGN,
-	token.TOKEN_MINUS_A:    ASSIGN,
-	token.TOKEN_ASTERISK_A: ASSIGN,
-	token.TOKEN_SLASH_A:    ASSIGN,
-	token.TOKEN_MOD_A:      ASSIGN,
-
-	token.TOKEN_FATARROW: ASSIGN,
-	token.TOKEN_OR:       CONDOR,
-	token.TOKEN_AND:      CONDAND,
+	//x = 1, x += 1, x := 1, x => x + 1
+	token.TOKEN_ASSIGN:        ASSIGN,
+	token.TOKEN_PLUS_A:        ASSIGN,
+	token.TOKEN_MINUS_A:       ASSIGN,
+	token.TOKEN_ASTERISK_A:    ASSIGN,
+	token.TOKEN_SLASH_A:       ASSIGN,
+	token.TOKEN_MOD_A:         ASSIGN,
+	token.TOKEN_OR_A:          ASSIGN,
+	token.TOKEN_NILCOALESCE_A: ASSIGN,
+	token.TOKEN_COLONASSIGN:   ASSIGN,
+	token.TOKEN_FATARROW:      ASSIGN,
+
+	//a ? b : c, a ?: b
+	token.TOKEN_QUESTIONM: TERNARY,
+
+	token.TOKEN_NILCOALESCE: NILCOALESCE,
+	token.TOKEN_PIPE:        PIPE,
+	token.TOKEN_OR:          CONDOR,
+	token.TOKEN_AND:         CONDAND,
 
 	token.TOKEN_EQ:  EQUALS,
 	token.TOKEN_NEQ: EQUALS,
 
-	token.TOKEN_LT:   LESSGREATER,
-	token.TOKEN_LE:   LESSGREATER,
-	token.TOKEN_GT:   LESSGREATER,
-	token.TOKEN_GE:   LESSGREATER,
-	token.TOKEN_IN:   LESSGREATER,
-	token.TOKEN_PIPE: LESSGREATER,
+	token.TOKEN_LT: LESSGREATER,
+	token.TOKEN_LE: LESSGREATER,
+	token.TOKEN_GT: LESSGREATER,
+	token.TOKEN_GE: LESSGREATER,
+	token.TOKEN_IN: LESSGREATER,
+
+	token.TOKEN_DOTDOT:   RANGE,
+	token.TOKEN_DOTDOTEQ: RANGE,
+	token.TOKEN_DOTDOTLT: RANGE,
 
 	token.TOKEN_PLUS:     SUM,
 	token.TOKEN_MINUS:    SUM,
 	token.TOKEN_MULTIPLY: PRODUCT,
 	token.TOKEN_DIVIDE:   PRODUCT,
 	token.TOKEN_MOD:      PRODUCT,
-	token.TOKEN_POWER:    PRODUCT,
 
-	token.TOKEN_LPAREN:    CALL,
-	token.TOKEN_DOT:       CALL,
-	token.TOKEN_LBRACKET:  CALL,
+	token.TOKEN_MATCH:    REGEXP_MATCH,
+	token.TOKEN_NOTMATCH: REGEXP_MATCH,
+
+	token.TOKEN_POWER: POWER,
+
 	token.TOKEN_INCREMENT: INCREMENT,
 	token.TOKEN_DECREMENT: INCREMENT,
 
-	token.TOKEN_MATCH:    REGEXP_MATCH,
-	token.TOKEN_NOTMATCH: REGEXP_MATCH,
-	token.TOKEN_DOTDOT:   RANGE,
+	token.TOKEN_LPAREN:            CALL,
+	token.TOKEN_DOT:               CALL,
+	token.TOKEN_LBRACKET:          CALL,
+	token.TOKEN_OPTIONAL_LBRACKET: CALL,
+	token.TOKEN_OPTIONAL_DOT:      CALL,
+	token.TOKEN_SCOPE:             CALL,
 }
 
 type (
@@ -94,22 +119,66 @@ func isStdLib(name string) bool {
 }
 
 type Parser struct {
-	l          *lexer.Lexer
-	errors     []string //error messages
-	errorLines []string //for using with wasm communication.
+	l           *lexer.Lexer
+	errors      []string     //error messages
+	errorLines  []string     //for using with wasm communication.
+	parseErrors []ParseError //same errors as 'errors', with positions
 
 	curToken   token.Token
 	peekToken  token.Token
 	savedToken token.Token //used in anonymous functions parsing
 
+	arrowReturnType *ast.Identifier //the return type of the arrow function being parsed, e.g. '(x): Number => x'
+	arrowParams     ast.Expression  //the parameters 'arrowReturnType' belongs to, nil for '()'
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 
 	loopDepth        int // current loop depth (0 if not in any loops)
 	fallthroughDepth int //current fallthrough depth (0 if not in switch cases)
 
+	//greater than 0 when parsing an expression which is followed by a block, e.g. 'if x.y {',
+	//where the '{' does not start a trailing closure
+	noTrailingBlock int
+
+	//true at the top level of the brackets of an index, where '::' is the two colons of a slice,
+	//e.g. a[i::n], not a scope resolution. See enterBrackets().
+	sliceColons bool
+
+	//the number of ':' the enclosing ternaries, hash keys and indexes are waiting for inside the
+	//current brackets, e.g. 1 after the '?' of 'a ? b'. See ternaryFollows().
+	colons int
+
+	//the precedences of this parse, changed by the '#prec' pragmas at the top of the file.
+	//nil if there are no pragmas, then the global 'precedences' is used.
+	precedences map[token.TokenType]int
+	inPragmas   bool           //true while reading the pragmas at the top of the file
+	precPragmas map[string]int //the pragmas by operator, recorded on the Program for the formatter
+
 	Attachments *ember.Attachments
 	importLib   map[string]*ast.Program //for use with imported standard libs
+
+	stats  *ParseStats //nil if not enabled
+	tokens int         //the number of tokens read from the lexer, excluding the EOF, see ParseStats.Tokens
+
+	warnEmptyBlocks bool //see EnableEmptyBlockWarnings()
+	autoSemicolon   bool //see ParserOptions.AutoSemicolon
+	maxErrors       int  //see WithMaxErrors(), 0 if there is no limit
+
+	depth    int  //the number of the nested parseExpression() calls
+	maxDepth int  //see WithMaxDepth(), 0 if there is no limit
+	tooDeep  bool //true after 'maxDepth' is exceeded, then the parsing stops
+}
+
+//the default limit of the nested expressions, deeper input is likely to be generated or malicious,
+//and could exhaust the stack.
+const defaultMaxDepth = 1000
+
+//ParserOptions are the options of NewParserWithOptions(), the zero value is the same as NewParser().
+type ParserOptions struct {
+	//AutoSemicolon makes a newline end an expression like a ';' does. So a multi-line expression must be
+	//broken after an operator, e.g. 'a +' newline 'b', while 'a' newline '+b' are two statements.
+	AutoSemicolon bool
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -120,27 +189,44 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
-func NewParser(l *lexer.Lexer) *Parser {
+//NewParser returns a parser reading the tokens from 'l', the options are applied after the
+//pragmas at the top of the file are read.
+func NewParser(l *lexer.Lexer, opts ...Option) *Parser {
 	p := &Parser{
 		l:          l,
 		errors:     []string{},
 		errorLines: []string{},
 		importLib:  make(map[string]*ast.Program),
+		maxDepth:   defaultMaxDepth,
 	}
 
 	p.registerAction()
+	for _, opt := range opts { //before reading the first tokens, e.g. WithStats() counts them
+		opt(p)
+	}
 
+	p.inPragmas = true
 	p.nextToken()
 	p.nextToken()
+	p.parsePragmas()
 	return p
 }
 
+func NewParserWithOptions(l *lexer.Lexer, opts ParserOptions) *Parser {
+	if opts.AutoSemicolon {
+		return NewParser(l, WithAutoSemicolon())
+	}
+	return NewParser(l)
+}
+
 func (p *Parser) registerAction() {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.TOKEN_ILLEGAL, p.parsePrefixIllegalExpression)
 	p.registerPrefix(token.TOKEN_NUMBER, p.parseNumber)
-	p.registerPrefix(token.TOKEN_IDENTIFIER, p.parseIdentifier)
+	p.registerPrefix(token.TOKEN_IDENTIFIER, p.parseIdentifierOrMatch)
 	p.registerPrefix(token.TOKEN_STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TOKEN_CHAR, p.parseCharLiteral)
+	p.registerPrefix(token.TOKEN_RAWSTRING, p.parseRawStringLiteral)
 	p.registerPrefix(token.TOKEN_FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.TOKEN_TRUE, p.parseBooleanLiteral)
 	p.registerPrefix(token.TOKEN_FALSE, p.parseBooleanLiteral)
@@ -151,18 +237,24 @@ func (p *Parser) registerAction() {
 	p.registerPrefix(token.TOKEN_PLUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TOKEN_MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TOKEN_BANG, p.parsePrefixExpression)
+	p.registerPrefix(token.TOKEN_NOT, p.parsePrefixExpression) //same as '!'
+	p.registerPrefix(token.TOKEN_TILDE, p.parsePrefixExpression)
+	p.registerPrefix(token.TOKEN_INCREMENT, p.parseIncDecPrefixExpression)
+	p.registerPrefix(token.TOKEN_DECREMENT, p.parseIncDecPrefixExpression)
 	p.registerPrefix(token.TOKEN_LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.TOKEN_IF, p.parseIfExpression)
 	p.registerPrefix(token.TOKEN_SWITCH, p.parseSwitchExpression)
 	p.registerPrefix(token.TOKEN_FALLTHROUGH, p.parseFallThroughExpression)
 
-	p.registerPrefix(token.TOKEN_DO, p.parseDoLoopExpression)
+	p.registerPrefix(token.TOKEN_DO, p.parseDoExpression)
 	p.registerPrefix(token.TOKEN_WHILE, p.parseWhileLoopExpression)
 	p.registerPrefix(token.TOKEN_FOR, p.parseForLoopExpression)
 	p.registerPrefix(token.TOKEN_BREAK, p.parseBreakExpression)
 	p.registerPrefix(token.TOKEN_CONTINUE, p.parseContinueExpression)
 	p.registerPrefix(token.TOKEN_AT, p.parseDecorator)
 	p.registerPrefix(token.TOKEN_CMD, p.parseCommand)
+	p.registerPrefix(token.TOKEN_ASYNC, p.parseAsyncLiteral)
+	p.registerPrefix(token.TOKEN_AWAIT, p.parseAwaitExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerPrefix(token.TOKEN_ILLEGAL, p.parseInfixIllegalExpression)
@@ -174,6 +266,8 @@ func (p *Parser) registerAction() {
 	p.registerInfix(token.TOKEN_POWER, p.parseInfixExpression)
 	p.registerInfix(token.TOKEN_LPAREN, p.parseCallExpression)
 	p.registerInfix(token.TOKEN_LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.TOKEN_OPTIONAL_LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.TOKEN_OPTIONAL_DOT, p.parseOptionalChain)
 
 	p.registerInfix(token.TOKEN_LT, p.parseInfixExpression)
 	p.registerInfix(token.TOKEN_LE, p.parseInfixExpression)
@@ -182,19 +276,24 @@ func (p *Parser) registerAction() {
 	p.registerInfix(token.TOKEN_EQ, p.parseInfixExpression)
 	p.registerInfix(token.TOKEN_NEQ, p.parseInfixExpression)
 	p.registerInfix(token.TOKEN_IN, p.parseInfixExpression)
-	p.registerInfix(token.TOKEN_PIPE, p.parseInfixExpression)
+	p.registerInfix(token.TOKEN_PIPE, p.parsePipeExpression)
 
 	p.registerInfix(token.TOKEN_AND, p.parseInfixExpression)
 	p.registerInfix(token.TOKEN_OR, p.parseInfixExpression)
+	p.registerInfix(token.TOKEN_NILCOALESCE, p.parseInfixExpression)
+	p.registerInfix(token.TOKEN_QUESTIONM, p.parseTernaryExpression)
 
 	p.registerInfix(token.TOKEN_MATCH, p.parseInfixExpression)
 	p.registerInfix(token.TOKEN_NOTMATCH, p.parseInfixExpression)
-	p.registerInfix(token.TOKEN_DOTDOT, p.parseInfixExpression)
+	p.registerInfix(token.TOKEN_DOTDOT, p.parseRangeExpression)
+	p.registerInfix(token.TOKEN_DOTDOTEQ, p.parseRangeExpression)
+	p.registerInfix(token.TOKEN_DOTDOTLT, p.parseRangeExpression)
 
 	p.registerInfix(token.TOKEN_INCREMENT, p.parsePostfixExpression)
 	p.registerInfix(token.TOKEN_DECREMENT, p.parsePostfixExpression)
 
 	p.registerInfix(token.TOKEN_DOT, p.parseMethodCallExpression)
+	p.registerInfix(token.TOKEN_SCOPE, p.parseScopeResolution)
 
 	p.registerInfix(token.TOKEN_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.TOKEN_PLUS_A, p.parseAssignExpression)
@@ -202,6 +301,9 @@ func (p *Parser) registerAction() {
 	p.registerInfix(token.TOKEN_ASTERISK_A, p.parseAssignExpression)
 	p.registerInfix(token.TOKEN_SLASH_A, p.parseAssignExpression)
 	p.registerInfix(token.TOKEN_MOD_A, p.parseAssignExpression)
+	p.registerInfix(token.TOKEN_OR_A, p.parseAssignExpression)
+	p.registerInfix(token.TOKEN_NILCOALESCE_A, p.parseAssignExpression)
+	p.registerInfix(token.TOKEN_COLONASSIGN, p.parseDeclareAssignExpression)
 
 	p.registerInfix(token.TOKEN_FATARROW, p.parseFatArrow)
 }
@@ -214,17 +316,21 @@ func (p *Parser) ParseProgram() *ast.Program {
 	}()
 
 	program := &ast.Program{}
+	if p.stats != nil {
+		defer p.recordStats(program, time.Now(), p.stats.LexTime)
+	}
 
 	program.Statements = []ast.Statement{}
 	program.Imports = make(map[string]*ast.ImportStatement)
+	program.Precedences = p.precPragmas
 
-	for p.curToken.Type != token.TOKEN_EOF {
-		stmt := p.parseStatement()
+	for p.curToken.Type != token.TOKEN_EOF && !p.tooDeep {
+		stmt := p.parseStatementWithRecovery()
 		if stmt != nil {
-			if importStmt, ok := stmt.(*ast.ImportStatement); ok {
+			//a conditional import is evaluated where it is, e.g. after 'let DEBUG = true'
+			if importStmt, ok := stmt.(*ast.ImportStatement); ok && importStmt.Condition == nil {
 				importPath := importStmt.ImportPath
-				_, ok := program.Imports[importPath]
-				if !ok { //if not ok, we need to import it, or else we do not want to import twice
+				if _, ok := program.Imports[importPath]; !ok { //if not ok, we need to import it, or else we do not want to import twice
 					program.Imports[importPath] = importStmt
 				}
 			} else {
@@ -234,15 +340,125 @@ func (p *Parser) ParseProgram() *ast.Program {
 		p.nextToken()
 	}
 
+	if p.warnEmptyBlocks {
+		p.checkEmptyBlocks(program)
+	}
+
 	return program
 }
 
+//the pragmas at the top of the file, before the first statement:
+//   #prec(+, 13)  //'+' binds tighter than '*'(PRODUCT) for the rest of the file
+func (p *Parser) parsePragmas() {
+	for p.curTokenIs(token.TOKEN_PRAGMA) {
+		p.parsePrecPragma()
+		p.nextToken()
+	}
+	p.inPragmas = false
+	if p.peekTokenIs(token.TOKEN_PRAGMA) { //e.g. 'x #prec(+, 7)'
+		p.readPeekToken()
+	}
+}
+
+func (p *Parser) parsePrecPragma() {
+	tok := p.curToken
+	args := strings.TrimPrefix(tok.Literal, "prec(")
+	idx := strings.LastIndex(args, ",")
+	if !strings.HasSuffix(args, ")") || idx == -1 {
+		p.errorf(tok.Pos, "invalid pragma '#%s', expected '#prec(operator, precedence)'", tok.Literal)
+		return
+	}
+
+	op := strings.TrimSpace(args[:idx])
+	l := lexer.NewLexer(op)
+	opTok := l.NextToken()
+	if _, ok := p.infixParseFns[opTok.Type]; !ok || opTok.Literal != op {
+		p.errorf(tok.Pos, "invalid pragma '#%s', '%s' is not an infix operator", tok.Literal, op)
+		return
+	}
+
+	prec, err := strconv.Atoi(strings.TrimSpace(args[idx+1 : len(args)-1]))
+	if err != nil || prec <= LOWEST || prec > CALL { //an operator of LOWEST is never parsed as infix
+		p.errorf(tok.Pos, "invalid pragma '#%s', precedence should be an integer between %d and %d", tok.Literal, ASSIGN, CALL)
+		return
+	}
+
+	if p.precedences == nil {
+		p.precedences = make(map[token.TokenType]int, len(precedences))
+		for t, prec := range precedences {
+			p.precedences[t] = prec
+		}
+	}
+	p.precedences[opTok.Type] = prec
+
+	if p.precPragmas == nil {
+		p.precPragmas = make(map[string]int)
+	}
+	p.precPragmas[opTok.Literal] = prec
+}
+
+//ParseNext parses the next statement, it returns nil if there are no more statements.
+//'semicolon' reports whether the statement ended with an explicit ';', so a REPL
+//could decide whether to print the result or not, e.g. 'x;' vs 'x'.
+func (p *Parser) ParseNext() (stmt ast.Statement, semicolon bool) {
+	for p.curTokenIs(token.TOKEN_SEMICOLON) { //skip empty statements
+		p.nextToken()
+	}
+	if p.curTokenIs(token.TOKEN_EOF) {
+		return nil, false
+	}
+
+	stmt = p.parseStatementWithRecovery()
+	semicolon = p.curTokenIs(token.TOKEN_SEMICOLON)
+	p.nextToken()
+
+	return stmt, semicolon
+}
+
+//parseStatementWithRecovery parses a statement, if the statement has errors, the rest of it
+//is skipped, so the next statement is parsed independently and reports its own errors.
+func (p *Parser) parseStatementWithRecovery() ast.Statement {
+	errCount := len(p.errors)
+	stmt := p.parseStatement()
+	if len(p.errors) > errCount {
+		p.skipStatement()
+	}
+	return stmt
+}
+
+//skipStatement skips tokens up to the end of the current statement: a ';', the end of the line,
+//or the token before the '}' which closes the enclosing block. Blocks opened while skipping are
+//skipped as a whole, e.g. the body of 'if x +* 1 { ... }'.
+func (p *Parser) skipStatement() {
+	depth := 0
+	for !p.curTokenIs(token.TOKEN_EOF) {
+		if p.curTokenIs(token.TOKEN_LBRACE) {
+			depth++
+		} else if p.curTokenIs(token.TOKEN_RBRACE) && depth > 0 {
+			depth--
+		}
+
+		if depth == 0 {
+			if p.curTokenIs(token.TOKEN_SEMICOLON) || p.peekTokenIs(token.TOKEN_RBRACE) || p.peekTokenIs(token.TOKEN_EOF) {
+				return
+			}
+			if p.peekToken.Pos.Line > p.curToken.Pos.Line {
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.TOKEN_IMPORT:
 		return p.parseImportStatement()
 	case token.TOKEN_LET:
-		return p.parseLetStatement()
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
+		return nil //do not return a typed nil
 	case token.TOKEN_RETURN:
 		return p.parseReturnStatement()
 	case token.TOKEN_TAIL:
@@ -255,6 +471,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseTryStatement()
 	case token.TOKEN_THROW:
 		return p.parseThrowStatement()
+	case token.TOKEN_DO:
+		return p.parseDoLoopStatement()
+	case token.TOKEN_USE:
+		return p.parseUseStatement()
+	case token.TOKEN_EXPORT:
+		return p.parseExportStatement()
 	case token.TOKEN_IDENTIFIER:
 		stmt := p.parseExpressionStatement()
 		if p.peekTokenIs(token.TOKEN_COMMA) {
@@ -266,27 +488,122 @@ func (p *Parser) parseStatement() ast.Statement {
 	}
 }
 
+//export { a, b, c }
+func (p *Parser) parseExportStatement() ast.Statement {
+	stmt := &ast.ExportStatement{Token: p.curToken}
+	if !p.expectPeek(token.TOKEN_LBRACE) {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	duplicated := false
+	for !p.peekTokenIs(token.TOKEN_RBRACE) {
+		if !p.expectPeek(token.TOKEN_IDENTIFIER) {
+			return nil
+		}
+		name := p.curToken.Literal
+		if seen[name] { //report it, but parse the rest of the list
+			p.errorf(p.curToken.Pos, "duplicate name '%s' in export list", name)
+			duplicated = true
+		}
+		seen[name] = true
+		stmt.Names = append(stmt.Names, name)
+
+		if !p.peekTokenIs(token.TOKEN_COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.TOKEN_RBRACE) || duplicated {
+		return nil
+	}
+	stmt.RBraceToken = p.curToken
+
+	if p.peekTokenIs(token.TOKEN_SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+//use name = expression in { block }
+//The expression is parsed with a precedence higher than 'in', so comparisons need parentheses.
+func (p *Parser) parseUseStatement() ast.Statement {
+	stmt := &ast.UseStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.TOKEN_IDENTIFIER) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if stmt.Name.Value == "self" {
+		p.errorf(p.curToken.Pos, "'self' can not be used as an alias")
+		return nil
+	}
+
+	if !p.expectPeek(token.TOKEN_ASSIGN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LESSGREATER) //do not consume the 'in'
+	if stmt.Value == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.TOKEN_IN) {
+		return nil
+	}
+	if !p.expectPeek(token.TOKEN_LBRACE) {
+		return nil
+	}
+	stmt.Block = p.parseBlockStatement()
+
+	return stmt
+}
+
+//import sub_package.calc
+//import "sub_package/calc"
 func (p *Parser) parseImportStatement() *ast.ImportStatement {
 	stmt := &ast.ImportStatement{Token: p.curToken}
 
 	p.nextToken()
 
-	paths := []string{}
-	paths = append(paths, p.curToken.Literal)
-
-	for p.peekTokenIs(token.TOKEN_DOT) {
-		p.nextToken() //skip current token
-		p.nextToken() //skip '.'
+	var path string
+	if p.curTokenIs(token.TOKEN_STRING) {
+		path = strings.TrimSuffix(strings.TrimSpace(p.curToken.Literal), ".mp")
+		if path == "" {
+			p.errorf(p.curToken.Pos, "empty import path")
+			return stmt
+		}
+	} else {
+		paths := []string{}
 		paths = append(paths, p.curToken.Literal)
-	}
 
-	path := strings.TrimSpace(strings.Join(paths, "/"))
+		for p.peekTokenIs(token.TOKEN_DOT) {
+			p.nextToken() //skip current token
+			p.nextToken() //skip '.'
+			paths = append(paths, p.curToken.Literal)
+		}
+
+		path = strings.TrimSpace(strings.Join(paths, "/"))
+	}
 	stmt.ImportPath = filepath.Base(path)
+	stmt.Path = path
+
+	//import "debug/tools" if DEBUG: the file is not read here, the evaluator loads it with
+	//LoadImport() when the statement is reached and the condition is true. An 'if' on the
+	//next line starts a new statement.
+	if p.peekTokenIs(token.TOKEN_IF) && p.peekToken.Pos.Line == p.curToken.Pos.Line {
+		p.nextToken()
+		p.nextToken()
+		stmt.Condition = p.parseExpression(LOWEST)
+		if p.peekTokenIs(token.TOKEN_SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
+	}
 
 	program, err := p.getImportedStatements(path)
 	if err != nil {
-		p.errors = append(p.errors, err.Error())
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "%s", err)
 		return stmt
 	}
 
@@ -298,6 +615,22 @@ func (p *Parser) parseImportStatement() *ast.ImportStatement {
 	return stmt
 }
 
+//LoadImport reads and parses the program of a conditional import, e.g. 'import "debug/tools" if DEBUG',
+//which the parser leaves unloaded. The path is resolved like the other imports, relative to the file of the statement.
+func LoadImport(stmt *ast.ImportStatement) (*ast.Program, error) {
+	l := lexer.NewLexer("")
+	l.Filename = stmt.Token.Pos.Filename
+	p := NewParser(l)
+	program, err := p.getImportedStatements(stmt.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.errors) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(p.errors, "; "))
+	}
+	return program, nil
+}
+
 func (p *Parser) getImportedStatements(importpath string) (*ast.Program, error) {
 	var f []byte
 	var fn string
@@ -326,7 +659,7 @@ func (p *Parser) getImportedStatements(importpath string) (*ast.Program, error)
 			if len(importRoot) == 0 { //'MAGPIE_ROOT' environment variable is not set
 				//check embedded file
 				if p.Attachments == nil {
-					return nil, fmt.Errorf("Syntax Error:%v- no file or directory: %s.mp, %s", p.curToken.Pos, importpath, path)
+					return nil, fmt.Errorf("no file or directory: %s.mp, %s", importpath, path)
 				}
 
 				//search in attachments
@@ -339,19 +672,19 @@ func (p *Parser) getImportedStatements(importpath string) (*ast.Program, error)
 					}
 				}
 				if !iFound {
-					return nil, fmt.Errorf("Syntax Error:%v- no file or directory: %s.mp, %s", p.curToken.Pos, importpath, path)
+					return nil, fmt.Errorf("no file or directory: %s.mp, %s", importpath, path)
 				}
 
 				buf, err := p.Attachments.GetResource(importpath)
 				if err != nil {
-					return nil, fmt.Errorf("Syntax Error:%v- no file or directory: %s.mp, %s", p.curToken.Pos, importpath, path)
+					return nil, fmt.Errorf("no file or directory: %s.mp, %s", importpath, path)
 				}
 				f = buf
 			} else {
 				fn = filepath.Join(importRoot, importpath+".mp")
 				e, err := ioutil.ReadFile(fn)
 				if err != nil {
-					return nil, fmt.Errorf("Syntax Error:%v- no file or directory: %s.mp, %s", p.curToken.Pos, importpath, importRoot)
+					return nil, fmt.Errorf("no file or directory: %s.mp, %s", importpath, importRoot)
 				}
 				f = e
 			}
@@ -364,9 +697,8 @@ func (p *Parser) getImportedStatements(importpath string) (*ast.Program, error)
 	ps := NewParser(l)
 	ps.Attachments = p.Attachments
 	parsed := ps.ParseProgram()
-	if len(ps.errors) != 0 {
-		p.errors = append(p.errors, ps.errors...)
-		p.errorLines = append(p.errorLines, ps.errorLines...)
+	for _, e := range ps.parseErrors {
+		p.addError(e)
 	}
 
 	if isStdLib(importpath) {
@@ -378,23 +710,32 @@ func (p *Parser) getImportedStatements(importpath string) (*ast.Program, error)
 
 //let a,b,c = 1,2,3 (with assignment)
 //let a; (without assignment, 'a' is assumed to be 'nil')
+//let mut a = 1 (mutable binding)
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
+	if p.peekTokenIs(token.TOKEN_MUT) {
+		p.nextToken()
+		stmt.Mutable = true
+	}
+
+	if p.peekTokenIs(token.TOKEN_LBRACE) || p.peekTokenIs(token.TOKEN_LBRACKET) {
+		p.nextToken()
+		return p.parseDestructuringLet(stmt)
+	}
 
 	//parse left hand side of the assignment
 	for {
 		p.nextToken()
+		if p.reservedKeywordError() {
+			return stmt
+		}
 		if !p.curTokenIs(token.TOKEN_IDENTIFIER) && p.curToken.Literal != "_" {
-			msg := fmt.Sprintf("Syntax Error:%v- expected token to be identifier|underscore, got %s instead.", p.curToken.Pos, p.curToken.Type)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			p.errorf(p.curToken.Pos, "expected token to be identifier|underscore, got %s instead.", p.curToken.Type)
 			return stmt
 		}
 		name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		if p.curToken.Literal == "self" {
-			msg := fmt.Sprintf("Syntax Error:%v- 'self' can not be assigned", p.curToken.Pos)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			p.errorf(p.curToken.Pos, "'self' can not be assigned")
 			return nil
 		}
 		stmt.Names = append(stmt.Names, name)
@@ -404,9 +745,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 			break
 		}
 		if !p.curTokenIs(token.TOKEN_COMMA) {
-			msg := fmt.Sprintf("Syntax Error:%v- expected token to be comma, got %s instead.", p.curToken.Pos, p.curToken.Type)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			p.errorf(p.curToken.Pos, "expected token to be comma, got %s instead.", p.curToken.Type)
 			return stmt
 		}
 	}
@@ -430,7 +769,82 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
-func (p *Parser) parseMultiAssignStatement(expr ast.Expression) *ast.MultiAssignStatement {
+//let {x, y = 0} = point
+//let [a, b = 2] = xs
+func (p *Parser) parseDestructuringLet(stmt *ast.LetStatement) *ast.LetStatement {
+	open := p.curToken
+	end := token.TOKEN_RBRACE
+	if open.Type == token.TOKEN_LBRACKET {
+		end = token.TOKEN_RBRACKET
+	}
+
+	elements := []*ast.PatternElement{}
+	seen := make(map[string]bool)
+	for !p.peekTokenIs(end) {
+		if !p.expectPeek(token.TOKEN_IDENTIFIER) {
+			return nil
+		}
+		name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if name.Value == "self" {
+			p.errorf(p.curToken.Pos, "'self' can not be assigned")
+			return nil
+		}
+		if name.Value != "_" {
+			if seen[name.Value] {
+				p.errorf(p.curToken.Pos, "duplicate name '%s' in destructuring pattern", name.Value)
+			}
+			seen[name.Value] = true
+		}
+
+		elem := &ast.PatternElement{Name: name}
+		if p.peekTokenIs(token.TOKEN_ASSIGN) {
+			p.nextToken() //skip name
+			p.nextToken() //skip '='
+			elem.Default = p.parseExpression(LOWEST)
+			if elem.Default == nil {
+				return nil
+			}
+		}
+		elements = append(elements, elem)
+
+		if !p.peekTokenIs(token.TOKEN_COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(end) {
+		return nil
+	}
+	if len(elements) == 0 {
+		p.errorf(open.Pos, "empty destructuring pattern")
+		return nil
+	}
+
+	if end == token.TOKEN_RBRACE {
+		stmt.Pattern = &ast.HashPattern{Token: open, Elements: elements, RBraceToken: p.curToken}
+	} else {
+		stmt.Pattern = &ast.ArrayPattern{Token: open, Elements: elements, RBracketToken: p.curToken}
+	}
+
+	if !p.expectPeek(token.TOKEN_ASSIGN) {
+		return nil
+	}
+	p.nextToken()
+	value := p.parseExpressionStatement().Expression
+	if value == nil {
+		return nil
+	}
+	stmt.Values = []ast.Expression{value}
+	if p.peekTokenIs(token.TOKEN_COMMA) {
+		p.errorf(p.peekToken.Pos, "destructuring let statement must have exactly one value")
+		return nil
+	}
+
+	return stmt
+}
+
+//a, b, c = c, a, b
+func (p *Parser) parseMultiAssignStatement(expr ast.Expression) ast.Statement {
 	tok := token.Token{Pos: p.curToken.Pos, Type: token.TOKEN_ASSIGN, Literal: "="}
 	stmt := &ast.MultiAssignStatement{Token: tok}
 
@@ -448,7 +862,8 @@ func (p *Parser) parseMultiAssignStatement(expr ast.Expression) *ast.MultiAssign
 			break
 		}
 		if !p.peekTokenIs(token.TOKEN_COMMA) {
-			break
+			p.errorf(p.peekToken.Pos, "expected '=' in multiple assignment, got %s instead", p.peekToken.Type)
+			return nil
 		}
 
 		p.nextToken()
@@ -467,17 +882,39 @@ func (p *Parser) parseMultiAssignStatement(expr ast.Expression) *ast.MultiAssign
 		p.nextToken()
 	}
 
+	//check the counts. A call may return multiple values, e.g. 'a, b = f()', and a single
+	//value may be a tuple to destructure, e.g. 'a, _ = pair', so when there are less values
+	//than names, it's only an error if there are several values and none of them is a call.
+	namesLen, valuesLen := len(stmt.Names), len(stmt.Values)
+	if valuesLen > namesLen || (valuesLen < namesLen && valuesLen > 1 && !hasCall(stmt.Values)) {
+		p.errorf(stmt.Token.Pos, "assignment mismatch: %d variables but %d values", namesLen, valuesLen)
+		return nil
+	}
+
 	//fmt.Printf("MultiAssignStatement=%s\n", stmt)
 	return stmt
 }
 
+func hasCall(exprs []ast.Expression) bool {
+	for _, expr := range exprs {
+		switch expr.(type) {
+		case *ast.CallExpression, *ast.MethodCallExpression, *ast.ScopeResolution:
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken, ReturnValues: []ast.Expression{}}
 	if p.peekTokenIs(token.TOKEN_SEMICOLON) { //e.g.{ return; }
 		p.nextToken()
 		return stmt
 	}
-	if p.peekTokenIs(token.TOKEN_RBRACE) { //e.g. { return }
+	if p.peekTokenIs(token.TOKEN_RBRACE) || p.peekTokenIs(token.TOKEN_EOF) { //e.g. { return }
+		return stmt
+	}
+	if p.autoSemicolon && p.peekOnNewLine() { //'return' newline 'x' returns nothing
 		return stmt
 	}
 
@@ -507,9 +944,7 @@ func (p *Parser) parseTailCallStatement() *ast.TailCallStatement {
 	switch stmt.Call.(type) {
 	case *ast.CallExpression:
 	default:
-		msg := fmt.Sprintf("Syntax Error:%v- 'tailcall' must be followed by a function call")
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "'tailcall' must be followed by a function call")
 		return nil
 	}
 
@@ -518,10 +953,21 @@ func (p *Parser) parseTailCallStatement() *ast.TailCallStatement {
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	blockStmt := &ast.BlockStatement{Token: p.curToken}
-	blockStmt.Statements = []ast.Statement{}
 	p.nextToken()
+	p.parseBlockBody(blockStmt)
+	return blockStmt
+}
+
+//parseBlockBody parses the statements of a block from the current token until the '}'.
+func (p *Parser) parseBlockBody(blockStmt *ast.BlockStatement) {
+	saved := p.noTrailingBlock
+	p.noTrailingBlock = 0
+	defer func() { p.noTrailingBlock = saved }()
+	defer p.enterBrackets(false)()
+
+	blockStmt.Statements = []ast.Statement{}
 	for !p.curTokenIs(token.TOKEN_RBRACE) {
-		stmt := p.parseStatement()
+		stmt := p.parseStatementWithRecovery()
 		if stmt != nil {
 			blockStmt.Statements = append(blockStmt.Statements, stmt)
 		}
@@ -532,7 +978,35 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	}
 
 	blockStmt.RBraceToken = p.curToken
-	return blockStmt
+	p.checkUnreachable(blockStmt)
+}
+
+//warns about the first statement after a 'return', 'throw', 'break' or 'continue' in the block,
+//e.g. '{ return 1; foo() }', because it will never run.
+func (p *Parser) checkUnreachable(blockStmt *ast.BlockStatement) {
+	if len(blockStmt.Statements) == 0 {
+		return
+	}
+	for i, stmt := range blockStmt.Statements[:len(blockStmt.Statements)-1] {
+		var what string
+		switch s := stmt.(type) {
+		case *ast.ReturnStatement, *ast.TailCallStatement:
+			what = "return"
+		case *ast.ThrowStmt:
+			what = "throw"
+		case *ast.ExpressionStatement:
+			switch s.Expression.(type) {
+			case *ast.BreakExpression:
+				what = "break"
+			case *ast.ContinueExpression:
+				what = "continue"
+			}
+		}
+		if what != "" {
+			p.warnf(blockStmt.Statements[i+1].Pos(), "unreachable code after '%s'", what)
+			return
+		}
+	}
 }
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
@@ -547,12 +1021,26 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	if p.tooDeep {
+		return nil
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		p.errorf(p.curToken.Pos, "maximum nesting depth exceeded, the limit is %d", p.maxDepth)
+		p.tooDeep = true //the outer expressions would report lots of missing ')', ']' etc.
+		return nil
+	}
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
 		return nil
 	}
 	leftExp := prefix()
+	if p.tooDeep { //bail out, the operands are incomplete
+		return nil
+	}
 
 	// Run the infix function until the next token has a higher precedence.
 	for precedence < p.peekPrecedence() {
@@ -560,6 +1048,13 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		if infix == nil {
 			return leftExp
 		}
+		//'++'/'--' on a new line starts a prefix expression, e.g. 'x = 1' + newline + '++y'
+		if (p.peekTokenIs(token.TOKEN_INCREMENT) || p.peekTokenIs(token.TOKEN_DECREMENT)) && p.peekOnNewLine() {
+			return leftExp
+		}
+		if p.autoSemicolon && p.peekOnNewLine() {
+			return leftExp
+		}
 		p.nextToken()
 		leftExp = infix(leftExp)
 	}
@@ -569,11 +1064,28 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 func (p *Parser) parseAssignExpression(name ast.Expression) ast.Expression {
 	if name.String() == "self" {
-		msg := fmt.Sprintf("Syntax Error:%v- 'self' can not be assigned", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "'self' can not be assigned")
+		return nil
+	}
+	switch n := name.(type) {
+	case *ast.Identifier, *ast.IndexExpression: //x = 1, arr[i] = 1, arr[1..3] = [x, y, z]
+	case *ast.MethodCallExpression: //obj.field = 1, but not obj.method() = 1 or obj?.field = 1
+		if _, ok := n.Call.(*ast.CallExpression); ok || n.Optional {
+			p.errorf(name.Pos(), "invalid assignment target '%s'", name.String())
+			return nil
+		}
+	default:
+		p.errorf(name.Pos(), "invalid assignment target '%s'", name.String())
 		return nil
 	}
+	if p.curTokenIs(token.TOKEN_OR_A) || p.curTokenIs(token.TOKEN_NILCOALESCE_A) {
+		switch name.(type) {
+		case *ast.Identifier, *ast.IndexExpression: //x ??= 1, m[k] ??= 1
+		default:
+			p.errorf(p.curToken.Pos, "invalid left hand side '%s' of '%s'", name.String(), p.curToken.Literal)
+			return nil
+		}
+	}
 	a := &ast.AssignExpression{Token: p.curToken, Name: name}
 
 	p.nextToken()
@@ -582,6 +1094,22 @@ func (p *Parser) parseAssignExpression(name ast.Expression) ast.Expression {
 	return a
 }
 
+//x := 5, declares 'x' in the current scope like 'let', but it is an expression,
+//so it could be used in conditions: if (m := str.match(re)) != nil { ... }
+func (p *Parser) parseDeclareAssignExpression(name ast.Expression) ast.Expression {
+	ident, ok := name.(*ast.Identifier)
+	if !ok || ident.Value == "self" || ident.Value == "_" {
+		p.errorf(name.Pos(), "invalid declaration target '%s' of ':='", name.String())
+		return nil
+	}
+	d := &ast.DeclareAssignExpression{Token: p.curToken, Name: ident}
+
+	p.nextToken()
+	d.Value = p.parseExpression(LOWEST)
+
+	return d
+}
+
 // EXPRESSION => EXPRESSION
 //(x, y) => x + y + 5      left expression is *TupleLiteral
 //(x) => x + 5             left expression is *Identifier
@@ -597,6 +1125,10 @@ func (p *Parser) parseFatArrow(left ast.Expression) ast.Expression {
 	tok := token.Token{Pos: pos, Type: token.TOKEN_FUNCTION, Literal: "fn"}
 
 	fn := &ast.FunctionLiteral{Token: tok}
+	if p.arrowReturnType != nil && p.arrowParams == left { //not the type of other parameters, e.g. 'a + (x): T => x'
+		fn.ReturnType = p.arrowReturnType
+	}
+	p.arrowReturnType, p.arrowParams = nil, nil
 	switch exprType := left.(type) {
 	case nil:
 		//no argument.
@@ -610,18 +1142,15 @@ func (p *Parser) parseFatArrow(left ast.Expression) ast.Expression {
 			case *ast.Identifier:
 				fn.Parameters = append(fn.Parameters, param)
 			default:
-				msg := fmt.Sprintf("Syntax Error:%v- Arrow function expects a list of identifiers as arguments", param.Pos())
-				p.errors = append(p.errors, msg)
-				p.errorLines = append(p.errorLines, param.Pos().Sline())
+				p.errorf(param.Pos(), "Arrow function expects a list of identifiers as arguments")
 				return nil
 			}
 		}
 	default:
-		msg := fmt.Sprintf("Syntax Error:%v- Arrow function expects identifiers as arguments", exprType.Pos())
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, exprType.Pos().Sline())
+		p.errorf(exprType.Pos(), "Arrow function expects identifiers as arguments")
 		return nil
 	}
+	p.checkDuplicateParameters(fn.Parameters)
 
 	p.nextToken()
 	if p.curTokenIs(token.TOKEN_LBRACE) { //if it's block, we use parseBlockStatement
@@ -631,7 +1160,9 @@ func (p *Parser) parseFatArrow(left ast.Expression) ast.Expression {
 		    (x) => return x  //error: no prefix parse functions for 'RETURN' found
 		so we need to use parseStatement() here
 		*/
+		tok := p.curToken
 		fn.Body = &ast.BlockStatement{
+			Token: tok,
 			Statements: []ast.Statement{
 				p.parseStatement(),
 			},
@@ -658,7 +1189,8 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 
 	// if the token is '**', we process it specially. e.g. 3 ** 2 ** 3 = 3 ** (2 ** 3)
 	// i.e. Exponent operator '**'' has right-to-left associativity
-	if p.curTokenIs(token.TOKEN_POWER) {
+	// Nil-coalescing operator '??' is also right-associative. e.g. a ?? b ?? c = a ?? (b ?? c)
+	if p.curTokenIs(token.TOKEN_POWER) || p.curTokenIs(token.TOKEN_NILCOALESCE) {
 		precedence--
 	}
 
@@ -675,30 +1207,84 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	if p.isCompareOperator() {
-		msg := fmt.Sprintf("Syntax Error:%v- too much comare operator", p.peekToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.peekToken.Pos.Sline())
+		p.errorf(p.peekToken.Pos, "too much comare operator")
 		return nil
 	}
 
 	return expression
 }
 
-func (p *Parser) isCompareOperator() bool {
-	return p.peekTokenIs(token.TOKEN_LT) || p.peekTokenIs(token.TOKEN_LE) ||
-		p.peekTokenIs(token.TOKEN_GT) || p.peekTokenIs(token.TOKEN_GE) ||
-		p.peekTokenIs(token.TOKEN_EQ) || p.peekTokenIs(token.TOKEN_NEQ)
+//start..end   (end is included)
+//start..=end  (the same as '..', end is included)
+//start..<end  (end is excluded)
+func (p *Parser) parseRangeExpression(start ast.Expression) ast.Expression {
+	expression := &ast.RangeExpression{
+		Token:     p.curToken,
+		StartIdx:  start,
+		Inclusive: !p.curTokenIs(token.TOKEN_DOTDOTLT),
+	}
+	precedence := p.curPrecedence()
+
+	p.nextToken()
+	expression.EndIdx = p.parseExpression(precedence)
+
+	return expression
 }
 
-func (p *Parser) parseGroupedExpression() ast.Expression {
-	savedToken := p.curToken
-	p.savedToken = p.curToken
+//The pipe operator is desugared into function calls, the left
+//expression becomes the first argument of the right function:
+//  x |> f |> g     =>  g(f(x))
+//  x |> f(y)       =>  f(x, y)
+//  x |> obj.f      =>  obj.f(x)
+//  x |> obj.f(y)   =>  obj.f(x, y)
+func (p *Parser) parsePipeExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+	precedence := p.curPrecedence()
+
 	p.nextToken()
+	right := p.parseExpression(precedence)
+	if right == nil {
+		return nil
+	}
 
-	// NOTE: if previous token is token.TOKEN_LPAREN, and the current
-	//       token is token.TOKEN_RPAREN, that is an empty parentheses,
+	switch r := right.(type) {
+	case *ast.Identifier:
+		return &ast.CallExpression{Token: tok, Function: r, Arguments: []ast.Expression{left}}
+	case *ast.CallExpression:
+		r.Arguments = append([]ast.Expression{left}, r.Arguments...)
+		return r
+	case *ast.MethodCallExpression:
+		switch call := r.Call.(type) {
+		case *ast.Identifier:
+			r.Call = &ast.CallExpression{Token: tok, Function: call, Arguments: []ast.Expression{left}}
+			return r
+		case *ast.CallExpression:
+			call.Arguments = append([]ast.Expression{left}, call.Arguments...)
+			return r
+		}
+	}
+
+	p.errorf(right.Pos(), "pipe operator's right hand side must be a function or a function call, got '%s'", right.String())
+	return nil
+}
+
+func (p *Parser) isCompareOperator() bool {
+	return p.peekTokenIs(token.TOKEN_LT) || p.peekTokenIs(token.TOKEN_LE) ||
+		p.peekTokenIs(token.TOKEN_GT) || p.peekTokenIs(token.TOKEN_GE) ||
+		p.peekTokenIs(token.TOKEN_EQ) || p.peekTokenIs(token.TOKEN_NEQ)
+}
+
+func (p *Parser) parseGroupedExpression() ast.Expression {
+	defer p.enterBrackets(false)()
+	savedToken := p.curToken
+	p.savedToken = p.curToken
+	p.nextToken()
+
+	// NOTE: if previous token is token.TOKEN_LPAREN, and the current
+	//       token is token.TOKEN_RPAREN, that is an empty parentheses,
 	//       we need to return earlier.
 	if savedToken.Type == token.TOKEN_LPAREN && p.curTokenIs(token.TOKEN_RPAREN) {
+		p.parseArrowReturnType(nil)              //e.g. '(): Number => 5'
 		if p.peekTokenIs(token.TOKEN_FATARROW) { //e.g. '() => 5': this is a short function
 			p.nextToken() //skip current token
 			ret := p.parseFatArrow(nil)
@@ -706,7 +1292,7 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 		}
 
 		//empty tuple, e.g. 'x = ()'
-		return &ast.TupleLiteral{Token: savedToken, Members: []ast.Expression{}}
+		return &ast.TupleLiteral{Token: savedToken, Members: []ast.Expression{}, RParenToken: p.curToken}
 	}
 
 	exp := p.parseExpression(LOWEST)
@@ -714,91 +1300,501 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	if p.peekTokenIs(token.TOKEN_COMMA) {
 		p.nextToken()
 		ret := p.parseTupleExpression(savedToken, exp)
+		p.parseArrowReturnType(ret) //e.g. '(x, y): Number => x + y'
 		return ret
 	}
 
-	if !p.expectPeek(token.TOKEN_RPAREN) {
+	if !p.peekTokenIs(token.TOKEN_RPAREN) {
+		//report the opening '(', the current token is usually far away from it
+		p.errorf(savedToken.Pos, "unclosed parenthesis opened at line %d, column %d, got %s instead", savedToken.Pos.Line, savedToken.Pos.Col, p.peekToken.Type)
 		return nil
 	}
+	p.nextToken()
+	p.parseArrowReturnType(exp) //e.g. '(x): Number => x * 2'
 
 	return exp
 }
 
+//parses the ': Type' between the parameters and the '=>' of an arrow function, the current token
+//is the ')' of the parameters. It looks ahead for the '=>', so '(a, b): x' in a hash literal or
+//a ternary expression is not taken as a return type. The type is kept with 'params', so a type
+//whose '=>' is never reached does not leak into the next arrow function.
+func (p *Parser) parseArrowReturnType(params ast.Expression) {
+	p.arrowReturnType, p.arrowParams = nil, nil
+	if !p.peekTokenIs(token.TOKEN_COLON) {
+		return
+	}
+	l := *p.l //the lexer has no other state, so a copy of it could look ahead
+	typeTok, arrowTok := l.NextToken(), l.NextToken()
+	if typeTok.Type != token.TOKEN_IDENTIFIER || arrowTok.Type != token.TOKEN_FATARROW {
+		return
+	}
+
+	p.nextToken() //skip ')'
+	p.nextToken() //skip ':'
+	p.arrowReturnType = p.parseIdentifier().(*ast.Identifier)
+	p.arrowParams = params
+}
+
 func (p *Parser) parsePrefixIllegalExpression() ast.Expression {
-	msg := fmt.Sprintf("Syntax Error:%v - Illegal token found. Literal: '%s'", p.curToken.Pos, p.curToken.Literal)
-	p.errors = append(p.errors, msg)
-	p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+	p.errorf(p.curToken.Pos, "Illegal token found. Literal: '%s'", p.curToken.Literal)
 	return nil
 }
 
 func (p *Parser) parseInfixIllegalExpression() ast.Expression {
-	msg := fmt.Sprintf("Syntax Error:%v - Illegal token found. Literal: '%s'", p.curToken.Pos, p.curToken.Literal)
-	p.errors = append(p.errors, msg)
-	p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+	p.errorf(p.curToken.Pos, "Illegal token found. Literal: '%s'", p.curToken.Literal)
 	return nil
 }
 
 func (p *Parser) parseNumber() ast.Expression {
 	lit := &ast.NumberLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	literal := p.curToken.Literal
+	base := numberBase(literal)
+	if base != 10 {
+		literal = literal[2:] //remove the prefix
+	}
+
+	//digit separators, e.g. 1_000_000, 0xDE_AD_BE_EF
+	if strings.Contains(literal, "_") {
+		if !validDigitSeparators(literal, base) {
+			p.errorf(p.curToken.Pos, "invalid digit separator '_' in %q", p.curToken.Literal)
+			return nil
+		}
+		literal = strings.Replace(literal, "_", "", -1)
+	}
+
+	if base == 16 && strings.ContainsAny(literal, ".pP") { //hex float, e.g. 0x1.8p3
+		return p.parseHexFloat(lit, literal)
+	}
+
+	if base != 10 {
+		value, err := strconv.ParseInt(literal, base, 64)
+		if err != nil {
+			p.errorf(p.curToken.Pos, "could not parse %q as base %d integer", p.curToken.Literal, base)
+			return nil
+		}
+		lit.Value = float64(value)
+		lit.IsInt, lit.Int = true, value
+		if value > maxExactInteger {
+			p.warnPrecisionLoss(lit)
+		}
+		return lit
+	}
+
+	if strings.ContainsAny(literal[len(literal)-1:], "eE+-") { //e.g. '1e', '1e+'
+		p.errorf(p.curToken.Pos, "malformed number %q, missing exponent digits", p.curToken.Literal)
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(literal, 64)
 	if err != nil {
-		msg := fmt.Sprintf("Syntax Error:%v - could not parse %q as float", p.curToken.Pos, p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 	lit.Value = value
+	if !strings.ContainsAny(literal, ".eE") { //an integer literal
+		if n, err := strconv.ParseInt(literal, 10, 64); err == nil {
+			lit.IsInt, lit.Int = true, n
+		}
+		if n, err := strconv.ParseUint(literal, 10, 64); err != nil || n > maxExactInteger {
+			p.warnPrecisionLoss(lit)
+		}
+	}
 	return lit
 }
 
+//all numbers are float64, which could represent the integers in [-2^53, 2^53] exactly.
+const maxExactInteger = 1 << 53
+
+//the literal is still parsed, but the value is the nearest float64, e.g. 9007199254740993 => 9007199254740992
+func (p *Parser) warnPrecisionLoss(lit *ast.NumberLiteral) {
+	p.warnf(lit.Token.Pos, "integer literal %s exceeds the exact integer range of float64(2^53), it is stored as %s",
+		lit.Token.Literal, strconv.FormatFloat(lit.Value, 'f', -1, 64))
+}
+
+//0x1.8p3, the exponent('p') is required.
+//'digits' is the literal without the '0x' prefix and the digit separators.
+func (p *Parser) parseHexFloat(lit *ast.NumberLiteral, digits string) ast.Expression {
+	if !strings.ContainsAny(digits, "pP") {
+		p.errorf(p.curToken.Pos, "hexadecimal float %q requires a 'p' exponent", p.curToken.Literal)
+		return nil
+	}
+
+	value, err := strconv.ParseFloat("0x"+digits, 64)
+	if err != nil {
+		p.errorf(p.curToken.Pos, "could not parse %q as hexadecimal float", p.curToken.Literal)
+		return nil
+	}
+	lit.Value = value
+	return lit
+}
+
+//reports whether every '_' in the digits is placed between two digits,
+//so '_1', '1_', '1__0', '1_.5' and '1_e5' are all invalid.
+func validDigitSeparators(digits string, base int) bool {
+	nonDigits := "_."
+	switch base {
+	case 10:
+		nonDigits = "_.eE+-"
+	case 16:
+		nonDigits = "_.pP+-"
+	}
+
+	for i := 0; i < len(digits); i++ {
+		if digits[i] != '_' {
+			continue
+		}
+		if i == 0 || i == len(digits)-1 {
+			return false
+		}
+		if strings.IndexByte(nonDigits, digits[i-1]) != -1 || strings.IndexByte(nonDigits, digits[i+1]) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+//returns the base of a number literal according to its prefix: 0x(16), 0o(8), 0b(2)
+func numberBase(literal string) int {
+	if len(literal) < 2 || literal[0] != '0' {
+		return 10
+	}
+	switch literal[1] {
+	case 'x', 'X':
+		return 16
+	case 'o', 'O':
+		return 8
+	case 'b', 'B':
+		return 2
+	}
+	return 10
+}
+
+func (p *Parser) parseRawStringLiteral() ast.Expression {
+	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal, Raw: true}
+}
+
+func (p *Parser) parseCharLiteral() ast.Expression {
+	value, _ := utf8.DecodeRuneInString(p.curToken.Literal) //the lexer already decoded the escapes
+	return &ast.CharLiteral{Token: p.curToken, Value: value}
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+//'match' is not a keyword, so it could still be used as a name, e.g. 'match = re.match(s)'.
+//It starts a match expression only when followed by an operand on the same line, e.g. 'match v {'.
+func (p *Parser) parseIdentifierOrMatch() ast.Expression {
+	if p.curToken.Literal == "match" && p.matchFollows() {
+		return p.parseMatchExpression()
+	}
+	return p.parseIdentifier()
+}
+
+//matchFollows reports whether the 'match' of the current token starts a match expression, that is
+//it is followed by the scrutinee, a '{' and a type pattern or a pattern with its '=>', e.g.
+//'match (x) { is number', 'match -1 { _ =>'. Otherwise 'match' is an identifier, e.g. 'match(x)',
+//'match - 1'.
+func (p *Parser) matchFollows() bool {
+	if p.peekToken.Pos.Line != p.curToken.Pos.Line || p.peekTokenIs(token.TOKEN_LBRACE) || p.prefixParseFns[p.peekToken.Type] == nil {
+		return false
+	}
+
+	l := *p.l //see parseArrowReturnType()
+	next := func() token.Token {
+		tok := l.NextToken()
+		for tok.Type == token.TOKEN_PRAGMA {
+			tok = l.NextToken()
+		}
+		return tok
+	}
+
+	//the scrutinee ends at the first '{' outside of the brackets, the first pattern at the '=>'
+	depth := 0
+	tok := p.peekToken
+	for depth > 0 || tok.Type != token.TOKEN_LBRACE {
+		switch tok.Type {
+		case token.TOKEN_LPAREN, token.TOKEN_LBRACKET, token.TOKEN_LBRACE:
+			depth++
+		case token.TOKEN_RPAREN, token.TOKEN_RBRACKET, token.TOKEN_RBRACE:
+			if depth--; depth < 0 {
+				return false
+			}
+		case token.TOKEN_SEMICOLON, token.TOKEN_EOF:
+			return false
+		}
+		tok = next()
+	}
+	switch tok = next(); {
+	case tok.Type == token.TOKEN_RBRACE: //no arms, e.g. 'match v {}'
+		return true
+	case tok.Type == token.TOKEN_IDENTIFIER && tok.Literal == "is": //a type pattern, see parseMatchPattern()
+		return next().Type == token.TOKEN_IDENTIFIER
+	}
+	for ; ; tok = next() {
+		switch tok.Type {
+		case token.TOKEN_FATARROW:
+			if depth == 0 {
+				return true
+			}
+		case token.TOKEN_LPAREN, token.TOKEN_LBRACKET, token.TOKEN_LBRACE:
+			depth++
+		case token.TOKEN_RPAREN, token.TOKEN_RBRACKET, token.TOKEN_RBRACE:
+			if depth--; depth < 0 {
+				return false
+			}
+		case token.TOKEN_COMMA, token.TOKEN_SEMICOLON:
+			if depth == 0 {
+				return false
+			}
+		case token.TOKEN_EOF:
+			return false
+		}
+	}
+}
+
 func (p *Parser) parseBooleanLiteral() ast.Expression {
 	return &ast.BooleanLiteral{Token: p.curToken, Value: p.curTokenIs(token.TOKEN_TRUE)}
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
-	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	tok := p.curToken
+	is := &ast.InterpolatedStringLiteral{Token: tok}
+
+	//the segments are split on the raw literal, so an escaped '$' never starts an interpolation,
+	//e.g. "\${x}", "\x24{x}", then each text segment is decoded on its own.
+	raw := []rune(tok.Literal)
+	posAt := func(i int) token.Position { //the position of raw[i], skip the opening quote
+		return token.Position{Filename: tok.Pos.Filename, Offset: tok.Pos.Offset + 1 + i, Line: tok.Pos.Line, Col: tok.Pos.Col + 1 + i}
+	}
+
+	start := 0 //the start of the current text segment
+	addSegment := func(end int) bool {
+		if start == end {
+			return true
+		}
+		seg := string(raw[start:end])
+		value, dollars, ok := p.unquoteString(seg, posAt(start))
+		if !ok {
+			return false
+		}
+		is.Parts = append(is.Parts, &ast.StringLiteral{Token: token.Token{Pos: posAt(start), Type: token.TOKEN_STRING, Literal: seg}, Value: value, EscapedDollars: dollars, Segment: true})
+		return true
+	}
+
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '\\' { //skip the escaped character
+			i++
+			continue
+		}
+		if raw[i] != '$' || i+1 >= len(raw) || raw[i+1] != '{' {
+			continue
+		}
+
+		end := interpolationEnd(raw, i+2)
+		if end == -1 { //unterminated '${', e.g. "my ${var", treat it as normal text
+			break
+		}
+
+		if !addSegment(i) {
+			return nil
+		}
+		src, _, ok := p.unquoteString(string(raw[i+2:end]), posAt(i+2)) //e.g. "${h[\"a\"]}"
+		if !ok {
+			return nil
+		}
+		expr := p.parseInterpolation(posAt(i), src, posAt(i+2))
+		if expr == nil {
+			return nil
+		}
+		is.Parts = append(is.Parts, expr)
+		i = end
+		start = end + 1
+	}
+
+	if len(is.Parts) == 0 { //no interpolation
+		value, dollars, ok := p.unquoteString(tok.Literal, posAt(0))
+		if !ok {
+			return nil
+		}
+		return &ast.StringLiteral{Token: tok, Value: value, EscapedDollars: dollars}
+	}
+	if !addSegment(len(raw)) {
+		return nil
+	}
+	return is
+}
+
+//unquoteString decodes the escape sequences of a raw string, which the lexer keeps as is, e.g.
+//'\n', '\"', '\0', '\xFF', '\u00e9'. pos is the position of the first character, used by the errors.
+//It also returns the byte offsets of the '$' written as an escape('\$' or '\x24') in the decoded
+//string, see ast.StringLiteral.EscapedDollars.
+func (p *Parser) unquoteString(s string, pos token.Position) (string, []int, bool) {
+	var ret []rune
+	var dollars []int
+	length := 0 //the length of 'ret' in bytes
+	col := pos.Col
+	for len(s) > 0 {
+		var value rune
+		var tail string
+		var err error
+		switch {
+		case strings.HasPrefix(s, "\\$"):
+			value, tail = '$', s[2:]
+		case strings.HasPrefix(s, "\\'"): //strconv only accepts it in a character literal
+			value, tail = '\'', s[2:]
+		case strings.HasPrefix(s, "\\0") && (len(s) == 2 || s[2] < '0' || s[2] > '7'): //strconv wants three octal digits
+			value, tail = 0, s[2:]
+		default:
+			value, _, tail, err = strconv.UnquoteChar(s, '"')
+		}
+		if err != nil {
+			_, size := utf8.DecodeRuneInString(s[1:]) //the backslash and the escaped character, e.g. '\q'
+			p.errorf(token.Position{Filename: pos.Filename, Line: pos.Line, Col: col}, "invalid escape sequence '%s' in string", s[:1+size])
+			return "", nil, false
+		}
+		if value == '$' && s[0] == '\\' {
+			dollars = append(dollars, length)
+		}
+		ret = append(ret, value)
+		length += utf8.RuneLen(value)
+		col += utf8.RuneCountInString(s) - utf8.RuneCountInString(tail)
+		s = tail
+	}
+	return string(ret), dollars, true
+}
+
+//returns the index of the '}' which closes the interpolation starting at 'start', -1 if not found.
+//The lexer ends a string at the first unescaped '"', so a string inside '${...}' must escape its
+//quotes, e.g. "${h[\"a\"]}", and its braces are counted as well.
+func interpolationEnd(str []rune, start int) int {
+	depth := 1
+	for i := start; i < len(str); i++ {
+		switch str[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+//parse the expression inside '${...}' using a sub-parser. 'pos' is the position of the '${', and
+//'srcPos' is the position of 'src', so the errors and the nodes point into the string.
+func (p *Parser) parseInterpolation(pos token.Position, src string, srcPos token.Position) ast.Expression {
+	if strings.TrimSpace(src) == "" {
+		p.errorf(pos, "empty interpolation '${}' in string")
+		return nil
+	}
+
+	ps := NewParser(lexer.NewLexerAt(src, srcPos), func(ps *Parser) { ps.stats = p.stats }) //the lexing is timed as a part of this parse
+	defer func() { p.tokens += ps.tokens }()
+	ps.precedences = p.precedences
+	expr := ps.parseExpression(LOWEST)
+	if len(ps.errors) == 0 && ps.curTokenIs(token.TOKEN_EOF) { //e.g. "${1 +}"
+		return p.interpolationError(pos, src, "unexpected end of expression")
+	}
+	if len(ps.errors) == 0 && !ps.peekTokenIs(token.TOKEN_EOF) {
+		return p.interpolationError(pos, src, fmt.Sprintf("unexpected token '%s'", ps.peekToken.Literal))
+	}
+	if len(ps.errors) > 0 {
+		return p.interpolationError(pos, src, strings.Join(ps.errors, "; "))
+	}
+	return expr
+}
+
+func (p *Parser) interpolationError(pos token.Position, src string, reason string) ast.Expression {
+	p.errorf(pos, "invalid interpolation '${%s}' in string: %s", src, reason)
+	return nil
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
+	defer p.enterBrackets(false)()
 	array := &ast.ArrayLiteral{Token: p.curToken}
-	array.Members, _ = p.parseExpressionList(token.TOKEN_RBRACKET)
+	if p.peekTokenIs(token.TOKEN_RBRACKET) {
+		p.nextToken()
+		array.Members = []ast.Expression{}
+		array.RBracketToken = p.curToken
+		return array
+	}
+
+	p.nextToken()
+	first := p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.TOKEN_FOR) { //[x * 2 for x in arr]
+		return p.parseArrayComprehension(array.Token, first)
+	}
+
+	members, gotEllipsis := p.parseExpressionListFrom(array.Token, first, token.TOKEN_RBRACKET)
+	if gotEllipsis { //e.g. [args...]
+		p.errorf(p.curToken.Pos, "'...' is not allowed in array literal")
+		return nil
+	}
+	array.Members = members
+	array.RBracketToken = p.curToken
 	return array
 }
 
-func (p *Parser) parseExpressionList(end token.TokenType) ([]ast.Expression, bool) {
-	gotEllipsis := false
-	success := false
+//[expr for x in xs], [x * y for x in 1..3 for y in 1..3]
+//The 'for' clauses are nested from left to right, so the last one varies the fastest.
+func (p *Parser) parseArrayComprehension(tok token.Token, expr ast.Expression) ast.Expression {
+	comp := &ast.ArrayComprehension{Token: tok, Expr: expr}
+	for p.peekTokenIs(token.TOKEN_FOR) {
+		p.nextToken()
+		clause := &ast.ComprehensionClause{Token: p.curToken}
+		if !p.expectPeek(token.TOKEN_IDENTIFIER) {
+			return nil
+		}
+		clause.Var = p.curToken.Literal
+		if !p.expectPeek(token.TOKEN_IN) {
+			return nil
+		}
 
-	list := []ast.Expression{}
-	if p.peekTokenIs(end) {
 		p.nextToken()
-		return list, false
+		clause.Value = p.parseExpression(LOWEST)
+		if clause.Value == nil {
+			return nil
+		}
+		comp.Clauses = append(comp.Clauses, clause)
 	}
 
-	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
-	gotEllipsis, success = p.checkEllipsis() //e.g. call(args...)
+	if !p.expectPeekInside(tok, token.TOKEN_RBRACKET) {
+		return nil
+	}
+	comp.RBracketToken = p.curToken
+
+	return comp
+}
+
+//parses the rest of an expression list whose first expression is already parsed,
+//'open' is the token which starts the list, e.g. '['
+func (p *Parser) parseExpressionListFrom(open token.Token, first ast.Expression, end token.TokenType) ([]ast.Expression, bool) {
+	list := []ast.Expression{first}
+	gotEllipsis, success := p.checkEllipsis(end, "argument") //e.g. call(args...)
 	if !success {
 		return nil, false
 	}
 
 	for p.peekTokenIs(token.TOKEN_COMMA) {
 		p.nextToken()
+		if p.peekTokenIs(end) { //trailing comma, e.g. [1, 2,], f(x, y,)
+			break
+		}
 		p.nextToken()
 		list = append(list, p.parseExpression(LOWEST))
 
-		gotEllipsis, success = p.checkEllipsis()
+		gotEllipsis, success = p.checkEllipsis(end, "argument")
 		if !success {
 			return nil, false
 		}
 	}
 
-	if !p.expectPeek(end) {
+	if !p.expectPeekInside(open, end) {
 		return nil, false
 	}
 
@@ -808,15 +1804,13 @@ func (p *Parser) parseExpressionList(end token.TokenType) ([]ast.Expression, boo
 /* first 'bool' means if we got Ellipsis or not
    second 'bool' means success or failure
 */
-func (p *Parser) checkEllipsis() (bool, bool) {
+func (p *Parser) checkEllipsis(end token.TokenType, what string) (bool, bool) {
 	gotEllipsis := false
 	if p.peekTokenIs(token.TOKEN_ELLIPSIS) {
 		gotEllipsis = true
 		p.nextToken()
-		if !p.peekTokenIs(token.TOKEN_RPAREN) {
-			msg := fmt.Sprintf("Syntax Error:%v- can only have '...' after last parameter", p.curToken.Pos)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		if !p.peekTokenIs(end) {
+			p.errorf(p.curToken.Pos, "can only have '...' after last %s", what)
 			return false, false
 		}
 	}
@@ -824,31 +1818,79 @@ func (p *Parser) checkEllipsis() (bool, bool) {
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
-	hash := &ast.HashLiteral{Token: p.curToken, Order: []ast.Expression{}}
+	defer p.enterBrackets(false)()
+	hash := &ast.HashLiteral{Token: p.curToken, Order: []ast.Expression{}, IsOrdered: true}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
+	seen := make(map[string]ast.Expression) //literal keys, for detecting duplicate keys
 	for !p.peekTokenIs(token.TOKEN_RBRACE) {
 		p.nextToken()
+		p.colons++
 		key := p.parseExpression(LOWEST)
-		if !p.expectPeek(token.TOKEN_COLON) {
+		p.colons--
+		p.checkHashKey(key)
+		if !p.expectPeekInside(hash.Token, token.TOKEN_COLON) {
 			return nil
 		}
 
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
+
+		//duplicate key, e.g. {"a": 1, "a": 2}, keep the last value, but the first-seen order
+		if k := literalKey(key); k != "" {
+			if first, ok := seen[k]; ok {
+				hash.Pairs[first] = value
+				if !p.peekTokenIs(token.TOKEN_RBRACE) && !p.expectPeekInside(hash.Token, token.TOKEN_COMMA) {
+					return nil
+				}
+				continue
+			}
+			seen[k] = key
+		}
+
 		hash.Pairs[key] = value
-		hash.Order = append(hash.Order, key)
-		if !p.peekTokenIs(token.TOKEN_RBRACE) && !p.expectPeek(token.TOKEN_COMMA) {
+		hash.Order = append(hash.Order, key) //always keep the declaration order
+		if !p.peekTokenIs(token.TOKEN_RBRACE) && !p.expectPeekInside(hash.Token, token.TOKEN_COMMA) {
 			return nil
 		}
 	}
 
-	if !p.expectPeek(token.TOKEN_RBRACE) {
+	if !p.expectPeekInside(hash.Token, token.TOKEN_RBRACE) {
 		return nil
 	}
+	hash.RBraceToken = p.curToken
 
 	return hash
 }
 
+//warns about a key which could never be hashed at runtime, e.g. {[1]: 2}, {fn() {}: 1}
+func (p *Parser) checkHashKey(key ast.Expression) {
+	var kind string
+	switch key.(type) {
+	case *ast.ArrayLiteral:
+		kind = "array"
+	case *ast.HashLiteral:
+		kind = "hash"
+	case *ast.FunctionLiteral:
+		kind = "function"
+	default:
+		return
+	}
+	p.warnf(key.Pos(), "%s literal '%s' cannot be used as a hash key", kind, key.String())
+}
+
+//returns a string which identifies a literal hash key, or "" if the key is not a literal.
+func literalKey(key ast.Expression) string {
+	switch k := key.(type) {
+	case *ast.StringLiteral:
+		return "s:" + k.Value
+	case *ast.NumberLiteral:
+		return "n:" + strconv.FormatFloat(k.Value, 'g', -1, 64)
+	case *ast.BooleanLiteral:
+		return "b:" + strconv.FormatBool(k.Value)
+	}
+	return ""
+}
+
 // parses a regular-expression
 func (p *Parser) parseRegexpLiteral() ast.Expression {
 	return &ast.RegExLiteral{Token: p.curToken, Value: p.curToken.Literal}
@@ -861,14 +1903,14 @@ func (p *Parser) parseTupleExpression(tok token.Token, expr ast.Expression) ast.
 	for {
 		switch p.curToken.Type {
 		case token.TOKEN_RPAREN:
-			ret := &ast.TupleLiteral{Token: tok, Members: members}
+			ret := &ast.TupleLiteral{Token: tok, Members: members, RParenToken: p.curToken}
 			return ret
 		case token.TOKEN_COMMA:
 			p.nextToken()
 			//For a 1-tuple: "(1,)", the trailing comma is necessary to distinguish it
 			//from the parenthesized expression (1).
 			if p.curTokenIs(token.TOKEN_RPAREN) { //e.g.  let x = (1,)
-				ret := &ast.TupleLiteral{Token: tok, Members: members}
+				ret := &ast.TupleLiteral{Token: tok, Members: members, RParenToken: p.curToken}
 				return ret
 			}
 			members = append(members, p.parseExpression(LOWEST))
@@ -876,14 +1918,14 @@ func (p *Parser) parseTupleExpression(tok token.Token, expr ast.Expression) ast.
 			p.nextToken()
 		default:
 			oldToken.Pos.Col = oldToken.Pos.Col + len(oldToken.Literal)
-			msg := fmt.Sprintf("Syntax Error:%v- expected token to be ',' or ')', got %s instead", oldToken.Pos, p.curToken.Type)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, oldToken.Pos.Sline())
+			p.errorf(oldToken.Pos, "expected token to be ',' or ')', got %s instead", p.curToken.Type)
 			return nil
 		}
 	}
 }
 
+//fn (x, y) { block }      anonymous function
+//fn add(x, y) { block }   named function, the evaluator binds it to 'add' in current scope
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
@@ -895,52 +1937,299 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.expectPeek(token.TOKEN_LPAREN) {
 		return nil
 	}
-	lit.Parameters, lit.Variadic = p.parseFunctionParameters()
+	lit.Parameters, lit.Defaults, lit.Variadic = p.parseFunctionParameters()
+	if lit.Parameters == nil { //error already reported
+		return nil
+	}
+	if p.peekTokenIs(token.TOKEN_COLON) { //fn add(x, y): Number { x + y }
+		p.nextToken()
+		if !p.expectPeek(token.TOKEN_IDENTIFIER) {
+			return nil
+		}
+		lit.ReturnType = p.parseIdentifier().(*ast.Identifier)
+	}
 	if !p.expectPeek(token.TOKEN_LBRACE) {
 		return nil
 	}
 	lit.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.TOKEN_WHERE) {
+		p.nextToken()
+		lit.Where = p.parseWhereBindings()
+		if lit.Where == nil { //error already reported
+			return nil
+		}
+	}
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, bool) {
+//where a = 1, b = a + 1
+//Each binding is parsed as a 'let' statement, later bindings could refer to earlier ones.
+func (p *Parser) parseWhereBindings() []*ast.LetStatement {
+	bindings := []*ast.LetStatement{}
+	for {
+		if !p.expectPeek(token.TOKEN_IDENTIFIER) {
+			return nil
+		}
+		tok := token.Token{Pos: p.curToken.Pos, Type: token.TOKEN_LET, Literal: "let"}
+		name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.TOKEN_ASSIGN) {
+			return nil
+		}
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
+		bindings = append(bindings, &ast.LetStatement{Token: tok, Names: []*ast.Identifier{name}, Values: []ast.Expression{value}})
+
+		if !p.peekTokenIs(token.TOKEN_COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	return bindings
+}
+
+//async fn xxx(args) { block }
+func (p *Parser) parseAsyncLiteral() ast.Expression {
+	if !p.expectPeek(token.TOKEN_FUNCTION) {
+		return nil
+	}
+
+	fn := p.parseFunctionLiteral()
+	if fn == nil {
+		return nil
+	}
+	fn.(*ast.FunctionLiteral).Async = true
+	return fn
+}
+
+//await <expression>
+func (p *Parser) parseAwaitExpression() ast.Expression {
+	expression := &ast.AwaitExpression{Token: p.curToken}
+	p.nextToken()
+	expression.Value = p.parseExpression(PREFIX)
+
+	return expression
+}
+
+//returns the parameters, their default values(nil if none of the parameters has one), and whether
+//the last parameter is variadic. e.g. fn(a, b = 10, c = a + 1), fn(format, args...)
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.Expression, bool) {
 	gotEllipsis := false
 	success := false
 
 	identifiers := []*ast.Identifier{}
+	defaults := []ast.Expression{}
+	hasDefault := false
 	if p.peekTokenIs(token.TOKEN_RPAREN) {
 		p.nextToken()
-		return identifiers, false
+		return identifiers, nil, false
 	}
-	p.nextToken()
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
-	gotEllipsis, success = p.checkEllipsis() //e.g. fn xxx(args...)
-	if !success {
-		return nil, false
+	for {
+		p.nextToken()
+		ident := p.parseFunctionParameter()
+		if ident == nil {
+			return nil, nil, false
+		}
+		identifiers = append(identifiers, ident)
+
+		var value ast.Expression
+		if p.peekTokenIs(token.TOKEN_ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			value = p.parseExpression(LOWEST)
+			hasDefault = true
+		} else if hasDefault && !p.peekTokenIs(token.TOKEN_ELLIPSIS) {
+			//the arguments are passed by position, so 'b' in 'fn(a = 1, b)' could never be omitted
+			p.errorf(ident.Pos(), "required parameter '%s' cannot follow a parameter with a default value", ident.Value)
+			return nil, nil, false
+		}
+		defaults = append(defaults, value)
+
+		gotEllipsis, success = p.checkEllipsis(token.TOKEN_RPAREN, "parameter") //e.g. fn xxx(args...)
+		if !success {
+			return nil, nil, false
+		}
+		if gotEllipsis && hasDefault {
+			p.errorf(ident.Pos(), "a variadic function cannot have default parameter values")
+			return nil, nil, false
+		}
+		if !p.peekTokenIs(token.TOKEN_COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 
-	for p.peekTokenIs(token.TOKEN_COMMA) {
+	if !p.expectPeek(token.TOKEN_RPAREN) {
+		return nil, nil, false
+	}
+	p.checkDuplicateParameters(identifiers)
+	if !hasDefault {
+		defaults = nil
+	}
+	return identifiers, defaults, gotEllipsis
+}
+
+//reports the repeated parameter names, e.g. 'fn(a, b, a)', at the position of the repeat.
+//'_' could be repeated, because it is not bound.
+func (p *Parser) checkDuplicateParameters(params []*ast.Identifier) {
+	seen := make(map[string]bool)
+	for _, param := range params {
+		if param.Value == "_" {
+			continue
+		}
+		if seen[param.Value] {
+			p.errorf(param.Pos(), "duplicate parameter '%s'", param.Value)
+			continue
+		}
+		seen[param.Value] = true
+	}
+}
+
+//reports the current token if it is a keyword where a name is expected, e.g. 'let for = 1', which
+//is clearer than the generic 'expected identifier' error. It returns true if the error is reported.
+func (p *Parser) reservedKeywordError() bool {
+	if t := token.LookupIdent(p.curToken.Literal); t == token.TOKEN_IDENTIFIER || t != p.curToken.Type {
+		return false //e.g. "for" is a string
+	}
+	p.errorf(p.curToken.Pos, "'%s' is a reserved keyword and cannot be used as a name", p.curToken.Literal)
+	return true
+}
+
+func (p *Parser) parseFunctionParameter() *ast.Identifier {
+	if p.reservedKeywordError() {
+		return nil
+	}
+	if !p.curTokenIs(token.TOKEN_IDENTIFIER) {
+		p.errorf(p.curToken.Pos, "expected function parameter to be an identifier, got %s instead", p.curToken.Type)
+		return nil
+	}
+	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+}
+
+func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	exp := &ast.CallExpression{Token: p.curToken, Function: function}
+	exp.Arguments, exp.Variadic = p.parseCallArguments()
+	if exp.Arguments == nil { //error already reported
+		return nil
+	}
+	exp.RParenToken = p.curToken
+
+	if p.trailingClosureFollows() { //f(x) { item -> process(item) }
 		p.nextToken()
+		closure := p.parseTrailingClosure()
+		if closure == nil {
+			return nil
+		}
+		exp.Arguments = append(exp.Arguments, closure)
+	}
+	return exp
+}
+
+//f(1, 2), f(1, y: 2), f(args...)
+//The named arguments must be after the positional ones, and could not be used with '...'.
+func (p *Parser) parseCallArguments() ([]ast.Expression, bool) {
+	defer p.enterBrackets(false)()
+	open := p.curToken
+	args := []ast.Expression{}
+	gotEllipsis, success := false, false
+	named := make(map[string]bool)
+	for !p.peekTokenIs(token.TOKEN_RPAREN) {
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
-		gotEllipsis, success = p.checkEllipsis()
+		if p.curTokenIs(token.TOKEN_IDENTIFIER) && p.peekTokenIs(token.TOKEN_COLON) { //name: value
+			name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if named[name.Value] {
+				p.errorf(name.Pos(), "duplicate named argument '%s'", name.Value)
+				return nil, false
+			}
+			named[name.Value] = true
+			p.nextToken()
+			p.nextToken()
+			args = append(args, &ast.NamedArgument{Token: name.Token, Name: name, Value: p.parseExpression(LOWEST)})
+		} else {
+			arg := p.parseExpression(LOWEST)
+			if arg == nil { //error already reported
+				return nil, false
+			}
+			if len(named) > 0 {
+				p.errorf(arg.Pos(), "positional argument cannot follow a named argument")
+				return nil, false
+			}
+			args = append(args, arg)
+		}
+
+		gotEllipsis, success = p.checkEllipsis(token.TOKEN_RPAREN, "argument") //e.g. call(args...)
 		if !success {
 			return nil, false
 		}
+		if gotEllipsis && len(named) > 0 {
+			p.errorf(p.curToken.Pos, "cannot use '...' with named arguments")
+			return nil, false
+		}
+		if !p.peekTokenIs(token.TOKEN_COMMA) {
+			break
+		}
+		p.nextToken() //trailing comma is allowed, e.g. f(x, y,)
+	}
+
+	if !p.expectPeekInside(open, token.TOKEN_RPAREN) {
+		return nil, false
+	}
+	return args, gotEllipsis
+}
+
+//a?.b, a?.b(args): the member is evaluated only if 'a' is not nil, or else the result is nil.
+//f?.(args): calls 'f' only if it is not nil, e.g. obj.callback?.(x)
+//Only the member right after '?.' is skipped, so 'a?.b.c' is '(a?.b).c', use 'a?.b?.c' if 'b' could be nil.
+func (p *Parser) parseOptionalChain(left ast.Expression) ast.Expression {
+	if !p.peekTokenIs(token.TOKEN_LPAREN) {
+		if !p.peekTokenIs(token.TOKEN_IDENTIFIER) {
+			p.peekError(token.TOKEN_IDENTIFIER)
+			return nil
+		}
+		exp := p.parseMethodCallExpression(left)
+		if methodCall, ok := exp.(*ast.MethodCallExpression); ok {
+			methodCall.Optional = true
+		}
+		return exp
 	}
 
-	if !p.expectPeek(token.TOKEN_RPAREN) {
-		return nil, false
+	p.nextToken()
+	exp := p.parseCallExpression(left)
+	if call, ok := exp.(*ast.CallExpression); ok {
+		call.Optional = true
 	}
-	return identifiers, gotEllipsis
+	return exp
 }
 
-func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	exp := &ast.CallExpression{Token: p.curToken, Function: function}
-	exp.Arguments, exp.Variadic = p.parseExpressionList(token.TOKEN_RPAREN)
-	return exp
+//trailingClosureFollows reports whether the next token is a '{' which starts a trailing closure,
+//it must be on the same line as the call.
+func (p *Parser) trailingClosureFollows() bool {
+	return p.noTrailingBlock == 0 && p.peekTokenIs(token.TOKEN_LBRACE) && p.peekToken.Pos.Line == p.curToken.Pos.Line
+}
+
+//the trailing closure of a call is the last argument of the call:
+//   arr.each { item -> process(item) }  ==> arr.each(fn(item) { process(item) })
+//   run() { println("hello") }          ==> run(fn() { println("hello") })
+func (p *Parser) parseTrailingClosure() *ast.FunctionLiteral {
+	tok := token.Token{Pos: p.curToken.Pos, Type: token.TOKEN_FUNCTION, Literal: "fn"}
+	fn := &ast.FunctionLiteral{Token: tok, Parameters: []*ast.Identifier{}}
+	fn.Body = &ast.BlockStatement{Token: p.curToken}
+
+	p.nextToken() //skip '{'
+
+	//named parameter, e.g. 'item ->'
+	if p.curTokenIs(token.TOKEN_IDENTIFIER) && p.peekTokenIs(token.TOKEN_THINARROW) {
+		fn.Parameters = append(fn.Parameters, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		p.nextToken() //skip parameter
+		p.nextToken() //skip '->'
+	}
+	p.parseBlockBody(fn.Body)
+
+	return fn
 }
 
 /*
@@ -966,15 +2255,82 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	exp.Optional = p.curTokenIs(token.TOKEN_OPTIONAL_LBRACKET) //a?[i]
 	p.nextToken()
+	if p.curTokenIs(token.TOKEN_COLON) || p.curTokenIs(token.TOKEN_SCOPE) { //a[:high], a[::step]
+		return p.parseSliceExpression(exp, nil)
+	}
+
+	restore := p.enterBrackets(true)
+	p.colons++ //the ':' of a slice
 	exp.Index = p.parseExpression(LOWEST)
+	restore()
+	if p.peekTokenIs(token.TOKEN_COLON) || p.peekTokenIs(token.TOKEN_SCOPE) { //a[low:high], a[low::step]
+		p.nextToken()
+		return p.parseSliceExpression(exp, exp.Index)
+	}
 	if !p.expectPeek(token.TOKEN_RBRACKET) {
 		return nil
 	}
+	exp.RBracketToken = p.curToken
+	p.checkConstantIndex(exp)
 
 	return exp
 }
 
+//warns about an obvious out of range index, when both the array literal and the index are constants,
+//e.g. [1, 2, 3][-1], [1, 2][5]
+func (p *Parser) checkConstantIndex(exp *ast.IndexExpression) {
+	arr, ok := exp.Left.(*ast.ArrayLiteral)
+	if !ok {
+		return
+	}
+
+	var idx float64
+	switch index := exp.Index.(type) {
+	case *ast.NumberLiteral:
+		idx = index.Value
+	case *ast.PrefixExpression: //-1
+		num, ok := index.Right.(*ast.NumberLiteral)
+		if !ok || index.Operator != "-" {
+			return
+		}
+		idx = -num.Value
+	default:
+		return
+	}
+
+	if idx < 0 || idx >= float64(len(arr.Members)) {
+		p.warnf(exp.Index.Pos(), "index %s out of range of the array literal with %d elements", exp.Index.String(), len(arr.Members))
+	}
+}
+
+//a[low:high:step], the current token is the first ':'
+func (p *Parser) parseSliceExpression(exp *ast.IndexExpression, low ast.Expression) ast.Expression {
+	slice := &ast.SliceExpression{Token: exp.Token, Left: exp.Left, Low: low, Optional: exp.Optional}
+	hasStep := p.curTokenIs(token.TOKEN_SCOPE) //'::' is lexed as one token, it omits the high, e.g. a[::2]
+	if !hasStep {
+		if !p.peekTokenIs(token.TOKEN_COLON) && !p.peekTokenIs(token.TOKEN_RBRACKET) {
+			p.nextToken()
+			slice.High = p.parseExpression(LOWEST)
+		}
+		if p.peekTokenIs(token.TOKEN_COLON) {
+			p.nextToken()
+			hasStep = true
+		}
+	}
+	if hasStep && !p.peekTokenIs(token.TOKEN_RBRACKET) {
+		p.nextToken()
+		slice.Step = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.TOKEN_RBRACKET) {
+		return nil
+	}
+	slice.RBracketToken = p.curToken
+
+	return slice
+}
+
 func (p *Parser) parseNilExpression() ast.Expression {
 	return &ast.NilLiteral{Token: p.curToken}
 }
@@ -990,18 +2346,21 @@ func (p *Parser) parseConditionalExpressions(ie *ast.IfExpression) []*ast.IfCond
 	// if part
 	ic := []*ast.IfConditionExpr{p.parseConditionalExpression()}
 
-	//else-if
-	for p.peekTokenIs(token.TOKEN_ELSE) {
+	//else-if, or elif
+	for p.peekTokenIs(token.TOKEN_ELSE) || p.peekTokenIs(token.TOKEN_ELIF) {
 		p.nextToken()
 
+		if p.curTokenIs(token.TOKEN_ELIF) { //'elif' is the same as 'else if'
+			ic = append(ic, p.parseConditionalExpression())
+			continue
+		}
+
 		if !p.peekTokenIs(token.TOKEN_IF) {
 			if p.peekTokenIs(token.TOKEN_LBRACE) { //block statement. e.g. 'else {'
 				p.nextToken()
 				ie.Alternative = p.parseBlockStatement()
 			} else {
-				msg := fmt.Sprintf("Syntax Error:%v- 'else' part must be followed by a '{'.", p.curToken.Pos)
-				p.errors = append(p.errors, msg)
-				p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+				p.errorf(p.curToken.Pos, "'else' part must be followed by a '{'.")
 				return nil
 			}
 			break
@@ -1018,12 +2377,12 @@ func (p *Parser) parseConditionalExpression() *ast.IfConditionExpr {
 	ic := &ast.IfConditionExpr{Token: p.curToken}
 	p.nextToken()
 
+	p.noTrailingBlock++
 	ic.Cond = p.parseExpressionStatement().Expression
+	p.noTrailingBlock--
 
 	if !p.peekTokenIs(token.TOKEN_LBRACE) {
-		msg := fmt.Sprintf("Syntax Error:%v- 'if' expression must be followed by a '{'.", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "'if' expression must be followed by a '{'.")
 		return nil
 	} else {
 		p.nextToken()
@@ -1033,12 +2392,24 @@ func (p *Parser) parseConditionalExpression() *ast.IfConditionExpr {
 	return ic
 }
 
+//obj.method(args), obj.field
+//The method call is returned to the infix loop of parseExpression, so a following '.' takes
+//it as the new object, e.g. a.b().c() is (a.b()).c(), and a.b.c is (a.b).c
 func (p *Parser) parseMethodCallExpression(obj ast.Expression) ast.Expression {
 	methodCall := &ast.MethodCallExpression{Token: p.curToken, Object: obj}
 	p.nextToken()
 
 	name := p.parseIdentifier()
-	if !p.peekTokenIs(token.TOKEN_LPAREN) {
+	if p.trailingClosureFollows() { //arr.each { item -> process(item) }
+		call := &ast.CallExpression{Token: p.peekToken, Function: name}
+		p.nextToken()
+		closure := p.parseTrailingClosure()
+		if closure == nil {
+			return nil
+		}
+		call.Arguments = []ast.Expression{closure}
+		methodCall.Call = call
+	} else if !p.peekTokenIs(token.TOKEN_LPAREN) {
 		//methodCall.Call = p.parseExpression(LOWEST)
 		//Note: here the precedence should not be `LOWEST`, or else when parsing below line:
 		//     logger.LDATE + 1 ==> logger.(LDATE + 1)
@@ -1051,10 +2422,158 @@ func (p *Parser) parseMethodCallExpression(obj ast.Expression) ast.Expression {
 	return methodCall
 }
 
+//module::name, Type::member, e.g. math::sqrt(2), Color::Red
+//Unlike '.', the member after '::' must be a name, so 'a::b::c' is (a::b)::c.
+func (p *Parser) parseScopeResolution(left ast.Expression) ast.Expression {
+	exp := &ast.ScopeResolution{Token: p.curToken, Left: left}
+	if !p.expectPeek(token.TOKEN_IDENTIFIER) {
+		return nil
+	}
+
+	name := p.parseIdentifier()
+	if !p.peekTokenIs(token.TOKEN_LPAREN) {
+		exp.Member = name
+		return exp
+	}
+
+	p.nextToken()
+	exp.Member = p.parseCallExpression(name)
+	if exp.Member == nil {
+		return nil
+	}
+	return exp
+}
+
+//cond ? a : b, or the Elvis form 'a ?: b', which is an infix expression with operator '?:'.
+//Both are right-associative: a ? b : c ?: d = a ? b : (c ?: d)
+func (p *Parser) parseTernaryExpression(cond ast.Expression) ast.Expression {
+	if p.peekTokenIs(token.TOKEN_COLON) { //a ?: b
+		tok := p.curToken
+		tok.Literal = "?:"
+		p.nextToken() //skip '?'
+		p.nextToken() //skip ':'
+		right := p.parseExpression(TERNARY - 1)
+		if right == nil {
+			return nil
+		}
+		return &ast.InfixExpression{Token: tok, Operator: tok.Literal, Left: cond, Right: right}
+	}
+
+	expr := &ast.TernaryExpression{Token: p.curToken, Condition: cond}
+	if p.curToken.Literal == "?[" { //c ?[1] : [2], the '[' starts an array literal, see ternaryFollows()
+		expr.Token.Literal = "?"
+		pos := p.curToken.Pos
+		pos.Offset++
+		pos.Col++
+		p.curToken = token.Token{Pos: pos, Type: token.TOKEN_LBRACKET, Literal: "["}
+	} else {
+		p.nextToken() //skip '?'
+	}
+	p.colons++
+	expr.IfTrue = p.parseExpression(LOWEST)
+	p.colons--
+	if expr.IfTrue == nil {
+		return nil
+	}
+	if !p.expectPeek(token.TOKEN_COLON) {
+		return nil
+	}
+	p.nextToken() //skip ':'
+	expr.IfFalse = p.parseExpression(TERNARY - 1)
+	if expr.IfFalse == nil {
+		return nil
+	}
+
+	return expr
+}
+
+//ternaryFollows reports whether the '?[' of the peek token is the '?' of a ternary followed by an
+//array literal, e.g. 'c ?[1] : [2]', instead of an optional index, e.g. 'a?[i]'. It is a ternary if
+//the ']' is followed by a ':' on the same line, which none of the enclosing ternaries, hash keys and
+//indexes are waiting for, e.g. the '?[' of 'a ? b?[0] : c', '{b?[0]: c}' and 'a[b?[0]:c]' is an
+//optional index.
+func (p *Parser) ternaryFollows() bool {
+	l := *p.l //see parseArrowReturnType()
+	next := func() token.Token {
+		tok := l.NextToken()
+		for tok.Type == token.TOKEN_PRAGMA {
+			tok = l.NextToken()
+		}
+		return tok
+	}
+
+	depth := 1     //inside the '?['
+	questions := 0 //the '?' after the ']' waiting for their ':'
+	colons := 0    //the ':' not matching any of the 'questions'
+	prev := p.peekToken
+	for tok := next(); ; prev, tok = tok, next() {
+		if depth == 0 && tok.Pos.Line != prev.Pos.Line {
+			return false
+		}
+		switch tok.Type {
+		case token.TOKEN_LPAREN, token.TOKEN_LBRACKET, token.TOKEN_OPTIONAL_LBRACKET, token.TOKEN_LBRACE:
+			depth++
+		case token.TOKEN_RPAREN, token.TOKEN_RBRACKET, token.TOKEN_RBRACE:
+			if depth--; depth < 0 {
+				return false
+			}
+		case token.TOKEN_QUESTIONM:
+			if depth == 0 {
+				questions++
+			}
+		case token.TOKEN_COLON:
+			if depth > 0 {
+				break
+			}
+			if questions > 0 {
+				questions--
+				break
+			}
+			if colons++; colons > p.colons {
+				return true
+			}
+		case token.TOKEN_COMMA, token.TOKEN_SEMICOLON:
+			if depth == 0 {
+				return false
+			}
+		case token.TOKEN_EOF:
+			return false
+		}
+	}
+}
+
+//x++, a[i]--
 func (p *Parser) parsePostfixExpression(left ast.Expression) ast.Expression {
 	return &ast.PostfixExpression{Token: p.curToken, Left: left, Operator: p.curToken.Literal}
 }
 
+//++x, --a[i]
+func (p *Parser) parseIncDecPrefixExpression() ast.Expression {
+	expression := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+	p.nextToken()
+	expression.Right = p.parseExpression(PREFIX)
+	if expression.Right == nil || !p.checkIncDecOperand(expression.Operator, expression.Right) {
+		return nil
+	}
+
+	return expression
+}
+
+//the operand of prefix '++' and '--' must be assignable: an identifier, an index expression or a struct field.
+func (p *Parser) checkIncDecOperand(operator string, operand ast.Expression) bool {
+	switch o := operand.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+		return true
+	case *ast.MethodCallExpression:
+		if _, ok := o.Call.(*ast.Identifier); ok {
+			return true
+		}
+	}
+
+	p.errorf(operand.Pos(), "invalid operand %s for '%s', expected identifier, index expression or field", operand.String(), operator)
+	return false
+}
+
 func (p *Parser) parseDoLoopExpression() ast.Expression {
 	p.loopDepth++
 	loop := &ast.DoLoop{Token: p.curToken}
@@ -1066,12 +2585,41 @@ func (p *Parser) parseDoLoopExpression() ast.Expression {
 	return loop
 }
 
+//'do' at statement position is a loop:
+//   do { block }
+//otherwise it's a 'do' expression(see parseDoExpression)
+func (p *Parser) parseDoLoopStatement() *ast.ExpressionStatement {
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseDoLoopExpression()
+
+	if p.peekTokenIs(token.TOKEN_SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+//'do' at expression position, the block is evaluated only once,
+//and its value is the block's last expression. e.g.
+//   let x = do { let a = compute(); a + 1 }
+func (p *Parser) parseDoExpression() ast.Expression {
+	expr := &ast.DoExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.TOKEN_LBRACE) {
+		return nil
+	}
+	expr.Block = p.parseBlockStatement()
+
+	return expr
+}
+
 func (p *Parser) parseWhileLoopExpression() ast.Expression {
 	p.loopDepth++
 	loop := &ast.WhileLoop{Token: p.curToken}
 
 	p.nextToken()
+	p.noTrailingBlock++
 	loop.Condition = p.parseExpressionStatement().Expression
+	p.noTrailingBlock--
 
 	if p.peekTokenIs(token.TOKEN_RPAREN) {
 		p.nextToken()
@@ -1081,9 +2629,7 @@ func (p *Parser) parseWhileLoopExpression() ast.Expression {
 		p.nextToken()
 		loop.Block = p.parseBlockStatement()
 	} else {
-		msg := fmt.Sprintf("Syntax Error:%v- for loop must be followed by a '{'", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "for loop must be followed by a '{'")
 		return nil
 	}
 
@@ -1118,9 +2664,7 @@ func (p *Parser) parseForLoopExpression() ast.Expression {
 			r = p.parseForEachArrayExpression(curToken, p.curToken.Literal)
 		}
 	} else {
-		msg := fmt.Sprintf("Syntax Error:%v- for loop must be followed by an underscore or identifier. got %s", p.curToken.Pos, p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "for loop must be followed by an underscore or identifier. got %s", p.curToken.Literal)
 		return nil
 	}
 
@@ -1165,9 +2709,7 @@ func (p *Parser) parseCForLoopExpression(curToken token.Token) ast.Expression {
 	}
 
 	if !p.peekTokenIs(token.TOKEN_LBRACE) {
-		msg := fmt.Sprintf("Syntax Error:%v- for loop must be followed by a '{'.", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "for loop must be followed by a '{'.")
 		return nil
 	}
 
@@ -1193,16 +2735,16 @@ func (p *Parser) parseForEachArrayExpression(curToken token.Token, variable stri
 	}
 	p.nextToken()
 
+	p.noTrailingBlock++
 	value := p.parseExpression(LOWEST)
+	p.noTrailingBlock--
 
 	var block *ast.BlockStatement
 	if p.peekTokenIs(token.TOKEN_LBRACE) {
 		p.nextToken()
 		block = p.parseBlockStatement()
 	} else {
-		msg := fmt.Sprintf("Syntax Error:%v- for loop must be followed by a '{' ", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "for loop must be followed by a '{' ")
 		return nil
 	}
 
@@ -1224,17 +2766,13 @@ func (p *Parser) parseForEachMapExpression(curToken token.Token, key string) ast
 	if p.curToken.Literal == "_" {
 		//do nothing
 	} else if !p.curTokenIs(token.TOKEN_IDENTIFIER) {
-		msg := fmt.Sprintf("Syntax Error:%v- for loop must be followed by an identifier. got %s", p.curToken.Pos, p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "for loop must be followed by an identifier. got %s", p.curToken.Literal)
 		return nil
 	}
 	loop.Value = p.curToken.Literal
 
 	if loop.Key == "_" && loop.Value == "_" { //for _, _ in xxx { block }
-		msg := fmt.Sprintf("Syntax Error:%v- foreach map's key & map are both '_'", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "foreach map's key & map are both '_'")
 		return nil
 	}
 
@@ -1243,15 +2781,15 @@ func (p *Parser) parseForEachMapExpression(curToken token.Token, key string) ast
 	}
 
 	p.nextToken()
+	p.noTrailingBlock++
 	loop.X = p.parseExpression(LOWEST)
+	p.noTrailingBlock--
 
 	if p.peekTokenIs(token.TOKEN_LBRACE) {
 		p.nextToken()
 		loop.Block = p.parseBlockStatement()
 	} else {
-		msg := fmt.Sprintf("Syntax Error:%v- for loop must be followed by a '{'.", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "for loop must be followed by a '{'.")
 		return nil
 	}
 
@@ -1270,9 +2808,7 @@ func (p *Parser) parseForEverLoopExpression(curToken token.Token) ast.Expression
 
 func (p *Parser) parseBreakExpression() ast.Expression {
 	if p.loopDepth == 0 {
-		msg := fmt.Sprintf("Syntax Error:%v- 'break' outside of loop context", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "'break' outside of loop context")
 
 		return nil
 	}
@@ -1283,9 +2819,7 @@ func (p *Parser) parseBreakExpression() ast.Expression {
 
 func (p *Parser) parseContinueExpression() ast.Expression {
 	if p.loopDepth == 0 {
-		msg := fmt.Sprintf("Syntax Error:%v- 'continue' outside of loop context", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "'continue' outside of loop context")
 
 		return nil
 	}
@@ -1305,18 +2839,103 @@ func (p *Parser) parseStructStatement() ast.Statement {
 		return nil
 	}
 
-	st.Block = p.parseBlockStatement()
+	//fields are saved in 'st.Fields', others(methods, let statements, etc) are saved in 'st.Block'
+	st.Block = &ast.BlockStatement{Token: p.curToken, Statements: []ast.Statement{}}
+	p.nextToken()
+	for !p.curTokenIs(token.TOKEN_RBRACE) {
+		if p.curTokenIs(token.TOKEN_EOF) {
+			p.errorf(p.curToken.Pos, "unterminated struct statement")
+			return nil
+		}
+
+		if p.isStructField() {
+			field := p.parseStructField()
+			if field == nil {
+				return nil
+			}
+			st.Fields = append(st.Fields, field)
+		} else if p.curTokenIs(token.TOKEN_STATIC) {
+			if fn := p.parseStaticMethod(); fn != nil {
+				st.Block.Statements = append(st.Block.Statements, fn)
+			} else {
+				p.skipStatement()
+			}
+		} else {
+			stmt := p.parseStatementWithRecovery()
+			if stmt != nil {
+				st.Block.Statements = append(st.Block.Statements, stmt)
+			}
+		}
+		p.nextToken()
+	}
+
+	st.Block.RBraceToken = p.curToken
 	st.RBraceToken = p.curToken
 
 	return st
 }
 
+//static fn name(args) { block }
+func (p *Parser) parseStaticMethod() ast.Statement {
+	if !p.expectPeek(token.TOKEN_FUNCTION) {
+		return nil
+	}
+	stmt := p.parseExpressionStatement()
+	fn, ok := stmt.Expression.(*ast.FunctionLiteral)
+	if !ok {
+		return nil //error already reported
+	}
+	if fn.Name == "" {
+		p.errorf(fn.Pos(), "static method must have a name")
+		return nil
+	}
+	fn.Static = true
+	return stmt
+}
+
+//field declaration inside struct, e.g.
+//  x = 0
+//  y;
+func (p *Parser) isStructField() bool {
+	if !p.curTokenIs(token.TOKEN_IDENTIFIER) {
+		return false
+	}
+
+	switch p.peekToken.Type {
+	case token.TOKEN_ASSIGN, token.TOKEN_SEMICOLON, token.TOKEN_RBRACE,
+		token.TOKEN_IDENTIFIER, token.TOKEN_FUNCTION, token.TOKEN_LET:
+		return true
+	}
+	return false
+}
+
+func (p *Parser) parseStructField() *ast.StructField {
+	if p.curToken.Literal == "self" {
+		p.errorf(p.curToken.Pos, "'self' can not be used as a field name")
+		return nil
+	}
+
+	field := &ast.StructField{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+	if p.peekTokenIs(token.TOKEN_ASSIGN) {
+		p.nextToken() //skip field name
+		p.nextToken() //skip '='
+		field.Default = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.TOKEN_SEMICOLON) {
+		p.nextToken()
+	}
+	return field
+}
+
 func (p *Parser) parseSwitchExpression() ast.Expression {
 	p.fallthroughDepth++
 	switchExpr := &ast.SwitchExpression{Token: p.curToken}
 
 	p.nextToken() //skip 'switch'
+	p.noTrailingBlock++
 	switchExpr.Expr = p.parseExpression(LOWEST)
+	p.noTrailingBlock--
 	if switchExpr.Expr == nil {
 		return nil
 	}
@@ -1331,16 +2950,12 @@ func (p *Parser) parseSwitchExpression() ast.Expression {
 
 	for !p.curTokenIs(token.TOKEN_RBRACE) {
 		if p.curTokenIs(token.TOKEN_EOF) {
-			msg := fmt.Sprintf("Syntax Error:%v- unterminated switch statement", p.curToken.Pos)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			p.errorf(p.curToken.Pos, "unterminated switch statement")
 			return nil
 		}
 
 		if !p.curTokenIs(token.TOKEN_CASE) && !p.curTokenIs(token.TOKEN_DEFAULT) {
-			msg := fmt.Sprintf("Syntax Error:%v- expected 'case' or 'default'. got %s instead", p.curToken.Pos, p.curToken.Type)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			p.errorf(p.curToken.Pos, "expected 'case' or 'default'. got %s instead", p.curToken.Type)
 			return nil
 		}
 
@@ -1348,12 +2963,14 @@ func (p *Parser) parseSwitchExpression() ast.Expression {
 		if p.curTokenIs(token.TOKEN_CASE) {
 			p.nextToken() //skip 'case'
 
+			p.noTrailingBlock++
 			caseExpr.Exprs = append(caseExpr.Exprs, p.parseExpression(LOWEST))
 			for p.peekTokenIs(token.TOKEN_COMMA) {
 				p.nextToken() //skip current token
 				p.nextToken() //skip comma
 				caseExpr.Exprs = append(caseExpr.Exprs, p.parseExpression(LOWEST))
 			}
+			p.noTrailingBlock--
 		} else if p.curTokenIs(token.TOKEN_DEFAULT) {
 			default_cnt++
 			if default_cnt > 1 {
@@ -1364,21 +2981,24 @@ func (p *Parser) parseSwitchExpression() ast.Expression {
 
 		//are there more than one default?
 		if default_cnt > 1 {
-			msg := fmt.Sprintf("Syntax Error:%v- more than one default are not allowed", defaultToken.Pos)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, switchExpr.Token.Pos.Sline())
+			p.errorf(defaultToken.Pos, "more than one default are not allowed")
 			return nil
 		}
 
+		if p.peekTokenIs(token.TOKEN_COLON) { //brace-less form, e.g. 'case 1: doA()'
+			p.nextToken()
+			caseExpr.Block = p.parseCaseBody(caseExpr)
+			switchExpr.Cases = append(switchExpr.Cases, caseExpr)
+			continue
+		}
+
 		if !p.expectPeek(token.TOKEN_LBRACE) {
 			return nil
 		}
 
 		caseExpr.Block = p.parseBlockStatement()
 		if !p.curTokenIs(token.TOKEN_RBRACE) {
-			msg := fmt.Sprintf("Syntax Error:%v- expected token to be '}', got %s instead", p.curToken.Pos, p.curToken.Type)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			p.errorf(p.curToken.Pos, "expected token to be '}', got %s instead", p.curToken.Type)
 			return nil
 
 		}
@@ -1396,20 +3016,21 @@ func (p *Parser) parseSwitchExpression() ast.Expression {
 			lastStmt := j == len(cse.Block.Statements)-1
 			switch stmt := stmt.(type) {
 			case *ast.ExpressionStatement:
-				if _, ok := stmt.Expression.(*ast.FallthroughExpression); !ok {
+				ft, ok := stmt.Expression.(*ast.FallthroughExpression)
+				if !ok {
 					continue
 				}
 
 				if !lastStmt {
-					msg := fmt.Sprintf("Syntax Error:%v- fallthrough can be used only as a last statement inside case clause", stmt.Pos().Line)
-					p.errors = append(p.errors, msg)
-					p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+					p.errorf(stmt.Pos(), "fallthrough can be used only as a last statement inside case clause")
 					return nil
 				}
 				if lastCase {
-					msg := fmt.Sprintf("Syntax Error:%v- cannot fallthrough final case in switch", stmt.Pos().Line)
-					p.errors = append(p.errors, msg)
-					p.errorLines = append(p.errorLines, stmt.Pos().Sline())
+					p.errorf(stmt.Pos(), "cannot fallthrough final case in switch")
+					return nil
+				}
+				if ft.Target != nil && !p.fallthroughTargetFound(switchExpr.Cases[i+1:], ft.Target.String()) {
+					p.errorf(ft.Target.Pos(), "fallthrough target 'case %s' not found after the current case", ft.Target.String())
 					return nil
 				}
 			}
@@ -1422,16 +3043,139 @@ func (p *Parser) parseSwitchExpression() ast.Expression {
 	return switchExpr
 }
 
+//match v { is number => v + 1, is string => len(v), _ => 0 }
+//The arms are separated by ',' or ';', the value of the first matched arm is the result.
+func (p *Parser) parseMatchExpression() ast.Expression {
+	defer p.enterBrackets(false)()
+	matchExpr := &ast.MatchExpression{Token: p.curToken}
+
+	p.nextToken() //skip 'match'
+	p.noTrailingBlock++
+	matchExpr.Expr = p.parseExpression(LOWEST)
+	p.noTrailingBlock--
+	if matchExpr.Expr == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.TOKEN_LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	var wildcard *ast.MatchArm
+	for !p.curTokenIs(token.TOKEN_RBRACE) {
+		if p.curTokenIs(token.TOKEN_EOF) {
+			p.errorf(p.curToken.Pos, "unterminated match expression")
+			return nil
+		}
+
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		if arm.IsWildcard() {
+			if wildcard != nil {
+				p.errorf(arm.Pos(), "more than one '_' arm are not allowed")
+				return nil
+			}
+			wildcard = arm
+		}
+		matchExpr.Arms = append(matchExpr.Arms, arm)
+
+		p.nextToken()
+		if p.curTokenIs(token.TOKEN_COMMA) || p.curTokenIs(token.TOKEN_SEMICOLON) {
+			p.nextToken()
+		}
+	}
+	matchExpr.RBraceToken = p.curToken
+
+	return matchExpr
+}
+
+//pattern => expr
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	pattern := p.parseMatchPattern()
+	if pattern == nil {
+		return nil
+	}
+	if !p.expectPeek(token.TOKEN_FATARROW) {
+		return nil
+	}
+	arm := &ast.MatchArm{Token: p.curToken, Pattern: pattern}
+
+	p.nextToken() //skip '=>'
+	arm.Body = p.parseExpression(LOWEST)
+	if arm.Body == nil {
+		return nil
+	}
+	return arm
+}
+
+//a pattern is a type pattern(e.g. 'is number'), the wildcard '_', or a value.
+//'is' is not a keyword, it is a type pattern only when followed by a type name.
+//A value is parsed above ASSIGN, so the '=>' after it is not taken as a short function.
+func (p *Parser) parseMatchPattern() ast.Expression {
+	if p.curTokenIs(token.TOKEN_IDENTIFIER) && p.curToken.Literal == "is" && p.peekTokenIs(token.TOKEN_IDENTIFIER) {
+		tp := &ast.TypePattern{Token: p.curToken}
+		p.nextToken()
+		tp.Type = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		return tp
+	}
+	return p.parseExpression(ASSIGN)
+}
+
+func (p *Parser) fallthroughTargetFound(cases []*ast.CaseExpression, label string) bool {
+	for _, cse := range cases {
+		if cse.HasLabel(label) {
+			return true
+		}
+	}
+	return false
+}
+
+//case 1: doA()
+//The body of the brace-less case ends at the next 'case', 'default' or '}'.
+func (p *Parser) parseCaseBody(caseExpr *ast.CaseExpression) *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: p.curToken}
+	block.Statements = []ast.Statement{}
+
+	caseExpr.RBraceToken = p.curToken
+	p.nextToken() //skip ':'
+
+	for !p.curTokenIs(token.TOKEN_CASE) && !p.curTokenIs(token.TOKEN_DEFAULT) && !p.curTokenIs(token.TOKEN_RBRACE) {
+		if p.curTokenIs(token.TOKEN_EOF) { //reported by parseSwitchExpression
+			break
+		}
+		stmt := p.parseStatement()
+		if stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+		caseExpr.RBraceToken = p.curToken
+		p.nextToken()
+	}
+
+	return block
+}
+
 func (p *Parser) parseFallThroughExpression() ast.Expression {
 	if p.fallthroughDepth == 0 {
-		msg := fmt.Sprintf("Syntax Error:%v- 'fallthrough' outside of switch context", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "'fallthrough' outside of switch context")
 
 		return nil
 	}
 
-	return &ast.FallthroughExpression{Token: p.curToken}
+	ft := &ast.FallthroughExpression{Token: p.curToken}
+	//'fallthrough case 3', falls through to the case labeled '3'.
+	//The 'case' should be on the same line, or else it starts the next brace-less case.
+	if p.peekTokenIs(token.TOKEN_CASE) && p.peekToken.Pos.Line == p.curToken.Pos.Line {
+		p.nextToken()
+		p.nextToken()
+		ft.Target = p.parseExpression(LOWEST)
+		if ft.Target == nil {
+			return nil
+		}
+	}
+	return ft
 }
 
 func (p *Parser) parseTryStatement() ast.Statement {
@@ -1482,10 +3226,15 @@ func (p *Parser) parseThrowStatement() *ast.ThrowStmt {
 
 func (p *Parser) parseDecorator() ast.Expression {
 	if p.peekTokenIs(token.TOKEN_LBRACE) { //ordered hash
+		at := p.curToken
 		p.nextToken() //skip the '@'
-		result := p.parseHashLiteral().(*ast.HashLiteral)
-		result.IsOrdered = true
-		return result
+		result := p.parseHashLiteral()
+		if result == nil {
+			return nil
+		}
+		hash := result.(*ast.HashLiteral)
+		hash.Token = at //the formatter keeps the '@'
+		return hash
 	}
 
 	dc := &ast.DecoratorExpr{Token: p.curToken}
@@ -1498,18 +3247,14 @@ func (p *Parser) parseDecorator() ast.Expression {
 	switch nodeType := expr.(type) {
 	case *ast.FunctionLiteral:
 		if nodeType.Name == "" {
-			msg := fmt.Sprintf("Syntax Error:%v- decorator must be followed by a named function or another decorator", p.curToken.Pos)
-			p.errors = append(p.errors, msg)
-			p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+			p.errorf(p.curToken.Pos, "decorator must be followed by a named function or another decorator")
 			return nil
 		}
 		dc.Decorated = nodeType
 	case *ast.DecoratorExpr:
 		dc.Decorated = nodeType
 	default:
-		msg := fmt.Sprintf("Syntax Error:%v- decorator must be followed by a named function or another decorator", p.curToken.Pos)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "decorator must be followed by a named function or another decorator")
 		return nil
 	}
 	return dc
@@ -1522,9 +3267,7 @@ func (p *Parser) parseCommand() ast.Expression {
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	if t != token.TOKEN_EOF {
-		msg := fmt.Sprintf("Syntax Error:%v- no prefix parse functions for '%s' found", p.curToken.Pos, t)
-		p.errors = append(p.errors, msg)
-		p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+		p.errorf(p.curToken.Pos, "no prefix parse functions for '%s' found", t)
 	}
 }
 
@@ -1536,15 +3279,36 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool {
 	return p.peekToken.Type == t
 }
 
+//enterBrackets is called at the start of the parentheses, brackets and braces. It sets whether '::'
+//is read as the two colons of a slice, and returns a function restoring the previous state. Nested
+//parentheses, brackets and braces read it as a scope resolution again, e.g. a[f(m::x)], and are not
+//waiting for any ':', e.g. the ':' of 'a ? (b ? c : d) : e' is not the one of the outer ternary.
+func (p *Parser) enterBrackets(sliceColons bool) func() {
+	savedSliceColons, savedColons := p.sliceColons, p.colons
+	p.sliceColons, p.colons = sliceColons, 0
+	return func() { p.sliceColons, p.colons = savedSliceColons, savedColons }
+}
+
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
-		return p
+	if p.peekTokenIs(token.TOKEN_OPTIONAL_LBRACKET) && p.ternaryFollows() {
+		p.peekToken.Type = token.TOKEN_QUESTIONM //the literal is still '?[', see parseTernaryExpression()
 	}
-	return LOWEST
+	return p.precedence(p.peekToken.Type)
 }
 
 func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
+	return p.precedence(p.curToken.Type)
+}
+
+func (p *Parser) precedence(t token.TokenType) int {
+	if t == token.TOKEN_SCOPE && p.sliceColons { //a[i::n]
+		return LOWEST
+	}
+	table := precedences
+	if p.precedences != nil {
+		table = p.precedences
+	}
+	if p, ok := table[t]; ok {
 		return p
 	}
 	return LOWEST
@@ -1552,7 +3316,40 @@ func (p *Parser) curPrecedence() int {
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.readPeekToken()
+}
+
+func (p *Parser) readPeekToken() {
+	for {
+		if p.stats != nil {
+			p.nextTokenWithStats()
+		} else {
+			p.peekToken = p.l.NextToken()
+		}
+		if !p.peekTokenIs(token.TOKEN_EOF) {
+			p.tokens++
+		}
+		//a pragma after the first statement is just a comment
+		if p.inPragmas || !p.peekTokenIs(token.TOKEN_PRAGMA) {
+			return
+		}
+	}
+}
+
+//reports whether the next token is on a later line than the current one
+func (p *Parser) peekOnNewLine() bool {
+	return p.peekToken.Pos.Line > p.curToken.Pos.Line
+}
+
+//expectPeekInside is expectPeek() inside the delimiters started by 'open', e.g. the ']' or ',' of an array.
+//If the input ends before the closing delimiter, it reports where 'open' is, which is usually far
+//away from the end, e.g. "unclosed '[' opened at line 3, column 5, got EOF instead".
+func (p *Parser) expectPeekInside(open token.Token, t token.TokenType) bool {
+	if p.peekTokenIs(token.TOKEN_EOF) {
+		p.errorf(open.Pos, "unclosed '%s' opened at line %d, column %d, got EOF instead", open.Literal, open.Pos.Line, open.Pos.Col)
+		return false
+	}
+	return p.expectPeek(t)
 }
 
 func (p *Parser) expectPeek(t token.TokenType) bool {
@@ -1568,11 +3365,10 @@ func (p *Parser) peekError(t token.TokenType) {
 	newPos := p.curToken.Pos
 	newPos.Col = newPos.Col + utf8.RuneCountInString(p.curToken.Literal)
 
-	msg := fmt.Sprintf("Syntax Error:%v- expected next token to be %s, got %s instead", newPos, t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
-	p.errorLines = append(p.errorLines, p.curToken.Pos.Sline())
+	p.errorf(newPos, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
 
+//Errors returns the formatted error messages, see ParseErrors() for the positions.
 func (p *Parser) Errors() []string {
 	return p.errors
 }
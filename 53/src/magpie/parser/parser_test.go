@@ -0,0 +1,2605 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"magpie/ast"
+	"magpie/lexer"
+	"magpie/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//parses the input, and fails the test if there are any errors
+func parseProgram(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: unexpected parser errors: %s", input, strings.Join(p.Errors(), "; "))
+	}
+	return program
+}
+
+//parses the input, and returns the errors
+func parseErrors(input string) []string {
+	p := NewParser(lexer.NewLexer(input))
+	p.ParseProgram()
+	return p.Errors()
+}
+
+//checks that parsing the input reports an error containing 'expected'
+func checkParseError(t *testing.T, input string, expected string) {
+	t.Helper()
+	errors := parseErrors(input)
+	for _, err := range errors {
+		if strings.Contains(err, expected) {
+			return
+		}
+	}
+	t.Errorf("%q: expected an error containing %q, got %q", input, expected, errors)
+}
+
+func TestRangeExpression(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		inclusive bool
+	}{
+		{"1..10", "(1..10)", true},
+		{"1..=10", "(1..=10)", true},
+		{"1..<10", "(1..<10)", false},
+		{"1 + 2..10", "((1 + 2)..10)", true},
+		{"1..<n - 1", "(1..<(n - 1))", false},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		r, ok := stmt.Expression.(*ast.RangeExpression)
+		if !ok {
+			t.Errorf("%q: expected *ast.RangeExpression, got %T", tt.input, stmt.Expression)
+			continue
+		}
+		if r.Inclusive != tt.inclusive {
+			t.Errorf("%q: expected Inclusive=%t, got %t", tt.input, tt.inclusive, r.Inclusive)
+		}
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string //the parts, the string segments are quoted
+	}{
+		{`"hello ${name}!"`, []string{`"hello "`, "name", `"!"`}},
+		{`"${a + b}"`, []string{"(a + b)"}},
+		{`"${h[\"a\"]}"`, []string{"(h[a])"}},                 //the quotes inside must be escaped
+		{`"${x"`, []string{`"${x"`}},                          //unterminated, kept as text
+		{`"a \${x} b"`, []string{`"a ${x} b"`}},               //escaped, kept as text
+		{`"${ {\"k\": 1}[\"k\"] }"`, []string{"({k: 1}[k])"}}, //nested braces
+		{`"plain"`, []string{`"plain"`}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		var parts []string
+		switch e := program.Statements[0].(*ast.ExpressionStatement).Expression.(type) {
+		case *ast.StringLiteral:
+			parts = append(parts, `"`+e.Value+`"`)
+		case *ast.InterpolatedStringLiteral:
+			for _, part := range e.Parts {
+				if s, ok := part.(*ast.StringLiteral); ok {
+					parts = append(parts, `"`+s.Value+`"`)
+				} else {
+					parts = append(parts, part.String())
+				}
+			}
+		}
+		if strings.Join(parts, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("%q: expected parts %q, got %q", tt.input, tt.expected, parts)
+		}
+	}
+
+	//a string without interpolations stays a plain string literal
+	for _, input := range []string{`"plain"`, `"a \${x} b"`, `"${x"`} {
+		expr := parseProgram(t, input).Statements[0].(*ast.ExpressionStatement).Expression
+		if _, ok := expr.(*ast.StringLiteral); !ok {
+			t.Errorf("%q: expected a string literal, got %T", input, expr)
+		}
+	}
+}
+
+func TestMethodCallOnString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"${x}, world".upper()`, "${x}, world.upper()"},
+		{`"hello".upper().lower()`, "hello.upper().lower()"},
+		{`"a" "b"`, "ab"}, //two statements, adjacent strings are not concatenated
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+	if program := parseProgram(t, `"a" "b"`); len(program.Statements) != 2 {
+		t.Errorf("expected 2 statements for adjacent strings, got %d", len(program.Statements))
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string //the decoded value
+		dollars  []int  //the offsets of the escaped '$' in the value
+	}{
+		{`"a\nb"`, "a\nb", nil},
+		{`"a\tb"`, "a\tb", nil},
+		{`"a\rb"`, "a\rb", nil},
+		{`"\\"`, `\`, nil},
+		{`"\""`, `"`, nil},
+		{`"\'"`, `'`, nil},
+		{`"\0"`, "\x00", nil},
+		{`"\0a"`, "\x00a", nil},
+		{`"\101"`, "A", nil},
+		{`"\x41"`, "A", nil},
+		{`"\u00e9"`, "\u00e9", nil},
+		{`"\U0001F600"`, "\U0001F600", nil},
+		{`"\a\b\f\v"`, "\a\b\f\v", nil},
+		{`"\$x"`, `$x`, []int{0}}, //the evaluator tells it from an interpolation by the offsets
+		{`"\x24x"`, `$x`, []int{0}},
+		{`"\${x}"`, `${x}`, []int{0}}, //not an interpolation
+		{`"\x24{x}"`, `${x}`, []int{0}},
+		{`"cost \$5"`, `cost $5`, []int{5}},
+		{`"cost \x245"`, `cost $5`, []int{5}},
+		{`"a\\$b"`, `a\$b`, nil}, //a backslash, then an interpolation
+		{`"\\$name"`, `\$name`, nil},
+		{`"$a \$b $c \$d"`, `$a $b $c $d`, []int{3, 9}},
+		{`"\u00e9\$x"`, "\u00e9$x", []int{2}}, //in bytes
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		s, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.StringLiteral)
+		if !ok {
+			t.Errorf("%q: expected *ast.StringLiteral, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+			continue
+		}
+		if s.Value != tt.expected {
+			t.Errorf("%q: expected value %q, got %q", tt.input, tt.expected, s.Value)
+		}
+		if fmt.Sprint(s.EscapedDollars) != fmt.Sprint(tt.dollars) {
+			t.Errorf("%q: expected the escaped '$' at %v, got %v", tt.input, tt.dollars, s.EscapedDollars)
+		}
+		if raw := tt.input[1 : len(tt.input)-1]; s.Token.Literal != raw { //the token keeps the raw text
+			t.Errorf("%q: expected literal %q, got %q", tt.input, raw, s.Token.Literal)
+		}
+	}
+}
+
+func TestInvalidStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"\q"`, "<1:2> - invalid escape sequence '\\q' in string"},
+		{`"abc\q"`, "<1:5> - invalid escape sequence '\\q' in string"},
+		{`"${x} \q"`, "<1:7> - invalid escape sequence '\\q' in string"},
+		{`"\xZZ"`, "invalid escape sequence '\\x' in string"},
+		{`"\x4"`, "<1:2> - invalid escape sequence '\\x' in string"},        //too short
+		{`"\u00"`, "<1:2> - invalid escape sequence '\\u' in string"},       //too short
+		{`"\ud800"`, "<1:2> - invalid escape sequence '\\u' in string"},     //a surrogate half
+		{`"\U00110000"`, "<1:2> - invalid escape sequence '\\U' in string"}, //out of the unicode range
+	}
+
+	for _, tt := range tests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestInterpolationPositions(t *testing.T) {
+	program := parseProgram(t, "let s = 1\n  \"ab ${name}: ${f(x)}\"")
+	is := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.InterpolatedStringLiteral)
+	tests := []struct {
+		expected string //the part and its position
+	}{
+		{`"ab " @2:4`},
+		{`"name" @2:9`},
+		{`": " @2:14`},
+		{`"f(x)" @2:18`},
+	}
+	if len(is.Parts) != len(tests) {
+		t.Fatalf("expected %d parts, got %d", len(tests), len(is.Parts))
+	}
+	for i, tt := range tests {
+		part := is.Parts[i]
+		got := fmt.Sprintf("%q @%d:%d", part.String(), part.Pos().Line, part.Pos().Col)
+		if got != tt.expected {
+			t.Errorf("part %d: expected %q, got %q", i, tt.expected, got)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`"abc ${1 +}"`, "<1:6> - invalid interpolation '${1 +}' in string"},
+		{`"abc ${}"`, "<1:6> - empty interpolation '${}' in string"},
+		{`"abc ${a b}"`, "<1:6> - invalid interpolation '${a b}' in string: unexpected token 'b'"},
+		{`"abc ${)}"`, "<1:8>"}, //the error of the sub-parser points into the string
+	}
+	for _, tt := range errorTests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestHashLiteralOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"c": 1, "a": 2, "b": 3}`, "{c: 1, a: 2, b: 3}"},
+		{`{3: "x", 1: "y", 2: "z"}`, "{3: x, 1: y, 2: z}"},
+		{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"}, //the last value, the first-seen order
+		{`@{"b": 1, "a": 2}`, "{b: 1, a: 2}"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, "let h = "+tt.input)
+		hash, ok := program.Statements[0].(*ast.LetStatement).Values[0].(*ast.HashLiteral)
+		if !ok {
+			t.Errorf("%q: expected *ast.HashLiteral, got %T", tt.input, program.Statements[0].(*ast.LetStatement).Values[0])
+			continue
+		}
+		if !hash.IsOrdered {
+			t.Errorf("%q: expected IsOrdered", tt.input)
+		}
+		if hash.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, hash.String())
+		}
+	}
+}
+
+func TestVariadicParameters(t *testing.T) {
+	tests := []struct {
+		input      string
+		parameters string
+		variadic   bool
+	}{
+		{"fn(args...) {}", "args", true},
+		{"fn(a, b, args...) {}", "a, b, args", true},
+		{"fn(a, b) {}", "a, b", false},
+		{"fn() {}", "", false},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		fn, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Errorf("%q: expected *ast.FunctionLiteral, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+			continue
+		}
+		var params []string
+		for _, param := range fn.Parameters {
+			params = append(params, param.Value)
+		}
+		if strings.Join(params, ", ") != tt.parameters {
+			t.Errorf("%q: expected parameters %q, got %q", tt.input, tt.parameters, params)
+		}
+		if fn.Variadic != tt.variadic {
+			t.Errorf("%q: expected Variadic=%t, got %t", tt.input, tt.variadic, fn.Variadic)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(args..., b) {}", "can only have '...' after last parameter"},
+		{"fn(a, args..., b) {}", "can only have '...' after last parameter"},
+	}
+	for _, tt := range errorTests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestConditionalImport(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "nosuchmod" if DEBUG`, "import nosuchmod if DEBUG"},
+		{`let DEBUG = true; import "dbg/tools" if DEBUG`, "import tools if DEBUG"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input) //the file is not read by the parser
+		var imp *ast.ImportStatement
+		for _, stmt := range program.Statements {
+			if s, ok := stmt.(*ast.ImportStatement); ok {
+				imp = s
+			}
+		}
+		if imp == nil {
+			t.Errorf("%q: expected the import to stay in the statements", tt.input)
+			continue
+		}
+		if imp.Program != nil || len(program.Imports) != 0 {
+			t.Errorf("%q: expected the import not to be loaded", tt.input)
+		}
+		if imp.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, imp.String())
+		}
+	}
+
+	checkParseError(t, `import "nosuchmod"`, "no file or directory: nosuchmod.mp")
+	checkParseError(t, "import \"nosuchmod\"\nif DEBUG { 1 }", "no file or directory: nosuchmod.mp")
+
+	//an 'if' on the next line is a statement of its own, not the condition of the import
+	root, err := ioutil.TempDir("", "magpie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := ioutil.WriteFile(filepath.Join(root, "one.mp"), []byte("let One = 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MAGPIE_ROOT", root)
+
+	input := "import \"one\"\nif DEBUG { 1 }"
+	program := parseProgram(t, input)
+	if _, ok := program.Imports["one"]; !ok {
+		t.Errorf("%q: expected the import to be loaded", input)
+	}
+	if len(program.Statements) != 1 {
+		t.Fatalf("%q: expected 1 statement, got %d", input, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("%q: expected an expression statement, got %T", input, program.Statements[0])
+	}
+	if _, ok := stmt.Expression.(*ast.IfExpression); !ok {
+		t.Errorf("%q: expected an if expression, got %T", input, stmt.Expression)
+	}
+}
+
+func TestImportStatement(t *testing.T) {
+	root, err := ioutil.TempDir("", "magpie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	modules := map[string]string{
+		"one.mp":       "let One = 1",
+		"lib/two.mp":   "let Two = 2",
+		"lib/three.mp": "let Three = 3",
+	}
+	for name, content := range modules {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("MAGPIE_ROOT", root)
+
+	tests := []struct {
+		input    string
+		expected []string //the keys of program.Imports, the base names of the paths
+	}{
+		{`import "one"`, []string{"one"}},
+		{`import "one"; import "lib/two"`, []string{"one", "two"}},
+		{"import lib.two\nimport \"lib/three.mp\"\n1", []string{"two", "three"}},
+		{`import "one"; import "one"`, []string{"one"}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		for _, stmt := range program.Statements {
+			if _, ok := stmt.(*ast.ImportStatement); ok {
+				t.Errorf("%q: expected the import to be moved out of the statements", tt.input)
+			}
+		}
+		if len(program.Imports) != len(tt.expected) {
+			t.Errorf("%q: expected %d imports, got %d", tt.input, len(tt.expected), len(program.Imports))
+			continue
+		}
+		for _, name := range tt.expected {
+			imp, ok := program.Imports[name]
+			if !ok {
+				t.Errorf("%q: expected %q in the imports", tt.input, name)
+				continue
+			}
+			if imp.ImportPath != name {
+				t.Errorf("%q: expected the import path %q, got %q", tt.input, name, imp.ImportPath)
+			}
+			if imp.Program == nil || len(imp.Program.Statements) != 1 {
+				t.Errorf("%q: expected %q to be parsed", tt.input, imp.Path)
+			}
+		}
+	}
+}
+
+func TestSliceColons(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[i::n]", "(a[i::n])"},
+		{"a[i::2]", "(a[i::2])"},
+		{"a[1::2]", "(a[1::2])"},
+		{"a[::n]", "(a[::n])"},
+		{"a[i + 1::n]", "(a[(i + 1)::n])"},
+		{"a[i:j:n]", "(a[i:j:n])"},
+		{"a[m::x]", "(a[m::x])"},             //a slice, not a scope resolution
+		{"a[(m::x)]", "(a[m::x])"},           //a scope resolution inside the parentheses
+		{"a[f(m::x)]", "(a[f(m::x)])"},       //a scope resolution in the call
+		{"a[[m::x][0]]", "(a[([m::x][0])])"}, //a scope resolution in the array literal
+		{"m::x", "m::x"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	//the index of a[m::x] is a slice, the one of a[(m::x)] a scope resolution
+	program := parseProgram(t, "a[m::x]; a[(m::x)]")
+	if _, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.SliceExpression); !ok {
+		t.Errorf("expected a[m::x] to be a slice")
+	}
+	index, ok := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("expected a[(m::x)] to be an index expression")
+	}
+	if _, ok := index.Index.(*ast.ScopeResolution); !ok {
+		t.Errorf("expected the index of a[(m::x)] to be a scope resolution, got %T", index.Index)
+	}
+}
+
+func TestArrowReturnType(t *testing.T) {
+	tests := []struct {
+		input      string
+		returnType string //of the last arrow function, "" if none
+	}{
+		{"(x): Number => x", "Number"},
+		{"(x, y): Number => x + y", "Number"},
+		{"(): Number => 5", "Number"},
+		{"(x) => x", ""},
+		{"let f = (x): Number => x; let g = (y) => y", ""},
+		{"let f = (x): Number => (y) => y", "Number"}, //the outer function
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		var fn *ast.FunctionLiteral
+		for _, stmt := range program.Statements {
+			var expr ast.Expression
+			switch s := stmt.(type) {
+			case *ast.ExpressionStatement:
+				expr = s.Expression
+			case *ast.LetStatement:
+				expr = s.Values[0]
+			}
+			if f, ok := expr.(*ast.FunctionLiteral); ok {
+				fn = f
+			}
+		}
+		if fn == nil {
+			t.Errorf("%q: expected a function literal", tt.input)
+			continue
+		}
+		got := ""
+		if fn.ReturnType != nil {
+			got = fn.ReturnType.Value
+		}
+		if got != tt.returnType {
+			t.Errorf("%q: expected return type %q, got %q", tt.input, tt.returnType, got)
+		}
+	}
+
+	//the '=>' after 'T' is never consumed after the error, its type must not leak into 'g'
+	p := NewParser(lexer.NewLexer("a[1, (x): T => x]\nlet g = (y) => y"))
+	program := p.ParseProgram()
+	for _, stmt := range program.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok && let.Names[0].Value == "g" {
+			fn, ok := let.Values[0].(*ast.FunctionLiteral)
+			if !ok {
+				t.Fatalf("expected g to be a function literal, got %T", let.Values[0])
+			}
+			if fn.ReturnType != nil {
+				t.Errorf("expected no return type for g, got %s", fn.ReturnType.Value)
+			}
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		patterns []string //the type of each arm's pattern
+	}{
+		{"match v { is number => 1, is string => 2, _ => 3 }", []string{"TypePattern", "TypePattern", "Identifier"}},
+		{"match v {\n is number => 1\n is string => 2\n _ => 3\n}", []string{"TypePattern", "TypePattern", "Identifier"}},
+		{"match v { is number => 1; _ => 0; }", []string{"TypePattern", "Identifier"}},
+		{"match v { 1 => 1, 1..5 => 2, nil => 3, }", []string{"NumberLiteral", "RangeExpression", "NilLiteral"}},
+		{"match v {}", []string{}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		me, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+		if !ok {
+			t.Errorf("%q: expected a match expression, got %T", tt.input, program.Statements[0])
+			continue
+		}
+		if len(me.Arms) != len(tt.patterns) {
+			t.Errorf("%q: expected %d arms, got %d", tt.input, len(tt.patterns), len(me.Arms))
+			continue
+		}
+		for i, arm := range me.Arms {
+			if got := arm.Pattern.NodeType(); got != tt.patterns[i] {
+				t.Errorf("%q: expected arm %d to be a %s, got %s", tt.input, i, tt.patterns[i], got)
+			}
+		}
+	}
+
+	program := parseProgram(t, "match v { is number => v + 1, is string => len(v), _ => 0 }")
+	if got, expected := program.String(), "match v { is number => (v + 1), is string => len(v), _ => 0 }"; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	scrutinees := []struct {
+		input    string
+		expected string
+	}{
+		{"let r = match (x) { is number => 1, _ => 2 }", "x"},
+		{"match -1 { _ => 1 }", "(-1)"},
+		{"match !ok { true => 1, _ => 2 }", "(!ok)"},
+		{"match (a + b) * 2 { 0 => 1 }", "((a + b) * 2)"},
+		{"match f(x) { [1, 2] => 1 }", "f(x)"},
+		{"match [1, 2] {\n _ => 1\n}", "[1, 2]"},
+	}
+	for _, tt := range scrutinees {
+		program := parseProgram(t, tt.input)
+		var me *ast.MatchExpression
+		switch stmt := program.Statements[0].(type) {
+		case *ast.LetStatement:
+			me, _ = stmt.Values[0].(*ast.MatchExpression)
+		case *ast.ExpressionStatement:
+			me, _ = stmt.Expression.(*ast.MatchExpression)
+		}
+		if me == nil {
+			t.Errorf("%q: expected a match expression, got %s", tt.input, program.String())
+			continue
+		}
+		if me.Expr.String() != tt.expected {
+			t.Errorf("%q: expected the scrutinee %s, got %s", tt.input, tt.expected, me.Expr.String())
+		}
+	}
+
+	//'match' is still a name when not followed by an operand, a '{' and a pattern
+	for _, input := range []string{"match = 1", "match(x)", "let match = re.match(s)", "match\nx", "match - 1", "match(x) { y }", "match[0]"} {
+		program := parseProgram(t, input)
+		for _, stmt := range program.Statements {
+			if es, ok := stmt.(*ast.ExpressionStatement); ok {
+				if _, ok := es.Expression.(*ast.MatchExpression); ok {
+					t.Errorf("%q: expected 'match' to be a name", input)
+				}
+			}
+		}
+	}
+}
+
+func TestMatchExpressionErrors(t *testing.T) {
+	checkParseError(t, "match v { is number 1 }", "expected next token to be =>")
+	checkParseError(t, "match v { _ => 1, _ => 2 }", "more than one '_' arm are not allowed")
+	checkParseError(t, "match v { is number => 1", "unterminated match expression")
+}
+
+func TestDefaultParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		defaults []string //the default value of each parameter, "" for a required one
+	}{
+		{"fn(a, b = 10) { a }", "fn(a, b = 10) {a;}", []string{"", "10"}},
+		{"fn(a, b = 10, c = a + 1) { a }", "fn(a, b = 10, c = (a + 1)) {a;}", []string{"", "10", "(a + 1)"}},
+		{"fn f(a = 1, b = 2) { a }", "fn f(a = 1, b = 2) {a;}", []string{"1", "2"}},
+		{"fn(a, b) { a }", "fn(a, b) {a;}", nil}, //no defaults at all
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+		if len(fn.Defaults) != len(tt.defaults) {
+			t.Errorf("%q: expected %d defaults, got %d", tt.input, len(tt.defaults), len(fn.Defaults))
+			continue
+		}
+		for i, d := range fn.Defaults {
+			got := ""
+			if d != nil {
+				got = d.String()
+			}
+			if got != tt.defaults[i] {
+				t.Errorf("%q: expected the default of %s to be %q, got %q", tt.input, fn.Parameters[i].Value, tt.defaults[i], got)
+			}
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(a = 1, b) {}", "required parameter 'b' cannot follow a parameter with a default value"},
+		{"fn(a, b = 1, c, d = 2) {}", "required parameter 'c' cannot follow a parameter with a default value"},
+		{"fn(a = 1, args...) {}", "a variadic function cannot have default parameter values"},
+	}
+	for _, tt := range errorTests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestNamedArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		named    []bool //whether each argument is named
+	}{
+		{"f(x: 1, y: 2)", "f(x: 1, y: 2)", []bool{true, true}},
+		{"f(1, y: 2)", "f(1, y: 2)", []bool{false, true}},
+		{"f(1, 2)", "f(1, 2)", []bool{false, false}},
+		{"f(x: a + 1)", "f(x: (a + 1))", []bool{true}},
+		{"obj.f(x: 1)", "obj.f(x: 1)", []bool{true}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		expr := program.Statements[0].(*ast.ExpressionStatement).Expression
+		if mc, ok := expr.(*ast.MethodCallExpression); ok {
+			expr = mc.Call
+		}
+		call, ok := expr.(*ast.CallExpression)
+		if !ok {
+			t.Errorf("%q: expected a call expression, got %T", tt.input, expr)
+			continue
+		}
+		if len(call.Arguments) != len(tt.named) {
+			t.Errorf("%q: expected %d arguments, got %d", tt.input, len(tt.named), len(call.Arguments))
+			continue
+		}
+		for i, arg := range call.Arguments {
+			if _, named := arg.(*ast.NamedArgument); named != tt.named[i] {
+				t.Errorf("%q: expected argument %d named=%t, got %T", tt.input, i, tt.named[i], arg)
+			}
+		}
+	}
+
+	checkParseError(t, "f(x: 1, 2)", "positional argument cannot follow a named argument")
+	checkParseError(t, "f(1, x: 1, 2)", "positional argument cannot follow a named argument")
+}
+
+func TestAsyncAwait(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"async fn f() { await g() }", "async fn f() {(await g());}"},
+		{"let f = async fn() { let x = await g(1) + 1 }", "let f = async fn() {let x = ((await g(1)) + 1);}"},
+		{"fn f() { g() }", "fn f() {g();}"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	program := parseProgram(t, "async fn f() { await g() }")
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if !fn.Async {
+		t.Errorf("expected f to be async")
+	}
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression
+	if _, ok := body.(*ast.AwaitExpression); !ok {
+		t.Errorf("expected an await expression, got %T", body)
+	}
+
+	checkParseError(t, "async 1", "expected next token to be FUNCTION")
+}
+
+func TestStructFields(t *testing.T) {
+	tests := []struct {
+		input    string
+		fields   []string //name or 'name = default'
+		stmts    int      //the number of the methods and other statements
+		expected string
+	}{
+		{"struct Point { x = 0; y = 0 }", []string{"x = 0", "y = 0"}, 0, "struct Point{ x = 0;y = 0; }"},
+		{"struct Point { x = 0; y; fn dist() { x + y } }", []string{"x = 0", "y"}, 1, "struct Point{ x = 0;y;fn dist() {(x + y);}; }"},
+		{"struct P {\n x = 1 + 2\n y = \"a\"\n}", []string{"x = (1 + 2)", "y = a"}, 0, "struct P{ x = (1 + 2);y = a; }"},
+		{"struct P { fn f() {} }", nil, 1, "struct P{ fn f() {}; }"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		st, ok := program.Statements[0].(*ast.StructStatement)
+		if !ok {
+			t.Errorf("%q: expected a struct statement, got %T", tt.input, program.Statements[0])
+			continue
+		}
+		var fields []string
+		for _, f := range st.Fields {
+			fields = append(fields, f.String())
+		}
+		if strings.Join(fields, "|") != strings.Join(tt.fields, "|") {
+			t.Errorf("%q: expected fields %q, got %q", tt.input, tt.fields, fields)
+		}
+		if len(st.Block.Statements) != tt.stmts {
+			t.Errorf("%q: expected %d statements in the block, got %d", tt.input, tt.stmts, len(st.Block.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	checkParseError(t, "struct P { self = 1 }", "'self' can not be used as a field name")
+}
+
+func TestNilCoalescing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a ?? b", "(a ?? b)"},
+		{"a ?? b ?? c", "(a ?? (b ?? c))"}, //right-associative
+		{"a == b ?? c", "((a == b) ?? c)"},
+		{"a ?? b == c", "(a ?? (b == c))"},
+		{"a < b ?? c > d", "((a < b) ?? (c > d))"},
+		{"a || b ?? c", "((a || b) ?? c)"},
+		{"a ?? b ? c : d", "((a ?? b) ? c : d)"}, //above the ternary
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestDoExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		isLoop   bool //a 'do' at statement position is a loop
+	}{
+		{"let x = do { let a = compute(); a + 1 }", "let x = (do { let a = compute();(a + 1); })", false},
+		{"return do { 1 }", "return (do { 1; });", false},
+		{"f(do { 1 })", "f((do { 1; }))", false},
+		{"do { x++; if x > 3 { break } }", "do { (x++);if (x > 3) { break; }; }", true},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var isLoop bool
+		ast.Walk(program, func(node ast.Node) bool {
+			if _, ok := node.(*ast.DoLoop); ok {
+				isLoop = true
+			}
+			return true
+		})
+		if isLoop != tt.isLoop {
+			t.Errorf("%q: expected a loop=%t, got %t", tt.input, tt.isLoop, isLoop)
+		}
+	}
+}
+
+func TestPipeOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x |> f", "f(x)"},
+		{"x |> f |> g", "g(f(x))"},
+		{"[1,2] |> map(double) |> sum", "sum(map([1, 2], double))"},
+		{"x |> f |> g(1)", "g(f(x), 1)"},
+		{"a + b |> f", "f((a + b))"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	//[1,2] |> map(double) |> sum is sum(map([1, 2], double))
+	program := parseProgram(t, "[1,2] |> map(double) |> sum")
+	outer, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	if !ok || outer.Function.String() != "sum" || len(outer.Arguments) != 1 {
+		t.Fatalf("expected a call to sum with one argument, got %s", program.String())
+	}
+	inner, ok := outer.Arguments[0].(*ast.CallExpression)
+	if !ok || inner.Function.String() != "map" || len(inner.Arguments) != 2 {
+		t.Fatalf("expected a call to map with two arguments, got %s", outer.Arguments[0].String())
+	}
+	if _, ok := inner.Arguments[0].(*ast.ArrayLiteral); !ok {
+		t.Errorf("expected the first argument of map to be the array, got %T", inner.Arguments[0])
+	}
+
+	for _, input := range []string{"x |> 1", "x |> \"s\"", "x |> f + 1"} {
+		checkParseError(t, input, "pipe operator's right hand side must be a function or a function call")
+	}
+}
+
+func TestParseNext(t *testing.T) {
+	tests := []struct {
+		input      string
+		statements []string
+		semicolons []bool
+	}{
+		{"x;", []string{"x"}, []bool{true}},
+		{"x", []string{"x"}, []bool{false}},
+		{"x; y", []string{"x", "y"}, []bool{true, false}},
+		{"x\ny;", []string{"x", "y"}, []bool{false, true}},
+		{"let a = 1;", []string{"let a = 1"}, []bool{true}},
+		{";; x", []string{"x"}, []bool{false}}, //empty statements are skipped
+		{"", nil, nil},
+	}
+
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		var stmts []string
+		var semicolons []bool
+		for {
+			stmt, semicolon := p.ParseNext()
+			if stmt == nil {
+				break
+			}
+			stmts = append(stmts, stmt.String())
+			semicolons = append(semicolons, semicolon)
+		}
+		if len(p.Errors()) != 0 {
+			t.Errorf("%q: unexpected parser errors: %s", tt.input, strings.Join(p.Errors(), "; "))
+		}
+		if fmt.Sprint(stmts) != fmt.Sprint(tt.statements) {
+			t.Errorf("%q: expected statements %q, got %q", tt.input, tt.statements, stmts)
+		}
+		if fmt.Sprint(semicolons) != fmt.Sprint(tt.semicolons) {
+			t.Errorf("%q: expected semicolons %v, got %v", tt.input, tt.semicolons, semicolons)
+		}
+	}
+}
+
+//checks the value of the number literal which is the only expression of the input
+func checkNumberLiteral(t *testing.T, input string, expected float64) {
+	t.Helper()
+	program := parseProgram(t, input)
+	n, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.NumberLiteral)
+	if !ok {
+		t.Errorf("%q: expected a number literal, got %T", input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+		return
+	}
+	if n.Value != expected {
+		t.Errorf("%q: expected %v, got %v", input, expected, n.Value)
+	}
+}
+
+func TestNumberBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"0xFF", 255},
+		{"0XFF", 255},
+		{"0xff", 255},
+		{"0o17", 15},
+		{"0O17", 15},
+		{"0b1010", 10},
+		{"0B1010", 10},
+		{"0x0", 0},
+	}
+	for _, tt := range tests {
+		checkNumberLiteral(t, tt.input, tt.expected)
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"0b102", `could not parse "0b102" as base 2 integer`},
+		{"0o19", `could not parse "0o19" as base 8 integer`},
+		{"0xG", `could not parse "0xG" as base 16 integer`},
+	}
+	for _, tt := range errorTests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestLetMut(t *testing.T) {
+	tests := []struct {
+		input    string
+		mutable  bool
+		expected string
+	}{
+		{"let mut x = 1", true, "let mut x = 1"},
+		{"let x = 1", false, "let x = 1"},
+		{"let mut a, b = 1, 2", true, "let mut a, b = 1, 2"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		let, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Errorf("%q: expected a let statement, got %T", tt.input, program.Statements[0])
+			continue
+		}
+		if let.Mutable != tt.mutable {
+			t.Errorf("%q: expected Mutable=%t, got %t", tt.input, tt.mutable, let.Mutable)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	checkParseError(t, "let mut = 1", "expected token to be identifier|underscore, got = instead")
+}
+
+func TestDigitSeparators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1_000_000", 1000000},
+		{"0xDE_AD_BE_EF", 0xDEADBEEF},
+		{"0b1010_1010", 170},
+		{"3.14_15", 3.1415},
+		{"1_0e1_0", 10e10},
+	}
+	for _, tt := range tests {
+		checkNumberLiteral(t, tt.input, tt.expected)
+	}
+
+	for _, input := range []string{"1_", "1__0", "0x_FF", "1_.5", "1e_5"} {
+		checkParseError(t, input, fmt.Sprintf("invalid digit separator '_' in %q", input))
+	}
+}
+
+func TestBracelessCase(t *testing.T) {
+	input := "switch x {\ncase 1: doA()\ncase 2, 3: doB(); doC()\ncase 4 { doD() }\ndefault: doE()\n}"
+	program := parseProgram(t, input)
+	sw, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.SwitchExpression)
+	if !ok {
+		t.Fatalf("expected a switch expression, got %s", program.String())
+	}
+
+	expected := []struct {
+		labels string
+		body   string
+	}{
+		{"1", "doA()"},
+		{"2, 3", "doB()|doC()"},
+		{"4", "doD()"}, //the brace form in the same switch
+		{"", "doE()"},  //default
+	}
+	if len(sw.Cases) != len(expected) {
+		t.Fatalf("expected %d cases, got %d", len(expected), len(sw.Cases))
+	}
+	for i, c := range sw.Cases {
+		var labels, body []string
+		for _, e := range c.Exprs {
+			labels = append(labels, e.String())
+		}
+		for _, s := range c.Block.Statements {
+			body = append(body, strings.TrimSuffix(s.String(), ";"))
+		}
+		if strings.Join(labels, ", ") != expected[i].labels || strings.Join(body, "|") != expected[i].body {
+			t.Errorf("case %d: expected %q: %q, got %q: %q", i, expected[i].labels, expected[i].body, labels, body)
+		}
+	}
+	if !sw.Cases[3].Default {
+		t.Errorf("expected the last case to be the default")
+	}
+
+	program = parseProgram(t, "switch x { case 1:\n default { b } }") //an empty body
+	if sw := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.SwitchExpression); len(sw.Cases[0].Block.Statements) != 0 {
+		t.Errorf("expected an empty body, got %s", sw.Cases[0].Block.String())
+	}
+}
+
+func TestFloatLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"6.022e23", 6.022e23},
+		{"1E3", 1000},
+		{"1e10", 1e10},
+		{"1.5E-3", 0.0015},
+		{"2e+2", 200},
+		{".25", 0.25},
+		{".5", 0.5},
+	}
+	for _, tt := range tests {
+		checkNumberLiteral(t, tt.input, tt.expected)
+	}
+
+	for _, input := range []string{"1e", "1e+", "1.5E-"} {
+		checkParseError(t, input, fmt.Sprintf("malformed number %q, missing exponent digits", input))
+	}
+}
+
+func TestHexFloats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"0x1.8p3", 12},
+		{"0x1p-2", 0.25},
+		{"0xA.Bp0", 10.6875},
+		{"0x1P4", 16},
+		{"0x1_0.8p1", 33},
+	}
+	for _, tt := range tests {
+		checkNumberLiteral(t, tt.input, tt.expected)
+	}
+
+	for _, input := range []string{"0x1.8", "0x1.8 + 1"} {
+		checkParseError(t, input, `hexadecimal float "0x1.8" requires a 'p' exponent`)
+	}
+	checkParseError(t, "0x1.8p", `could not parse "0x1.8p" as hexadecimal float`)
+}
+
+func TestCharLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected rune
+	}{
+		{`'a'`, 'a'},
+		{`' '`, ' '},
+		{`'\n'`, '\n'},
+		{`'\t'`, '\t'},
+		{`'\\'`, '\\'},
+		{`'\''`, '\''},
+		{`'\u00e9'`, '\u00e9'},
+		{`'\x41'`, 'A'},
+		{"'\u00e9'", '\u00e9'}, //a multi-byte rune in the source
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		c, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CharLiteral)
+		if !ok {
+			t.Errorf("%q: expected a char literal, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+			continue
+		}
+		if c.Value != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, c.Value)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`'ab'`, "more than one character in character literal"},
+		{`''`, "empty character literal"},
+		{`'\q'`, "invalid character literal"},
+	}
+	for _, tt := range errorTests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestLogicalAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"m[k] ??= 1", "(m[k])??=1"},
+		{"a[i] ||= 0", "(a[i])||=0"},
+		{"x ??= 1", "x??=1"},
+		{"m[k][j] ??= []", "((m[k])[j])??=[]"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		assign, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Errorf("%q: expected an assign expression, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+			continue
+		}
+		if tt.input[0] != 'x' {
+			if _, ok := assign.Name.(*ast.IndexExpression); !ok {
+				t.Errorf("%q: expected an index target, got %T", tt.input, assign.Name)
+			}
+		}
+	}
+
+	checkParseError(t, "f() ??= 1", "invalid assignment target 'f()'")
+}
+
+func TestRawStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"```a\\nb```", `a\nb`}, //no escape processing
+		{"```line1\nline2```", "line1\nline2"},
+		{"```${x}```", "${x}"}, //no interpolation
+		{"``````", ""},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		s, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.StringLiteral)
+		if !ok {
+			t.Errorf("%q: expected a string literal, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+			continue
+		}
+		if !s.Raw || s.Value != tt.expected {
+			t.Errorf("%q: expected the raw string %q, got %q(Raw=%t)", tt.input, tt.expected, s.Value, s.Raw)
+		}
+	}
+
+	//a single backtick is still a command
+	program := parseProgram(t, "`ls -l`")
+	if _, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CmdExpression); !ok {
+		t.Errorf("expected a command expression, got %s", program.String())
+	}
+
+	checkParseError(t, "```unterminated", "unterminated raw string")
+}
+
+func TestRangeCaseLabels(t *testing.T) {
+	tests := []struct {
+		input  string
+		labels []string //the type of each label of the first case
+	}{
+		{"switch x { case 1..5 { a } }", []string{"RangeExpression"}},
+		{"switch x { case 1, 5..10 { a } }", []string{"NumberLiteral", "RangeExpression"}},
+		{"switch x { case 1..<5, 10..=20, 100 { a } }", []string{"RangeExpression", "RangeExpression", "NumberLiteral"}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		sw := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.SwitchExpression)
+		var labels []string
+		for _, e := range sw.Cases[0].Exprs {
+			labels = append(labels, e.NodeType())
+		}
+		if strings.Join(labels, ", ") != strings.Join(tt.labels, ", ") {
+			t.Errorf("%q: expected labels %q, got %q", tt.input, tt.labels, labels)
+		}
+	}
+}
+
+func TestCmdExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		value    string
+		pos, end string //line:col
+	}{
+		{"`ls -l`", "ls -l", "1:1", "1:8"},
+		{"x = `ls ${dir}`", "ls ${dir}", "1:5", "1:16"}, //the interpolation is kept raw
+		{"\n  `pwd`", "pwd", "2:3", "2:8"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		var cmd *ast.CmdExpression
+		ast.Walk(program, func(node ast.Node) bool {
+			if c, ok := node.(*ast.CmdExpression); ok {
+				cmd = c
+			}
+			return true
+		})
+		if cmd == nil {
+			t.Errorf("%q: expected a command expression", tt.input)
+			continue
+		}
+		if cmd.Value != tt.value {
+			t.Errorf("%q: expected the value %q, got %q", tt.input, tt.value, cmd.Value)
+		}
+		pos := fmt.Sprintf("%d:%d", cmd.Pos().Line, cmd.Pos().Col)
+		end := fmt.Sprintf("%d:%d", cmd.End().Line, cmd.End().Col)
+		if pos != tt.pos || end != tt.end {
+			t.Errorf("%q: expected the span %s-%s, got %s-%s", tt.input, tt.pos, tt.end, pos, end)
+		}
+	}
+}
+
+func TestUseStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"use s = a.b.c in { s + 1 }", "use s = a.b.c in { (s + 1); }"},
+		{"use a = x in { use b = a in { b } }", "use a = x in { use b = a in { b; }; }"},
+		{"use v = x + 1 in { v }", "use v = (x + 1) in { v; }"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		if _, ok := program.Statements[0].(*ast.UseStatement); !ok {
+			t.Errorf("%q: expected a use statement, got %T", tt.input, program.Statements[0])
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"use a = 1 { a }", "expected next token to be IN"},
+		{"use self = 1 in { }", "'self' can not be used as an alias"},
+		{"use a = 1 in a", "expected next token to be {"},
+	}
+	for _, tt := range errorTests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestPowerOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(a + b) ** 2", "((a + b) ** 2)"},
+		{"(a) ** (b) ** (c)", "(a ** (b ** c))"}, //right-associative
+		{"a ** b ** c", "(a ** (b ** c))"},
+		{"(a ** b) ** c", "((a ** b) ** c)"},
+		{"2 * (a) ** 2", "(2 * (a ** 2))"},
+		{"-a ** 2", "(-(a ** 2))"},
+		{"f(x) ** (y + 1)", "(f(x) ** (y + 1))"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestSpreadArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		variadic bool
+	}{
+		{"f(a, b, rest...)", true},
+		{"f(rest...)", true},
+		{"f(a, b)", false},
+		{"obj.f(rest...)", true},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.input { //String() round-trips the '...'
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.input, program.String())
+		}
+		expr := program.Statements[0].(*ast.ExpressionStatement).Expression
+		if mc, ok := expr.(*ast.MethodCallExpression); ok {
+			expr = mc.Call
+		}
+		if call := expr.(*ast.CallExpression); call.Variadic != tt.variadic {
+			t.Errorf("%q: expected Variadic=%t, got %t", tt.input, tt.variadic, call.Variadic)
+		}
+	}
+
+	checkParseError(t, "f(a..., b)", "can only have '...' after last argument")
+}
+
+func TestMultiAssignRotation(t *testing.T) {
+	tests := []struct {
+		input  string
+		names  string
+		values string
+	}{
+		{"a, b = b, a", "a, b", "b, a"},
+		{"a, b, c = c, a, b", "a, b, c", "c, a, b"},
+		{"a[0], b.c = b.c, a[0]", "(a[0]), b.c", "b.c, (a[0])"},
+		{"a, b = f()", "a, b", "f()"}, //a function returning multiple values
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		ma, ok := program.Statements[0].(*ast.MultiAssignStatement)
+		if !ok {
+			t.Errorf("%q: expected a multiple assignment, got %T", tt.input, program.Statements[0])
+			continue
+		}
+		var names, values []string
+		for _, n := range ma.Names {
+			names = append(names, n.String())
+		}
+		for _, v := range ma.Values {
+			values = append(values, v.String())
+		}
+		if strings.Join(names, ", ") != tt.names || strings.Join(values, ", ") != tt.values {
+			t.Errorf("%q: expected %s = %s, got %q = %q", tt.input, tt.names, tt.values, names, values)
+		}
+	}
+
+	checkParseError(t, "a, b, c = 1, 2", "assignment mismatch: 3 variables but 2 values")
+	checkParseError(t, "a, b = 1, 2, 3", "assignment mismatch: 2 variables but 3 values")
+}
+
+func TestNamedFunctionLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		expected string
+	}{
+		{"fn() { 1 }", "", "fn() {1;}"},
+		{"fn add(a, b) { a + b }", "add", "fn add(a, b) {(a + b);}"},
+		{"let f = fn add(a,b) { a }", "add", "let f = fn add(a, b) {a;}"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var fn *ast.FunctionLiteral
+		ast.Walk(program, func(node ast.Node) bool {
+			if f, ok := node.(*ast.FunctionLiteral); ok {
+				fn = f
+			}
+			return fn == nil
+		})
+		if fn == nil || fn.Name != tt.name {
+			t.Errorf("%q: expected the function name %q, got %v", tt.input, tt.name, fn)
+		}
+	}
+}
+
+func TestOptionalIndex(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		optional []bool //from the outermost index
+	}{
+		{"a?[i]", "(a?[i])", []bool{true}},
+		{"a?[i]?[j]", "((a?[i])?[j])", []bool{true, true}},
+		{"a?[i][j]", "((a?[i])[j])", []bool{false, true}},
+		{"a[i]", "(a[i])", []bool{false}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var optional []bool
+		expr := program.Statements[0].(*ast.ExpressionStatement).Expression
+		for {
+			index, ok := expr.(*ast.IndexExpression)
+			if !ok {
+				break
+			}
+			optional = append(optional, index.Optional)
+			expr = index.Left
+		}
+		if fmt.Sprint(optional) != fmt.Sprint(tt.optional) {
+			t.Errorf("%q: expected Optional %v, got %v", tt.input, tt.optional, optional)
+		}
+	}
+}
+
+func TestWhereClause(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		names    []string //the names bound by the where clause
+	}{
+		{"fn area(r) { return pi * r * r } where pi = 3.14159", "fn area(r) {return ((pi * r) * r);} where pi = 3.14159", []string{"pi"}},
+		{"let f = fn(x) { x + a + b } where a = 1, b = a + 1", "let f = fn(x) {((x + a) + b);} where a = 1, b = (a + 1)", []string{"a", "b"}},
+		{"fn g() { 1 }", "fn g() {1;}", nil},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var fn *ast.FunctionLiteral
+		ast.Walk(program, func(node ast.Node) bool {
+			if f, ok := node.(*ast.FunctionLiteral); ok {
+				fn = f
+			}
+			return fn == nil
+		})
+		if fn == nil || len(fn.Where) != len(tt.names) {
+			t.Errorf("%q: expected %d where bindings, got %v", tt.input, len(tt.names), fn)
+			continue
+		}
+		for i, name := range tt.names {
+			if got := fn.Where[i].Names[0].Value; got != name {
+				t.Errorf("%q: expected the binding %d to be %q, got %q", tt.input, i, name, got)
+			}
+		}
+	}
+
+	checkParseError(t, "fn g() { 1 } where", "expected next token to be IDENTIFIER")
+	checkParseError(t, "fn g() { 1 } where a", "expected next token to be =")
+}
+
+func TestIncrementDecrement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		postfix  bool
+	}{
+		{"i++", "(i++)", true},
+		{"i--", "(i--)", true},
+		{"--j", "(--j)", false},
+		{"++x", "(++x)", false},
+		{"arr[0]++", "((arr[0])++)", true},
+		{"a.b++", "(a.b++)", true},
+		{"x++ + 1", "((x++) + 1)", true},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var postfix, prefix bool
+		ast.Walk(program, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.PostfixExpression:
+				postfix = true
+			case *ast.PrefixExpression:
+				prefix = n.Operator == "++" || n.Operator == "--"
+			}
+			return true
+		})
+		if postfix != tt.postfix || prefix == tt.postfix {
+			t.Errorf("%q: expected postfix=%t, got postfix=%t prefix=%t", tt.input, tt.postfix, postfix, prefix)
+		}
+	}
+}
+
+func TestBlankIdentifier(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"_ = f()", "_=f()"},
+		{"a, _ = pair", "a, _ = pair"},
+		{"_, b = 1, 2", "_, b = 1, 2"},
+		{"a, _, c = f()", "a, _, c = f()"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	checkParseError(t, "a, _, c = 1, 2", "assignment mismatch: 3 variables but 2 values")
+}
+
+func TestBitwiseNot(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"~x", "(~x)"},
+		{"~a + b", "((~a) + b)"},
+		{"~~x", "(~(~x))"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestIndexMethodChains(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		chain    []string //the node types from the outermost, following the object or the indexed value
+	}{
+		{"matrix[i][j].round().toString()", "((matrix[i])[j]).round().toString()",
+			[]string{"MethodCallExpression", "MethodCallExpression", "IndexExpression", "IndexExpression", "Identifier"}},
+		{"a[0].b[1].c()", "((a[0]).b[1]).c()",
+			[]string{"MethodCallExpression", "IndexExpression", "MethodCallExpression", "IndexExpression", "Identifier"}},
+		{"f(x)[0].g()", "(f(x)[0]).g()",
+			[]string{"MethodCallExpression", "IndexExpression", "CallExpression"}},
+		{"s[0].upper().len() + 1", "((s[0]).upper().len() + 1)", nil},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		if tt.chain == nil {
+			continue
+		}
+		var chain []string
+		expr := program.Statements[0].(*ast.ExpressionStatement).Expression
+		for expr != nil {
+			chain = append(chain, expr.NodeType())
+			switch e := expr.(type) {
+			case *ast.MethodCallExpression:
+				expr = e.Object
+			case *ast.IndexExpression:
+				expr = e.Left
+			default:
+				expr = nil
+			}
+		}
+		if strings.Join(chain, " ") != strings.Join(tt.chain, " ") {
+			t.Errorf("%q: expected the chain %v, got %v", tt.input, tt.chain, chain)
+		}
+	}
+}
+
+func TestUnclosedParenthesis(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(1 + 2", "<1:1> - unclosed parenthesis opened at line 1, column 1"},
+		{"x = (\n1 + 2", "unclosed parenthesis opened at line 1, column 5"},
+		{"let a = 1 + (2 * (3", "unclosed parenthesis opened at line 1, column 18"},
+		{"f((1, 2)", "unclosed '(' opened at line 1, column 2"},
+	}
+
+	for _, tt := range tests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestHashConditionalValues(t *testing.T) {
+	tests := []struct {
+		input  string
+		values []string //the node types of the values, in the declaration order
+	}{
+		{`let h = {"status": if ok { "up" } else { "down" }}`, []string{"IfExpression"}},
+		{`let h = {status: if ok { "up" } else { "down" }, "n": 1}`, []string{"IfExpression", "NumberLiteral"}},
+		{`let h = {"level": switch x { case 1 { "low" } default { "high" } }, "n": 1}`, []string{"SwitchExpression", "NumberLiteral"}},
+		{`let h = {"a": if ok { [1] } else { [] }, "c": switch x { case 1 { 2 } }}`, []string{"IfExpression", "SwitchExpression"}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if len(program.Statements) != 1 {
+			t.Errorf("%q: expected 1 statement, got %d", tt.input, len(program.Statements))
+			continue
+		}
+		hash, ok := program.Statements[0].(*ast.LetStatement).Values[0].(*ast.HashLiteral)
+		if !ok {
+			t.Errorf("%q: expected a hash literal, got %s", tt.input, program.String())
+			continue
+		}
+		var values []string
+		for _, key := range hash.Order {
+			values = append(values, hash.Pairs[key].NodeType())
+		}
+		if strings.Join(values, " ") != strings.Join(tt.values, " ") {
+			t.Errorf("%q: expected the values %v, got %v", tt.input, tt.values, values)
+		}
+	}
+}
+
+func TestPrefixOnCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		right    string //the node type of the prefix operand
+	}{
+		{"-compute()", "(-compute())", "CallExpression"},
+		{"+getOffset()", "(+getOffset())", "CallExpression"},
+		{"-a.b()", "(-a.b())", "MethodCallExpression"},
+		{"!f()", "(!f())", "CallExpression"},
+		{"-a[0]", "(-(a[0]))", "IndexExpression"},
+		{"-a ** 2", "(-(a ** 2))", "InfixExpression"},
+		{"-f() ** 2", "(-(f() ** 2))", "InfixExpression"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		prefix, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.PrefixExpression)
+		if !ok {
+			t.Errorf("%q: expected a prefix expression at the top", tt.input)
+			continue
+		}
+		if prefix.Right.NodeType() != tt.right {
+			t.Errorf("%q: expected the operand to be %s, got %s", tt.input, tt.right, prefix.Right.NodeType())
+		}
+	}
+
+	program := parseProgram(t, "2 ** -1")
+	if got := program.String(); got != "(2 ** (-1))" {
+		t.Errorf("expected (2 ** (-1)), got %s", got)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string //the positions of the errors
+	}{
+		{"let = 1\nx +* 2\nlet y = (3", []string{"<1:5>", "<2:4>", "<3:9>"}},
+		{"let = 1; let x = 2 +; let z = 3 +* 1", []string{"<1:5>", "<1:21>", "<1:34>"}},
+		{"fn f() {\n let = 1\n let a = 2\n}\nlet = 3", []string{"<2:6>", "<5:5>"}},
+	}
+
+	for _, tt := range tests {
+		errors := parseErrors(tt.input)
+		if len(errors) != len(tt.expected) {
+			t.Errorf("%q: expected %d errors, got %d: %q", tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+		for i, pos := range tt.expected {
+			if !strings.Contains(errors[i], pos) {
+				t.Errorf("%q: expected the error %d at %s, got %q", tt.input, i, pos, errors[i])
+			}
+		}
+	}
+
+	//the statements after a broken one are still parsed
+	p := NewParser(lexer.NewLexer("let a = 1 +* 2\nlet b = 2\nlet c = 3"))
+	program := p.ParseProgram()
+	if n := len(program.Statements); n < 2 {
+		t.Fatalf("expected the statements after the error to be parsed, got %d statements", n)
+	}
+	stmts := program.Statements[len(program.Statements)-2:]
+	if stmts[0].String() != "let b = 2" || stmts[1].String() != "let c = 3" {
+		t.Errorf("expected the statements after the error to be parsed, got %s and %s", stmts[0], stmts[1])
+	}
+}
+
+func TestExportList(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		names    []string
+	}{
+		{"export { a, b, c }", "export { a, b, c }", []string{"a", "b", "c"}},
+		{"export { a }", "export { a }", []string{"a"}},
+		{"export { a, }", "export { a }", []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		stmt, ok := program.Statements[0].(*ast.ExportStatement)
+		if !ok {
+			t.Errorf("%q: expected an export statement, got %T", tt.input, program.Statements[0])
+			continue
+		}
+		if strings.Join(stmt.Names, " ") != strings.Join(tt.names, " ") {
+			t.Errorf("%q: expected the names %v, got %v", tt.input, tt.names, stmt.Names)
+		}
+	}
+
+	checkParseError(t, "export { a, b, a }", "duplicate name 'a' in export list")
+}
+
+func TestStaticMethods(t *testing.T) {
+	const input = "struct P {\nstatic fn Origin() { P() }\nfn Sum() { 1 }\n}"
+	program := parseProgram(t, input)
+	if got, expected := program.String(), "struct P{ static fn Origin() {P();};fn Sum() {1;}; }"; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	s := program.Statements[0].(*ast.StructStatement)
+	static := map[string]bool{}
+	ast.Walk(s, func(node ast.Node) bool {
+		if fn, ok := node.(*ast.FunctionLiteral); ok {
+			static[fn.Name] = fn.Static
+		}
+		return true
+	})
+	if len(static) != 2 || !static["Origin"] || static["Sum"] {
+		t.Errorf("expected Origin to be static and Sum not, got %v", static)
+	}
+	if s.StaticMethod("Origin") == nil || s.StaticMethod("Sum") != nil {
+		t.Errorf("expected StaticMethod() to only find Origin")
+	}
+
+	checkParseError(t, "struct P {\nstatic let x = 1\n}", "expected next token to be FUNCTION, got LET instead")
+	checkParseError(t, "static fn f() {}", "no prefix parse functions for 'STATIC' found")
+}
+
+func TestAssignmentTargets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		rangeIdx bool //the target is indexed by a range
+	}{
+		{"arr[1..3] = [x, y, z]", "(arr[(1..3)])=[x, y, z]", true},
+		{"arr[1..<3] = []", "(arr[(1..<3)])=[]", true},
+		{"arr[0] = 1", "(arr[0])=1", false},
+		{"a.b = 2", "a.b=2", false},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		assign := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.AssignExpression)
+		var isRange bool
+		if index, ok := assign.Name.(*ast.IndexExpression); ok {
+			_, isRange = index.Index.(*ast.RangeExpression)
+		}
+		if isRange != tt.rangeIdx {
+			t.Errorf("%q: expected a range index to be %t, got %t", tt.input, tt.rangeIdx, isRange)
+		}
+	}
+
+	checkParseError(t, "1 = 2", "invalid assignment target '1'")
+	checkParseError(t, "f() = 3", "invalid assignment target 'f()'")
+}
+
+func TestTrailingClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		params   int //the parameters of the closure
+	}{
+		{"arr.each { item -> process(item) }", "arr.each(fn(item) {process(item);})", 1},
+		{"arr.each { process(it) }", "arr.each(fn() {process(it);})", 0},
+		{"f(1) { x -> x }", "f(1, fn(x) {x;})", 1},
+		{"f() { 7 }", "f(fn() {7;})", 0},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var fn *ast.FunctionLiteral
+		ast.Walk(program, func(node ast.Node) bool {
+			if f, ok := node.(*ast.FunctionLiteral); ok {
+				fn = f
+			}
+			return fn == nil
+		})
+		if fn == nil || len(fn.Parameters) != tt.params {
+			t.Errorf("%q: expected a closure with %d parameters, got %v", tt.input, tt.params, fn)
+		}
+	}
+}
+
+func TestTernaryAndElvis(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		top      string //the node type at the top, an elvis is an infix expression
+	}{
+		{"a ? b : c", "(a ? b : c)", "TernaryExpression"},
+		{"a ?: c", "(a ?: c)", "InfixExpression"},
+		{"a ? b : c ?: d", "(a ? b : (c ?: d))", "TernaryExpression"},
+		{"a ?: b ? c : d", "(a ?: (b ? c : d))", "InfixExpression"},
+		{"a ?: b ?: c", "(a ?: (b ?: c))", "InfixExpression"},
+		{"a ? b ? c : d : e", "(a ? (b ? c : d) : e)", "TernaryExpression"},
+		{"x = a ? 1 : 2", "x=(a ? 1 : 2)", "AssignExpression"},
+		{"c ?[1] : [2]", "(c ? [1] : [2])", "TernaryExpression"}, //'?[' followed by a ':' is not an optional index
+		{"c ?.5 : 1", "(c ? .5 : 1)", "TernaryExpression"},
+		{"x + c ?[1] : [2]", "((x + c) ? [1] : [2])", "TernaryExpression"},
+		{"a ? c ?[1] : [2] : [3]", "(a ? (c ? [1] : [2]) : [3])", "TernaryExpression"},
+		{"a ? (c ?[1] : [2]) : 3", "(a ? (c ? [1] : [2]) : 3)", "TernaryExpression"},
+		{"a ? b?[0] : c", "(a ? (b?[0]) : c)", "TernaryExpression"}, //the ':' is the one of the outer ternary
+		{"f(c ?[1, 2] : [])", "f((c ? [1, 2] : []))", "CallExpression"},
+		{"a[b?[0]:c]", "(a[(b?[0]):c])", "SliceExpression"}, //the ':' of the slice
+		{"x = a?[0]\ny = b ? 1 : 2", "x=(a?[0])y=(b ? 1 : 2)", "AssignExpression"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		if top := program.Statements[0].(*ast.ExpressionStatement).Expression.NodeType(); top != tt.top {
+			t.Errorf("%q: expected a %s, got %s", tt.input, tt.top, top)
+		}
+	}
+
+	program := parseProgram(t, "let h = {b?[0]: c, d: e ?[1] : [2]}") //the ':' of the hash key
+	if got, expected := program.String(), "let h = {(b?[0]): c, d: (e ? [1] : [2])}"; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	checkParseError(t, "a ? b", "expected next token to be :, got EOF instead")
+}
+
+func TestDestructuringDefaults(t *testing.T) {
+	tests := []struct {
+		input    string
+		pattern  string   //the node type of the pattern
+		defaults []string //the defaults of the elements, "" if none
+	}{
+		{"let {x = 0, y = 0} = point", "HashPattern", []string{"0", "0"}},
+		{"let {x, y = 2} = p", "HashPattern", []string{"", "2"}},
+		{"let [a = 1, b = 2] = xs", "ArrayPattern", []string{"1", "2"}},
+		{"let [a, b = f()] = xs", "ArrayPattern", []string{"", "f()"}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.input {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.input, program.String())
+		}
+		let := program.Statements[0].(*ast.LetStatement)
+		var elements []*ast.PatternElement
+		switch p := let.Pattern.(type) {
+		case *ast.HashPattern:
+			elements = p.Elements
+		case *ast.ArrayPattern:
+			elements = p.Elements
+		}
+		if let.Pattern == nil || let.Pattern.NodeType() != tt.pattern {
+			t.Errorf("%q: expected a %s, got %v", tt.input, tt.pattern, let.Pattern)
+			continue
+		}
+		var defaults []string
+		for _, e := range elements {
+			if e.Default == nil {
+				defaults = append(defaults, "")
+			} else {
+				defaults = append(defaults, e.Default.String())
+			}
+		}
+		if strings.Join(defaults, ",") != strings.Join(tt.defaults, ",") {
+			t.Errorf("%q: expected the defaults %q, got %q", tt.input, tt.defaults, defaults)
+		}
+	}
+
+	checkParseError(t, "let {x = } = p", "no prefix parse functions for '}' found")
+}
+
+func TestPrecPragma(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#prec(+, 13)\na = 2*3+4", "a=(2 * (3 + 4))"},
+		{"#prec(+, 13)\n#prec(-, 13)\n2*3-4", "(2 * (3 - 4))"},
+		{"#prec(*, 2)\n2*3+4", "(2 * (3 + 4))"},
+		{"let x = 1\n#prec(+, 13)\n2*3+4", "let x = 1((2 * 3) + 4)"}, //only before the first statement
+		{"a = 2*3+4", "a=((2 * 3) + 4)"},                             //the pragmas of another parse are not kept
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	program := parseProgram(t, "#prec(+, 13)\n1")
+	if len(program.Precedences) != 1 || program.Precedences["+"] != 13 {
+		t.Errorf("expected the pragma to be recorded on the program, got %v", program.Precedences)
+	}
+
+	checkParseError(t, "#prec(a, 5)\n1", "'a' is not an infix operator")
+	checkParseError(t, "#prec(+)\n1", "expected '#prec(operator, precedence)'")
+	checkParseError(t, "#prec(+, 100)\n1", "precedence should be an integer between 2 and 17")
+	checkParseError(t, "#prec(+, 1)\n1", "precedence should be an integer between 2 and 17")
+}
+
+func TestDeclareAssign(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x := 5", "x:=5"},
+		{"x := y := 1", "x:=y:=1"},
+		{"if (y := f()) > 0 { y }", "if (y:=f() > 0) { y; }"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	//the declaration is the left operand of '>', not 'y := (f() > 0)'
+	program := parseProgram(t, "if (y := f()) > 0 { y }")
+	cond := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression).Conditions[0].Cond
+	infix, ok := cond.(*ast.InfixExpression)
+	if !ok || infix.Operator != ">" {
+		t.Fatalf("expected a '>' condition, got %T", cond)
+	}
+	if decl, ok := infix.Left.(*ast.DeclareAssignExpression); !ok || decl.Name.Value != "y" {
+		t.Errorf("expected 'y := f()' on the left of '>', got %T", infix.Left)
+	}
+
+	checkParseError(t, "1 := 2", "invalid declaration target '1' of ':='")
+}
+
+//representative expressions at the boundary of each two adjacent levels of the precedence table
+func TestOperatorPrecedence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a = b ? c : d", "a=(b ? c : d)"},       //ASSIGN < TERNARY
+		{"a += b || c", "a+=(b || c)"},           //ASSIGN < CONDOR
+		{"a = b == c", "a=(b == c)"},             //ASSIGN < EQUALS
+		{"a ? b : c ?? d", "(a ? b : (c ?? d))"}, //TERNARY < NILCOALESCE
+		{"a ?? b || c", "(a ?? (b || c))"},       //NILCOALESCE < CONDOR
+		{"a || b |> f", "f((a || b))"},           //PIPE < CONDOR
+		{"a || b && c", "(a || (b && c))"},       //CONDOR < CONDAND
+		{"a && b == c", "(a && (b == c))"},       //CONDAND < EQUALS
+		{"a == b < c", "(a == (b < c))"},         //EQUALS < LESSGREATER
+		{"a < b .. c", "(a < (b..c))"},           //LESSGREATER < RANGE
+		{"a .. b + 1", "(a..(b + 1))"},           //RANGE < SUM
+		{"a + b * c", "(a + (b * c))"},           //SUM < PRODUCT
+		{"a * b =~ c", "(a * (b =~ c))"},         //PRODUCT < REGEXP_MATCH
+		{"a * -b", "(a * (-b))"},                 //PRODUCT < PREFIX
+		{"-a ** 2", "(-(a ** 2))"},               //PREFIX < POWER
+		{"-a++", "(-(a++))"},                     //PREFIX < INCREMENT
+		{"-f(x)", "(-f(x))"},                     //PREFIX < CALL
+		{"a[i]++", "((a[i])++)"},                 //INCREMENT < CALL
+		{"a + b - c", "((a + b) - c)"},           //left-associative
+		{"a = b = c", "a=b=c"},                   //right-associative
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestElif(t *testing.T) {
+	tests := []struct {
+		input      string
+		expected   string
+		conditions int
+		hasElse    bool
+	}{
+		{"if a { 1 } elif b { 2 } elif c { 3 }", "if a { 1; } elif b { 2; } elif c { 3; }", 3, false},
+		{"if a { 1 } elif b { 2 } else { 3 }", "if a { 1; } elif b { 2; } else { 3; }", 2, true},
+		{"if a { 1 } else if b { 2 } elif c { 3 } else { 4 }", "if a { 1; } elif b { 2; } elif c { 3; } else { 4; }", 3, true},
+		{"if a { 1 } elif b { 2 } else if c { 3 }", "if a { 1; } elif b { 2; } elif c { 3; }", 3, false},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		ifExpr := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+		if len(ifExpr.Conditions) != tt.conditions || (ifExpr.Alternative != nil) != tt.hasElse {
+			t.Errorf("%q: expected %d conditions and else=%t, got %d and else=%t", tt.input,
+				tt.conditions, tt.hasElse, len(ifExpr.Conditions), ifExpr.Alternative != nil)
+		}
+	}
+}
+
+func TestMethodChains(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		calls    []string //the calls from the outermost, following the objects
+		object   string   //the innermost object
+	}{
+		{`"abc".upper().reverse()`, "abc.upper().reverse()", []string{"reverse()", "upper()"}, "abc"},
+		{"arr.filter(f).map(g).length", "arr.filter(f).map(g).length", []string{"length", "map(g)", "filter(f)"}, "arr"},
+		{"a.b().c().d", "a.b().c().d", []string{"d", "c()", "b()"}, "a"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var calls []string
+		expr := program.Statements[0].(*ast.ExpressionStatement).Expression
+		for {
+			mc, ok := expr.(*ast.MethodCallExpression)
+			if !ok {
+				break
+			}
+			calls = append(calls, mc.Call.String())
+			expr = mc.Object
+		}
+		if strings.Join(calls, " ") != strings.Join(tt.calls, " ") || expr.String() != tt.object {
+			t.Errorf("%q: expected the calls %v on %s, got %v on %s", tt.input, tt.calls, tt.object, calls, expr.String())
+		}
+	}
+}
+
+func TestFallthroughTarget(t *testing.T) {
+	tests := []struct {
+		input  string
+		target string //"" if falls through to the next case
+	}{
+		{"switch x { case 1 { fallthrough case 3 } case 2 { 2 } case 3 { 3 } }", "3"},
+		{"switch x { case 1 { fallthrough } case 2 { 2 } }", ""},
+		{`switch x { case "a" { fallthrough case "b" } case "b" { 2 } }`, "b"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		var ft *ast.FallthroughExpression
+		ast.Walk(program, func(node ast.Node) bool {
+			if f, ok := node.(*ast.FallthroughExpression); ok {
+				ft = f
+			}
+			return ft == nil
+		})
+		if ft == nil {
+			t.Errorf("%q: no fallthrough found", tt.input)
+			continue
+		}
+		target := ""
+		if ft.Target != nil {
+			target = ft.Target.String()
+		}
+		if target != tt.target {
+			t.Errorf("%q: expected the target %q, got %q", tt.input, tt.target, target)
+		}
+	}
+
+	checkParseError(t, "switch x { case 1 { fallthrough case 9 } case 2 { 2 } }", "fallthrough target 'case 9' not found after the current case")
+	checkParseError(t, "switch x { case 1 { fallthrough case 1 } case 2 { 2 } }", "fallthrough target 'case 1' not found after the current case")
+	checkParseError(t, "switch x { case 3 { 3 } case 1 { fallthrough case 3 } }", "cannot fallthrough final case in switch")
+}
+
+func TestSliceExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		bounds   string //which of low, high and step are present, e.g. "lh-"
+	}{
+		{"a[1:3]", "(a[1:3])", "lh-"},
+		{"a[:2]", "(a[:2])", "-h-"},
+		{"a[1:]", "(a[1:])", "l--"},
+		{"a[:]", "(a[:])", "---"},
+		{"a[::]", "(a[:])", "---"},
+		{"a[1:3:2]", "(a[1:3:2])", "lhs"},
+		{"a[:3:]", "(a[:3])", "-h-"},
+		{"a[::2]", "(a[::2])", "--s"},
+		{"a?[1:]", "(a?[1:])", "l--"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		slice, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.SliceExpression)
+		if !ok {
+			t.Errorf("%q: expected a slice expression", tt.input)
+			continue
+		}
+		bounds := []byte("---")
+		for i, b := range []ast.Expression{slice.Low, slice.High, slice.Step} {
+			if b != nil {
+				bounds[i] = "lhs"[i]
+			}
+		}
+		if string(bounds) != tt.bounds {
+			t.Errorf("%q: expected the bounds %s, got %s", tt.input, tt.bounds, bounds)
+		}
+	}
+}
+
+func TestOptionalCall(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		optional string //the optional nodes, from the outermost
+	}{
+		{"f?.()", "f?.()", "CallExpression"},
+		{"f?.(1, 2)", "f?.(1, 2)", "CallExpression"},
+		{"obj.m?.(x)", "obj.m?.(x)", "CallExpression"},
+		{"obj?.method?.()", "obj?.method?.()", "CallExpression MethodCallExpression"},
+		{"f()", "f()", ""},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var optional []string
+		ast.Walk(program, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.CallExpression:
+				if n.Optional {
+					optional = append(optional, n.NodeType())
+				}
+			case *ast.MethodCallExpression:
+				if n.Optional {
+					optional = append(optional, n.NodeType())
+				}
+			}
+			return true
+		})
+		if strings.Join(optional, " ") != tt.optional {
+			t.Errorf("%q: expected the optional nodes %q, got %q", tt.input, tt.optional, optional)
+		}
+	}
+}
+
+func TestTrailingCommas(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2,]", "[1, 2]"},
+		{"[1,\n2,\n]", "[1, 2]"},
+		{`let h = {"a": 1,}`, "let h = {a: 1}"},
+		{"(1, 2,)", "(1, 2)"},
+		{"f(1, 2,)", "f(1, 2)"},
+		{"obj.m(1,)", "obj.m(1)"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	for _, input := range []string{"[1,,2]", "f(1,,2)", "(1,,2)", `let h = {"a": 1,,}`, "[,]"} {
+		checkParseError(t, input, "no prefix parse functions for ',' found")
+	}
+}
+
+func TestCommentsInLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[\n  1, // first\n  2, // second\n]", "[1, 2]"},
+		{"[\n  1, # first\n  2 # second\n]", "[1, 2]"},
+		{"[1, /* a */ 2]", "[1, 2]"},
+		{"let h = {\n  \"a\": 1, // one\n  // standalone\n  \"b\": 2, /* two */\n}", "let h = {a: 1, b: 2}"},
+		{"f(\n  1, // x\n  2, // y\n)", "f(1, 2)"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestIfAsValue(t *testing.T) {
+	const input = `let grade = if s >= 90 { "A" } elif s >= 80 { "B" } else { "C" }`
+	program := parseProgram(t, input)
+	if got, expected := program.String(), "let grade = if (s >= 90) { A; } elif (s >= 80) { B; } else { C; }"; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	if let.Names[0].Value != "grade" {
+		t.Errorf("expected the target grade, got %s", let.Names[0].Value)
+	}
+	ifExpr, ok := let.Values[0].(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("expected an if expression value, got %T", let.Values[0])
+	}
+	var branches []string
+	for _, c := range ifExpr.Conditions {
+		branches = append(branches, c.Body.String())
+	}
+	if ifExpr.Alternative != nil {
+		branches = append(branches, ifExpr.Alternative.String())
+	}
+	if got := strings.Join(branches, " "); got != "A; B; C;" {
+		t.Errorf("expected the branches A, B and C, got %s", got)
+	}
+}
+
+func TestScopeResolution(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		chain    []string //the members from the outermost, following the left sides
+	}{
+		{"math::sin", "math::sin", []string{"sin"}},
+		{"Color::Red", "Color::Red", []string{"Red"}},
+		{"math::sqrt(2)", "math::sqrt(2)", []string{"sqrt(2)"}},
+		{"a::b::c", "a::b::c", []string{"c", "b"}},
+		{"a::b::c(1)", "a::b::c(1)", []string{"c(1)", "b"}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var chain []string
+		expr := program.Statements[0].(*ast.ExpressionStatement).Expression
+		for {
+			sr, ok := expr.(*ast.ScopeResolution)
+			if !ok {
+				break
+			}
+			chain = append(chain, sr.Member.String())
+			expr = sr.Left
+		}
+		if strings.Join(chain, " ") != strings.Join(tt.chain, " ") {
+			t.Errorf("%q: expected the members %v, got %v", tt.input, tt.chain, chain)
+		}
+	}
+}
+
+func TestArrayComprehension(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		vars     []string //the variables of the 'for' clauses from left to right
+	}{
+		{"[x for x in 1..10]", "[x for x in (1..10)]", []string{"x"}},
+		{"[x * y for x in 1..3 for y in 1..3]", "[(x * y) for x in (1..3) for y in (1..3)]", []string{"x", "y"}},
+		{"[x + 1 for x in arr]", "[(x + 1) for x in arr]", []string{"x"}},
+		{"[[x, y] for x in 1..2 for y in x..2]", "[[x, y] for x in (1..2) for y in (x..2)]", []string{"x", "y"}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		comp, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ArrayComprehension)
+		if !ok {
+			t.Fatalf("%q: expected an array comprehension, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+		var vars []string
+		for _, c := range comp.Clauses {
+			vars = append(vars, c.Var)
+		}
+		if strings.Join(vars, " ") != strings.Join(tt.vars, " ") {
+			t.Errorf("%q: expected the variables %v, got %v", tt.input, tt.vars, vars)
+		}
+	}
+
+	//the sources of the clauses are the ranges
+	comp := parseProgram(t, "[x * y for x in 1..3 for y in 1..3]").Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ArrayComprehension)
+	for _, c := range comp.Clauses {
+		if _, ok := c.Value.(*ast.RangeExpression); !ok {
+			t.Errorf("expected a range source, got %T", c.Value)
+		}
+	}
+
+	//an array literal is not affected
+	if _, ok := parseProgram(t, "[x, y]").Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ArrayLiteral); !ok {
+		t.Errorf("expected an array literal for [x, y]")
+	}
+
+	checkParseError(t, "[x for y]", "expected next token to be IN")
+	checkParseError(t, "[x for x in]", "no prefix parse functions for ']'")
+	checkParseError(t, "[x for x in 1..3", "unclosed '[' opened at line 1, column 1")
+}
+
+func TestReturnCoalesce(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string //the return values
+	}{
+		{"fn() { return a ?? b }", []string{"(a ?? b)"}},
+		{"fn() { return a ?? b, c }", []string{"(a ?? b)", "c"}},
+		{"fn() { return a ?? b ?? c, d ?? e }", []string{"(a ?? (b ?? c))", "(d ?? e)"}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+		ret, ok := fn.Body.Statements[0].(*ast.ReturnStatement)
+		if !ok {
+			t.Fatalf("%q: expected a return statement, got %T", tt.input, fn.Body.Statements[0])
+		}
+		var values []string
+		for _, v := range ret.ReturnValues {
+			values = append(values, v.String())
+		}
+		if strings.Join(values, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("%q: expected the return values %v, got %v", tt.input, tt.expected, values)
+		}
+		if ret.ReturnValue != ret.ReturnValues[0] {
+			t.Errorf("%q: expected ReturnValue to be the first return value", tt.input)
+		}
+	}
+}
+
+func TestTypedArrowFunction(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(x, y): Number => { return x + y }", "fn(x, y): Number {return (x + y);}"}, //block body
+		{"(x, y): Number => x + y", "fn(x, y): Number {(x + y);}"},                   //expression body
+		{"fn add(x, y): Number { x + y }", "fn add(x, y): Number {(x + y);}"},
+		{"let f = fn(x): String { x }", "let f = fn(x): String {x;}"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	fn := parseProgram(t, "(x, y): Number => { return x + y }").Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(fn.Parameters) != 2 || fn.ReturnType == nil || fn.ReturnType.Value != "Number" {
+		t.Errorf("expected 2 parameters and the return type Number, got %d and %v", len(fn.Parameters), fn.ReturnType)
+	}
+	if _, ok := fn.Body.Statements[0].(*ast.ReturnStatement); !ok {
+		t.Errorf("expected the block body to be kept, got %T", fn.Body.Statements[0])
+	}
+}
+
+func TestDuplicateParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string //"" if no error
+	}{
+		{"fn(a, b...) {}", ""},
+		{"fn f(_, _) {}", ""}, //'_' is not bound
+		{"fn(a, a, b) {}", "<1:7> - duplicate parameter 'a'"},
+		{"fn(a, b, a...) {}", "<1:10> - duplicate parameter 'a'"}, //the variadic one
+		{"fn(a, b = 1, b = 2) {}", "<1:14> - duplicate parameter 'b'"},
+		{"(x, x) => x", "<1:5> - duplicate parameter 'x'"},
+	}
+
+	for _, tt := range tests {
+		if tt.expected == "" {
+			parseProgram(t, tt.input)
+			continue
+		}
+		if errors := parseErrors(tt.input); len(errors) != 1 {
+			t.Errorf("%q: expected one error, got %q", tt.input, errors)
+		}
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestReservedKeywordAsName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let for = 1", "<1:5> - 'for' is a reserved keyword and cannot be used as a name"},
+		{"let a, if = 1, 2", "<1:8> - 'if' is a reserved keyword and cannot be used as a name"},
+		{"fn f(return) {}", "<1:6> - 'return' is a reserved keyword and cannot be used as a name"},
+		{"fn f(a, while...) {}", "<1:9> - 'while' is a reserved keyword and cannot be used as a name"},
+		{`fn f(1, "a") {}`, "<1:6> - expected function parameter to be an identifier, got NUMBER instead"},
+		{`fn(a, "b") {}`, "<1:7> - expected function parameter to be an identifier, got STRING instead"},
+		{"let 1 = 2", "expected token to be identifier|underscore"}, //not a keyword
+	}
+
+	for _, tt := range tests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+
+	parseProgram(t, "let fork = 1; fn f(returned) {}") //a keyword prefix is fine
+}
+
+func TestNotKeyword(t *testing.T) {
+	//'not' has the same PREFIX precedence as '!', so it binds tighter than any infix operator
+	//except the calls, indexes and member accesses: 'not a == b' is '(not a) == b'.
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"not a", "(not a)"},
+		{"not a == b", "((not a) == b)"},
+		{"!a == b", "((!a) == b)"},
+		{"not a && b", "((not a) && b)"},
+		{"not not a", "(not (not a))"},
+		{"not f(x)", "(not f(x))"},
+		{"not a.b", "(not a.b)"},
+		{"not (a == b)", "(not (a == b))"},
+		{"nothing", "nothing"}, //still an identifier
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	prefix, ok := parseProgram(t, "not a").Statements[0].(*ast.ExpressionStatement).Expression.(*ast.PrefixExpression)
+	if !ok || prefix.Operator != "not" || prefix.Token.Type != token.TOKEN_NOT {
+		t.Errorf("expected a prefix expression with the operator 'not', got %#v", prefix)
+	}
+}
+
+func TestMethodCallOnLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		object   string //the type of the receiver
+	}{
+		{`"hello".len()`, "hello.len()", "*ast.StringLiteral"},
+		{"[1, 2, 3].len()", "[1, 2, 3].len()", "*ast.ArrayLiteral"},
+		{`let k = {"a": 1}.keys()`, "let k = {a: 1}.keys()", "*ast.HashLiteral"}, //a '{' statement is a block
+		{"16.sqrt()", "16.sqrt()", "*ast.NumberLiteral"},
+		{"2.5.floor()", "2.5.floor()", "*ast.NumberLiteral"},
+		{"true.toYesNo()", "true.toYesNo()", "*ast.BooleanLiteral"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var expr ast.Expression
+		switch s := program.Statements[0].(type) {
+		case *ast.ExpressionStatement:
+			expr = s.Expression
+		case *ast.LetStatement:
+			expr = s.Values[0]
+		}
+		call, ok := expr.(*ast.MethodCallExpression)
+		if !ok {
+			t.Errorf("%q: expected a method call, got %T", tt.input, expr)
+			continue
+		}
+		if got := fmt.Sprintf("%T", call.Object); got != tt.object {
+			t.Errorf("%q: expected the receiver %s, got %s", tt.input, tt.object, got)
+		}
+	}
+}
+
+func TestOptionalChaining(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		optional []bool //the Optional flags of the member accesses, from the outermost
+	}{
+		{"a?.b", "a?.b", []bool{true}},
+		{"a?.b()", "a?.b()", []bool{true}},
+		{"a?.b?.c", "a?.b?.c", []bool{true, true}},
+		{"a?.b.c", "a?.b.c", []bool{false, true}}, //(a?.b).c
+		{"a.b?.c()", "a.b?.c()", []bool{true, false}},
+		{"a.b.c", "a.b.c", []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+		var optional []bool
+		expr := program.Statements[0].(*ast.ExpressionStatement).Expression
+		for {
+			call, ok := expr.(*ast.MethodCallExpression)
+			if !ok {
+				break
+			}
+			optional = append(optional, call.Optional)
+			expr = call.Object
+		}
+		if fmt.Sprint(optional) != fmt.Sprint(tt.optional) {
+			t.Errorf("%q: expected the optional flags %v, got %v", tt.input, tt.optional, optional)
+		}
+	}
+
+	checkParseError(t, "a?.[0]", "expected next token to be IDENTIFIER, got [ instead")
+	checkParseError(t, "a?.1", "expected next token to be :, got EOF instead") //'?' and '.1', see TestTernaryAndElvis
+	checkParseError(t, "a?.b = 1", "invalid assignment target 'a?.b'")
+}
+
+func TestArrowFunction(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x => x + 1", "fn(x) {(x + 1);}"},
+		{"(a, b) => a + b", "fn(a, b) {(a + b);}"},
+		{"(a) => { a * 2 }", "fn(a) {(a * 2);}"},
+		{"() => 42", "fn() {42;}"},
+		{"let f = x => y => x + y", "let f = fn(x) {fn(y) {(x + y);};}"},
+		{"f(x => x * 2)", "f(fn(x) {(x * 2);})"},
+
+		//not arrow functions
+		{"(a + b)", "(a + b)"},
+		{"(a, b)", "(a, b)"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, program.String())
+		}
+	}
+
+	checkParseError(t, "(a + 1) => a", "Arrow function expects identifiers as arguments")
+	checkParseError(t, "(1, 2) => 3", "Arrow function expects a list of identifiers as arguments")
+}
+
+func TestUnclosedBrackets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2", "<1:1> - unclosed '[' opened at line 1, column 1, got EOF instead"},
+		{"let a = [\n1,\n2", "<1:9> - unclosed '[' opened at line 1, column 9, got EOF instead"},
+		{"let a = [x for x in 1..3", "<1:9> - unclosed '[' opened at line 1, column 9, got EOF instead"},
+		{`let h = {"a": 1`, "<1:9> - unclosed '{' opened at line 1, column 9, got EOF instead"},
+		{`let h = {"a"`, "<1:9> - unclosed '{' opened at line 1, column 9, got EOF instead"},
+		{"let h = {\n\"a\": 1,", "<1:9> - unclosed '{' opened at line 1, column 9, got EOF instead"},
+		{"[1, 2 3]", "expected next token to be ], got NUMBER instead"}, //not at the end of the input
+	}
+
+	for _, tt := range tests {
+		checkParseError(t, tt.input, tt.expected)
+	}
+}
+
+func TestIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input string
+		isInt bool
+		value int64 //the exact value if isInt
+	}{
+		{"5", true, 5},
+		{"5.0", false, 0},
+		{"5e2", false, 0},
+		{".5", false, 0},
+		{"1_000", true, 1000},
+		{"0xFF", true, 255},
+		{"0b101", true, 5},
+		{"0o17", true, 15},
+		{"9007199254740993", true, 9007199254740993}, //beyond 2^53, 'Value' loses the precision
+		{"0x7FFFFFFFFFFFFFFF", true, 9223372036854775807},
+		{"9223372036854775808", false, 0}, //does not fit in an int64
+	}
+
+	for _, tt := range tests {
+		program := parseProgram(t, tt.input)
+		n, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.NumberLiteral)
+		if !ok {
+			t.Fatalf("%q: expected a number literal, got %T", tt.input, program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+		if n.IsInt != tt.isInt || n.Int != tt.value {
+			t.Errorf("%q: expected IsInt %t and Int %d, got %t and %d", tt.input, tt.isInt, tt.value, n.IsInt, n.Int)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"magpie/ast"
+	"time"
+)
+
+//ParseStats is used for diagnosing slow files, see EnableStats().
+type ParseStats struct {
+	LexTime   time.Duration  //time spent in the lexer
+	ParseTime time.Duration  //time spent in the parser, excluding LexTime
+	Tokens    int            //number of tokens read, excluding the EOF
+	Nodes     map[string]int //node kind => count, e.g. "InfixExpression" => 3
+}
+
+//EnableStats makes the parser record the timings and node counts of ParseProgram(),
+//which could be retrieved by Stats(). It should be called before ParseProgram().
+//When not enabled, no stats are recorded. The first tokens are read by NewParser(), they are
+//counted but their lexing is not timed, use WithStats() for that.
+func (p *Parser) EnableStats() {
+	p.stats = &ParseStats{Nodes: make(map[string]int)}
+}
+
+//Stats returns the recorded stats, it returns an empty ParseStats if not enabled.
+func (p *Parser) Stats() ParseStats {
+	if p.stats == nil {
+		return ParseStats{}
+	}
+	stats := *p.stats
+	stats.Tokens = p.tokens
+	return stats
+}
+
+func (p *Parser) nextTokenWithStats() {
+	start := time.Now()
+	p.peekToken = p.l.NextToken()
+	p.stats.LexTime += time.Since(start)
+}
+
+//lexTime is the LexTime when the parse starts, the tokens lexed before are read by NewParser()
+func (p *Parser) recordStats(program *ast.Program, start time.Time, lexTime time.Duration) {
+	p.stats.ParseTime = time.Since(start) - (p.stats.LexTime - lexTime)
+	p.stats.Nodes = ast.NodeCounts(program)
+}
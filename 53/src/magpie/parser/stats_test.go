@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"magpie/lexer"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	const input = "let x = 1 + 2\nlet y = x * 3"
+
+	p := NewParser(lexer.NewLexer(input))
+	p.ParseProgram()
+	if stats := p.Stats(); stats.Tokens != 0 || stats.Nodes != nil {
+		t.Errorf("expected no stats when not enabled, got %+v", stats)
+	}
+
+	for _, p := range []*Parser{
+		NewParser(lexer.NewLexer(input), WithStats()),
+		func() *Parser { p := NewParser(lexer.NewLexer(input)); p.EnableStats(); return p }(),
+	} {
+		p.ParseProgram()
+		stats := p.Stats()
+		if stats.Tokens != 12 { //let x = 1 + 2 let y = x * 3
+			t.Errorf("expected 12 tokens, got %d", stats.Tokens)
+		}
+		if stats.LexTime < 0 || stats.ParseTime < 0 {
+			t.Errorf("expected non-negative timings, got lex=%s parse=%s", stats.LexTime, stats.ParseTime)
+		}
+
+		expected := map[string]int{
+			"Program":         1,
+			"LetStatement":    2,
+			"Identifier":      3, //x, y and the x in 'x * 3'
+			"InfixExpression": 2,
+			"NumberLiteral":   3,
+		}
+		for kind, count := range expected {
+			if stats.Nodes[kind] != count {
+				t.Errorf("expected %d %s nodes, got %d", count, kind, stats.Nodes[kind])
+			}
+		}
+		if len(stats.Nodes) != len(expected) {
+			t.Errorf("expected the node kinds %v, got %v", expected, stats.Nodes)
+		}
+	}
+}
+
+//the tokens read by NewParser(), the pragmas and the ones of the interpolations are counted as well
+func TestStatsTokens(t *testing.T) {
+	tests := []struct {
+		input  string
+		tokens int
+	}{
+		{"", 0},
+		{"x", 1},
+		{"#prec(+, 5)\nlet s = 1", 5},
+		{`let s = "a${x + 1}b"`, 7}, //let s = "..." and x + 1
+		{`"${\"${y}\"}"`, 3},        //a nested interpolation
+	}
+
+	for _, tt := range tests {
+		for _, p := range []*Parser{
+			NewParser(lexer.NewLexer(tt.input), WithStats()),
+			func() *Parser { p := NewParser(lexer.NewLexer(tt.input)); p.EnableStats(); return p }(),
+		} {
+			p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				t.Fatalf("%q: unexpected errors %v", tt.input, p.Errors())
+			}
+			if got := p.Stats().Tokens; got != tt.tokens {
+				t.Errorf("%q: expected %d tokens, got %d", tt.input, tt.tokens, got)
+			}
+		}
+	}
+}
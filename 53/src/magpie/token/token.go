@@ -25,6 +25,9 @@ const (
 	TOKEN_SLASH_A    // /=
 	TOKEN_MOD_A      // %=
 
+	TOKEN_OR_A          // ||=
+	TOKEN_NILCOALESCE_A // ??=
+
 	TOKEN_LPAREN    // (
 	TOKEN_RPAREN    // )
 	TOKEN_ASSIGN    // =
@@ -33,6 +36,8 @@ const (
 	TOKEN_COMMA     //,
 	TOKEN_DOT       //.
 	TOKEN_DOTDOT    //..
+	TOKEN_DOTDOTEQ  //..=
+	TOKEN_DOTDOTLT  //..<
 	TOKEN_ELLIPSIS  //...
 	TOKEN_LBRACE    // {
 	TOKEN_RBRACE    // }
@@ -43,16 +48,30 @@ const (
 	TOKEN_AT        // @
 	TOKEN_CMD       // `
 
-	TOKEN_LT       // <
-	TOKEN_LE       // <=
-	TOKEN_GT       // >
-	TOKEN_GE       // >=
-	TOKEN_EQ       // ==
-	TOKEN_NEQ      // !=
-	TOKEN_MATCH    // =~
-	TOKEN_NOTMATCH // !~
-	TOKEN_FATARROW // =>
-	TOKEN_PIPE     // |>
+	TOKEN_LT          // <
+	TOKEN_LE          // <=
+	TOKEN_GT          // >
+	TOKEN_GE          // >=
+	TOKEN_EQ          // ==
+	TOKEN_NEQ         // !=
+	TOKEN_MATCH       // =~
+	TOKEN_NOTMATCH    // !~
+	TOKEN_FATARROW    // =>
+	TOKEN_PIPE        // |>
+	TOKEN_NILCOALESCE // ??
+
+	TOKEN_OPTIONAL_LBRACKET // ?[
+	TOKEN_OPTIONAL_DOT      // ?.
+
+	TOKEN_TILDE // ~
+
+	TOKEN_THINARROW // ->
+
+	TOKEN_QUESTIONM // ?
+
+	TOKEN_COLONASSIGN // :=
+
+	TOKEN_SCOPE // ::
 
 	TOKEN_AND // &&
 	TOKEN_OR  // ||
@@ -60,6 +79,8 @@ const (
 	TOKEN_NUMBER     //10 or 10.1
 	TOKEN_IDENTIFIER //identifier
 	TOKEN_STRING     //""
+	TOKEN_CHAR       //'a'
+	TOKEN_RAWSTRING  //```raw string```
 
 	//reserved keywords
 	TOKEN_TRUE        //true
@@ -70,6 +91,7 @@ const (
 	TOKEN_FUNCTION    //fn
 	TOKEN_IF          //if
 	TOKEN_ELSE        //else
+	TOKEN_ELIF        //elif
 	TOKEN_WHILE       //while
 	TOKEN_DO          //do
 	TOKEN_FOR         //for
@@ -87,8 +109,18 @@ const (
 	TOKEN_FINALLY     //finally
 	TOKEN_THROW       //throw
 	TOKEN_TAIL        //tail call
+	TOKEN_ASYNC       //async
+	TOKEN_AWAIT       //await
+	TOKEN_MUT         //mut
+	TOKEN_USE         //use
+	TOKEN_WHERE       //where
+	TOKEN_EXPORT      //export
+	TOKEN_STATIC      //static
+	TOKEN_NOT         //not
 
 	TOKEN_REGEX // regular expression
+
+	TOKEN_PRAGMA // #prec(+, 5)
 )
 
 //for debug & testing
@@ -119,6 +151,10 @@ func (tt TokenType) String() string {
 		return "/="
 	case TOKEN_MOD_A:
 		return "%="
+	case TOKEN_OR_A:
+		return "||="
+	case TOKEN_NILCOALESCE_A:
+		return "??="
 
 	case TOKEN_POWER:
 		return "**"
@@ -142,6 +178,10 @@ func (tt TokenType) String() string {
 		return "."
 	case TOKEN_DOTDOT:
 		return ".."
+	case TOKEN_DOTDOTEQ:
+		return "..="
+	case TOKEN_DOTDOTLT:
+		return "..<"
 	case TOKEN_ELLIPSIS:
 		return "..."
 	case TOKEN_LBRACE:
@@ -150,6 +190,16 @@ func (tt TokenType) String() string {
 		return "}"
 	case TOKEN_BANG:
 		return "!"
+	case TOKEN_TILDE:
+		return "~"
+	case TOKEN_THINARROW:
+		return "->"
+	case TOKEN_QUESTIONM:
+		return "?"
+	case TOKEN_COLONASSIGN:
+		return ":="
+	case TOKEN_SCOPE:
+		return "::"
 	case TOKEN_LBRACKET:
 		return "["
 	case TOKEN_RBRACKET:
@@ -180,6 +230,12 @@ func (tt TokenType) String() string {
 		return "=>"
 	case TOKEN_PIPE:
 		return "|>"
+	case TOKEN_NILCOALESCE:
+		return "??"
+	case TOKEN_OPTIONAL_DOT:
+		return "?."
+	case TOKEN_OPTIONAL_LBRACKET:
+		return "?["
 
 	case TOKEN_AND:
 		return "&&"
@@ -192,6 +248,10 @@ func (tt TokenType) String() string {
 		return "IDENTIFIER"
 	case TOKEN_STRING:
 		return "STRING"
+	case TOKEN_CHAR:
+		return "CHAR"
+	case TOKEN_RAWSTRING:
+		return "RAWSTRING"
 
 	case TOKEN_TRUE:
 		return "TRUE"
@@ -209,6 +269,8 @@ func (tt TokenType) String() string {
 		return "IF"
 	case TOKEN_ELSE:
 		return "ELSE"
+	case TOKEN_ELIF:
+		return "ELIF"
 	case TOKEN_WHILE:
 		return "WHILE"
 	case TOKEN_DO:
@@ -243,8 +305,26 @@ func (tt TokenType) String() string {
 		return "THROW"
 	case TOKEN_TAIL:
 		return "TAILCALL"
+	case TOKEN_ASYNC:
+		return "ASYNC"
+	case TOKEN_AWAIT:
+		return "AWAIT"
+	case TOKEN_MUT:
+		return "MUT"
+	case TOKEN_USE:
+		return "USE"
+	case TOKEN_WHERE:
+		return "WHERE"
+	case TOKEN_EXPORT:
+		return "EXPORT"
+	case TOKEN_STATIC:
+		return "STATIC"
+	case TOKEN_NOT:
+		return "NOT"
 	case TOKEN_REGEX:
 		return "<REGEX>"
+	case TOKEN_PRAGMA:
+		return "<PRAGMA>"
 	default:
 		return "UNKNOWN"
 	}
@@ -259,6 +339,7 @@ var keywords = map[string]TokenType{
 	"fn":          TOKEN_FUNCTION,
 	"if":          TOKEN_IF,
 	"else":        TOKEN_ELSE,
+	"elif":        TOKEN_ELIF,
 	"while":       TOKEN_WHILE,
 	"do":          TOKEN_DO,
 	"for":         TOKEN_FOR,
@@ -276,6 +357,14 @@ var keywords = map[string]TokenType{
 	"finally":     TOKEN_FINALLY,
 	"throw":       TOKEN_THROW,
 	"tailcall":    TOKEN_TAIL,
+	"async":       TOKEN_ASYNC,
+	"await":       TOKEN_AWAIT,
+	"mut":         TOKEN_MUT,
+	"use":         TOKEN_USE,
+	"where":       TOKEN_WHERE,
+	"export":      TOKEN_EXPORT,
+	"static":      TOKEN_STATIC,
+	"not":         TOKEN_NOT,
 }
 
 type Token struct {
@@ -320,6 +409,55 @@ func (p Position) Sline() string { //String line
 	return msg
 }
 
+//Span is the source range from Start(inclusive) to End(exclusive), e.g. a node's Pos() and End()
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (s Span) String() string {
+	return fmt.Sprintf(" <%d:%d-%d:%d> ", s.Start.Line, s.Start.Col, s.End.Line, s.End.Col)
+}
+
+//Contains reports whether the position is inside the span, only the lines and columns are compared.
+func (s Span) Contains(pos Position) bool {
+	afterStart := pos.Line > s.Start.Line || pos.Line == s.Start.Line && pos.Col >= s.Start.Col
+	beforeEnd := pos.Line < s.End.Line || pos.Line == s.End.Line && pos.Col < s.End.Col
+	return afterStart && beforeEnd
+}
+
+//Text returns the text of the span in 'src', which is the whole source the positions refer to.
+//The lines and columns are 1-based, and the columns are counted in runes, same as the lexer does.
+//It returns "" if the span is not inside 'src'.
+func (s Span) Text(src string) string {
+	runes := []rune(src)
+	start, end := runeOffset(runes, s.Start), runeOffset(runes, s.End)
+	if start < 0 || end < start {
+		return ""
+	}
+	return string(runes[start:end])
+}
+
+//returns the index of the position in 'runes', or -1 if there is no such position
+func runeOffset(runes []rune, pos Position) int {
+	line, col := 1, 1
+	for i, r := range runes {
+		if line == pos.Line && col == pos.Col {
+			return i
+		}
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	if line == pos.Line && col == pos.Col { //the end of the source
+		return len(runes)
+	}
+	return -1
+}
+
 func LookupIdent(ident string) TokenType {
 	if tok, ok := keywords[ident]; ok {
 		return tok
@@ -0,0 +1,44 @@
+package token
+
+import "testing"
+
+func TestSpanText(t *testing.T) {
+	src := "let s = \"h\u00e9llo\"\nf(a,\n  b)"
+	tests := []struct {
+		start, end Position
+		expected   string
+	}{
+		{Position{Line: 1, Col: 1}, Position{Line: 1, Col: 4}, "let"},
+		{Position{Line: 1, Col: 9}, Position{Line: 1, Col: 16}, "\"h\u00e9llo\""}, //the columns are counted in runes
+		{Position{Line: 2, Col: 1}, Position{Line: 3, Col: 5}, "f(a,\n  b)"},
+		{Position{Line: 3, Col: 5}, Position{Line: 3, Col: 5}, ""},
+		{Position{Line: 9, Col: 1}, Position{Line: 9, Col: 2}, ""}, //not inside the source
+	}
+
+	for _, tt := range tests {
+		span := Span{Start: tt.start, End: tt.end}
+		if got := span.Text(src); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", span, tt.expected, got)
+		}
+	}
+}
+
+func TestSpanContains(t *testing.T) {
+	span := Span{Start: Position{Line: 1, Col: 5}, End: Position{Line: 2, Col: 3}}
+	tests := []struct {
+		pos      Position
+		expected bool
+	}{
+		{Position{Line: 1, Col: 4}, false},
+		{Position{Line: 1, Col: 5}, true},
+		{Position{Line: 1, Col: 80}, true},
+		{Position{Line: 2, Col: 2}, true},
+		{Position{Line: 2, Col: 3}, false}, //the end is exclusive
+	}
+
+	for _, tt := range tests {
+		if got := span.Contains(tt.pos); got != tt.expected {
+			t.Errorf("%s contains %d:%d: expected %t, got %t", span, tt.pos.Line, tt.pos.Col, tt.expected, got)
+		}
+	}
+}